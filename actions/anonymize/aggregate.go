@@ -24,6 +24,8 @@ import (
 	"github.com/kiprotect/kodex/actions/anonymize/aggregate/filter_functions"
 	"github.com/kiprotect/kodex/actions/anonymize/aggregate/group_by_functions"
 	"github.com/kiprotect/kodex/actions/anonymize/aggregate/groups"
+	"github.com/kiprotect/kodex/budget"
+	"math"
 	"sync"
 	"time"
 )
@@ -38,7 +40,21 @@ type AggregateAnonymizer struct {
 	filterFunctions      []filterFunctions.FilterFunction
 	groupByFunctions     []groupByFunctions.GroupByFunction
 	alwaysIncludedGroups int
+	groupStoreType       string
+	groupStoreConfig     map[string]interface{}
 	groupStore           aggregate.GroupStore
+	budgetID             string
+	onExhausted          string
+	ledger               kodex.PrivacyBudgetLedger
+	ledgerSetUp          bool
+	eventTime            bool
+	timeField            string
+	timeFormat           string
+	allowedLateness      int64
+	lateData             string
+	lateChannels         []string
+	maxEventTime         int64
+	timeMutex            sync.Mutex
 	mutex                sync.Mutex
 }
 
@@ -46,15 +62,66 @@ func (a *AggregateAnonymizer) Setup(settings kodex.Settings) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 	var err error
-	if a.groupStore, err = groups.GroupStores["in-memory"](map[string]interface{}{}, a.id); err != nil {
-		return errors.MakeExternalError("in-memory store not defined", "IN-MEMORY-STORE", nil, err)
+	if a.ledger, err = budget.MakeLedger(settings); err != nil {
+		return errors.MakeExternalError("cannot create privacy budget ledger", "PRIVACY-BUDGET", nil, err)
+	}
+	a.ledgerSetUp = true
+	return a.setupGroupStore()
+}
+
+func (a *AggregateAnonymizer) setupGroupStore() error {
+	groupStoreMaker, ok := groups.GroupStores[a.groupStoreType]
+	if !ok {
+		return errors.MakeExternalError("group store not defined", "GROUP-STORE", a.groupStoreType, nil)
+	}
+	var err error
+	if a.groupStore, err = groupStoreMaker(a.groupStoreConfig, a.id); err != nil {
+		return errors.MakeExternalError("cannot create group store", "GROUP-STORE", a.groupStoreType, err)
+	}
+	if mergingStore, ok := a.groupStore.(aggregate.MergingStore); ok {
+		mergingStore.SetFunction(a.function.Function)
+	}
+	return a.restoreWatermark()
+}
+
+// Restores the maximum event time from the group store, so that groups that
+// were finalized before a restart are not reopened by late items.
+func (a *AggregateAnonymizer) restoreWatermark() error {
+	watermarkStore, ok := a.groupStore.(aggregate.WatermarkStore)
+	if !a.eventTime || !ok {
+		return nil
+	}
+	maxEventTime, ok, err := watermarkStore.MaxEventTime()
+	if err != nil {
+		return errors.MakeExternalError("cannot restore watermark", "GROUP-STORE", a.groupStoreType, err)
+	}
+	a.timeMutex.Lock()
+	defer a.timeMutex.Unlock()
+	if ok && maxEventTime > a.maxEventTime {
+		a.maxEventTime = maxEventTime
 	}
 	return nil
 }
 
+// Actions that are restored from the parameter store are never set up, so we
+// make sure that the group store exists before we use it.
+func (a *AggregateAnonymizer) getGroupStore() (aggregate.GroupStore, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.groupStore == nil {
+		if err := a.setupGroupStore(); err != nil {
+			return nil, err
+		}
+	}
+	return a.groupStore, nil
+}
+
 func (a *AggregateAnonymizer) Teardown() error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
+	if a.groupStore == nil {
+		return nil
+	}
 	return a.groupStore.Teardown()
 }
 
@@ -83,6 +150,17 @@ func MakeAggregateAnonymizer(name string, id []byte, config map[string]interface
 				}
 			}
 		}
+		ff := make([]filterFunctions.FilterFunction, 0)
+		for _, filterFunction := range params["filters"].([]interface{}) {
+			ff = append(ff, filterFunction.(filterFunctions.FilterFunction))
+		}
+		groupStoreParams := params["group-store"].(map[string]interface{})
+		budgetParams := params["privacy-budget"].(map[string]interface{})
+		timeParams := params["time"].(map[string]interface{})
+		eventTime := timeParams["mode"].(string) == "event"
+		if eventTime && timeParams["field"].(string) == "" {
+			return nil, errors.MakeExternalError("a time field is required in event mode", "EVENT-TIME", nil, nil)
+		}
 		resultName, ok := params["result-name"].(string)
 		if !ok {
 			resultName = name
@@ -91,8 +169,20 @@ func MakeAggregateAnonymizer(name string, id []byte, config map[string]interface
 			function:             params["function"].(Function),
 			channels:             params["channels"].([]string),
 			finalizeAfter:        params["finalize-after"].(int64),
+			filterFunctions:      ff,
 			groupByFunctions:     gbf,
 			alwaysIncludedGroups: alwaysIncludedGroups,
+			groupStoreType:       groupStoreParams["type"].(string),
+			groupStoreConfig:     groupStoreParams["config"].(map[string]interface{}),
+			budgetID:             budgetParams["id"].(string),
+			onExhausted:          budgetParams["on-exhausted"].(string),
+			eventTime:            eventTime,
+			timeField:            timeParams["field"].(string),
+			timeFormat:           timeParams["format"].(string),
+			allowedLateness:      timeParams["allowed-lateness"].(int64) * int64(time.Second),
+			lateData:             timeParams["late-data"].(string),
+			lateChannels:         timeParams["late-channels"].([]string),
+			maxEventTime:         math.MinInt64,
 			resultName:           resultName,
 			name:                 name,
 			id:                   id,
@@ -117,19 +207,31 @@ func (a *AggregateAnonymizer) Anonymize(item *kodex.Item, writer kodex.ChannelWr
 }
 
 func (a *AggregateAnonymizer) Reset() error {
-	return a.groupStore.Reset()
+	groupStore, err := a.getGroupStore()
+	if err != nil {
+		return err
+	}
+	a.timeMutex.Lock()
+	a.maxEventTime = math.MinInt64
+	a.timeMutex.Unlock()
+	return groupStore.Reset()
 }
 
 func (a *AggregateAnonymizer) Advance(channelWriter kodex.ChannelWriter) ([]*kodex.Item, error) {
 
-	shard, err := a.groupStore.Shard()
+	groupStore, err := a.getGroupStore()
+	if err != nil {
+		return nil, err
+	}
+
+	shard, err := groupStore.Shard()
 	if err != nil {
-		return nil, errors.MakeExternalError("cannot get a shard", "IN-MEMORY-STORE", nil, err)
+		return nil, errors.MakeExternalError("cannot get a shard", "GROUP-STORE", nil, err)
 	}
 	defer shard.Return()
 
 	// we finalize all expired groups and return their results
-	aggregations, err := a.finalizeExpiredGroups(shard, time.Now().UnixNano())
+	aggregations, err := a.finalizeExpiredGroups(shard, a.watermark())
 
 	if err != nil {
 		return nil, err
@@ -157,14 +259,22 @@ func (a *AggregateAnonymizer) Finalize(writer kodex.ChannelWriter) ([]*kodex.Ite
 }
 
 func (a *AggregateAnonymizer) process(item *kodex.Item, channelWriter kodex.ChannelWriter) (*kodex.Item, error) {
-	shard, err := a.groupStore.Shard()
+	groupStore, err := a.getGroupStore()
 	if err != nil {
-		return nil, errors.MakeExternalError("cannot get a shard", "IN-MEMORY-STORE", nil, err)
+		return nil, err
+	}
+	shard, err := groupStore.Shard()
+	if err != nil {
+		return nil, errors.MakeExternalError("cannot get a shard", "GROUP-STORE", nil, err)
 	}
 	defer shard.Return()
 	if err := a.aggregate(item, channelWriter, shard); err != nil {
 		return nil, err
 	}
+	// we synchronize the shard with the group store
+	if err := shard.Commit(); err != nil {
+		return nil, errors.MakeExternalError("cannot commit shard", "GROUP-STORE", nil, err)
+	}
 	return item, nil
 }
 
@@ -182,7 +292,37 @@ func min(a, b int) int {
 	return b
 }
 
-func (a *AggregateAnonymizer) getGroupByValues(item *kodex.Item) ([]*groupByFunctions.GroupByValue, error) {
+// Returns the time against which we finalize groups. In event mode this is
+// the largest event time we have seen minus the allowed lateness.
+func (a *AggregateAnonymizer) watermark() int64 {
+	if !a.eventTime {
+		return time.Now().UnixNano()
+	}
+	a.timeMutex.Lock()
+	defer a.timeMutex.Unlock()
+	if a.maxEventTime == math.MinInt64 {
+		return math.MinInt64
+	}
+	return a.maxEventTime - a.allowedLateness
+}
+
+// Updates the maximum event time (also in the group store, if it can persist
+// it) and returns the new watermark
+func (a *AggregateAnonymizer) updateWatermark(groupStore aggregate.GroupStore, eventTime int64) (int64, error) {
+	a.timeMutex.Lock()
+	defer a.timeMutex.Unlock()
+	if eventTime > a.maxEventTime {
+		a.maxEventTime = eventTime
+		if watermarkStore, ok := groupStore.(aggregate.WatermarkStore); ok {
+			if err := watermarkStore.UpdateMaxEventTime(eventTime); err != nil {
+				return 0, errors.MakeExternalError("cannot store watermark", "GROUP-STORE", a.groupStoreType, err)
+			}
+		}
+	}
+	return a.maxEventTime - a.allowedLateness, nil
+}
+
+func (a *AggregateAnonymizer) getGroupByValues(item *kodex.Item, now int64) ([]*groupByFunctions.GroupByValue, error) {
 	/*
 		We calculate the power set of all group-by function values. Each group-by function can
 		produce one or more values. We
@@ -217,8 +357,8 @@ func (a *AggregateAnonymizer) getGroupByValues(item *kodex.Item) ([]*groupByFunc
 		}
 		for {
 			combinedGroupByValue := &groupByFunctions.GroupByValue{
-				// the minimum expiration time is the current system time plus the chosen "finalizeAfter" time
-				Expiration: time.Now().Add(time.Duration(fa) * time.Second).UnixNano(),
+				// the minimum expiration time is the current (system or event) time plus the chosen "finalizeAfter" time
+				Expiration: now + fa*int64(time.Second),
 				Values:     make(map[string]interface{}),
 			}
 			for i := 0; i < n; i++ {
@@ -295,42 +435,73 @@ func (a *AggregateAnonymizer) getGroupByValues(item *kodex.Item) ([]*groupByFunc
 	return combinedGroupByValues, nil
 }
 
-func (a *AggregateAnonymizer) getGroups(item *kodex.Item, function aggregate.Function, shard aggregate.Shard) ([]aggregate.Group, error) {
-	groupByValuesList, err := a.getGroupByValues(item)
+// Returns the groups for the given item. Groups that would have expired
+// before the given watermark are late, depending on the late data policy we
+// either skip them or replace them with correction groups. Returns whether
+// any of the groups was late.
+func (a *AggregateAnonymizer) getGroups(item *kodex.Item, function aggregate.Function, shard aggregate.Shard, now, watermark int64) ([]aggregate.Group, bool, error) {
+	groupByValuesList, err := a.getGroupByValues(item, now)
 	if err != nil {
-		return nil, errors.MakeExternalError("error getting group-by values",
+		return nil, false, errors.MakeExternalError("error getting group-by values",
 			"GET-GROUP-BY-VALUES",
 			nil,
 			err)
 	}
 
+	late := false
 	itemGroups := make([]aggregate.Group, 0)
 	for _, groupByValue := range groupByValuesList {
+		if a.eventTime && groupByValue.Expiration <= watermark {
+			late = true
+			if a.lateData != "correction" {
+				continue
+			}
+			// we collect late items in a separate correction group that we
+			// finalize once the watermark has passed the current event time
+			values := make(map[string]interface{}, len(groupByValue.Values)+1)
+			for k, v := range groupByValue.Values {
+				values[k] = v
+			}
+			values["correction"] = true
+			groupByValue = &groupByFunctions.GroupByValue{
+				Values:     values,
+				Expiration: watermark + a.allowedLateness,
+			}
+		}
 		hash, err := kodex.StructuredHash(groupByValue.Values)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 		group, err := shard.GroupByHash(hash)
 		if err != nil && err != aggregate.NotFound {
-			return nil, err
+			return nil, false, err
 		}
 		if group == nil {
 			group, err = shard.CreateGroup(hash, groupByValue.Values, groupByValue.Expiration)
 			if err != nil {
-				return nil, err
+				return nil, false, err
 			}
 			// we initialize the group
 			if err := function.Initialize(group); err != nil {
-				return nil, err
+				return nil, false, err
+			}
+		} else if !group.Initialized() {
+			// the group was restored from a checkpoint
+			if err := function.Initialize(group); err != nil {
+				return nil, false, err
 			}
 		}
 		itemGroups = append(itemGroups, group)
 	}
-	return itemGroups, nil
+	return itemGroups, late, nil
 }
 
 func (a *AggregateAnonymizer) finalizeAllGroups() ([]*kodex.Item, error) {
-	allGroups, err := a.groupStore.ExpireAllGroups()
+	groupStore, err := a.getGroupStore()
+	if err != nil {
+		return nil, err
+	}
+	allGroups, err := groupStore.ExpireAllGroups()
 	if err != nil {
 		return nil, err
 	}
@@ -341,7 +512,11 @@ func (a *AggregateAnonymizer) finalizeExpiredGroups(shard aggregate.Shard, expir
 	if a.finalizeAfter == -1 {
 		return nil, nil
 	}
-	expiredGroups, err := a.groupStore.ExpireGroups(expiration)
+	groupStore, err := a.getGroupStore()
+	if err != nil {
+		return nil, err
+	}
+	expiredGroups, err := groupStore.ExpireGroups(expiration)
 	if err != nil {
 		return nil, err
 	}
@@ -355,11 +530,38 @@ func (a *AggregateAnonymizer) finalizeGroups(groups map[string][]aggregate.Group
 	}
 
 	items := make([]*kodex.Item, 0)
+	mergedGroups := make([]aggregate.Group, 0, len(groups))
 	for _, hashGroups := range groups {
+		// groups restored from a persistent store need to be initialized
+		// before we can merge them
+		for _, group := range hashGroups {
+			if !group.Initialized() {
+				if err := a.function.Function.Initialize(group); err != nil {
+					return items, err
+				}
+			}
+		}
 		group, err := a.function.Function.Merge(hashGroups)
 		if err != nil {
 			return items, err
 		}
+		mergedGroups = append(mergedGroups, group)
+	}
+
+	if len(mergedGroups) == 0 {
+		return items, nil
+	}
+
+	// the groups are disjoint, so releasing all of them costs the privacy
+	// budget of a single release (parallel composition)
+	if ok, err := a.reserveBudget(); err != nil {
+		return items, err
+	} else if !ok {
+		// the privacy budget is exhausted, we suppress all results
+		return items, nil
+	}
+
+	for _, group := range mergedGroups {
 		result, err := a.function.Function.Finalize(group)
 		if err != nil {
 			return items, err
@@ -380,6 +582,37 @@ func (a *AggregateAnonymizer) finalizeGroups(groups map[string][]aggregate.Group
 	return items, nil
 }
 
+// Reserves the privacy budget for a single release of a differentially
+// private function. Returns false if the release should be suppressed. If
+// the anonymizer was never set up we cannot account for the release, so we
+// refuse it.
+func (a *AggregateAnonymizer) reserveBudget() (bool, error) {
+	privateFunction, ok := a.function.Function.(aggregate.PrivateFunction)
+	if !ok {
+		return true, nil
+	}
+	a.mutex.Lock()
+	ledger, ledgerSetUp := a.ledger, a.ledgerSetUp
+	a.mutex.Unlock()
+	if !ledgerSetUp {
+		return false, errors.MakeExternalError("privacy budget ledger not set up", "PRIVACY-BUDGET", a.budgetID, nil)
+	} else if ledger == nil {
+		// no privacy budget is configured
+		return true, nil
+	}
+	epsilon, delta := privateFunction.PrivacyCost()
+	if ok, err := ledger.Reserve(a.budgetID, epsilon, delta); err != nil {
+		return false, errors.MakeExternalError("cannot reserve privacy budget", "PRIVACY-BUDGET", a.budgetID, err)
+	} else if !ok {
+		if a.onExhausted == "error" {
+			return false, errors.MakeExternalError("privacy budget exhausted", "PRIVACY-BUDGET-EXHAUSTED", a.budgetID, nil)
+		}
+		kodex.Log.Warningf("Privacy budget '%s' is exhausted, suppressing result", a.budgetID)
+		return false, nil
+	}
+	return true, nil
+}
+
 func (a *AggregateAnonymizer) submitResults(items []*kodex.Item, channelWriter kodex.ChannelWriter) error {
 	for _, channel := range a.channels {
 		if err := channelWriter.Write(channel, items); err != nil {
@@ -398,13 +631,52 @@ func (a *AggregateAnonymizer) aggregate(item *kodex.Item, channelWriter kodex.Ch
 		- Finalize all groups
 	*/
 
+	// we only aggregate items that match all filters
+	for _, filterFunction := range a.filterFunctions {
+		if ok, err := filterFunction(item); err != nil {
+			return errors.MakeExternalError("error applying filter function",
+				"APPLY-FILTER",
+				nil,
+				err)
+		} else if !ok {
+			return nil
+		}
+	}
+
+	now := time.Now().UnixNano()
+	watermark := now
+
+	if a.eventTime {
+		eventTime, err := groupByFunctions.ItemTime(item, a.timeField, a.timeFormat)
+		if err != nil {
+			return errors.MakeExternalError("cannot determine event time", "EVENT-TIME", nil, err)
+		}
+		now = eventTime
+		groupStore, err := a.getGroupStore()
+		if err != nil {
+			return err
+		}
+		if watermark, err = a.updateWatermark(groupStore, eventTime); err != nil {
+			return err
+		}
+	}
+
 	// we retrieve or create the group for the given item
-	groups, err := a.getGroups(item, a.function.Function, shard)
+	groups, late, err := a.getGroups(item, a.function.Function, shard, now, watermark)
 
 	if err != nil {
 		return err
 	}
 
+	// we route late items to the configured channels
+	if late && a.lateData == "channel" {
+		for _, channel := range a.lateChannels {
+			if err := channelWriter.Write(channel, []*kodex.Item{item}); err != nil {
+				return err
+			}
+		}
+	}
+
 	var groupErr error
 	// todo: it might be problematic if a single group action fails for an
 	// item, as we do not want to retry it too often (as it will exhaust)
@@ -426,7 +698,7 @@ func (a *AggregateAnonymizer) aggregate(item *kodex.Item, channelWriter kodex.Ch
 	}
 
 	// we finalize all expired groups and return their results
-	aggregations, err := a.finalizeExpiredGroups(shard, time.Now().UnixNano())
+	aggregations, err := a.finalizeExpiredGroups(shard, watermark)
 
 	if err != nil {
 		return err
@@ -0,0 +1,77 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filterFunctions
+
+import (
+	"github.com/kiprotect/kodex"
+)
+
+// Boolean combinations receive their sub-filters as already constructed
+// filter functions (the aggregate form takes care of this).
+
+func filterList(config map[string]interface{}) []FilterFunction {
+	filters := make([]FilterFunction, 0)
+	for _, filter := range config["filters"].([]interface{}) {
+		filters = append(filters, filter.(FilterFunction))
+	}
+	return filters
+}
+
+func MakeAndFunction(config map[string]interface{}) (FilterFunction, error) {
+
+	filters := filterList(config)
+
+	return func(item *kodex.Item) (bool, error) {
+		for _, filter := range filters {
+			if ok, err := filter(item); err != nil {
+				return false, err
+			} else if !ok {
+				return false, nil
+			}
+		}
+		return true, nil
+	}, nil
+}
+
+func MakeOrFunction(config map[string]interface{}) (FilterFunction, error) {
+
+	filters := filterList(config)
+
+	return func(item *kodex.Item) (bool, error) {
+		for _, filter := range filters {
+			if ok, err := filter(item); err != nil {
+				return false, err
+			} else if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}, nil
+}
+
+func MakeNotFunction(config map[string]interface{}) (FilterFunction, error) {
+
+	filter := config["filter"].(FilterFunction)
+
+	return func(item *kodex.Item) (bool, error) {
+		ok, err := filter(item)
+		if err != nil {
+			return false, err
+		}
+		return !ok, nil
+	}, nil
+}
@@ -23,4 +23,13 @@ import (
 type FilterFunction func(item *kodex.Item) (bool, error)
 type FilterFunctionMaker func(map[string]interface{}) (FilterFunction, error)
 
-var Functions = map[string]FilterFunctionMaker{}
+var Functions = map[string]FilterFunctionMaker{
+	"equals": MakeEqualsFunction,
+	"in":     MakeInFunction,
+	"range":  MakeRangeFunction,
+	"regex":  MakeRegexFunction,
+	"exists": MakeExistsFunction,
+	"and":    MakeAndFunction,
+	"or":     MakeOrFunction,
+	"not":    MakeNotFunction,
+}
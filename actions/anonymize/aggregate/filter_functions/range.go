@@ -0,0 +1,49 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filterFunctions
+
+import (
+	"github.com/kiprotect/kodex"
+)
+
+func MakeRangeFunction(config map[string]interface{}) (FilterFunction, error) {
+
+	field := config["field"].(string)
+	min, hasMin := config["min"].(float64)
+	max, hasMax := config["max"].(float64)
+	exclusiveMin := config["exclusive-min"].(bool)
+	exclusiveMax := config["exclusive-max"].(bool)
+
+	return func(item *kodex.Item) (bool, error) {
+		value, ok := item.Get(field)
+		if !ok {
+			return false, nil
+		}
+		v, ok := numericValue(value)
+		if !ok {
+			// non-numeric values are never within the range
+			return false, nil
+		}
+		if hasMin && (v < min || (exclusiveMin && v == min)) {
+			return false, nil
+		}
+		if hasMax && (v > max || (exclusiveMax && v == max)) {
+			return false, nil
+		}
+		return true, nil
+	}, nil
+}
@@ -0,0 +1,50 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filterFunctions
+
+import (
+	"github.com/kiprotect/go-helpers/errors"
+	"github.com/kiprotect/kodex"
+	"regexp"
+)
+
+func MakeRegexFunction(config map[string]interface{}) (FilterFunction, error) {
+
+	field := config["field"].(string)
+	pattern := config["pattern"].(string)
+
+	regex, err := regexp.Compile(pattern)
+
+	if err != nil {
+		return nil, errors.MakeExternalError("invalid regular expression",
+			"INVALID-REGEX",
+			pattern,
+			err)
+	}
+
+	return func(item *kodex.Item) (bool, error) {
+		value, ok := item.Get(field)
+		if !ok {
+			return false, nil
+		}
+		strValue, ok := value.(string)
+		if !ok {
+			return false, nil
+		}
+		return regex.MatchString(strValue), nil
+	}, nil
+}
@@ -0,0 +1,100 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package filterFunctions
+
+import (
+	"github.com/kiprotect/kodex"
+	"reflect"
+)
+
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// Two values are equal if they are numerically equal (regardless of their
+// concrete number type) or if they are deeply equal.
+func equalValues(a, b interface{}) bool {
+	if na, ok := numericValue(a); ok {
+		if nb, ok := numericValue(b); ok {
+			return na == nb
+		}
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func MakeEqualsFunction(config map[string]interface{}) (FilterFunction, error) {
+
+	field := config["field"].(string)
+	expected := config["value"]
+
+	return func(item *kodex.Item) (bool, error) {
+		value, ok := item.Get(field)
+		if !ok {
+			return false, nil
+		}
+		return equalValues(value, expected), nil
+	}, nil
+}
+
+func MakeInFunction(config map[string]interface{}) (FilterFunction, error) {
+
+	field := config["field"].(string)
+	values := config["values"].([]interface{})
+
+	return func(item *kodex.Item) (bool, error) {
+		value, ok := item.Get(field)
+		if !ok {
+			return false, nil
+		}
+		for _, expected := range values {
+			if equalValues(value, expected) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}, nil
+}
+
+func MakeExistsFunction(config map[string]interface{}) (FilterFunction, error) {
+
+	field := config["field"].(string)
+
+	return func(item *kodex.Item) (bool, error) {
+		value, ok := item.Get(field)
+		return ok && value != nil, nil
+	}, nil
+}
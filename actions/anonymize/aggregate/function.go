@@ -29,3 +29,9 @@ type Function interface {
 	// Finalize a group and return the result
 	Finalize(group Group) (interface{}, error)
 }
+
+// A function that adds noise to its results to make them differentially
+// private. Every finalized result consumes the given privacy budget.
+type PrivateFunction interface {
+	PrivacyCost() (epsilon, delta float64)
+}
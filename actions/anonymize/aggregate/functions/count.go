@@ -126,3 +126,7 @@ func MakeCountFunction(config map[string]interface{}) (aggregate.Function, error
 		treshold: params["treshold"].(int64),
 	}, nil
 }
+
+func (c *Count) PrivacyCost() (float64, float64) {
+	return c.epsilon, 0
+}
@@ -23,6 +23,8 @@ import (
 type FunctionMaker func(map[string]interface{}) (aggregate.Function, error)
 
 var Functions = map[string]FunctionMaker{
-	"count":   MakeCountFunction,
-	"uniques": MakeUniquesFunction,
+	"count":          MakeCountFunction,
+	"uniques":        MakeUniquesFunction,
+	"approx-uniques": MakeApproxUniquesFunction,
+	"top-k":          MakeTopKFunction,
 }
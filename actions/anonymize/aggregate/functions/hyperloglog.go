@@ -0,0 +1,230 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package functions
+
+import (
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+var ApproxUniquesForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the approx-uniques config",
+	Fields: []forms.Field{
+		{
+			Name: "id",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			// the number of registers is 2^precision
+			Name: "precision",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 12},
+				forms.IsInteger{HasMin: true, Min: 4, HasMax: true, Max: 16},
+			},
+		},
+	},
+}
+
+// A mergeable HyperLogLog sketch
+type HyperLogLog struct {
+	P         uint8
+	Registers []uint8
+}
+
+func MakeHyperLogLog(precision uint8) *HyperLogLog {
+	return &HyperLogLog{
+		P:         precision,
+		Registers: make([]uint8, 1<<precision),
+	}
+}
+
+// We use FNV-1a followed by the SplitMix64 finalizer, which gives us well
+// distributed hashes that are stable across processes.
+func hash64(value string, seed uint64) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	x := h.Sum64() + seed*0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+func (h *HyperLogLog) Add(value string) {
+	x := hash64(value, 0)
+	index := x >> (64 - h.P)
+	// the number of leading zeros of the remaining bits, plus one
+	rank := uint8(bits.LeadingZeros64(x<<h.P|1<<(h.P-1))) + 1
+	if rank > h.Registers[index] {
+		h.Registers[index] = rank
+	}
+}
+
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if h.P != other.P {
+		return fmt.Errorf("cannot merge sketches with different precision")
+	}
+	for i, rank := range other.Registers {
+		if rank > h.Registers[i] {
+			h.Registers[i] = rank
+		}
+	}
+	return nil
+}
+
+// Returns the estimated number of distinct values
+func (h *HyperLogLog) Estimate() float64 {
+	m := float64(len(h.Registers))
+	sum := 0.0
+	zeros := 0
+	for _, rank := range h.Registers {
+		sum += math.Pow(2, -float64(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	var alpha float64
+	switch len(h.Registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / sum
+	// for small cardinalities we use linear counting instead
+	if estimate <= 2.5*m && zeros > 0 {
+		return m * math.Log(m/float64(zeros))
+	}
+	return estimate
+}
+
+func (h *HyperLogLog) Serialize() ([]byte, error) {
+	return append([]byte{h.P}, h.Registers...), nil
+}
+
+func (h *HyperLogLog) Deserialize(buf []byte) error {
+	if len(buf) < 1 || len(buf) != 1+1<<buf[0] {
+		return fmt.Errorf("invalid HyperLogLog state")
+	}
+	h.P = buf[0]
+	h.Registers = make([]uint8, len(buf)-1)
+	copy(h.Registers, buf[1:])
+	return nil
+}
+
+func (h *HyperLogLog) Clone() (aggregate.State, error) {
+	registers := make([]uint8, len(h.Registers))
+	copy(registers, h.Registers)
+	return &HyperLogLog{P: h.P, Registers: registers}, nil
+}
+
+// Estimates the number of distinct IDs using a HyperLogLog sketch. A single
+// ID can change a register (and therefore the estimate) by an arbitrary
+// amount, so we cannot calibrate noise to a bounded sensitivity. The result is
+// therefore NOT differentially private and does not consume privacy budget,
+// use the `uniques` function if you need a private result.
+type ApproxUniques struct {
+	idField   string
+	precision uint8
+}
+
+func (c *ApproxUniques) Initialize(group aggregate.Group) error {
+	group.Lock()
+	defer group.Unlock()
+	return group.Initialize(MakeHyperLogLog(c.precision))
+}
+
+func (c *ApproxUniques) Merge(groups []aggregate.Group) (aggregate.Group, error) {
+	if len(groups) == 1 {
+		return groups[0], nil
+	}
+	newGroup := groups[0]
+	newGroup.Lock()
+	defer newGroup.Unlock()
+	sketch, ok := newGroup.State().(*HyperLogLog)
+	if !ok {
+		return nil, fmt.Errorf("Expected a HyperLogLog sketch")
+	}
+	for i, group := range groups {
+		if i == 0 {
+			continue
+		}
+		group.Lock()
+		otherSketch, ok := group.State().(*HyperLogLog)
+		if !ok {
+			group.Unlock()
+			return nil, fmt.Errorf("Expected a HyperLogLog sketch")
+		}
+		err := sketch.Merge(otherSketch)
+		group.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newGroup, nil
+}
+
+func (c *ApproxUniques) Add(item *kodex.Item, group aggregate.Group) error {
+	group.Lock()
+	defer group.Unlock()
+	sketch, ok := group.State().(*HyperLogLog)
+	if !ok {
+		return fmt.Errorf("Expected a HyperLogLog sketch")
+	}
+	idValue, ok := item.Get(c.idField)
+	if !ok {
+		return nil
+	}
+	idValueStr, ok := idValue.(string)
+	if !ok {
+		return fmt.Errorf("Expected a string ID")
+	}
+	sketch.Add(idValueStr)
+	return nil
+}
+
+func (c *ApproxUniques) Finalize(group aggregate.Group) (interface{}, error) {
+	group.Lock()
+	defer group.Unlock()
+	sketch, ok := group.State().(*HyperLogLog)
+	if !ok {
+		return nil, fmt.Errorf("Expected a HyperLogLog sketch")
+	}
+	return int64(math.Round(sketch.Estimate())), nil
+}
+
+func MakeApproxUniquesFunction(config map[string]interface{}) (aggregate.Function, error) {
+	params, err := ApproxUniquesForm.Validate(config)
+	if err != nil {
+		return nil, err
+	}
+	return &ApproxUniques{
+		idField:   params["id"].(string),
+		precision: uint8(params["precision"].(int64)),
+	}, nil
+}
@@ -0,0 +1,172 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package functions
+
+import (
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate/groups"
+	"math"
+	"testing"
+)
+
+func makeGroups(t *testing.T, function aggregate.Function, n int) []aggregate.Group {
+	groupList := make([]aggregate.Group, n)
+	for i := 0; i < n; i++ {
+		groupList[i] = groups.MakeInMemoryGroup([]byte("test"), map[string]interface{}{}, 0, nil)
+		if err := function.Initialize(groupList[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return groupList
+}
+
+func TestHyperLogLog(t *testing.T) {
+
+	sketch := MakeHyperLogLog(12)
+	otherSketch := MakeHyperLogLog(12)
+
+	for i := 0; i < 100000; i++ {
+		// the sketches overlap by half of their values
+		sketch.Add(fmt.Sprintf("user-%d", i))
+		otherSketch.Add(fmt.Sprintf("user-%d", i+50000))
+	}
+
+	if estimate := sketch.Estimate(); math.Abs(estimate-100000)/100000 > 0.05 {
+		t.Errorf("Estimate is too far off: %f", estimate)
+	}
+
+	if err := sketch.Merge(otherSketch); err != nil {
+		t.Fatal(err)
+	}
+
+	if estimate := sketch.Estimate(); math.Abs(estimate-150000)/150000 > 0.05 {
+		t.Errorf("Merged estimate is too far off: %f", estimate)
+	}
+
+	data, err := sketch.Serialize()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restoredSketch := &HyperLogLog{}
+
+	if err := restoredSketch.Deserialize(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if restoredSketch.Estimate() != sketch.Estimate() {
+		t.Errorf("Restored sketch differs from the original one")
+	}
+}
+
+func TestApproxUniques(t *testing.T) {
+
+	function, err := MakeApproxUniquesFunction(map[string]interface{}{"id": "id"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// we spread the items over several groups, as different shards would
+	shardGroups := makeGroups(t, function, 3)
+
+	for i := 0; i < 300; i++ {
+		item := kodex.MakeItem(map[string]interface{}{"id": fmt.Sprintf("user-%d", i%100)})
+		if err := function.Add(item, shardGroups[i%3]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	group, err := function.Merge(shardGroups)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err := function.Finalize(group); err != nil {
+		t.Fatal(err)
+	} else if r, ok := result.(int64); !ok || r < 97 || r > 103 {
+		t.Errorf("Expected about 100 uniques, got %v", result)
+	}
+	// the estimate is not private, so it should not consume privacy budget
+	if _, ok := function.(aggregate.PrivateFunction); ok {
+		t.Errorf("Approximate uniques should not be a private function")
+	}
+}
+
+func TestTopK(t *testing.T) {
+
+	function, err := MakeTopKFunction(map[string]interface{}{
+		"field":    "page",
+		"k":        3,
+		"epsilon":  10000,
+		"treshold": 5,
+		"domain":   []string{"a", "b", "c", "d", "e", "f"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shardGroups := makeGroups(t, function, 2)
+
+	counts := map[string]int{"a": 100, "b": 50, "c": 20, "d": 10, "e": 3}
+	n := 0
+
+	for value, count := range counts {
+		for i := 0; i < count; i++ {
+			n++
+			if err := function.Add(kodex.MakeItem(map[string]interface{}{"page": value}), shardGroups[n%2]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// values outside of the domain are never released
+	for i := 0; i < 200; i++ {
+		if err := function.Add(kodex.MakeItem(map[string]interface{}{"page": fmt.Sprintf("other-%d", i%2)}), shardGroups[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	group, err := function.Merge(shardGroups)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := function.Finalize(group)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topK, ok := result.([]map[string]interface{})
+
+	if !ok || len(topK) != 3 {
+		t.Fatalf("Expected three results, got %v", result)
+	}
+
+	for i, value := range []string{"a", "b", "c"} {
+		if topK[i]["value"] != value || topK[i]["count"] != int64(counts[value]) {
+			t.Errorf("Unexpected result at position %d: %v", i, topK[i])
+		}
+	}
+}
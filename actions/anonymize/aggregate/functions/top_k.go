@@ -0,0 +1,306 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package functions
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate"
+	"sort"
+)
+
+var TopKForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the top-k config",
+	Fields: []forms.Field{
+		{
+			Name: "field",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "k",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 10},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 1000},
+			},
+		},
+		{
+			Name: "epsilon",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 1.0},
+				forms.IsFloat{HasMin: true, Min: 0.01, HasMax: false},
+			},
+		},
+		{
+			// we do not report values with a noisy count at or below the treshold
+			Name: "treshold",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 10},
+				forms.IsInteger{HasMin: true, Min: 0, HasMax: false},
+			},
+		},
+		{
+			Name: "width",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 2048},
+				forms.IsInteger{HasMin: true, Min: 16, HasMax: true, Max: 1 << 20},
+			},
+		},
+		{
+			Name: "depth",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 4},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 16},
+			},
+		},
+		{
+			// the public list of values that we may release, as releasing
+			// values that we learned from the data would not be private
+			Name: "domain",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsStringList{},
+			},
+		},
+	},
+}
+
+// A mergeable count-min sketch
+type CountMinSketch struct {
+	Width  int
+	Depth  int
+	Counts []int64
+}
+
+func MakeCountMinSketch(width, depth int) *CountMinSketch {
+	return &CountMinSketch{
+		Width:  width,
+		Depth:  depth,
+		Counts: make([]int64, width*depth),
+	}
+}
+
+func (c *CountMinSketch) index(row int, value string) int {
+	return row*c.Width + int(hash64(value, uint64(row+1))%uint64(c.Width))
+}
+
+func (c *CountMinSketch) Add(value string) {
+	for row := 0; row < c.Depth; row++ {
+		c.Counts[c.index(row, value)]++
+	}
+}
+
+// Returns the estimated count of the given value, which is never smaller
+// than the true count.
+func (c *CountMinSketch) Estimate(value string) int64 {
+	var estimate int64 = -1
+	for row := 0; row < c.Depth; row++ {
+		if count := c.Counts[c.index(row, value)]; estimate == -1 || count < estimate {
+			estimate = count
+		}
+	}
+	return estimate
+}
+
+// Returns a copy of the sketch with symmetric geometric noise added to every
+// cell. A single item changes one cell per row, so the noisy sketch is
+// epsilon-differentially private if we scale the noise with the depth.
+func (c *CountMinSketch) Noisy(epsilon float64) (*CountMinSketch, error) {
+	noisySketch := MakeCountMinSketch(c.Width, c.Depth)
+	for i, count := range c.Counts {
+		noise, err := geometricNoise(epsilon/float64(c.Depth), true)
+		if err != nil {
+			return nil, err
+		}
+		noisySketch.Counts[i] = count + noise
+	}
+	return noisySketch, nil
+}
+
+func (c *CountMinSketch) Merge(other *CountMinSketch) error {
+	if c.Width != other.Width || c.Depth != other.Depth {
+		return fmt.Errorf("cannot merge sketches with different dimensions")
+	}
+	for i, count := range other.Counts {
+		c.Counts[i] += count
+	}
+	return nil
+}
+
+func (c *CountMinSketch) Serialize() ([]byte, error) {
+	var o bytes.Buffer
+	enc := gob.NewEncoder(&o)
+	if err := enc.Encode(c); err != nil {
+		return nil, err
+	}
+	return o.Bytes(), nil
+}
+
+func (c *CountMinSketch) Deserialize(buf []byte) error {
+	dec := gob.NewDecoder(bytes.NewBuffer(buf))
+	return dec.Decode(c)
+}
+
+func (c *CountMinSketch) Clone() (aggregate.State, error) {
+	counts := make([]int64, len(c.Counts))
+	copy(counts, c.Counts)
+	return &CountMinSketch{
+		Width:  c.Width,
+		Depth:  c.Depth,
+		Counts: counts,
+	}, nil
+}
+
+// Releases the k most frequent values of a field from a public domain. As
+// with the other functions, we assume that every individual contributes at
+// most one item to a group, otherwise the epsilon needs to be divided by the
+// maximum number of contributions.
+type TopK struct {
+	field    string
+	k        int
+	epsilon  float64
+	treshold int64
+	width    int
+	depth    int
+	domain   []string
+}
+
+func (t *TopK) Initialize(group aggregate.Group) error {
+	group.Lock()
+	defer group.Unlock()
+	return group.Initialize(MakeCountMinSketch(t.width, t.depth))
+}
+
+func (t *TopK) Merge(groups []aggregate.Group) (aggregate.Group, error) {
+	if len(groups) == 1 {
+		return groups[0], nil
+	}
+	newGroup := groups[0]
+	newGroup.Lock()
+	defer newGroup.Unlock()
+	sketch, ok := newGroup.State().(*CountMinSketch)
+	if !ok {
+		return nil, fmt.Errorf("Expected a count-min sketch")
+	}
+	for i, group := range groups {
+		if i == 0 {
+			continue
+		}
+		group.Lock()
+		otherSketch, ok := group.State().(*CountMinSketch)
+		if !ok {
+			group.Unlock()
+			return nil, fmt.Errorf("Expected a count-min sketch")
+		}
+		err := sketch.Merge(otherSketch)
+		group.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newGroup, nil
+}
+
+func (t *TopK) Add(item *kodex.Item, group aggregate.Group) error {
+	group.Lock()
+	defer group.Unlock()
+	sketch, ok := group.State().(*CountMinSketch)
+	if !ok {
+		return fmt.Errorf("Expected a count-min sketch")
+	}
+	value, ok := item.Get(t.field)
+	if !ok || value == nil {
+		return nil
+	}
+	strValue, ok := value.(string)
+	if !ok {
+		strValue = fmt.Sprint(value)
+	}
+	sketch.Add(strValue)
+	return nil
+}
+
+// Releases the k domain values with the largest noisy counts. We add noise to
+// the entire sketch (not to single counts, as a count-min estimate can change
+// for all values that collide with the value of an item), so everything we
+// derive from the noisy sketch is epsilon-differentially private as well.
+// Since the domain is public, the released values reveal nothing on their
+// own. We suppress all counts at or below the treshold.
+func (t *TopK) Finalize(group aggregate.Group) (interface{}, error) {
+	group.Lock()
+	defer group.Unlock()
+	sketch, ok := group.State().(*CountMinSketch)
+	if !ok {
+		return nil, fmt.Errorf("Expected a count-min sketch")
+	}
+	noisySketch, err := sketch.Noisy(t.epsilon)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]map[string]interface{}, 0, len(t.domain))
+	for _, value := range t.domain {
+		count := noisySketch.Estimate(value)
+		if count <= t.treshold {
+			continue
+		}
+		results = append(results, map[string]interface{}{
+			"value": value,
+			"count": count,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		ci, cj := results[i]["count"].(int64), results[j]["count"].(int64)
+		if ci == cj {
+			return results[i]["value"].(string) < results[j]["value"].(string)
+		}
+		return ci > cj
+	})
+	if len(results) > t.k {
+		results = results[:t.k]
+	}
+	// we omit empty results
+	if len(results) == 0 {
+		return nil, nil
+	}
+	return results, nil
+}
+
+// We only release values from the public domain, so we need no delta
+func (t *TopK) PrivacyCost() (float64, float64) {
+	return t.epsilon, 0
+}
+
+func MakeTopKFunction(config map[string]interface{}) (aggregate.Function, error) {
+	params, err := TopKForm.Validate(config)
+	if err != nil {
+		return nil, err
+	}
+	return &TopK{
+		field:    params["field"].(string),
+		k:        int(params["k"].(int64)),
+		epsilon:  params["epsilon"].(float64),
+		treshold: params["treshold"].(int64),
+		width:    int(params["width"].(int64)),
+		depth:    int(params["depth"].(int64)),
+		domain:   params["domain"].([]string),
+	}, nil
+}
@@ -180,3 +180,7 @@ func MakeUniquesFunction(config map[string]interface{}) (aggregate.Function, err
 		epsilon: params["epsilon"].(float64),
 	}, nil
 }
+
+func (c *Uniques) PrivacyCost() (float64, float64) {
+	return c.epsilon, 0
+}
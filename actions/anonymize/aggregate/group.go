@@ -62,6 +62,22 @@ type GroupStore interface {
 	ExpireAllGroups() (map[string][]Group, error)
 }
 
+// Group stores that implement this interface persist the largest event time
+// of event-time aggregations, so that the watermark survives restarts.
+type WatermarkStore interface {
+	// Return the stored maximum event time, or false if there is none
+	MaxEventTime() (int64, bool, error)
+	// Store the given event time if it is larger than the stored one
+	UpdateMaxEventTime(eventTime int64) error
+}
+
+// Group stores that merge group states in their backend implement this
+// interface, as they need the aggregate function for merging.
+type MergingStore interface {
+	// Set the function that is used to merge group states
+	SetFunction(function Function)
+}
+
 var AlreadyInitialized = errors.MakeExternalError("group has already been initialized", "GROUP-STORE", nil, nil)
 var AlreadyFinalized = errors.MakeExternalError("group has already been finalized", "GROUP-STORE", nil, nil)
 var NotFound = errors.MakeExternalError("group not found", "GROUP-STORE", nil, nil)
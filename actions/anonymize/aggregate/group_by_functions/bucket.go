@@ -0,0 +1,190 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package groupByFunctions
+
+import (
+	"github.com/kiprotect/go-helpers/errors"
+	"github.com/kiprotect/kodex"
+	"math"
+	"sort"
+)
+
+func getFloat(input interface{}) (float64, bool) {
+	switch v := input.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func getFloatList(input interface{}) []float64 {
+	list, ok := input.([]interface{})
+	if !ok {
+		return nil
+	}
+	values := make([]float64, 0, len(list))
+	for _, entry := range list {
+		values = append(values, entry.(float64))
+	}
+	return values
+}
+
+// Calculates the given quantiles of the reference values using linear
+// interpolation between the closest ranks.
+func QuantileEdges(reference []float64, quantiles []float64) []float64 {
+	sorted := make([]float64, len(reference))
+	copy(sorted, reference)
+	sort.Float64s(sorted)
+	edges := make([]float64, 0, len(quantiles))
+	for _, q := range quantiles {
+		pos := q * float64(len(sorted)-1)
+		lower := int(math.Floor(pos))
+		upper := int(math.Ceil(pos))
+		edge := sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+		// we skip duplicate edges
+		if len(edges) > 0 && edges[len(edges)-1] == edge {
+			continue
+		}
+		edges = append(edges, edge)
+	}
+	return edges
+}
+
+type BucketFunction func(float64) (interface{}, interface{})
+
+// Returns a fixed-width bucket function. Buckets include their lower bound
+// but not their upper bound.
+func FixedWidthBuckets(width, offset float64) BucketFunction {
+	return func(value float64) (interface{}, interface{}) {
+		from := math.Floor((value-offset)/width)*width + offset
+		return from, from + width
+	}
+}
+
+// Returns a bucket function for the given (sorted) edges. Values below the
+// first edge or above the last edge fall into open-ended buckets.
+func EdgeBuckets(edges []float64) BucketFunction {
+	return func(value float64) (interface{}, interface{}) {
+		i := sort.Search(len(edges), func(i int) bool { return edges[i] > value })
+		var from, to interface{}
+		if i > 0 {
+			from = edges[i-1]
+		}
+		if i < len(edges) {
+			to = edges[i]
+		}
+		return from, to
+	}
+}
+
+func MakeBucketFunction(config map[string]interface{}) (GroupByFunction, error) {
+
+	field := config["field"].(string)
+
+	var bucketFunction BucketFunction
+
+	switch config["type"].(string) {
+	case "fixed":
+		width, ok := config["width"].(float64)
+		if !ok {
+			return nil, errors.MakeExternalError("a width is required for fixed buckets",
+				"BUCKET-CONFIG",
+				nil,
+				nil)
+		}
+		bucketFunction = FixedWidthBuckets(width, config["offset"].(float64))
+	case "edges":
+		edges := getFloatList(config["edges"])
+		if len(edges) == 0 {
+			return nil, errors.MakeExternalError("edges are required for edge buckets",
+				"BUCKET-CONFIG",
+				nil,
+				nil)
+		}
+		if !sort.Float64sAreSorted(edges) {
+			return nil, errors.MakeExternalError("edges need to be sorted in ascending order",
+				"BUCKET-CONFIG",
+				edges,
+				nil)
+		}
+		bucketFunction = EdgeBuckets(edges)
+	case "quantiles":
+		quantiles := getFloatList(config["quantiles"])
+		reference := getFloatList(config["reference"])
+		if len(quantiles) == 0 || len(reference) == 0 {
+			return nil, errors.MakeExternalError("quantiles and reference values are required for quantile buckets",
+				"BUCKET-CONFIG",
+				nil,
+				nil)
+		}
+		if !sort.Float64sAreSorted(quantiles) {
+			return nil, errors.MakeExternalError("quantiles need to be sorted in ascending order",
+				"BUCKET-CONFIG",
+				quantiles,
+				nil)
+		}
+		bucketFunction = EdgeBuckets(QuantileEdges(reference, quantiles))
+	}
+
+	return func(item *kodex.Item) ([]*GroupByValue, error) {
+		value, ok := item.Get(field)
+		if !ok {
+			return nil, errors.MakeExternalError("group-by value not defined",
+				"VALUE-NOT-DEFINED",
+				field,
+				nil)
+		}
+		floatValue, ok := getFloat(value)
+		if !ok {
+			return nil, errors.MakeExternalError("expected a numeric value",
+				"VALUE-EXPECTED-NUMBER",
+				value,
+				nil)
+		}
+		from, to := bucketFunction(floatValue)
+		// open-ended buckets only have one of the two bounds
+		bucket := map[string]interface{}{}
+		if from != nil {
+			bucket["from"] = from
+		}
+		if to != nil {
+			bucket["to"] = to
+		}
+		return []*GroupByValue{
+			&GroupByValue{
+				Values: map[string]interface{}{
+					field: bucket,
+				},
+				Expiration: 0,
+			},
+		}, nil
+	}, nil
+}
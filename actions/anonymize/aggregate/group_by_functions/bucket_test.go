@@ -0,0 +1,97 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package groupByFunctions
+
+import (
+	"github.com/kiprotect/kodex"
+	"testing"
+)
+
+type BucketTest struct {
+	Value float64
+	From  interface{}
+	To    interface{}
+}
+
+func testBuckets(t *testing.T, config map[string]interface{}, tests []BucketTest) {
+	f, err := MakeBucketFunction(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		values, err := f(kodex.MakeItem(map[string]interface{}{"age": test.Value}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != 1 {
+			t.Fatalf("Expected one group-by value")
+		}
+		bucket := values[0].Values["age"].(map[string]interface{})
+		if bucket["from"] != test.From || bucket["to"] != test.To {
+			t.Errorf("Expected [%v, %v) for %g, got [%v, %v)", test.From, test.To, test.Value, bucket["from"], bucket["to"])
+		}
+	}
+}
+
+func TestFixedWidthBuckets(t *testing.T) {
+	testBuckets(t, map[string]interface{}{
+		"field":  "age",
+		"type":   "fixed",
+		"width":  10.0,
+		"offset": 5.0,
+	}, []BucketTest{
+		{Value: 23, From: 15.0, To: 25.0},
+		{Value: 25, From: 25.0, To: 35.0},
+		{Value: -1, From: -5.0, To: 5.0},
+	})
+}
+
+func TestEdgeBuckets(t *testing.T) {
+	testBuckets(t, map[string]interface{}{
+		"field": "age",
+		"type":  "edges",
+		"edges": []interface{}{18.0, 30.0, 65.0},
+	}, []BucketTest{
+		{Value: 12, From: nil, To: 18.0},
+		{Value: 18, From: 18.0, To: 30.0},
+		{Value: 40, From: 30.0, To: 65.0},
+		{Value: 80, From: 65.0, To: nil},
+	})
+}
+
+func TestQuantileBuckets(t *testing.T) {
+	testBuckets(t, map[string]interface{}{
+		"field":     "age",
+		"type":      "quantiles",
+		"quantiles": []interface{}{0.25, 0.5, 0.75},
+		"reference": []interface{}{50.0, 10.0, 40.0, 20.0, 30.0},
+	}, []BucketTest{
+		{Value: 5, From: nil, To: 20.0},
+		{Value: 25, From: 20.0, To: 30.0},
+		{Value: 45, From: 40.0, To: nil},
+	})
+}
+
+func TestInvalidBuckets(t *testing.T) {
+	if _, err := MakeBucketFunction(map[string]interface{}{
+		"field": "age",
+		"type":  "edges",
+		"edges": []interface{}{30.0, 18.0},
+	}); err == nil {
+		t.Errorf("Expected an error for unsorted edges")
+	}
+}
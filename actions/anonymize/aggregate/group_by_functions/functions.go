@@ -31,4 +31,6 @@ type GroupByFunctionMaker func(map[string]interface{}) (GroupByFunction, error)
 var Functions = map[string]GroupByFunctionMaker{
 	"time-window": MakeTimeWindowFunction,
 	"value":       MakeValueFunction,
+	"bucket":      MakeBucketFunction,
+	"geo":         MakeGeoFunction,
 }
@@ -0,0 +1,125 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package groupByFunctions
+
+import (
+	"github.com/kiprotect/go-helpers/errors"
+	"github.com/kiprotect/kodex"
+	"math"
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Encodes the given coordinates as a geohash with the given precision.
+func Geohash(latitude, longitude float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+	hash := make([]byte, 0, precision)
+	bit, ch := 0, 0
+	even := true
+	for len(hash) < precision {
+		var r *[2]float64
+		var v float64
+		if even {
+			r, v = &lonRange, longitude
+		} else {
+			r, v = &latRange, latitude
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bit++; bit == 5 {
+			hash = append(hash, geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(hash)
+}
+
+// Returns the south-west corner of the square grid cell (with the given size
+// in degrees) that contains the given coordinates.
+func GridCell(latitude, longitude, size float64) (float64, float64) {
+	return math.Floor(latitude/size) * size, math.Floor(longitude/size) * size
+}
+
+func getCoordinate(item *kodex.Item, field string, limit float64) (float64, error) {
+	value, ok := item.Get(field)
+	if !ok {
+		return 0, errors.MakeExternalError("coordinate not defined",
+			"VALUE-NOT-DEFINED",
+			field,
+			nil)
+	}
+	coordinate, ok := getFloat(value)
+	if !ok || coordinate < -limit || coordinate > limit {
+		return 0, errors.MakeExternalError("invalid coordinate",
+			"COORDINATE-INVALID",
+			map[string]interface{}{
+				"field": field,
+				"value": value},
+			nil)
+	}
+	return coordinate, nil
+}
+
+func MakeGeoFunction(config map[string]interface{}) (GroupByFunction, error) {
+
+	latitudeField := config["latitude"].(string)
+	longitudeField := config["longitude"].(string)
+	cellType := config["type"].(string)
+	precision := int(config["precision"].(int64))
+	cellSize := config["cell-size"].(float64)
+
+	return func(item *kodex.Item) ([]*GroupByValue, error) {
+		latitude, err := getCoordinate(item, latitudeField, 90)
+		if err != nil {
+			return nil, err
+		}
+		longitude, err := getCoordinate(item, longitudeField, 180)
+		if err != nil {
+			return nil, err
+		}
+		var values map[string]interface{}
+		switch cellType {
+		case "geohash":
+			values = map[string]interface{}{
+				"geohash": Geohash(latitude, longitude, precision),
+			}
+		case "grid":
+			lat, lon := GridCell(latitude, longitude, cellSize)
+			values = map[string]interface{}{
+				"cell": map[string]interface{}{
+					"lat":  lat,
+					"lon":  lon,
+					"size": cellSize,
+				},
+			}
+		}
+		return []*GroupByValue{
+			&GroupByValue{
+				Values:     values,
+				Expiration: 0,
+			},
+		}, nil
+	}, nil
+}
@@ -0,0 +1,88 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package groupByFunctions
+
+import (
+	"github.com/kiprotect/kodex"
+	"testing"
+)
+
+type GeohashTest struct {
+	Latitude  float64
+	Longitude float64
+	Precision int
+	Hash      string
+}
+
+var geohashTests = []GeohashTest{
+	{
+		Latitude:  57.64911,
+		Longitude: 10.40744,
+		Precision: 11,
+		Hash:      "u4pruydqqvj",
+	},
+	{
+		Latitude:  52.5200,
+		Longitude: 13.4050,
+		Precision: 5,
+		Hash:      "u33dc",
+	},
+	{
+		Latitude:  -33.8688,
+		Longitude: 151.2093,
+		Precision: 4,
+		Hash:      "r3gx",
+	},
+}
+
+func TestGeohash(t *testing.T) {
+	for _, test := range geohashTests {
+		if hash := Geohash(test.Latitude, test.Longitude, test.Precision); hash != test.Hash {
+			t.Errorf("Expected %s, got %s", test.Hash, hash)
+		}
+	}
+}
+
+func TestGeoFunction(t *testing.T) {
+	f, err := MakeGeoFunction(map[string]interface{}{
+		"latitude":  "lat",
+		"longitude": "lon",
+		"type":      "grid",
+		"precision": int64(5),
+		"cell-size": 0.5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := f(kodex.MakeItem(map[string]interface{}{"lat": 52.52, "lon": -13.405}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 {
+		t.Fatalf("Expected one group-by value")
+	}
+	cell := values[0].Values["cell"].(map[string]interface{})
+	if cell["lat"] != 52.5 || cell["lon"] != -13.5 {
+		t.Errorf("Unexpected grid cell: %v", cell)
+	}
+	if _, err := f(kodex.MakeItem(map[string]interface{}{"lat": 92.0, "lon": 0})); err == nil {
+		t.Errorf("Expected an error for an invalid latitude")
+	}
+	if _, err := f(kodex.MakeItem(map[string]interface{}{"lat": 12.0})); err == nil {
+		t.Errorf("Expected an error for a missing longitude")
+	}
+}
@@ -57,6 +57,15 @@ func getItemTime(item *kodex.Item, field string, parser TimeParser) (int64, erro
 	return t, nil
 }
 
+// Returns the time of the item in the given field, parsed with the given format
+func ItemTime(item *kodex.Item, field, format string) (int64, error) {
+	parser, ok := TimeParsers[format]
+	if !ok {
+		return 0, fmt.Errorf("unknown time format: %s", format)
+	}
+	return getItemTime(item, field, parser)
+}
+
 func MakeTimeWindowFunction(config map[string]interface{}) (GroupByFunction, error) {
 
 	format := config["format"].(string)
@@ -24,4 +24,5 @@ type GroupStoreMaker func(map[string]interface{}, []byte) (aggregate.GroupStore,
 
 var GroupStores = map[string]GroupStoreMaker{
 	"in-memory": MakeInMemoryGroupStore,
+	"redis":     MakeRedisGroupStore,
 }
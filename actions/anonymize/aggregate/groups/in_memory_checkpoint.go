@@ -0,0 +1,170 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package groups
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type checkpointGroup struct {
+	Shard      int                    `json:"shard"`
+	Hash       []byte                 `json:"hash"`
+	Values     map[string]interface{} `json:"values"`
+	Expiration int64                  `json:"expiration"`
+	State      []byte                 `json:"state"`
+}
+
+type checkpoint struct {
+	ShardCount   int                `json:"shard_count"`
+	Groups       []*checkpointGroup `json:"groups"`
+	MaxEventTime *int64             `json:"max_event_time,omitempty"`
+}
+
+// Returns whether the interval since the last checkpoint has elapsed.
+func (g *InMemoryGroupStore) checkpointDue() bool {
+	return g.checkpointFile != "" && time.Since(g.lastCheckpoint) >= g.checkpointInterval
+}
+
+// Writes the shards, groups and their states to the checkpoint file. The
+// caller needs to hold the store mutex. Groups of shards that are currently
+// in use are locked individually while we serialize their state.
+func (g *InMemoryGroupStore) checkpoint() error {
+
+	if g.checkpointFile == "" {
+		return nil
+	}
+
+	cp := &checkpoint{
+		ShardCount:   g.shardCount,
+		Groups:       make([]*checkpointGroup, 0),
+		MaxEventTime: g.maxEventTime,
+	}
+
+	for id, shard := range g.shards {
+		shard.hashMutex.RLock()
+		for _, group := range shard.groupsByHash {
+			inMemoryGroup, ok := group.(*InMemoryGroup)
+			if !ok {
+				shard.hashMutex.RUnlock()
+				return fmt.Errorf("cannot checkpoint a group of type %T", group)
+			}
+			cpGroup, err := inMemoryGroup.checkpoint(id)
+			if err != nil {
+				shard.hashMutex.RUnlock()
+				return err
+			}
+			cp.Groups = append(cp.Groups, cpGroup)
+		}
+		shard.hashMutex.RUnlock()
+	}
+
+	data, err := json.Marshal(cp)
+
+	if err != nil {
+		return err
+	}
+
+	// we write to a temporary file first so that a crash during the write
+	// cannot corrupt the existing checkpoint
+	tmpFile := g.checkpointFile + ".tmp"
+
+	if err := os.MkdirAll(filepath.Dir(g.checkpointFile), 0700); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpFile, g.checkpointFile); err != nil {
+		return err
+	}
+
+	g.lastCheckpoint = time.Now()
+
+	return nil
+}
+
+// Restores the shards, groups and the maximum event time from the checkpoint
+// file, if it exists.
+// Restored groups keep their serialized state until they get initialized.
+func (g *InMemoryGroupStore) restore() error {
+
+	data, err := os.ReadFile(g.checkpointFile)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	cp := &checkpoint{}
+
+	if err := json.Unmarshal(data, cp); err != nil {
+		return err
+	}
+
+	g.shardCount = cp.ShardCount
+	g.maxEventTime = cp.MaxEventTime
+
+	for _, cpGroup := range cp.Groups {
+		shard, ok := g.shards[cpGroup.Shard]
+		if !ok {
+			shard = MakeInMemoryShard(cpGroup.Shard, g)
+			g.shards[cpGroup.Shard] = shard
+			g.usedShards[cpGroup.Shard] = false
+		}
+		if cpGroup.Shard > g.shardCount {
+			g.shardCount = cpGroup.Shard
+		}
+		group := MakeInMemoryGroup(cpGroup.Hash, cpGroup.Values, cpGroup.Expiration, shard)
+		group.data = cpGroup.State
+		shard.addGroup(group)
+	}
+
+	g.lastCheckpoint = time.Now()
+
+	return nil
+}
+
+func (g *InMemoryGroup) checkpoint(shard int) (*checkpointGroup, error) {
+
+	g.RLock()
+	defer g.RUnlock()
+
+	data := g.data
+
+	if g.state != nil {
+		var err error
+		if data, err = g.state.Serialize(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &checkpointGroup{
+		Shard:      shard,
+		Hash:       g.hash,
+		Values:     g.groupByValues,
+		Expiration: g.expiration,
+		State:      data,
+	}, nil
+}
@@ -0,0 +1,144 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package groups
+
+import (
+	"encoding/hex"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate/functions"
+	"path/filepath"
+	"testing"
+)
+
+// Simulates a process restart by dropping the cached store and creating it
+// again from the checkpoint file.
+func restartInMemoryGroupStore(t *testing.T, config map[string]interface{}, id []byte) aggregate.GroupStore {
+	mutex.Lock()
+	delete(Store, hex.EncodeToString(id))
+	mutex.Unlock()
+	store, err := MakeInMemoryGroupStore(config, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestInMemoryGroupStoreCheckpoint(t *testing.T) {
+
+	function, err := functions.MakeCountFunction(map[string]interface{}{"epsilon": 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := []byte("checkpoint-test")
+	config := map[string]interface{}{
+		"checkpoint-file": filepath.Join(t.TempDir(), "groups.json"),
+	}
+
+	store := restartInMemoryGroupStore(t, config, id)
+
+	addItems(t, store, function, "a", 100, 2)
+	addItems(t, store, function, "b", 200, 3)
+
+	if err := store.(aggregate.WatermarkStore).UpdateMaxEventTime(300); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = restartInMemoryGroupStore(t, config, id)
+
+	if maxEventTime, ok, err := store.(aggregate.WatermarkStore).MaxEventTime(); err != nil {
+		t.Fatal(err)
+	} else if !ok || maxEventTime != 300 {
+		t.Fatalf("Expected a restored max event time of 300, got %d", maxEventTime)
+	}
+
+	// we add more items to a restored group
+	addItems(t, store, function, "a", 100, 1)
+
+	expiredGroups, err := store.ExpireGroups(150)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expiredGroups) != 1 || len(expiredGroups["a"]) != 1 {
+		t.Fatalf("Expected group 'a' to be expired")
+	}
+
+	group, err := function.Merge(expiredGroups["a"])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err := function.Finalize(group); err != nil {
+		t.Fatal(err)
+	} else if result != int64(3) {
+		t.Errorf("Expected a count of 3, got %v", result)
+	}
+
+	// after another restart, the finalized group must not show up again
+	store = restartInMemoryGroupStore(t, config, id)
+
+	expiredGroups, err = store.ExpireAllGroups()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expiredGroups) != 1 || len(expiredGroups["b"]) != 1 {
+		t.Fatalf("Expected only group 'b' to be restored")
+	}
+
+	if result := finalize(t, function, expiredGroups["b"]); result != int64(3) {
+		t.Errorf("Expected a count of 3, got %v", result)
+	}
+
+	if err := store.Reset(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInMemoryGroupStoreCheckpointInvalidGroup(t *testing.T) {
+
+	id := []byte("checkpoint-invalid-test")
+	config := map[string]interface{}{
+		"checkpoint-file": filepath.Join(t.TempDir(), "groups.json"),
+	}
+
+	store := restartInMemoryGroupStore(t, config, id).(*InMemoryGroupStore)
+
+	shard, err := store.Shard()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a group of a different type cannot be checkpointed
+	shard.(*InMemoryShard).groupsByHash["a"] = MakeRedisGroup([]byte("a"), nil, 100, nil)
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.checkpoint(); err == nil {
+		t.Fatalf("Expected an error")
+	}
+}
@@ -24,6 +24,7 @@ import (
 type InMemoryGroup struct {
 	mutex         sync.RWMutex
 	state         aggregate.State
+	data          []byte
 	shard         *InMemoryShard
 	groupByValues map[string]interface{}
 	hash          []byte
@@ -59,12 +60,16 @@ func (g *InMemoryGroup) RUnlock() {
 }
 
 func (g *InMemoryGroup) Clone() (aggregate.Group, error) {
-	clonedState, err := g.state.Clone()
-	if err != nil {
-		return nil, err
+	var clonedState aggregate.State
+	if g.state != nil {
+		var err error
+		if clonedState, err = g.state.Clone(); err != nil {
+			return nil, err
+		}
 	}
 	return &InMemoryGroup{
 		state:         clonedState,
+		data:          g.data,
 		shard:         g.shard,
 		groupByValues: g.groupByValues,
 		hash:          g.hash,
@@ -74,7 +79,7 @@ func (g *InMemoryGroup) Clone() (aggregate.Group, error) {
 
 // Returns whether a given group is initialized
 func (g *InMemoryGroup) Initialized() bool {
-	return g.state == nil
+	return g.state != nil
 }
 
 // Initialize the group
@@ -82,6 +87,14 @@ func (g *InMemoryGroup) Initialize(state aggregate.State) error {
 	if g.state != nil {
 		return aggregate.AlreadyInitialized
 	}
+	// if the group was restored from a checkpoint we deserialize its state
+	// into the given one
+	if g.data != nil {
+		if err := state.Deserialize(g.data); err != nil {
+			return err
+		}
+		g.data = nil
+	}
 	g.state = state
 	return nil
 }
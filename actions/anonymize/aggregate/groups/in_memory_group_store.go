@@ -19,16 +19,44 @@ package groups
 import (
 	"encoding/hex"
 	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
 	"github.com/kiprotect/kodex/actions/anonymize/aggregate"
+	"os"
 	"sync"
+	"time"
 )
 
+var InMemoryGroupStoreForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the in-memory group store config",
+	Fields: []forms.Field{
+		{
+			Name: "checkpoint-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// checkpoint interval in seconds
+			Name: "checkpoint-interval",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 60},
+				forms.IsInteger{Min: 1},
+			},
+		},
+	},
+}
+
 type InMemoryGroupStore struct {
-	shards     map[int]*InMemoryShard
-	usedShards map[int]bool
-	id         []byte
-	mutex      sync.RWMutex
-	shardCount int
+	shards             map[int]*InMemoryShard
+	usedShards         map[int]bool
+	id                 []byte
+	mutex              sync.RWMutex
+	shardCount         int
+	checkpointFile     string
+	checkpointInterval time.Duration
+	lastCheckpoint     time.Time
+	maxEventTime       *int64
 }
 
 var Store map[string]*InMemoryGroupStore
@@ -36,30 +64,42 @@ var mutex sync.Mutex
 
 // Create a new InMemoryGroupStore object for the given config
 func MakeInMemoryGroupStore(config map[string]interface{}, id []byte) (aggregate.GroupStore, error) {
+	params, err := InMemoryGroupStoreForm.Validate(config)
+	if err != nil {
+		return nil, err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
 	if Store == nil {
-		mutex.Lock()
-		if Store == nil {
-			Store = make(map[string]*InMemoryGroupStore)
-		}
-		mutex.Unlock()
+		Store = make(map[string]*InMemoryGroupStore)
 	}
 	strId := hex.EncodeToString(id)
-	if Store[strId] == nil {
-		mutex.Lock()
-		if Store[strId] == nil {
-			Store[strId] = &InMemoryGroupStore{
-				shards:     make(map[int]*InMemoryShard),
-				usedShards: make(map[int]bool),
-				id:         id,
-			}
+	if store, ok := Store[strId]; ok {
+		return store, nil
+	}
+	store := &InMemoryGroupStore{
+		shards:             make(map[int]*InMemoryShard),
+		usedShards:         make(map[int]bool),
+		id:                 id,
+		checkpointFile:     params["checkpoint-file"].(string),
+		checkpointInterval: time.Duration(params["checkpoint-interval"].(int64)) * time.Second,
+	}
+	// if a checkpoint file is given, the store periodically writes its groups
+	// to it, so we restore them here
+	if store.checkpointFile != "" {
+		if err := store.restore(); err != nil {
+			return nil, err
 		}
-		mutex.Unlock()
 	}
-	return Store[strId], nil
+	Store[strId] = store
+	return store, nil
 }
 
+// Write a final checkpoint
 func (g *InMemoryGroupStore) Teardown() error {
-	return nil
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.checkpoint()
 }
 
 func (g *InMemoryGroupStore) Return(id int) error {
@@ -73,6 +113,9 @@ func (g *InMemoryGroupStore) Return(id int) error {
 		return fmt.Errorf("Shard is not used")
 	}
 	g.usedShards[id] = false
+	if g.checkpointDue() {
+		return g.checkpoint()
+	}
 	return nil
 }
 
@@ -82,6 +125,12 @@ func (g *InMemoryGroupStore) Reset() error {
 	defer g.mutex.Unlock()
 	g.shards = make(map[int]*InMemoryShard)
 	g.usedShards = make(map[int]bool)
+	g.maxEventTime = nil
+	if g.checkpointFile != "" {
+		if err := os.Remove(g.checkpointFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -119,6 +168,13 @@ func (g *InMemoryGroupStore) ExpireGroups(expiration int64) (map[string][]aggreg
 			}
 		}
 	}
+	// we immediately persist the removal of expired groups so that they
+	// will not be finalized again after a restart
+	if len(expiredGroups) > 0 {
+		if err := g.checkpoint(); err != nil {
+			return nil, err
+		}
+	}
 	return expiredGroups, nil
 }
 
@@ -140,5 +196,32 @@ func (g *InMemoryGroupStore) ExpireAllGroups() (map[string][]aggregate.Group, er
 			}
 		}
 	}
+	// we immediately persist the removal of expired groups so that they
+	// will not be finalized again after a restart
+	if len(expiredGroups) > 0 {
+		if err := g.checkpoint(); err != nil {
+			return nil, err
+		}
+	}
 	return expiredGroups, nil
 }
+
+func (g *InMemoryGroupStore) MaxEventTime() (int64, bool, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	if g.maxEventTime == nil {
+		return 0, false, nil
+	}
+	return *g.maxEventTime, true, nil
+}
+
+// The event time gets persisted with the next checkpoint, which happens at
+// the latest when expired groups are removed from the store.
+func (g *InMemoryGroupStore) UpdateMaxEventTime(eventTime int64) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.maxEventTime == nil || eventTime > *g.maxEventTime {
+		g.maxEventTime = &eventTime
+	}
+	return nil
+}
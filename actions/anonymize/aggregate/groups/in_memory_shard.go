@@ -125,9 +125,16 @@ func (g *InMemoryShard) ID() interface{} {
 // Return a group based on its hash.
 func (g *InMemoryShard) CreateGroup(hash []byte,
 	groupByFields map[string]interface{}, expiration int64) (aggregate.Group, error) {
-	h := string(hash)
 
 	group := MakeInMemoryGroup(hash, groupByFields, expiration, g)
+	g.addGroup(group)
+	return group, nil
+}
+
+// Add a group to the hash and expiration indexes of the shard
+func (g *InMemoryShard) addGroup(group *InMemoryGroup) {
+	h := string(group.hash)
+	expiration := group.expiration
 
 	g.hashMutex.Lock()
 	g.groupsByHash[h] = group
@@ -149,7 +156,6 @@ func (g *InMemoryShard) CreateGroup(hash []byte,
 		g.groupsByTo.Tree.ReplaceOrInsert(gbt)
 	}
 	g.groupsByTo.Mutex.Unlock()
-	return group, nil
 }
 
 func (g *InMemoryShard) ExpireAllGroups() ([]aggregate.Group, error) {
@@ -0,0 +1,112 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package groups
+
+import (
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate"
+	"sync"
+)
+
+// A group that is either created locally in a Redis shard or restored from
+// Redis. Restored groups keep their serialized state until they get
+// initialized with an empty state of the right type.
+type RedisGroup struct {
+	mutex         sync.RWMutex
+	state         aggregate.State
+	data          []byte
+	groupByValues map[string]interface{}
+	hash          []byte
+	expiration    int64
+}
+
+func MakeRedisGroup(hash []byte,
+	groupByValues map[string]interface{},
+	expiration int64,
+	data []byte) *RedisGroup {
+	return &RedisGroup{
+		hash:          hash,
+		groupByValues: groupByValues,
+		expiration:    expiration,
+		data:          data,
+	}
+}
+
+func (g *RedisGroup) Lock() {
+	g.mutex.Lock()
+}
+
+func (g *RedisGroup) Unlock() {
+	g.mutex.Unlock()
+}
+
+func (g *RedisGroup) Clone() (aggregate.Group, error) {
+	var clonedState aggregate.State
+	if g.state != nil {
+		var err error
+		if clonedState, err = g.state.Clone(); err != nil {
+			return nil, err
+		}
+	}
+	return &RedisGroup{
+		state:         clonedState,
+		data:          g.data,
+		groupByValues: g.groupByValues,
+		hash:          g.hash,
+		expiration:    g.expiration,
+	}, nil
+}
+
+// Returns whether a given group is initialized
+func (g *RedisGroup) Initialized() bool {
+	return g.state != nil
+}
+
+// Initialize the group. If the group was restored from Redis we deserialize
+// its state into the given one.
+func (g *RedisGroup) Initialize(state aggregate.State) error {
+	if g.state != nil {
+		return aggregate.AlreadyInitialized
+	}
+	if g.data != nil {
+		if err := state.Deserialize(g.data); err != nil {
+			return err
+		}
+		g.data = nil
+	}
+	g.state = state
+	return nil
+}
+
+// Return the state of the group
+func (g *RedisGroup) State() aggregate.State {
+	return g.state
+}
+
+// Return the group-by fields of a given group
+func (g *RedisGroup) GroupByValues() map[string]interface{} {
+	return g.groupByValues
+}
+
+// Get the expiration value for the group
+func (g *RedisGroup) Expiration() int64 {
+	return g.expiration
+}
+
+// Get the hash for the group
+func (g *RedisGroup) Hash() []byte {
+	return g.hash
+}
@@ -0,0 +1,310 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package groups
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var RedisGroupStoreForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the Redis group store config",
+	Fields: []forms.Field{
+		{
+			Name: "addresses",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsStringList{},
+			},
+		},
+		{
+			Name: "database",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0},
+				// Redis provides 16 databases by default
+				forms.IsInteger{HasMin: true, Min: 0, HasMax: true, Max: 15},
+			},
+		},
+		{
+			Name: "password",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "prefix",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "kodex:aggregate"},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+// Atomically removes all groups that expire before the given value and
+// returns their metadata together with their state, so that a group can only
+// be finalized by a single process.
+var expireScript = redis.NewScript(`
+local hashes = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+local result = {}
+for i, hash in ipairs(hashes) do
+	local statesKey = ARGV[2] .. hash
+	local group = {hash, redis.call('HGET', KEYS[1], hash) or ''}
+	local state = redis.call('GET', statesKey)
+	if state then
+		table.insert(group, state)
+	end
+	redis.call('DEL', statesKey)
+	redis.call('HDEL', KEYS[1], hash)
+	redis.call('ZREM', KEYS[2], hash)
+	table.insert(result, group)
+end
+return result
+`)
+
+var resetScript = redis.NewScript(`
+for i, hash in ipairs(redis.call('HKEYS', KEYS[1])) do
+	redis.call('DEL', ARGV[1] .. hash)
+end
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3])
+return 0
+`)
+
+// Atomically stores the given event time if it is larger than the stored
+// one, as several processes can share the same store.
+var maxEventTimeScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if not current or tonumber(current) < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+type RedisGroupStore struct {
+	client     redis.UniversalClient
+	prefix     string
+	shards     map[int]*RedisShard
+	usedShards map[int]bool
+	id         []byte
+	mutex      sync.Mutex
+	shardCount int
+	function   aggregate.Function
+}
+
+var RedisStore map[string]*RedisGroupStore
+var redisMutex sync.Mutex
+
+// Create a new RedisGroupStore object for the given config. As with the
+// in-memory store, all anonymizers with the same ID share a store.
+func MakeRedisGroupStore(config map[string]interface{}, id []byte) (aggregate.GroupStore, error) {
+	params, err := RedisGroupStoreForm.Validate(config)
+	if err != nil {
+		return nil, err
+	}
+	configHash, err := kodex.StructuredHash(params)
+	if err != nil {
+		return nil, err
+	}
+	key := hex.EncodeToString(id) + ":" + hex.EncodeToString(configHash)
+	redisMutex.Lock()
+	defer redisMutex.Unlock()
+	if RedisStore == nil {
+		RedisStore = make(map[string]*RedisGroupStore)
+	}
+	if store, ok := RedisStore[key]; ok {
+		return store, nil
+	}
+	store, err := makeRedisGroupStore(params, id)
+	if err != nil {
+		return nil, err
+	}
+	RedisStore[key] = store
+	return store, nil
+}
+
+func makeRedisGroupStore(params map[string]interface{}, id []byte) (*RedisGroupStore, error) {
+
+	options := redis.UniversalOptions{
+		Password:     params["password"].(string),
+		ReadTimeout:  time.Second * 1.0,
+		WriteTimeout: time.Second * 1.0,
+		Addrs:        params["addresses"].([]string),
+		DB:           int(params["database"].(int64)),
+	}
+
+	client := redis.NewUniversalClient(&options)
+
+	if _, err := client.Ping().Result(); err != nil {
+		return nil, err
+	}
+
+	return &RedisGroupStore{
+		client: client,
+		// we use a hash tag so that all keys of the store end up in the same
+		// cluster slot, which is required by our scripts
+		prefix:     fmt.Sprintf("%s:{%s}", params["prefix"].(string), hex.EncodeToString(id)),
+		shards:     make(map[int]*RedisShard),
+		usedShards: make(map[int]bool),
+		id:         id,
+	}, nil
+}
+
+// The shards merge their group states with the ones stored in Redis
+// using the given function.
+func (r *RedisGroupStore) SetFunction(function aggregate.Function) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.function = function
+}
+
+func (r *RedisGroupStore) getFunction() aggregate.Function {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.function
+}
+
+func (r *RedisGroupStore) metaKey() string {
+	return r.prefix + ":meta"
+}
+
+func (r *RedisGroupStore) expirationKey() string {
+	return r.prefix + ":expiration"
+}
+
+func (r *RedisGroupStore) maxEventTimeKey() string {
+	return r.prefix + ":max-event-time"
+}
+
+func (r *RedisGroupStore) statesPrefix() string {
+	return r.prefix + ":states:"
+}
+
+func (r *RedisGroupStore) statesKey(hash string) string {
+	return r.statesPrefix() + hash
+}
+
+// The store is shared, so we do not close the client here
+func (r *RedisGroupStore) Teardown() error {
+	return nil
+}
+
+func (r *RedisGroupStore) Return(id int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	used, ok := r.usedShards[id]
+	if !ok {
+		return fmt.Errorf("Shard does not exist")
+	}
+	if !used {
+		return fmt.Errorf("Shard is not used")
+	}
+	r.usedShards[id] = false
+	return nil
+}
+
+// Reset the store
+func (r *RedisGroupStore) Reset() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.shards = make(map[int]*RedisShard)
+	r.usedShards = make(map[int]bool)
+	return resetScript.Run(r.client, []string{r.metaKey(), r.expirationKey(), r.maxEventTimeKey()}, r.statesPrefix()).Err()
+}
+
+func (r *RedisGroupStore) Shard() (aggregate.Shard, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, shard := range r.shards {
+		if used, ok := r.usedShards[i]; !ok || !used {
+			r.usedShards[i] = true
+			return shard, nil
+		}
+	}
+	r.shardCount++
+	newShard := MakeRedisShard(r.shardCount, r)
+	r.shards[r.shardCount] = newShard
+	r.usedShards[r.shardCount] = true
+	return newShard, nil
+}
+
+func (r *RedisGroupStore) expire(max string) (map[string][]aggregate.Group, error) {
+	result, err := expireScript.Run(r.client, []string{r.metaKey(), r.expirationKey()}, max, r.statesPrefix()).Result()
+	if err != nil {
+		return nil, err
+	}
+	groupsList, ok := result.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type")
+	}
+	expiredGroups := make(map[string][]aggregate.Group)
+	for _, groupData := range groupsList {
+		entries, ok := groupData.([]interface{})
+		if !ok || len(entries) < 2 {
+			return nil, fmt.Errorf("unexpected group data")
+		}
+		hexHash, _ := entries[0].(string)
+		metaData, _ := entries[1].(string)
+		hash, err := hex.DecodeString(hexHash)
+		if err != nil {
+			return nil, err
+		}
+		var meta redisGroupMeta
+		if err := json.Unmarshal([]byte(metaData), &meta); err != nil {
+			return nil, err
+		}
+		groups := make([]aggregate.Group, 0, len(entries)-2)
+		for _, state := range entries[2:] {
+			stateData, _ := state.(string)
+			groups = append(groups, MakeRedisGroup(hash, meta.Values, meta.Expiration, []byte(stateData)))
+		}
+		if len(groups) > 0 {
+			expiredGroups[string(hash)] = groups
+		}
+	}
+	return expiredGroups, nil
+}
+
+func (r *RedisGroupStore) ExpireGroups(expiration int64) (map[string][]aggregate.Group, error) {
+	return r.expire("(" + strconv.FormatInt(expiration, 10))
+}
+
+func (r *RedisGroupStore) ExpireAllGroups() (map[string][]aggregate.Group, error) {
+	return r.expire("+inf")
+}
+
+func (r *RedisGroupStore) MaxEventTime() (int64, bool, error) {
+	value, err := r.client.Get(r.maxEventTimeKey()).Int64()
+	if err == redis.Nil {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return value, true, nil
+}
+
+func (r *RedisGroupStore) UpdateMaxEventTime(eventTime int64) error {
+	return maxEventTimeScript.Run(r.client, []string{r.maxEventTimeKey()}, strconv.FormatInt(eventTime, 10)).Err()
+}
@@ -0,0 +1,282 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package groups
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate/functions"
+	"sync"
+	"testing"
+)
+
+func makeTestRedisGroupStore(t *testing.T, server *miniredis.Miniredis) *RedisGroupStore {
+	params, err := RedisGroupStoreForm.Validate(map[string]interface{}{
+		"addresses": []string{server.Addr()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := makeRedisGroupStore(params, []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func addItems(t *testing.T, store aggregate.GroupStore, function aggregate.Function, hash string, expiration int64, n int) {
+	if err := commitItems(store, function, hash, expiration, n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func commitItems(store aggregate.GroupStore, function aggregate.Function, hash string, expiration int64, n int) error {
+	if mergingStore, ok := store.(aggregate.MergingStore); ok {
+		mergingStore.SetFunction(function)
+	}
+	shard, err := store.Shard()
+	if err != nil {
+		return err
+	}
+	defer shard.Return()
+	group, err := shard.GroupByHash([]byte(hash))
+	if err == aggregate.NotFound {
+		if group, err = shard.CreateGroup([]byte(hash), map[string]interface{}{"hash": hash}, expiration); err != nil {
+			return err
+		}
+		if err := function.Initialize(group); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if !group.Initialized() {
+		if err := function.Initialize(group); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < n; i++ {
+		if err := function.Add(kodex.MakeItem(map[string]interface{}{}), group); err != nil {
+			return err
+		}
+	}
+	return shard.Commit()
+}
+
+func finalize(t *testing.T, function aggregate.Function, groups []aggregate.Group) interface{} {
+	for _, group := range groups {
+		if group.Initialized() {
+			t.Fatalf("Restored groups should not be initialized")
+		}
+		if err := function.Initialize(group); err != nil {
+			t.Fatal(err)
+		}
+	}
+	group, err := function.Merge(groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := function.Finalize(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestRedisGroupStoreMerge(t *testing.T) {
+
+	server := miniredis.RunT(t)
+
+	function, err := functions.MakeCountFunction(map[string]interface{}{"epsilon": 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two stores that share the same Redis server simulate two processes
+	storeA := makeTestRedisGroupStore(t, server)
+	storeB := makeTestRedisGroupStore(t, server)
+
+	addItems(t, storeA, function, "a", 100, 2)
+	addItems(t, storeB, function, "a", 100, 3)
+	addItems(t, storeB, function, "b", 200, 1)
+
+	expiredGroups, err := storeA.ExpireGroups(150)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the states of both processes should have been merged in Redis
+	if len(expiredGroups) != 1 || len(expiredGroups["a"]) != 1 {
+		t.Fatalf("Expected one group with a single state, got %v", expiredGroups)
+	}
+
+	if result := finalize(t, function, expiredGroups["a"]); result != int64(5) {
+		t.Errorf("Expected a count of 5, got %v", result)
+	}
+
+	// the group should not be emitted a second time by the other process
+	expiredGroups, err = storeB.ExpireGroups(150)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expiredGroups) != 0 {
+		t.Fatalf("Expected no expired groups, got %d", len(expiredGroups))
+	}
+
+	expiredGroups, err = storeB.ExpireAllGroups()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expiredGroups) != 1 || len(expiredGroups["b"]) != 1 {
+		t.Fatalf("Expected group 'b' to be expired")
+	}
+
+	group := expiredGroups["b"][0]
+
+	if group.Expiration() != 200 || group.GroupByValues()["hash"] != "b" {
+		t.Errorf("Group metadata was not restored correctly")
+	}
+
+	if result := finalize(t, function, expiredGroups["b"]); result != int64(1) {
+		t.Errorf("Expected a count of 1, got %v", result)
+	}
+}
+
+func TestRedisGroupStoreReset(t *testing.T) {
+
+	server := miniredis.RunT(t)
+
+	function, err := functions.MakeUniquesFunction(map[string]interface{}{"id": "id", "epsilon": 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := makeTestRedisGroupStore(t, server)
+
+	addItems(t, store, function, "a", 100, 1)
+
+	if err := store.UpdateMaxEventTime(100); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Fatalf("Expected no keys after a reset, got %v", keys)
+	}
+
+	expiredGroups, err := store.ExpireAllGroups()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expiredGroups) != 0 {
+		t.Fatalf("Expected no groups after a reset")
+	}
+}
+
+func TestRedisGroupStoreMaxEventTime(t *testing.T) {
+
+	server := miniredis.RunT(t)
+
+	store := makeTestRedisGroupStore(t, server)
+
+	if _, ok, err := store.MaxEventTime(); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatalf("Expected no max event time")
+	}
+
+	for _, eventTime := range []int64{200, 100} {
+		if err := store.UpdateMaxEventTime(eventTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// another store for the same Redis server simulates a restart
+	if maxEventTime, ok, err := makeTestRedisGroupStore(t, server).MaxEventTime(); err != nil {
+		t.Fatal(err)
+	} else if !ok || maxEventTime != 200 {
+		t.Fatalf("Expected a max event time of 200, got %d", maxEventTime)
+	}
+}
+
+func TestRedisGroupStoreConcurrentCommits(t *testing.T) {
+
+	server := miniredis.RunT(t)
+
+	function, err := functions.MakeCountFunction(map[string]interface{}{"epsilon": 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := []*RedisGroupStore{makeTestRedisGroupStore(t, server), makeTestRedisGroupStore(t, server)}
+
+	var wg sync.WaitGroup
+
+	for _, store := range stores {
+		wg.Add(1)
+		go func(store *RedisGroupStore) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if err := commitItems(store, function, "a", 100, 1); err != nil {
+					t.Error(err)
+				}
+			}
+		}(store)
+	}
+
+	wg.Wait()
+
+	// every group keeps a single state in Redis, however often it was committed
+	if keys := server.Keys(); len(keys) != 3 {
+		t.Fatalf("Expected three keys, got %v", keys)
+	}
+
+	expiredGroups, err := stores[0].ExpireAllGroups()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expiredGroups["a"]) != 1 {
+		t.Fatalf("Expected group 'a' with a single state")
+	}
+
+	if result := finalize(t, function, expiredGroups["a"]); result != int64(40) {
+		t.Errorf("Expected a count of 40, got %v", result)
+	}
+}
+
+func TestRedisGroupStoreForm(t *testing.T) {
+	for _, database := range []int{-1, 16} {
+		if _, err := RedisGroupStoreForm.Validate(map[string]interface{}{
+			"addresses": []string{"localhost:6379"},
+			"database":  database,
+		}); err == nil {
+			t.Errorf("Expected an error for database %d", database)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package groups
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// A Redis shard collects group states locally and merges them into the
+// states stored in Redis when it gets committed, so several shards (and
+// processes) can work on the same groups while Redis keeps a single state per
+// group.
+type RedisShard struct {
+	id     int
+	store  *RedisGroupStore
+	groups map[string]*RedisGroup
+	mutex  sync.Mutex
+}
+
+type redisGroupMeta struct {
+	Values     map[string]interface{} `json:"values"`
+	Expiration int64                  `json:"expiration"`
+}
+
+func MakeRedisShard(id int, store *RedisGroupStore) *RedisShard {
+	return &RedisShard{
+		id:     id,
+		store:  store,
+		groups: make(map[string]*RedisGroup),
+	}
+}
+
+func (r *RedisShard) ID() interface{} {
+	return r.id
+}
+
+// Return a group based on its hash
+func (r *RedisShard) GroupByHash(hash []byte) (aggregate.Group, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	group, ok := r.groups[string(hash)]
+	if !ok {
+		return nil, aggregate.NotFound
+	}
+	return group, nil
+}
+
+// Create a group in the shard
+func (r *RedisShard) CreateGroup(hash []byte,
+	groupByValues map[string]interface{}, expiration int64) (aggregate.Group, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	group := MakeRedisGroup(hash, groupByValues, expiration, nil)
+	r.groups[string(hash)] = group
+	return group, nil
+}
+
+// The number of times we retry a commit if another process modified one of
+// the group states concurrently
+const maxCommitAttempts = 10
+
+// Merge the local group states into the ones stored in Redis. We watch the
+// stored states so that all changes are written in a single transaction that
+// fails if another process modified them in the meantime, afterwards the shard
+// starts with an empty state again.
+func (r *RedisShard) Commit() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.groups) == 0 {
+		return nil
+	}
+
+	function := r.store.getFunction()
+
+	if function == nil {
+		return fmt.Errorf("no aggregate function set for the Redis group store")
+	}
+
+	groups := make([]*RedisGroup, 0, len(r.groups))
+	keys := make([]string, 0, len(r.groups))
+
+	for _, group := range r.groups {
+		group.Lock()
+		initialized := group.Initialized()
+		group.Unlock()
+		if !initialized {
+			continue
+		}
+		groups = append(groups, group)
+		keys = append(keys, r.store.statesKey(hex.EncodeToString(group.Hash())))
+	}
+
+	if len(groups) > 0 {
+		var err error
+		for i := 0; i < maxCommitAttempts; i++ {
+			if err = r.store.client.Watch(func(tx *redis.Tx) error {
+				return r.commit(tx, function, groups, keys)
+			}, keys...); err != redis.TxFailedErr {
+				break
+			}
+			// we wait for a random time so that competing processes do not
+			// keep invalidating each other's transactions
+			time.Sleep(time.Duration(rand.Int63n(int64(i+1) * int64(time.Millisecond))))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	r.groups = make(map[string]*RedisGroup)
+	return nil
+}
+
+func (r *RedisShard) commit(tx *redis.Tx, function aggregate.Function, groups []*RedisGroup, keys []string) error {
+
+	storedStates, err := tx.MGet(keys...).Result()
+
+	if err != nil {
+		return err
+	}
+
+	states := make([][]byte, len(groups))
+
+	for i, group := range groups {
+		var merged aggregate.Group = group
+		if data, ok := storedStates[i].(string); ok {
+			// we merge the local state into the stored one, which leaves the
+			// local state unchanged in case we need to retry the transaction
+			storedGroup := MakeRedisGroup(group.Hash(), group.GroupByValues(), group.Expiration(), []byte(data))
+			if err := function.Initialize(storedGroup); err != nil {
+				return err
+			}
+			if merged, err = function.Merge([]aggregate.Group{storedGroup, group}); err != nil {
+				return err
+			}
+		}
+		merged.Lock()
+		states[i], err = merged.State().Serialize()
+		merged.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, group := range groups {
+			meta, err := json.Marshal(&redisGroupMeta{
+				Values:     group.GroupByValues(),
+				Expiration: group.Expiration(),
+			})
+			if err != nil {
+				return err
+			}
+			h := hex.EncodeToString(group.Hash())
+			pipe.HSetNX(r.store.metaKey(), h, meta)
+			pipe.ZAddNX(r.store.expirationKey(), redis.Z{
+				Score:  float64(group.Expiration()),
+				Member: h,
+			})
+			pipe.Set(keys[i], states[i], 0)
+		}
+		return nil
+	})
+
+	return err
+}
+
+// Return the shard to the store
+func (r *RedisShard) Return() error {
+	return r.store.Return(r.id)
+}
+
+// Expire uncommitted groups in the shard
+func (r *RedisShard) ExpireGroups(expiration int64) ([]aggregate.Group, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	expiredGroups := make([]aggregate.Group, 0)
+	for h, group := range r.groups {
+		if group.Expiration() < expiration {
+			delete(r.groups, h)
+			expiredGroups = append(expiredGroups, group)
+		}
+	}
+	return expiredGroups, nil
+}
+
+// Expire all uncommitted groups in the shard
+func (r *RedisShard) ExpireAllGroups() ([]aggregate.Group, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	expiredGroups := make([]aggregate.Group, 0, len(r.groups))
+	for _, group := range r.groups {
+		expiredGroups = append(expiredGroups, group)
+	}
+	r.groups = make(map[string]*RedisGroup)
+	return expiredGroups, nil
+}
@@ -0,0 +1,156 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package anonymize_test
+
+import (
+	"github.com/kiprotect/go-helpers/settings"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions/anonymize"
+	"testing"
+)
+
+func makeBudgetAggregate(t *testing.T, onExhausted string) anonymize.Anonymizer {
+
+	aggregate, err := anonymize.MakeAggregateAnonymizer("counts", []byte(onExhausted), map[string]interface{}{
+		"function": "count",
+		"config": map[string]interface{}{
+			"epsilon": 10000,
+		},
+		"group-by": []map[string]interface{}{
+			{
+				"function": "value",
+				"config": map[string]interface{}{
+					"field": "type",
+				},
+			},
+		},
+		"privacy-budget": map[string]interface{}{
+			"id":           onExhausted,
+			"on-exhausted": onExhausted,
+		},
+		"finalize-after": -1,
+		"channels":       []string{"counts"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := settings.MakeSettings(nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Update(map[string]interface{}{
+		"privacy-budget": map[string]interface{}{
+			"type": "in-memory",
+			"budgets": map[string]interface{}{
+				"default": map[string]interface{}{
+					// enough budget for two releases
+					"epsilon": 25000,
+				},
+			},
+		},
+	})
+
+	if err := aggregate.(kodex.SetupAction).Setup(s); err != nil {
+		t.Fatal(err)
+	}
+
+	return aggregate
+}
+
+// Anonymizes one item per group and finalizes the groups, returning the
+// number of results.
+func releaseGroups(t *testing.T, aggregate anonymize.Anonymizer) (int, error) {
+
+	writer := kodex.MakeInMemoryChannelWriter()
+
+	for _, tp := range []string{"a", "b", "c"} {
+		if _, err := aggregate.Anonymize(kodex.MakeItem(map[string]interface{}{"type": tp}), writer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := aggregate.(kodex.StatefulAction).Finalize(writer)
+
+	return len(writer.Items["counts"]), err
+}
+
+func TestAggregatePrivacyBudget(t *testing.T) {
+
+	aggregate := makeBudgetAggregate(t, "suppress")
+
+	// the groups are disjoint, so every release of all groups consumes the
+	// budget only once
+	for i := 0; i < 2; i++ {
+		if n, err := releaseGroups(t, aggregate); err != nil {
+			t.Fatal(err)
+		} else if n != 3 {
+			t.Fatalf("Expected 3 results, got %d", n)
+		}
+	}
+
+	// the third release exceeds the budget and all results should be suppressed
+	if n, err := releaseGroups(t, aggregate); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("Expected no results, got %d", n)
+	}
+
+	aggregate = makeBudgetAggregate(t, "error")
+
+	for i := 0; i < 2; i++ {
+		if _, err := releaseGroups(t, aggregate); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := releaseGroups(t, aggregate); err == nil {
+		t.Fatalf("Expected an error when the budget is exhausted")
+	}
+}
+
+func TestAggregatePrivacyBudgetWithoutSetup(t *testing.T) {
+
+	aggregate, err := anonymize.MakeAggregateAnonymizer("counts", []byte("without-setup"), map[string]interface{}{
+		"function": "count",
+		"config": map[string]interface{}{
+			"epsilon": 1,
+		},
+		"group-by": []map[string]interface{}{
+			{
+				"function": "value",
+				"config": map[string]interface{}{
+					"field": "type",
+				},
+			},
+		},
+		"finalize-after": -1,
+		"channels":       []string{"counts"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a ledger we cannot account for the release, so it should fail
+	if _, err := releaseGroups(t, aggregate); err == nil {
+		t.Fatalf("Expected an error without a privacy budget ledger")
+	}
+}
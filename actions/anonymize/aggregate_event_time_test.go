@@ -0,0 +1,182 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package anonymize_test
+
+import (
+	"encoding/hex"
+	"github.com/kiprotect/go-helpers/settings"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/actions/anonymize"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate/groups"
+	"path/filepath"
+	"testing"
+)
+
+func makeEventTimeAggregate(t *testing.T, lateData string) anonymize.Anonymizer {
+	return makeEventTimeAggregateWithStore(t, lateData, []byte("event-time-"+lateData), map[string]interface{}{})
+}
+
+func makeEventTimeAggregateWithStore(t *testing.T, lateData string, id []byte, groupStore map[string]interface{}) anonymize.Anonymizer {
+	aggregate, err := anonymize.MakeAggregateAnonymizer("counts", id, map[string]interface{}{
+		"function": "count",
+		"config": map[string]interface{}{
+			"epsilon": 10000,
+		},
+		"group-by": []map[string]interface{}{
+			{
+				"function": "time-window",
+				"config": map[string]interface{}{
+					"field":  "timestamp",
+					"format": "rfc3339",
+					"window": "hour",
+				},
+			},
+		},
+		"time": map[string]interface{}{
+			"mode":          "event",
+			"field":         "timestamp",
+			"late-data":     lateData,
+			"late-channels": []string{"late"},
+		},
+		"finalize-after": 0,
+		"channels":       []string{"counts"},
+		"group-store":    groupStore,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := settings.MakeSettings(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := aggregate.(kodex.SetupAction).Setup(s); err != nil {
+		t.Fatal(err)
+	}
+	return aggregate
+}
+
+func anonymizeTimestamps(t *testing.T, aggregate anonymize.Anonymizer, writer kodex.ChannelWriter, timestamps ...string) {
+	for _, timestamp := range timestamps {
+		if _, err := aggregate.Anonymize(kodex.MakeItem(map[string]interface{}{"timestamp": timestamp}), writer); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAggregateEventTime(t *testing.T) {
+
+	aggregate := makeEventTimeAggregate(t, "channel")
+	writer := kodex.MakeInMemoryChannelWriter()
+
+	// historical items should be finalized based on their own time
+	anonymizeTimestamps(t, aggregate, writer, "2020-01-01T10:10:00Z", "2020-01-01T10:50:00Z")
+
+	if len(writer.Items["counts"]) != 0 {
+		t.Fatalf("Expected no results before the watermark passes the window")
+	}
+
+	anonymizeTimestamps(t, aggregate, writer, "2020-01-01T11:05:00Z")
+
+	if len(writer.Items["counts"]) != 1 {
+		t.Fatalf("Expected one result, got %d", len(writer.Items["counts"]))
+	}
+
+	if count, _ := writer.Items["counts"][0].Get("counts"); count != int64(2) {
+		t.Errorf("Expected a count of 2, got %v", count)
+	}
+
+	// this item belongs to a window that was already finalized
+	anonymizeTimestamps(t, aggregate, writer, "2020-01-01T10:30:00Z")
+
+	if len(writer.Items["late"]) != 1 {
+		t.Fatalf("Expected the late item to be routed to the late channel")
+	}
+
+	if _, err := aggregate.(kodex.StatefulAction).Finalize(writer); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(writer.Items["counts"]) != 2 {
+		t.Fatalf("Expected two results, got %d", len(writer.Items["counts"]))
+	}
+}
+
+func TestAggregateEventTimeCorrection(t *testing.T) {
+
+	aggregate := makeEventTimeAggregate(t, "correction")
+	writer := kodex.MakeInMemoryChannelWriter()
+
+	anonymizeTimestamps(t, aggregate, writer,
+		"2020-01-01T10:10:00Z",
+		"2020-01-01T11:05:00Z",
+		// two late items for the finalized window
+		"2020-01-01T10:20:00Z",
+		"2020-01-01T10:30:00Z",
+		// this moves the watermark past the correction group
+		"2020-01-01T11:10:00Z",
+	)
+
+	if len(writer.Items["counts"]) != 2 {
+		t.Fatalf("Expected two results, got %d", len(writer.Items["counts"]))
+	}
+
+	correction := writer.Items["counts"][1]
+
+	if group, _ := correction.Get("group"); group.(map[string]interface{})["correction"] != true {
+		t.Fatalf("Expected a correction result")
+	}
+
+	if count, _ := correction.Get("counts"); count != int64(2) {
+		t.Errorf("Expected a correction count of 2, got %v", count)
+	}
+}
+
+func TestAggregateEventTimeRestart(t *testing.T) {
+
+	id := []byte("event-time-restart")
+	groupStore := map[string]interface{}{
+		"type": "in-memory",
+		"config": map[string]interface{}{
+			"checkpoint-file": filepath.Join(t.TempDir(), "groups.json"),
+		},
+	}
+
+	aggregate := makeEventTimeAggregateWithStore(t, "channel", id, groupStore)
+	writer := kodex.MakeInMemoryChannelWriter()
+
+	anonymizeTimestamps(t, aggregate, writer, "2020-01-01T10:10:00Z", "2020-01-01T11:05:00Z")
+
+	if len(writer.Items["counts"]) != 1 {
+		t.Fatalf("Expected one result, got %d", len(writer.Items["counts"]))
+	}
+
+	if err := aggregate.(kodex.TeardownAction).Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// we simulate a restart by dropping the cached group store
+	delete(groups.Store, hex.EncodeToString(id))
+
+	aggregate = makeEventTimeAggregateWithStore(t, "channel", id, groupStore)
+
+	// the finalized window must not be reopened after the restart
+	anonymizeTimestamps(t, aggregate, writer, "2020-01-01T10:30:00Z")
+
+	if len(writer.Items["late"]) != 1 {
+		t.Fatalf("Expected the late item to be routed to the late channel")
+	}
+}
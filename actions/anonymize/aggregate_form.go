@@ -21,8 +21,10 @@ import (
 	"github.com/kiprotect/go-helpers/errors"
 	"github.com/kiprotect/go-helpers/forms"
 	"github.com/kiprotect/kodex/actions/anonymize/aggregate"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate/filter_functions"
 	"github.com/kiprotect/kodex/actions/anonymize/aggregate/functions"
 	"github.com/kiprotect/kodex/actions/anonymize/aggregate/group_by_functions"
+	"github.com/kiprotect/kodex/actions/anonymize/aggregate/groups"
 )
 
 type Function struct {
@@ -135,13 +137,284 @@ var GroupByValueForm = forms.Form{
 	},
 }
 
+var GroupByBucketForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "field",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "type",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "fixed"},
+				forms.IsString{},
+				forms.IsIn{Choices: []interface{}{"fixed", "edges", "quantiles"}},
+			},
+		},
+		{
+			Name: "width",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsFloat{HasMin: true, Min: 1e-12},
+			},
+		},
+		{
+			Name: "offset",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0.0},
+				forms.IsFloat{},
+			},
+		},
+		{
+			Name: "edges",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsFloat{},
+					},
+				},
+			},
+		},
+		{
+			Name: "quantiles",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsFloat{HasMin: true, Min: 0, HasMax: true, Max: 1},
+					},
+				},
+			},
+		},
+		{
+			// reference values from which we calculate the quantile edges
+			Name: "reference",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsFloat{},
+					},
+				},
+			},
+		},
+	},
+}
+
+var GroupByGeoForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "latitude",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "longitude",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "type",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "geohash"},
+				forms.IsString{},
+				forms.IsIn{Choices: []interface{}{"geohash", "grid"}},
+			},
+		},
+		{
+			Name: "precision",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 5},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 12},
+			},
+		},
+		{
+			// the size of a grid cell in degrees
+			Name: "cell-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0.1},
+				forms.IsFloat{HasMin: true, Min: 1e-6, HasMax: true, Max: 180},
+			},
+		},
+	},
+}
+
+type IsFilter struct{}
+
+func (i IsFilter) Validate(input interface{}, values map[string]interface{}) (interface{}, error) {
+	config, ok := input.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("IsFilter: expected a string map")
+	}
+	params, err := FilterForm.Validate(config)
+	if err != nil {
+		return nil, err
+	}
+	name := params["function"].(string)
+	f, ok := filterFunctions.Functions[name]
+	if !ok {
+		return nil, errors.MakeExternalError("unknown filter function", "AGGREGATE", name, nil)
+	}
+	filterFunction, err := f(params["config"].(map[string]interface{}))
+	if err != nil {
+		return nil, errors.MakeExternalError("cannot initialize filter function", "AGGREGATE", name, err)
+	}
+	return filterFunction, nil
+}
+
+func filterFunctionValues() []interface{} {
+	values := make([]interface{}, 0)
+	for key, _ := range filterFunctions.Functions {
+		values = append(values, key)
+	}
+	return values
+}
+
+var FilterEqualsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "field",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "value",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+			},
+		},
+	},
+}
+
+var FilterInForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "field",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "values",
+			Validators: []forms.Validator{
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.CanBeAnything{},
+					},
+				},
+			},
+		},
+	},
+}
+
+var FilterRangeForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "field",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "min",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsFloat{},
+			},
+		},
+		{
+			Name: "max",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsFloat{},
+			},
+		},
+		{
+			Name: "exclusive-min",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+		{
+			Name: "exclusive-max",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+	},
+}
+
+var FilterRegexForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "field",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "pattern",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+var FilterExistsForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "field",
+			Validators: []forms.Validator{
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+var FilterCombinationForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "filters",
+			Validators: []forms.Validator{
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsStringMap{},
+						IsFilter{},
+					},
+				},
+			},
+		},
+	},
+}
+
+var FilterNotForm = forms.Form{
+	Fields: []forms.Field{
+		{
+			Name: "filter",
+			Validators: []forms.Validator{
+				forms.IsStringMap{},
+				IsFilter{},
+			},
+		},
+	},
+}
+
 var FilterForm = forms.Form{
 	ErrorMsg: "invalid data encountered in the aggregation filter form",
 	Fields: []forms.Field{
 		{
 			Name: "function",
 			Validators: []forms.Validator{
-				forms.IsIn{Choices: []interface{}{}},
+				forms.IsIn{Choices: filterFunctionValues()},
 			},
 		},
 		{
@@ -151,14 +424,44 @@ var FilterForm = forms.Form{
 				forms.Switch{
 					Key: "function",
 					Cases: map[string][]forms.Validator{
-						"time-window": {
+						"equals": {
 							forms.IsStringMap{
-								Form: &GroupByTimeWindowForm,
+								Form: &FilterEqualsForm,
 							},
 						},
-						"value": {
+						"in": {
 							forms.IsStringMap{
-								Form: &GroupByValueForm,
+								Form: &FilterInForm,
+							},
+						},
+						"range": {
+							forms.IsStringMap{
+								Form: &FilterRangeForm,
+							},
+						},
+						"regex": {
+							forms.IsStringMap{
+								Form: &FilterRegexForm,
+							},
+						},
+						"exists": {
+							forms.IsStringMap{
+								Form: &FilterExistsForm,
+							},
+						},
+						"and": {
+							forms.IsStringMap{
+								Form: &FilterCombinationForm,
+							},
+						},
+						"or": {
+							forms.IsStringMap{
+								Form: &FilterCombinationForm,
+							},
+						},
+						"not": {
+							forms.IsStringMap{
+								Form: &FilterNotForm,
 							},
 						},
 					},
@@ -174,7 +477,7 @@ var GroupByForm = forms.Form{
 		{
 			Name: "function",
 			Validators: []forms.Validator{
-				forms.IsIn{Choices: []interface{}{"time-window", "value"}},
+				forms.IsIn{Choices: []interface{}{"time-window", "value", "bucket", "geo"}},
 			},
 		},
 		{
@@ -201,6 +504,16 @@ var GroupByForm = forms.Form{
 								Form: &GroupByValueForm,
 							},
 						},
+						"bucket": {
+							forms.IsStringMap{
+								Form: &GroupByBucketForm,
+							},
+						},
+						"geo": {
+							forms.IsStringMap{
+								Form: &GroupByGeoForm,
+							},
+						},
 					},
 				},
 			},
@@ -208,6 +521,107 @@ var GroupByForm = forms.Form{
 	},
 }
 
+func groupStoreValues() []interface{} {
+	values := make([]interface{}, 0)
+	for key, _ := range groups.GroupStores {
+		values = append(values, key)
+	}
+	return values
+}
+
+var GroupStoreForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the group store form",
+	Fields: []forms.Field{
+		{
+			Name: "type",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "in-memory"},
+				forms.IsString{},
+				forms.IsIn{Choices: groupStoreValues()},
+			},
+		},
+		{
+			Name: "config",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+	},
+}
+
+var AggregateTimeForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the aggregate time form",
+	Fields: []forms.Field{
+		{
+			// in event mode we finalize groups based on the time of the items
+			Name: "mode",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "processing"},
+				forms.IsIn{Choices: []interface{}{"processing", "event"}},
+			},
+		},
+		{
+			Name: "field",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "format",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "rfc3339"},
+				forms.IsString{},
+				forms.IsIn{Choices: timeFormatValues()},
+			},
+		},
+		{
+			// allowed lateness of items in seconds
+			Name: "allowed-lateness",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+		{
+			Name: "late-data",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "drop"},
+				forms.IsIn{Choices: []interface{}{"drop", "channel", "correction"}},
+			},
+		},
+		{
+			Name: "late-channels",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []string{}},
+				forms.IsStringList{},
+			},
+		},
+	},
+}
+
+var PrivacyBudgetForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the privacy budget form",
+	Fields: []forms.Field{
+		{
+			// the ID of the project or dataset budget in the ledger
+			Name: "id",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "default"},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "on-exhausted",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "suppress"},
+				forms.IsIn{Choices: []interface{}{"suppress", "error"}},
+			},
+		},
+	},
+}
+
 var AggregateForm = forms.Form{
 	ErrorMsg: "invalid data encountered in the aggregation config",
 	Fields: []forms.Field{
@@ -232,9 +646,8 @@ var AggregateForm = forms.Form{
 				forms.IsOptional{Default: []interface{}{}},
 				forms.IsList{
 					Validators: []forms.Validator{
-						forms.IsStringMap{
-							Form: &FilterForm,
-						},
+						forms.IsStringMap{},
+						IsFilter{},
 					},
 				},
 			},
@@ -252,6 +665,33 @@ var AggregateForm = forms.Form{
 				},
 			},
 		},
+		{
+			Name: "group-store",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{
+					Form: &GroupStoreForm,
+				},
+			},
+		},
+		{
+			Name: "time",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{
+					Form: &AggregateTimeForm,
+				},
+			},
+		},
+		{
+			Name: "privacy-budget",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{
+					Form: &PrivacyBudgetForm,
+				},
+			},
+		},
 		{
 			Name: "result-name",
 			Validators: []forms.Validator{
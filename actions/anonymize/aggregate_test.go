@@ -684,6 +684,337 @@ var tests = []AggregateTest{
 			},
 		},
 	},
+	AggregateTest{
+		Config: map[string]interface{}{
+			"destinations": []map[string]interface{}{},
+			"actions": []map[string]interface{}{
+				{
+					"name": "completed-counts",
+					"type": "anonymize",
+					"config": map[string]interface{}{
+						"method":   "aggregate",
+						"function": "count",
+						"config": map[string]interface{}{
+							"epsilon": 10000,
+						},
+						"filters": []map[string]interface{}{
+							{
+								"function": "equals",
+								"config": map[string]interface{}{
+									"field": "status",
+									"value": "completed",
+								},
+							},
+							{
+								"function": "range",
+								"config": map[string]interface{}{
+									"field":         "amount",
+									"min":           0,
+									"exclusive-min": true,
+								},
+							},
+						},
+						"group-by": []map[string]interface{}{
+							{
+								"function":        "value",
+								"always-included": true,
+								"config": map[string]interface{}{
+									"field": "type",
+								},
+							},
+						},
+						"result-name":    "count",
+						"channels":       []string{"counts"},
+						"finalize-after": -1,
+					},
+				},
+			},
+			"streams": []map[string]interface{}{
+				{
+					"name": "default",
+					"configs": []map[string]interface{}{
+						{
+							"name":   "default",
+							"status": "active",
+							"actions": []map[string]interface{}{
+								map[string]interface{}{
+									"name": "completed-counts",
+								},
+							},
+							"destinations": []map[string]interface{}{},
+						},
+					},
+				},
+			},
+		},
+		Items: []map[string]interface{}{
+			map[string]interface{}{
+				"type":   "a",
+				"status": "completed",
+				"amount": 10,
+			},
+			map[string]interface{}{
+				"type":   "a",
+				"status": "completed",
+				"amount": 0,
+			},
+			map[string]interface{}{
+				"type":   "a",
+				"status": "pending",
+				"amount": 5,
+			},
+			map[string]interface{}{
+				"type":   "b",
+				"status": "completed",
+				"amount": 3.5,
+			},
+			map[string]interface{}{
+				"type":   "b",
+				"status": "completed",
+				"amount": 7,
+			},
+		},
+		Result: map[string][]map[string]interface{}{
+			"counts": []map[string]interface{}{
+				map[string]interface{}{
+					"count": 1,
+					"group": map[string]interface{}{"type": "a"},
+				},
+				map[string]interface{}{
+					"count": 2,
+					"group": map[string]interface{}{"type": "b"},
+				},
+			},
+		},
+	},
+	AggregateTest{
+		Config: map[string]interface{}{
+			"destinations": []map[string]interface{}{},
+			"actions": []map[string]interface{}{
+				{
+					"name": "filtered-country-counts",
+					"type": "anonymize",
+					"config": map[string]interface{}{
+						"method":   "aggregate",
+						"function": "count",
+						"config": map[string]interface{}{
+							"epsilon": 10000,
+						},
+						"filters": []map[string]interface{}{
+							{
+								"function": "or",
+								"config": map[string]interface{}{
+									"filters": []map[string]interface{}{
+										{
+											"function": "regex",
+											"config": map[string]interface{}{
+												"field":   "email",
+												"pattern": "@example\\.com$",
+											},
+										},
+										{
+											"function": "and",
+											"config": map[string]interface{}{
+												"filters": []map[string]interface{}{
+													{
+														"function": "in",
+														"config": map[string]interface{}{
+															"field":  "country",
+															"values": []string{"de", "fr"},
+														},
+													},
+													{
+														"function": "not",
+														"config": map[string]interface{}{
+															"filter": map[string]interface{}{
+																"function": "exists",
+																"config": map[string]interface{}{
+																	"field": "deleted",
+																},
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						"group-by": []map[string]interface{}{
+							{
+								"function":        "value",
+								"always-included": true,
+								"config": map[string]interface{}{
+									"field": "country",
+								},
+							},
+						},
+						"result-name":    "count",
+						"channels":       []string{"counts"},
+						"finalize-after": -1,
+					},
+				},
+			},
+			"streams": []map[string]interface{}{
+				{
+					"name": "default",
+					"configs": []map[string]interface{}{
+						{
+							"name":   "default",
+							"status": "active",
+							"actions": []map[string]interface{}{
+								map[string]interface{}{
+									"name": "filtered-country-counts",
+								},
+							},
+							"destinations": []map[string]interface{}{},
+						},
+					},
+				},
+			},
+		},
+		Items: []map[string]interface{}{
+			map[string]interface{}{
+				"country": "de",
+				"email":   "x@example.com",
+			},
+			map[string]interface{}{
+				"country": "de",
+				"email":   "y@other.org",
+			},
+			map[string]interface{}{
+				"country": "fr",
+				"email":   "z@other.org",
+				"deleted": true,
+			},
+			map[string]interface{}{
+				"country": "us",
+				"email":   "w@other.org",
+			},
+			map[string]interface{}{
+				"country": "us",
+				"email":   "v@example.com",
+			},
+		},
+		Result: map[string][]map[string]interface{}{
+			"counts": []map[string]interface{}{
+				map[string]interface{}{
+					"count": 2,
+					"group": map[string]interface{}{"country": "de"},
+				},
+				map[string]interface{}{
+					"count": 1,
+					"group": map[string]interface{}{"country": "us"},
+				},
+			},
+		},
+	},
+	AggregateTest{
+		Config: map[string]interface{}{
+			"destinations": []map[string]interface{}{},
+			"actions": []map[string]interface{}{
+				{
+					"name": "counts-by-region-and-age",
+					"type": "anonymize",
+					"config": map[string]interface{}{
+						"method":   "aggregate",
+						"function": "count",
+						"config": map[string]interface{}{
+							"epsilon": 10000,
+						},
+						"group-by": []map[string]interface{}{
+							{
+								"function":        "geo",
+								"always-included": true,
+								"config": map[string]interface{}{
+									"latitude":  "lat",
+									"longitude": "lon",
+									"precision": 3,
+								},
+							},
+							{
+								"function":        "bucket",
+								"always-included": true,
+								"config": map[string]interface{}{
+									"field": "age",
+									"type":  "edges",
+									"edges": []interface{}{18, 65},
+								},
+							},
+						},
+						"result-name":    "count",
+						"channels":       []string{"counts"},
+						"finalize-after": -1,
+					},
+				},
+			},
+			"streams": []map[string]interface{}{
+				{
+					"name": "default",
+					"configs": []map[string]interface{}{
+						{
+							"name":   "default",
+							"status": "active",
+							"actions": []map[string]interface{}{
+								map[string]interface{}{
+									"name": "counts-by-region-and-age",
+								},
+							},
+							"destinations": []map[string]interface{}{},
+						},
+					},
+				},
+			},
+		},
+		Items: []map[string]interface{}{
+			map[string]interface{}{
+				"lat": 52.52,
+				"lon": 13.405,
+				"age": 34,
+			},
+			map[string]interface{}{
+				"lat": 52.40,
+				"lon": 13.06,
+				"age": 40,
+			},
+			map[string]interface{}{
+				"lat": 52.52,
+				"lon": 13.405,
+				"age": 70,
+			},
+			map[string]interface{}{
+				"lat": 48.137,
+				"lon": 11.575,
+				"age": 20,
+			},
+		},
+		Result: map[string][]map[string]interface{}{
+			"counts": []map[string]interface{}{
+				map[string]interface{}{
+					"count": 2,
+					"group": map[string]interface{}{
+						"geohash": "u33",
+						"age":     map[string]interface{}{"from": 18.0, "to": 65.0},
+					},
+				},
+				map[string]interface{}{
+					"count": 1,
+					"group": map[string]interface{}{
+						"geohash": "u33",
+						"age":     map[string]interface{}{"from": 65.0},
+					},
+				},
+				map[string]interface{}{
+					"count": 1,
+					"group": map[string]interface{}{
+						"geohash": "u28",
+						"age":     map[string]interface{}{"from": 18.0, "to": 65.0},
+					},
+				},
+			},
+		},
+	},
 }
 
 func numericValue(value interface{}) (float64, bool) {
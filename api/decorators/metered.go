@@ -22,6 +22,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/kiprotect/kodex"
 	"github.com/kiprotect/kodex/api"
+	"github.com/kiprotect/kodex/metrics"
 	"strings"
 	"time"
 )
@@ -59,6 +60,9 @@ func MeterEndpointCalls(meter kodex.Meter, meterId string) func(*gin.Context) {
 
 		path := c.Request.URL.Path
 
+		// we use the route instead of the path to keep the number of series small
+		metrics.APIRequests.WithLabelValues(c.Request.Method, c.FullPath()).Inc()
+
 		pathComponents := strings.Split(path, "/")
 
 		now := time.Now().UTC().UnixNano()
@@ -28,7 +28,7 @@ var AddConfigDestinationForm = forms.Form{
 			Name: "status",
 			Validators: []forms.Validator{
 				forms.IsRequired{},
-				forms.IsIn{Choices: []interface{}{"active", "disabled", "testing"}},
+				forms.IsIn{Choices: []interface{}{"active", "disabled", "testing", "dead-letter"}},
 			},
 		},
 		{
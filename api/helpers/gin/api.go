@@ -26,6 +26,7 @@ import (
 	"github.com/kiprotect/kodex"
 	"github.com/kiprotect/kodex/api"
 	"github.com/kiprotect/kodex/helpers"
+	"github.com/kiprotect/kodex/metrics"
 )
 
 type tcpKeepAliveListener struct {
@@ -64,6 +65,12 @@ func Router(controller api.Controller, prefix string, decorator gin.HandlerFunc)
 		return nil, err
 	}
 
+	// the metrics are not authenticated, so we only expose them (in the
+	// Prometheus format) if they are enabled explicitly
+	if enabled, _ := controller.Settings().Bool("metrics.enable"); enabled {
+		g.GET("/metrics", gin.WrapH(metrics.Handler()))
+	}
+
 	group, err := InitializeRouterGroup(g, prefix, controller)
 
 	if err != nil {
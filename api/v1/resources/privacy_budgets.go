@@ -0,0 +1,63 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package resources
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/kiprotect/kodex/api"
+	"github.com/kiprotect/kodex/api/helpers"
+	"github.com/kiprotect/kodex/budget"
+)
+
+// Get the spent and remaining privacy budgets
+func PrivacyBudgets(c *gin.Context) {
+
+	controller := helpers.Controller(c)
+
+	if controller == nil {
+		return
+	}
+
+	ledger, err := budget.MakeLedger(controller.Settings())
+
+	if err != nil {
+		api.HandleError(c, 500, err)
+		return
+	}
+
+	if ledger == nil {
+		api.HandleError(c, 404, fmt.Errorf("no privacy budget ledger configured"))
+		return
+	}
+
+	budgets, err := ledger.Budgets()
+
+	if err != nil {
+		api.HandleError(c, 500, err)
+		return
+	}
+
+	summaries := make([]map[string]interface{}, len(budgets))
+
+	for i, budget := range budgets {
+		summaries[i] = budget.Summary()
+	}
+
+	c.JSON(200, map[string]interface{}{"data": summaries})
+
+}
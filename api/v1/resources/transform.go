@@ -24,6 +24,7 @@ import (
 	"github.com/kiprotect/kodex"
 	"github.com/kiprotect/kodex/api"
 	"github.com/kiprotect/kodex/api/helpers"
+	"github.com/kiprotect/kodex/metrics"
 )
 
 func TransformEndpoint(meter kodex.Meter) func(c *gin.Context) {
@@ -160,16 +161,23 @@ func meterUsage(meter kodex.Meter, c *gin.Context, items []*kodex.Item) {
 
 	data := map[string]string{}
 
+	values := map[string]int64{
+		"source-items":       int64(len(items)),
+		"source-volume":      int64(c.Request.ContentLength),
+		"destination-volume": int64(c.Writer.Size()),
+	}
+
+	for key, value := range values {
+		// the content length is -1 if it's unknown
+		if value > 0 {
+			metrics.APIUsage.WithLabelValues(key).Add(float64(value))
+		}
+	}
+
 	for _, twt := range tws {
 
 		tw := twt(time.Now().UTC().UnixNano())
 
-		values := map[string]int64{
-			"source-items":       int64(len(items)),
-			"source-volume":      int64(c.Request.ContentLength),
-			"destination-volume": int64(c.Writer.Size()),
-		}
-
 		for key, value := range values {
 			err := meter.Add(id, key, data, tw, value)
 			if err != nil {
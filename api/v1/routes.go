@@ -68,6 +68,11 @@ func Initialize(group *gin.RouterGroup,
 	definitionsEndpoints.Use(decorators.ValidUser(settings, []string{"kiprotect:api:definitions"}, false))
 	definitionsEndpoints.GET("/definitions", resources.Definitions)
 
+	// Privacy budgets of differentially private releases
+	budgetEndpoints := endpoints.Group("")
+	budgetEndpoints.Use(decorators.ValidUser(settings, []string{"kiprotect:api:privacy-budgets"}, false))
+	budgetEndpoints.GET("/privacy-budgets", resources.PrivacyBudgets)
+
 	// Item Submission Endpoint
 	submitEndpoints := endpoints.Group("")
 	submitEndpoints.Use(decorators.ValidObject(settings,
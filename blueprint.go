@@ -124,6 +124,16 @@ var BlueprintStreamForm = forms.Form{
 				forms.IsIn{Choices: []interface{}{"active", "inactive", "testing"}},
 			},
 		},
+		{
+			// the number of workers that process the stream in parallel
+			Name:       "workers",
+			Validators: WorkersValidators,
+		},
+		{
+			// items with the same value of this field are processed in order
+			Name:       "partition-key",
+			Validators: PartitionKeyValidators,
+		},
 	},
 }
 
@@ -0,0 +1,167 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package budget
+
+import (
+	"encoding/json"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var FileLedgerForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the file ledger config",
+	Fields: []forms.Field{
+		{
+			Name: "filename",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "~/.kiprotect/privacy-budget.json"},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+// A ledger that persists the spent budget to a file, so that it survives
+// restarts and repeated runs of the same stream. The file is re-read before
+// every change, which allows several processes to use the same ledger
+// sequentially.
+type FileLedger struct {
+	mutex    sync.Mutex
+	filename string
+	budgets  map[string]*kodex.PrivacyBudget
+}
+
+func MakeFileLedger(config map[string]interface{}, budgets map[string]*kodex.PrivacyBudget) (kodex.PrivacyBudgetLedger, error) {
+	params, err := FileLedgerForm.Validate(config)
+	if err != nil {
+		return nil, err
+	}
+	filename, err := kodex.NormalizePath(params["filename"].(string))
+	if err != nil {
+		return nil, err
+	}
+	return &FileLedger{
+		filename: filename,
+		budgets:  budgets,
+	}, nil
+}
+
+func (l *FileLedger) load() (map[string]*kodex.PrivacyBudget, error) {
+	spent := make(map[string]*kodex.PrivacyBudget)
+	data, err := os.ReadFile(l.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return spent, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &spent); err != nil {
+		return nil, err
+	}
+	return spent, nil
+}
+
+func (l *FileLedger) save(spent map[string]*kodex.PrivacyBudget) error {
+	data, err := json.MarshalIndent(spent, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.filename), 0700); err != nil {
+		return err
+	}
+	tmpFilename := l.filename + ".tmp"
+	if err := os.WriteFile(tmpFilename, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, l.filename)
+}
+
+// Returns the spent budget for the given ID. The limits and composition
+// rule always come from the settings, so they can be changed later on.
+func (l *FileLedger) budget(spent map[string]*kodex.PrivacyBudget, id string) (*kodex.PrivacyBudget, error) {
+	budget, err := configuredBudget(l.budgets, id)
+	if err != nil {
+		return nil, err
+	}
+	if spentBudget, ok := spent[id]; ok {
+		budget.Releases = spentBudget.Releases
+		budget.SumEpsilon = spentBudget.SumEpsilon
+		budget.SumDelta = spentBudget.SumDelta
+		budget.SumEpsilonSquared = spentBudget.SumEpsilonSquared
+		budget.SumEpsilonExpM1 = spentBudget.SumEpsilonExpM1
+	}
+	return budget, nil
+}
+
+func (l *FileLedger) Reserve(id string, epsilon, delta float64) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	spent, err := l.load()
+	if err != nil {
+		return false, err
+	}
+	budget, err := l.budget(spent, id)
+	if err != nil {
+		return false, err
+	}
+	if !budget.Reserve(epsilon, delta) {
+		return false, nil
+	}
+	spent[id] = budget
+	return true, l.save(spent)
+}
+
+func (l *FileLedger) Budget(id string) (*kodex.PrivacyBudget, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	spent, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	return l.budget(spent, id)
+}
+
+func (l *FileLedger) Budgets() ([]*kodex.PrivacyBudget, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	spent, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	for id := range spent {
+		budget, err := l.budget(spent, id)
+		if err != nil {
+			return nil, err
+		}
+		spent[id] = budget
+	}
+	return sortedBudgets(l.budgets, spent)
+}
+
+func (l *FileLedger) Reset(id string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	spent, err := l.load()
+	if err != nil {
+		return err
+	}
+	delete(spent, id)
+	return l.save(spent)
+}
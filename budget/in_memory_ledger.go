@@ -0,0 +1,104 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package budget
+
+import (
+	"github.com/kiprotect/kodex"
+	"sort"
+	"sync"
+)
+
+// A ledger that only tracks the spent budget within a single process. The
+// budget starts from zero on every run, so repeated runs can exceed it. Use
+// the file ledger for anything but testing.
+type InMemoryLedger struct {
+	mutex   sync.Mutex
+	budgets map[string]*kodex.PrivacyBudget
+	spent   map[string]*kodex.PrivacyBudget
+}
+
+func MakeInMemoryLedger(config map[string]interface{}, budgets map[string]*kodex.PrivacyBudget) (kodex.PrivacyBudgetLedger, error) {
+	return &InMemoryLedger{
+		budgets: budgets,
+		spent:   make(map[string]*kodex.PrivacyBudget),
+	}, nil
+}
+
+func (l *InMemoryLedger) budget(id string) (*kodex.PrivacyBudget, error) {
+	if budget, ok := l.spent[id]; ok {
+		return budget, nil
+	}
+	budget, err := configuredBudget(l.budgets, id)
+	if err != nil {
+		return nil, err
+	}
+	l.spent[id] = budget
+	return budget, nil
+}
+
+func (l *InMemoryLedger) Reserve(id string, epsilon, delta float64) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	budget, err := l.budget(id)
+	if err != nil {
+		return false, err
+	}
+	return budget.Reserve(epsilon, delta), nil
+}
+
+func (l *InMemoryLedger) Budget(id string) (*kodex.PrivacyBudget, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	budget, err := l.budget(id)
+	if err != nil {
+		return nil, err
+	}
+	budgetCopy := *budget
+	return &budgetCopy, nil
+}
+
+func (l *InMemoryLedger) Budgets() ([]*kodex.PrivacyBudget, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return sortedBudgets(l.budgets, l.spent)
+}
+
+func (l *InMemoryLedger) Reset(id string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.spent, id)
+	return nil
+}
+
+// Returns all configured and spent budgets, sorted by their ID
+func sortedBudgets(budgets, spent map[string]*kodex.PrivacyBudget) ([]*kodex.PrivacyBudget, error) {
+	allBudgets := make([]*kodex.PrivacyBudget, 0, len(spent))
+	for id, budget := range budgets {
+		if _, ok := spent[id]; !ok {
+			budgetCopy := *budget
+			allBudgets = append(allBudgets, &budgetCopy)
+		}
+	}
+	for _, budget := range spent {
+		budgetCopy := *budget
+		allBudgets = append(allBudgets, &budgetCopy)
+	}
+	sort.Slice(allBudgets, func(i, j int) bool {
+		return allBudgets[i].ID < allBudgets[j].ID
+	})
+	return allBudgets, nil
+}
@@ -0,0 +1,187 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package budget
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/go-helpers/maps"
+	"github.com/kiprotect/kodex"
+	"sync"
+)
+
+type LedgerMaker func(config map[string]interface{}, budgets map[string]*kodex.PrivacyBudget) (kodex.PrivacyBudgetLedger, error)
+
+var Ledgers = map[string]LedgerMaker{
+	"in-memory": MakeInMemoryLedger,
+	"file":      MakeFileLedger,
+}
+
+var BudgetForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the privacy budget config",
+	Fields: []forms.Field{
+		{
+			Name: "epsilon",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsFloat{HasMin: true, Min: 0},
+			},
+		},
+		{
+			Name: "delta",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0.0},
+				forms.IsFloat{HasMin: true, Min: 0, HasMax: true, Max: 1},
+			},
+		},
+		{
+			Name: "composition",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "basic"},
+				forms.IsIn{Choices: []interface{}{"basic", "advanced"}},
+			},
+		},
+		{
+			// the delta slack for advanced composition
+			Name: "slack",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0.0},
+				forms.IsFloat{HasMin: true, Min: 0, HasMax: true, Max: 1},
+			},
+		},
+	},
+}
+
+var LedgerForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the privacy budget ledger config",
+	Fields: []forms.Field{
+		{
+			Name: "type",
+			Validators: []forms.Validator{
+				// the in-memory ledger forgets the spent budget when the
+				// process ends, so we persist it by default
+				forms.IsOptional{Default: "file"},
+				forms.IsIn{Choices: []interface{}{"in-memory", "file"}},
+			},
+		},
+		{
+			Name: "config",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+		{
+			Name: "budgets",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsStringMap{},
+			},
+		},
+	},
+}
+
+var ledgers map[string]kodex.PrivacyBudgetLedger
+var mutex sync.Mutex
+
+// Returns the privacy budget ledger defined in the settings, or nil if no
+// ledger is defined. Ledgers with the same config are shared, so that all
+// actions of a process draw from the same budget.
+func MakeLedger(settings kodex.Settings) (kodex.PrivacyBudgetLedger, error) {
+
+	config, err := settings.Get("privacy-budget")
+
+	if err != nil {
+		return nil, nil
+	}
+
+	configMap, ok := maps.ToStringMap(config)
+
+	if !ok {
+		return nil, fmt.Errorf("not a valid config for the privacy budget ledger")
+	}
+
+	params, err := LedgerForm.Validate(configMap)
+
+	if err != nil {
+		return nil, err
+	}
+
+	configHash, err := kodex.StructuredHash(params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := hex.EncodeToString(configHash)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if ledgers == nil {
+		ledgers = make(map[string]kodex.PrivacyBudgetLedger)
+	}
+
+	if ledger, ok := ledgers[key]; ok {
+		return ledger, nil
+	}
+
+	budgets := make(map[string]*kodex.PrivacyBudget)
+
+	for id, budgetConfig := range params["budgets"].(map[string]interface{}) {
+		budgetConfigMap, ok := maps.ToStringMap(budgetConfig)
+		if !ok {
+			return nil, fmt.Errorf("not a valid config for privacy budget '%s'", id)
+		}
+		budgetParams, err := BudgetForm.Validate(budgetConfigMap)
+		if err != nil {
+			return nil, err
+		}
+		budgets[id] = &kodex.PrivacyBudget{
+			ID:          id,
+			Epsilon:     budgetParams["epsilon"].(float64),
+			Delta:       budgetParams["delta"].(float64),
+			Composition: budgetParams["composition"].(string),
+			Slack:       budgetParams["slack"].(float64),
+		}
+	}
+
+	ledger, err := Ledgers[params["type"].(string)](params["config"].(map[string]interface{}), budgets)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ledgers[key] = ledger
+
+	return ledger, nil
+}
+
+// Returns a copy of the configured budget for the given ID. If no budget is
+// configured for the ID we fall back to the "default" budget.
+func configuredBudget(budgets map[string]*kodex.PrivacyBudget, id string) (*kodex.PrivacyBudget, error) {
+	budget, ok := budgets[id]
+	if !ok {
+		if budget, ok = budgets["default"]; !ok {
+			return nil, fmt.Errorf("no privacy budget defined for '%s'", id)
+		}
+	}
+	newBudget := *budget
+	newBudget.ID = id
+	return &newBudget, nil
+}
@@ -0,0 +1,167 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package budget
+
+import (
+	"github.com/kiprotect/go-helpers/settings"
+	"github.com/kiprotect/kodex"
+	"path/filepath"
+	"testing"
+)
+
+func makeTestLedger(t *testing.T, ledgerType string, config map[string]interface{}) kodex.PrivacyBudgetLedger {
+	s, err := settings.MakeSettings(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledgerConfig := map[string]interface{}{
+		"config": config,
+		"budgets": map[string]interface{}{
+			"default": map[string]interface{}{
+				"epsilon": 1,
+			},
+			"dataset": map[string]interface{}{
+				"epsilon":     2.5,
+				"delta":       1e-5,
+				"composition": "advanced",
+				"slack":       1e-6,
+			},
+		},
+	}
+	if ledgerType != "" {
+		ledgerConfig["type"] = ledgerType
+	}
+	s.Update(map[string]interface{}{"privacy-budget": ledgerConfig})
+	ledger, err := MakeLedger(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ledger
+}
+
+func TestInMemoryLedger(t *testing.T) {
+
+	ledger := makeTestLedger(t, "in-memory", map[string]interface{}{})
+
+	for i := 0; i < 4; i++ {
+		if ok, err := ledger.Reserve("project", 0.25, 0); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatalf("Expected reservation %d to succeed", i)
+		}
+	}
+
+	if ok, err := ledger.Reserve("project", 0.25, 0); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatalf("Expected the budget to be exhausted")
+	}
+
+	budget, err := ledger.Budget("project")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if remaining, _ := budget.Remaining(); budget.Releases != 4 || remaining != 0 {
+		t.Errorf("Unexpected budget state: %v", budget)
+	}
+
+	if err := ledger.Reset("project"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := ledger.Reserve("project", 0.25, 0); err != nil || !ok {
+		t.Fatalf("Expected the budget to be available after a reset")
+	}
+}
+
+func TestFileLedger(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "budget.json")
+
+	ledger := makeTestLedger(t, "file", map[string]interface{}{"filename": filename})
+
+	if ok, err := ledger.Reserve("project", 0.75, 0); err != nil || !ok {
+		t.Fatalf("Expected the reservation to succeed")
+	}
+
+	// a second ledger for the same file simulates another run
+	otherLedger, err := MakeFileLedger(map[string]interface{}{"filename": filename},
+		map[string]*kodex.PrivacyBudget{"default": &kodex.PrivacyBudget{Epsilon: 1}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := otherLedger.Reserve("project", 0.5, 0); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatalf("Expected the budget to be exhausted")
+	}
+
+	budgets, err := otherLedger.Budgets()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(budgets) != 2 || budgets[1].ID != "project" || budgets[1].SumEpsilon != 0.75 {
+		t.Fatalf("Unexpected budgets: %v", budgets)
+	}
+}
+
+func TestDefaultLedger(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "budget.json")
+
+	// without a type we persist the budget to a file
+	ledger := makeTestLedger(t, "", map[string]interface{}{"filename": filename})
+
+	if _, ok := ledger.(*FileLedger); !ok {
+		t.Fatalf("Expected a file ledger, got %T", ledger)
+	}
+}
+
+func TestAdvancedComposition(t *testing.T) {
+
+	ledger := makeTestLedger(t, "in-memory", map[string]interface{}{})
+
+	// with basic composition only 250 releases would fit into the budget
+	releases := 0
+	for ; releases < 10000; releases++ {
+		if ok, err := ledger.Reserve("dataset", 0.01, 0); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			break
+		}
+	}
+
+	if releases <= 250 {
+		t.Fatalf("Expected advanced composition to allow more releases, got %d", releases)
+	}
+
+	budget, err := ledger.Budget("dataset")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if epsilon, delta := budget.Spent(); epsilon > 2.5 || delta != 1e-6 {
+		t.Errorf("Unexpected spent budget: %g, %g", epsilon, delta)
+	}
+}
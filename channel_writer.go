@@ -48,18 +48,20 @@ type Warning struct {
 }
 
 type InMemoryChannelWriter struct {
-	Items    map[string][]*Item
-	Messages []*Message
-	Errors   []*Error
-	Warnings []*Warning
+	Items       map[string][]*Item
+	Messages    []*Message
+	Errors      []*Error
+	Warnings    []*Warning
+	DeadLetters []*DeadLetter
 }
 
 func MakeInMemoryChannelWriter() *InMemoryChannelWriter {
 	return &InMemoryChannelWriter{
-		Items:    make(map[string][]*Item),
-		Messages: make([]*Message, 0),
-		Errors:   make([]*Error, 0),
-		Warnings: make([]*Warning, 0),
+		Items:       make(map[string][]*Item),
+		Messages:    make([]*Message, 0),
+		Errors:      make([]*Error, 0),
+		Warnings:    make([]*Warning, 0),
+		DeadLetters: make([]*DeadLetter, 0),
 	}
 }
 
@@ -95,3 +97,8 @@ func (c *InMemoryChannelWriter) Warning(item *Item, warn error) error {
 	})
 	return nil
 }
+
+func (c *InMemoryChannelWriter) DeadLetter(deadLetter *DeadLetter) error {
+	c.DeadLetters = append(c.DeadLetters, deadLetter)
+	return nil
+}
@@ -17,15 +17,24 @@
 package helpers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/kiprotect/kodex"
 	"github.com/kiprotect/kodex/api"
+	"github.com/kiprotect/kodex/budget"
 	kipHelpers "github.com/kiprotect/kodex/helpers"
+	"github.com/kiprotect/kodex/metrics"
 	"github.com/kiprotect/kodex/processing"
+	"github.com/kiprotect/kodex/readers"
 	"github.com/urfave/cli"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 type decorator func(f func(c *cli.Context) error) func(c *cli.Context) error
@@ -99,6 +108,44 @@ func exportParameters(controller kodex.Controller, path string) error {
 	}
 }
 
+func privacyBudgets(controller kodex.Controller) error {
+	ledger, err := budget.MakeLedger(controller.Settings())
+	if err != nil {
+		return err
+	}
+	if ledger == nil {
+		return fmt.Errorf("no privacy budget ledger configured")
+	}
+	if _, ok := ledger.(*budget.InMemoryLedger); ok {
+		kodex.Log.Warning("The in-memory privacy budget ledger only covers a single process, budgets spent by other runs are not shown")
+	}
+	budgets, err := ledger.Budgets()
+	if err != nil {
+		return err
+	}
+	summaries := make([]map[string]interface{}, len(budgets))
+	for i, budget := range budgets {
+		summaries[i] = budget.Summary()
+	}
+	if bytes, err := json.MarshalIndent(summaries, "", "  "); err != nil {
+		return err
+	} else {
+		fmt.Println(string(bytes))
+	}
+	return nil
+}
+
+func resetPrivacyBudget(controller kodex.Controller, id string) error {
+	ledger, err := budget.MakeLedger(controller.Settings())
+	if err != nil {
+		return err
+	}
+	if ledger == nil {
+		return fmt.Errorf("no privacy budget ledger configured")
+	}
+	return ledger.Reset(id)
+}
+
 func downloadBlueprints(path, url string) error {
 	if data, err := Download(url); err != nil {
 		return err
@@ -110,6 +157,159 @@ func downloadBlueprints(path, url string) error {
 	return nil
 }
 
+// Loads the given blueprint and replays the dead letters from the given file
+// through its default stream.
+func replay(controller kodex.Controller, blueprintName, version, format, path string) error {
+
+	blueprintConfig, err := kodex.LoadBlueprintConfig(controller.Settings(), blueprintName, version)
+
+	if err != nil {
+		return err
+	}
+
+	project, err := kodex.MakeBlueprint(blueprintConfig).Create(controller, true)
+
+	if err != nil {
+		return err
+	}
+
+	streams, err := controller.Streams(map[string]interface{}{"name": "default", "project.id": project.ID()})
+
+	if err != nil {
+		return err
+	}
+
+	if len(streams) != 1 {
+		return fmt.Errorf("expected one stream")
+	}
+
+	reader, err := readers.MakeFileReader(map[string]interface{}{
+		"path":       path,
+		"format":     format,
+		"compressed": "auto",
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := reader.Setup(nil); err != nil {
+		return err
+	}
+
+	deadLetters, err := processing.ReadDeadLetters(reader)
+
+	reader.Teardown()
+
+	if err != nil {
+		return err
+	}
+
+	n, err := processing.Replay(streams[0], deadLetters)
+
+	if err != nil {
+		return err
+	}
+
+	kodex.Log.Infof("Replayed %d dead letters, wrote %d items", len(deadLetters), n)
+
+	return nil
+}
+
+// Loads the given blueprints and processes all streams, sources and
+// destinations of the controller until we receive a signal to stop. We then
+// give the executors up to the shutdown timeout to process the payloads that
+// are in flight.
+func serve(controller kodex.Controller, blueprintNames []string, version string, options processing.SchedulerOptions) error {
+
+	for _, blueprintName := range blueprintNames {
+
+		blueprintConfig, err := kodex.LoadBlueprintConfig(controller.Settings(), blueprintName, version)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := kodex.MakeBlueprint(blueprintConfig).Create(controller, true); err != nil {
+			return err
+		}
+	}
+
+	if options.Workers < 1 || options.PollInterval <= 0 || options.PingInterval <= 0 {
+		return fmt.Errorf("workers, poll interval and ping interval need to be positive")
+	}
+
+	scheduler := processing.MakeScheduler(controller, options)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	kodex.Log.Infof("Starting scheduler with processor ID %x", scheduler.ProcessorID())
+
+	scheduler.Start(ctx)
+
+	<-ctx.Done()
+
+	kodex.Log.Infof("Received signal, stopping...")
+
+	scheduler.Stop(options.DrainTimeout)
+
+	return nil
+}
+
+// Serves the metrics in the Prometheus format on the given address (if it
+// isn't empty). The returned function shuts the server down.
+func serveMetrics(addr string) (func(), error) {
+
+	if addr == "" {
+		return func() {}, nil
+	}
+
+	listener, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot serve metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			kodex.Log.Error("Metrics Server Error - ", err)
+		}
+	}()
+
+	kodex.Log.Infof("Serving metrics on http://%s/metrics", listener.Addr())
+
+	return func() {
+		if err := srv.Close(); err != nil {
+			kodex.Log.Error(err)
+		}
+	}, nil
+}
+
+// Returns a context that is cancelled when we receive SIGINT or SIGTERM.
+// After the first signal we restore the default behavior, so that a second
+// signal terminates the process without waiting for a graceful shutdown.
+func signalContext() (context.Context, context.CancelFunc) {
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	return ctx, stop
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
 func Settings() (kodex.Settings, error) {
 	if settingsPaths, fS, err := kipHelpers.SettingsPaths(); err != nil {
 		return nil, err
@@ -199,6 +399,27 @@ func Kodex(definitions *api.Definitions) {
 				},
 			},
 		},
+		cli.Command{
+			Name:  "budget",
+			Usage: "list or reset privacy budgets (the in-memory ledger only covers the current process)",
+			Subcommands: []cli.Command{
+				cli.Command{
+					Name: "list",
+					Action: func(c *cli.Context) error {
+						return privacyBudgets(controller)
+					},
+				},
+				cli.Command{
+					Name: "reset",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return fmt.Errorf("usage: reset [id]")
+						}
+						return resetPrivacyBudget(controller, c.Args().Get(0))
+					},
+				},
+			},
+		},
 		cli.Command{
 			Name: "blueprints",
 			Subcommands: []cli.Command{
@@ -268,6 +489,16 @@ func Kodex(definitions *api.Definitions) {
 					Value: "",
 					Usage: "optional: the version of the blueprint to load",
 				},
+				cli.Float64Flag{
+					Name:  "shutdown-timeout",
+					Value: 30,
+					Usage: "how long to wait for payloads in flight when stopping (in seconds, 0 to wait indefinitely)",
+				},
+				cli.StringFlag{
+					Name:  "metrics-address",
+					Value: "",
+					Usage: "optional: serve Prometheus metrics on this address (e.g. 'localhost:9090')",
+				},
 			},
 			Action: func(c *cli.Context) error {
 
@@ -303,7 +534,128 @@ func Kodex(definitions *api.Definitions) {
 
 				stream := streams[0]
 
-				return processing.ProcessStream(stream, 0)
+				stopMetrics, err := serveMetrics(c.String("metrics-address"))
+
+				if err != nil {
+					return err
+				}
+
+				defer stopMetrics()
+
+				ctx, cancel := signalContext()
+				defer cancel()
+
+				if err := processing.ProcessStream(ctx, stream, seconds(c.Float64("shutdown-timeout"))); err != nil && err != context.Canceled {
+					return err
+				}
+
+				return nil
+			},
+		},
+		cli.Command{
+			Name:      "serve",
+			Usage:     "continuously process the most urgent streams, sources and destinations",
+			ArgsUsage: "[blueprint...]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "optional: the version of the blueprints to load",
+				},
+				cli.IntFlag{
+					Name:  "workers",
+					Value: 2,
+					Usage: "the default number of workers per stream and destination",
+				},
+				cli.IntFlag{
+					Name:  "max-streams",
+					Value: 10,
+					Usage: "the maximum number of streams to process at the same time",
+				},
+				cli.IntFlag{
+					Name:  "max-sources",
+					Value: 10,
+					Usage: "the maximum number of sources to process at the same time",
+				},
+				cli.IntFlag{
+					Name:  "max-destinations",
+					Value: 10,
+					Usage: "the maximum number of destinations to process at the same time",
+				},
+				cli.Float64Flag{
+					Name:  "poll-interval",
+					Value: 1,
+					Usage: "how often to look for new work (in seconds)",
+				},
+				cli.Float64Flag{
+					Name:  "ping-interval",
+					Value: 10,
+					Usage: "how often to renew leases and update priorities (in seconds)",
+				},
+				cli.Float64Flag{
+					Name:  "idle-timeout",
+					Value: 60,
+					Usage: "release entities that were idle for this long (in seconds, 0 to disable)",
+				},
+				cli.Float64Flag{
+					Name:  "shutdown-timeout",
+					Value: 30,
+					Usage: "how long to wait for payloads in flight when stopping or releasing idle entities (in seconds, 0 to wait indefinitely)",
+				},
+				cli.StringFlag{
+					Name:  "metrics-address",
+					Value: "",
+					Usage: "optional: serve Prometheus metrics on this address (e.g. 'localhost:9090')",
+				},
+			},
+			Action: func(c *cli.Context) error {
+
+				stopMetrics, err := serveMetrics(c.String("metrics-address"))
+
+				if err != nil {
+					return err
+				}
+
+				defer stopMetrics()
+
+				return serve(controller, c.Args(), c.String("version"), processing.SchedulerOptions{
+					Workers:         c.Int("workers"),
+					MaxStreams:      c.Int("max-streams"),
+					MaxSources:      c.Int("max-sources"),
+					MaxDestinations: c.Int("max-destinations"),
+					PollInterval:    seconds(c.Float64("poll-interval")),
+					PingInterval:    seconds(c.Float64("ping-interval")),
+					IdleTimeout:     seconds(c.Float64("idle-timeout")),
+					DrainTimeout:    seconds(c.Float64("shutdown-timeout")),
+				})
+			},
+		},
+		cli.Command{
+			Name:      "replay",
+			Usage:     "replay the items from a dead-letter file through the current blueprint",
+			ArgsUsage: "[dead-letter-file]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "blueprint",
+					Value: "",
+					Usage: "optional: the name of the blueprint to load",
+				},
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "optional: the version of the blueprint to load",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "json",
+					Usage: "the format of the dead-letter file",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("usage: replay [dead-letter-file]")
+				}
+				return replay(controller, c.String("blueprint"), c.String("version"), c.String("format"), c.Args().Get(0))
 			},
 		},
 	}
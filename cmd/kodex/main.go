@@ -19,6 +19,8 @@ package main
 import (
 	"github.com/kiprotect/kodex/api/definitions"
 	cmdHelpers "github.com/kiprotect/kodex/cmd/helpers"
+	// database/sql drivers for the SQL reader and writer
+	_ "modernc.org/sqlite"
 )
 
 func main() {
@@ -0,0 +1,227 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+	"sort"
+	"strings"
+)
+
+// No compression
+const None = ""
+
+// Detect the codec from the file extension or the magic bytes of the data
+const Auto = "auto"
+
+type Codec struct {
+	Name      string
+	Extension string
+	Magic     []byte
+	Reader    func(io.Reader) (io.ReadCloser, error)
+	// nil if we can only decompress data
+	Writer func(io.Writer) (io.WriteCloser, error)
+}
+
+var Codecs = map[string]*Codec{
+	"gzip": {
+		Name:      "gzip",
+		Extension: ".gz",
+		Magic:     []byte{0x1f, 0x8b},
+		Reader: func(reader io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(reader)
+		},
+		Writer: func(writer io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(writer), nil
+		},
+	},
+	"zstd": {
+		Name:      "zstd",
+		Extension: ".zst",
+		Magic:     []byte{0x28, 0xb5, 0x2f, 0xfd},
+		Reader: func(reader io.Reader) (io.ReadCloser, error) {
+			if decoder, err := zstd.NewReader(reader); err != nil {
+				return nil, err
+			} else {
+				return decoder.IOReadCloser(), nil
+			}
+		},
+		Writer: func(writer io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(writer)
+		},
+	},
+	"bzip2": {
+		Name:      "bzip2",
+		Extension: ".bz2",
+		Magic:     []byte("BZh"),
+		Reader: func(reader io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(bzip2.NewReader(reader)), nil
+		},
+	},
+	"xz": {
+		Name:      "xz",
+		Extension: ".xz",
+		Magic:     []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+		Reader: func(reader io.Reader) (io.ReadCloser, error) {
+			if xzReader, err := xz.NewReader(reader); err != nil {
+				return nil, err
+			} else {
+				return io.NopCloser(xzReader), nil
+			}
+		},
+		Writer: func(writer io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(writer)
+		},
+	},
+}
+
+// Returns the codec with the given name, or nil for no compression.
+func Get(name string) (*Codec, error) {
+	if name == None {
+		return nil, nil
+	}
+	if codec, ok := Codecs[name]; ok {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("unknown compression codec: %s", name)
+}
+
+// Returns the codec matching the extension of the path, or nil.
+func ForPath(path string) *Codec {
+	for _, codec := range Codecs {
+		if strings.HasSuffix(path, codec.Extension) {
+			return codec
+		}
+	}
+	return nil
+}
+
+// Returns the codec whose magic bytes the data starts with, or nil.
+func Detect(reader *bufio.Reader) (*Codec, error) {
+	for _, codec := range Codecs {
+		data, err := reader.Peek(len(codec.Magic))
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		if bytes.Equal(data, codec.Magic) {
+			return codec, nil
+		}
+	}
+	return nil, nil
+}
+
+// Returns the codec with the given name. For the "auto" codec we determine
+// the codec from the path (if given) or the magic bytes of the data (if a
+// reader is given). Returns nil if the data is not compressed.
+func Resolve(name, path string, reader *bufio.Reader) (*Codec, error) {
+
+	if name != Auto {
+		return Get(name)
+	}
+
+	if path != "" {
+		if codec := ForPath(path); codec != nil {
+			return codec, nil
+		}
+	}
+
+	if reader != nil {
+		return Detect(reader)
+	}
+
+	return nil, nil
+}
+
+// Returns a reader that decompresses the data using the given codec, see
+// Resolve for the "auto" codec. Closing the reader doesn't close the
+// underlying reader.
+func NewReader(reader io.Reader, name, path string) (io.ReadCloser, error) {
+
+	bufReader := bufio.NewReader(reader)
+
+	codec, err := Resolve(name, path, bufReader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if codec == nil {
+		return io.NopCloser(bufReader), nil
+	}
+
+	return codec.Reader(bufReader)
+}
+
+// Returns a writer that compresses data using the given codec. The writer
+// must be closed to flush all data, which doesn't close the underlying writer.
+func NewWriter(writer io.Writer, name string) (io.WriteCloser, error) {
+
+	codec, err := Get(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if codec == nil {
+		return nopWriteCloser{writer}, nil
+	}
+
+	if codec.Writer == nil {
+		return nil, fmt.Errorf("codec %s cannot be used for compression", name)
+	}
+
+	return codec.Writer(writer)
+}
+
+// Returns the file extension for the given codec.
+func Extension(name string) string {
+	if codec, ok := Codecs[name]; ok {
+		return codec.Extension
+	}
+	return ""
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (n nopWriteCloser) Close() error {
+	return nil
+}
+
+func names(writing bool) []interface{} {
+	names := make([]string, 0, len(Codecs))
+	for name, codec := range Codecs {
+		if writing && codec.Writer == nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	choices := make([]interface{}, len(names))
+	for i, name := range names {
+		choices[i] = name
+	}
+	return choices
+}
@@ -0,0 +1,157 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package compression_test
+
+import (
+	"bytes"
+	"github.com/kiprotect/kodex/compression"
+	"io"
+	"os/exec"
+	"testing"
+)
+
+var data = bytes.Repeat([]byte("{\"name\": \"test\", \"value\": 1}\n"), 1000)
+
+func compress(t *testing.T, name string, data []byte) []byte {
+	var buf bytes.Buffer
+	writer, err := compression.NewWriter(&buf, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decompress(t *testing.T, name, path string, data []byte) []byte {
+	reader, err := compression.NewReader(bytes.NewReader(data), name, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decompressed
+}
+
+func TestRoundTrip(t *testing.T) {
+
+	for name, codec := range compression.Codecs {
+
+		if codec.Writer == nil {
+			continue
+		}
+
+		t.Run(name, func(t *testing.T) {
+
+			// compressed streams can be concatenated
+			compressed := append(compress(t, name, data), compress(t, name, data)...)
+
+			if len(compressed) >= len(data) {
+				t.Errorf("Expected the data to be compressed")
+			}
+
+			expected := append(append([]byte{}, data...), data...)
+
+			if !bytes.Equal(decompress(t, name, "", compressed), expected) {
+				t.Errorf("Expected the data to be unchanged")
+			}
+
+			// we detect the codec from the magic bytes...
+			if !bytes.Equal(decompress(t, compression.Auto, "", compressed), expected) {
+				t.Errorf("Expected the codec to be detected from the data")
+			}
+
+			// ...and from the extension
+			if !bytes.Equal(decompress(t, compression.Auto, "items.json"+codec.Extension, compressed), expected) {
+				t.Errorf("Expected the codec to be detected from the path")
+			}
+		})
+	}
+}
+
+func TestBzip2(t *testing.T) {
+
+	if _, err := compression.NewWriter(io.Discard, "bzip2"); err == nil {
+		t.Fatalf("Expected an error as bzip2 is read-only")
+	}
+
+	path, err := exec.LookPath("bzip2")
+
+	if err != nil {
+		t.Skip("bzip2 is not installed")
+	}
+
+	cmd := exec.Command(path, "-c")
+	cmd.Stdin = bytes.NewReader(data)
+
+	compressed, err := cmd.Output()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decompress(t, compression.Auto, "", compressed), data) {
+		t.Errorf("Expected the data to be unchanged")
+	}
+}
+
+func TestUncompressed(t *testing.T) {
+
+	if !bytes.Equal(decompress(t, compression.Auto, "items.json", data), data) {
+		t.Errorf("Expected uncompressed data to be unchanged")
+	}
+
+	if !bytes.Equal(compress(t, compression.None, data), data) {
+		t.Errorf("Expected uncompressed data to be unchanged")
+	}
+}
+
+func TestIsCodec(t *testing.T) {
+
+	for input, expected := range map[interface{}]interface{}{
+		true:   "gzip",
+		false:  "",
+		"none": "",
+		"zstd": "zstd",
+		"auto": "auto",
+	} {
+		if value, err := (compression.IsCodec{}).Validate(input, nil); err != nil {
+			t.Errorf("Unexpected error for %v: %v", input, err)
+		} else if value != expected {
+			t.Errorf("Expected %v for %v, got %v", expected, input, value)
+		}
+	}
+
+	for _, input := range []interface{}{"lz4", 1} {
+		if _, err := (compression.IsCodec{}).Validate(input, nil); err == nil {
+			t.Errorf("Expected an error for %v", input)
+		}
+	}
+
+	for _, input := range []interface{}{"auto", "bzip2"} {
+		if _, err := (compression.IsCodec{Writing: true}).Validate(input, nil); err == nil {
+			t.Errorf("Expected an error when writing with %v", input)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package compression
+
+import (
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+// Validates a compression option, which can be the name of a codec, "none"
+// or a boolean (true stands for gzip). Returns the name of the codec, or an
+// empty string for no compression.
+type IsCodec struct {
+	// whether the codec is used for writing
+	Writing bool `json:"writing"`
+}
+
+func (i IsCodec) Validate(input interface{}, values map[string]interface{}) (interface{}, error) {
+
+	switch value := input.(type) {
+	case bool:
+		if value {
+			return "gzip", nil
+		}
+		return None, nil
+	case string:
+		if value == "none" || value == None {
+			return None, nil
+		}
+		if value == Auto && !i.Writing {
+			return Auto, nil
+		}
+		if _, err := (forms.IsIn{Choices: names(i.Writing)}).Validate(value, values); err != nil {
+			if !i.Writing {
+				return nil, fmt.Errorf("%w, auto or none", err)
+			}
+			return nil, fmt.Errorf("%w or none", err)
+		}
+		return value, nil
+	default:
+		return nil, fmt.Errorf("expected a codec name or a boolean")
+	}
+}
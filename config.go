@@ -184,6 +184,47 @@ func (b *BaseChannelWriter) Warning(item *Item, itemError error) error {
 	return b.sendErrorWarning(item, itemError, WarningDestination)
 }
 
+// Sends the dead letter to all dead-letter destinations of the config.
+func (b *BaseChannelWriter) DeadLetter(deadLetter *DeadLetter) error {
+
+	destinations, err := b.config.Destinations()
+
+	if err != nil {
+		return err
+	}
+
+	for _, destinationMaps := range destinations {
+
+		for _, destinationMap := range destinationMaps {
+			if destinationMap.Status() != DeadLetterDestination {
+				continue
+			}
+
+			writer, err := destinationMap.Destination().Writer()
+
+			if err != nil {
+				return err
+			}
+
+			if err := writer.Setup(b.config); err != nil {
+				return err
+			}
+
+			err = writer.Write(MakeBasicPayload([]*Item{deadLetter.ToItem()}, map[string]interface{}{}, false))
+
+			if teardownErr := writer.Teardown(); err == nil {
+				err = teardownErr
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+	}
+	return nil
+}
+
 func (b *BaseChannelWriter) sendErrorWarning(
 	item *Item,
 	itemError error,
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"sort"
 	"sync"
 )
 
@@ -447,22 +448,55 @@ func (c *InMemoryController) Destination(destinationID []byte) (kodex.Destinatio
 	return nil, kodex.NotFound
 }
 
+// Returns true if a is more urgent than b, i.e. it has a higher priority or
+// the same priority but was updated less recently.
+func moreUrgent(a, b kodex.PriorityModel) bool {
+	if a.Priority() != b.Priority() {
+		return a.Priority() > b.Priority()
+	}
+	return a.PriorityTime().Before(b.PriorityTime())
+}
+
+// Returns true if the processable entity was acquired by a processor.
+func (c *InMemoryController) acquired(processable kodex.Processable) bool {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	table, err := c.getTable(processable)
+
+	if err != nil {
+		return false
+	}
+
+	_, ok := table[string(processable.ID())]
+
+	return ok
+}
+
+// Returns the n most urgent streams that were not acquired yet
 func (c *InMemoryController) StreamsByUrgency(n int) ([]kodex.Stream, error) {
 
 	streams := make([]kodex.Stream, 0)
 	for _, stream := range c.streams {
-		streams = append(streams, stream)
-		if len(streams) >= n {
-			break
+		if !c.acquired(stream) {
+			streams = append(streams, stream)
 		}
 	}
+
+	sort.SliceStable(streams, func(i, j int) bool { return moreUrgent(streams[i], streams[j]) })
+
+	if len(streams) > n {
+		streams = streams[:n]
+	}
+
 	return streams, nil
 }
 
+// Returns the n most urgent source maps that were not acquired yet
 func (c *InMemoryController) SourcesByUrgency(n int) ([]kodex.SourceMap, error) {
 
 	sources := make([]kodex.SourceMap, 0)
-OUTER:
 	for _, stream := range c.streams {
 		streamSources, err := stream.Sources()
 		if err != nil {
@@ -470,18 +504,24 @@ OUTER:
 		}
 
 		for _, source := range streamSources {
-			sources = append(sources, source)
-			if len(sources) >= n {
-				break OUTER
+			if !c.acquired(source) {
+				sources = append(sources, source)
 			}
 		}
 	}
+
+	sort.SliceStable(sources, func(i, j int) bool { return moreUrgent(sources[i], sources[j]) })
+
+	if len(sources) > n {
+		sources = sources[:n]
+	}
+
 	return sources, nil
 }
 
+// Returns the n most urgent destination maps that were not acquired yet
 func (c *InMemoryController) DestinationsByUrgency(n int) ([]kodex.DestinationMap, error) {
 	destinations := make([]kodex.DestinationMap, 0)
-OUTER:
 	for _, stream := range c.streams {
 		streamConfigs, err := stream.Configs()
 		if err != nil {
@@ -495,14 +535,20 @@ OUTER:
 			}
 			for _, destinationMaps := range configDestinations {
 				for _, destinationMap := range destinationMaps {
-					destinations = append(destinations, destinationMap)
-					if len(destinations) >= n {
-						break OUTER
+					if !c.acquired(destinationMap) {
+						destinations = append(destinations, destinationMap)
 					}
 				}
 			}
 		}
 	}
+
+	sort.SliceStable(destinations, func(i, j int) bool { return moreUrgent(destinations[i], destinations[j]) })
+
+	if len(destinations) > n {
+		destinations = destinations[:n]
+	}
+
 	return destinations, nil
 }
 
@@ -510,9 +556,9 @@ func (c *InMemoryController) getTable(processable kodex.Processable) (map[string
 	switch processable.Type() {
 	case "stream":
 		return c.streamStats, nil
-	case "source":
+	case "source", "source_map":
 		return c.sourceStats, nil
-	case "destination":
+	case "destination", "destination_map":
 		return c.destinationStats, nil
 	default:
 		return nil, fmt.Errorf("invalid type: %s", processable.Type())
@@ -572,6 +618,26 @@ func (c *InMemoryController) Release(processable kodex.Processable, processorID
 
 // Send a pingback with stats for a processable entity
 func (c *InMemoryController) Ping(processable kodex.Processable, stats kodex.ProcessingStats) error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	table, err := c.getTable(processable)
+
+	if err != nil {
+		return err
+	}
+
+	pId := string(processable.ID())
+	entry, ok := table[pId]
+
+	if !ok || len(entry.ProcessorStats) == 0 {
+		return fmt.Errorf("not acquired")
+	}
+
+	entry.ProcessorStats[0].IdleFraction = stats.IdleFraction
+	entry.ProcessorStats[0].ItemsProcessed += stats.ItemsProcessed
+
 	return nil
 }
 
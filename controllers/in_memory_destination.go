@@ -32,6 +32,8 @@ type InMemoryDestination struct {
 	data            interface{}
 	destinationType string
 	configData      map[string]interface{}
+	workers         int
+	partitionKey    string
 	id              []byte
 }
 
@@ -145,3 +147,21 @@ func (i *InMemoryDestination) SetDescription(description string) error {
 	i.description = description
 	return nil
 }
+
+func (i *InMemoryDestination) Workers() int {
+	return i.workers
+}
+
+func (i *InMemoryDestination) SetWorkers(workers int) error {
+	i.workers = workers
+	return nil
+}
+
+func (i *InMemoryDestination) PartitionKey() string {
+	return i.partitionKey
+}
+
+func (i *InMemoryDestination) SetPartitionKey(partitionKey string) error {
+	i.partitionKey = partitionKey
+	return nil
+}
@@ -25,19 +25,21 @@ import (
 
 type InMemoryStream struct {
 	kodex.BaseStream
-	id          []byte
-	name        string
-	status      kodex.StreamStatus
-	data        interface{}
-	description string
-	prio        float64
-	prioT       time.Time
-	createdAt   time.Time
-	updatedAt   time.Time
-	deletedAt   *time.Time
-	config      map[string]interface{}
-	configs     []kodex.Config
-	sources     map[string]kodex.SourceMap
+	id           []byte
+	name         string
+	status       kodex.StreamStatus
+	data         interface{}
+	description  string
+	prio         float64
+	workers      int
+	partitionKey string
+	prioT        time.Time
+	createdAt    time.Time
+	updatedAt    time.Time
+	deletedAt    *time.Time
+	config       map[string]interface{}
+	configs      []kodex.Config
+	sources      map[string]kodex.SourceMap
 }
 
 func MakeInMemoryStream(id []byte, config map[string]interface{}, project *InMemoryProject) (kodex.Stream, error) {
@@ -244,3 +246,23 @@ func (i *InMemoryStream) SetPriorityAndTime(value float64, t time.Time) error {
 	i.prio = value
 	return nil
 }
+
+/* Parallelism Related Functionality */
+
+func (i *InMemoryStream) Workers() int {
+	return i.workers
+}
+
+func (i *InMemoryStream) SetWorkers(workers int) error {
+	i.workers = workers
+	return nil
+}
+
+func (i *InMemoryStream) PartitionKey() string {
+	return i.partitionKey
+}
+
+func (i *InMemoryStream) SetPartitionKey(partitionKey string) error {
+	i.partitionKey = partitionKey
+	return nil
+}
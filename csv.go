@@ -0,0 +1,529 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var CSVColumnForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the CSV column form",
+	Fields: []forms.Field{
+		{
+			Name: "name",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "type",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "string"},
+				forms.IsIn{Choices: []interface{}{"string", "int", "float", "bool", "date"}},
+			},
+		},
+		{
+			// the Go time layout used for date columns
+			Name: "layout",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: time.RFC3339},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+var CSVForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the CSV form",
+	Fields: []forms.Field{
+		{
+			// defaults to a comma for CSV and to a tab for TSV
+			Name: "delimiter",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "quote",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "\""},
+				forms.IsString{},
+			},
+		},
+		{
+			// quote all fields instead of only the ones that require it
+			Name: "quote-all",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+		{
+			Name: "header",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: true},
+				forms.IsBoolean{},
+			},
+		},
+		{
+			Name: "columns",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{}},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.Or{
+							Options: [][]forms.Validator{
+								{
+									forms.IsString{},
+								},
+								{
+									forms.IsStringMap{
+										Form: &CSVColumnForm,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+type CSVColumn struct {
+	Name   string
+	Type   string
+	Layout string
+}
+
+type CSVConfig struct {
+	Delimiter rune
+	Quote     rune
+	QuoteAll  bool
+	Header    bool
+	Columns   []*CSVColumn
+}
+
+// Creates a CSV config from the validated CSV form parameters. The format
+// is either "csv" or "tsv", which determines the default delimiter.
+func MakeCSVConfig(params map[string]interface{}, format string) (*CSVConfig, error) {
+
+	if params == nil {
+		var err error
+		if params, err = CSVForm.Validate(map[string]interface{}{}); err != nil {
+			return nil, err
+		}
+	}
+
+	delimiter := params["delimiter"].(string)
+
+	if delimiter == "" {
+		if format == "tsv" {
+			delimiter = "\t"
+		} else {
+			delimiter = ","
+		}
+	}
+
+	quote := params["quote"].(string)
+
+	if utf8.RuneCountInString(delimiter) != 1 || utf8.RuneCountInString(quote) != 1 {
+		return nil, fmt.Errorf("delimiter and quote must be single characters")
+	}
+
+	config := &CSVConfig{
+		Delimiter: []rune(delimiter)[0],
+		Quote:     []rune(quote)[0],
+		QuoteAll:  params["quote-all"].(bool),
+		Header:    params["header"].(bool),
+		Columns:   make([]*CSVColumn, 0),
+	}
+
+	if config.Delimiter == config.Quote || config.Delimiter == '\n' || config.Delimiter == '\r' {
+		return nil, fmt.Errorf("invalid delimiter")
+	}
+
+	for _, column := range params["columns"].([]interface{}) {
+		switch c := column.(type) {
+		case string:
+			config.Columns = append(config.Columns, &CSVColumn{Name: c, Type: "string"})
+		case map[string]interface{}:
+			config.Columns = append(config.Columns, &CSVColumn{
+				Name:   c["name"].(string),
+				Type:   c["type"].(string),
+				Layout: c["layout"].(string),
+			})
+		}
+	}
+
+	if !config.Header && len(config.Columns) == 0 {
+		return nil, fmt.Errorf("columns are required if there is no header row")
+	}
+
+	return config, nil
+}
+
+func (c *CSVConfig) column(name string) *CSVColumn {
+	for _, column := range c.Columns {
+		if column.Name == name {
+			return column
+		}
+	}
+	return nil
+}
+
+// Decodes items from RFC 4180 CSV data
+type CSVDecoder struct {
+	reader  *bufio.Reader
+	config  *CSVConfig
+	columns []*CSVColumn
+	names   []string
+}
+
+func MakeCSVDecoder(reader *bufio.Reader, config *CSVConfig) *CSVDecoder {
+	return &CSVDecoder{
+		reader: reader,
+		config: config,
+	}
+}
+
+// Reads a single record. Quoted fields may contain delimiters, line breaks
+// and escaped (doubled) quotes. Returns io.EOF if there are no more records.
+func (d *CSVDecoder) readRecord() ([]string, error) {
+
+	record := make([]string, 0)
+	var field bytes.Buffer
+	quoted := false
+	// whether the current field started with a quote
+	wasQuoted := false
+	empty := true
+
+	for {
+		r, _, err := d.reader.ReadRune()
+		if err == io.EOF {
+			if quoted {
+				return nil, fmt.Errorf("unterminated quoted field")
+			}
+			if empty {
+				return nil, io.EOF
+			}
+			return append(record, field.String()), nil
+		} else if err != nil {
+			return nil, err
+		}
+		empty = false
+		if quoted {
+			if r == d.config.Quote {
+				next, _, err := d.reader.ReadRune()
+				if err == nil && next == d.config.Quote {
+					// an escaped quote
+					field.WriteRune(r)
+					continue
+				}
+				if err == nil {
+					d.reader.UnreadRune()
+				} else if err != io.EOF {
+					return nil, err
+				}
+				quoted = false
+				continue
+			}
+			field.WriteRune(r)
+			continue
+		}
+		switch r {
+		case d.config.Quote:
+			if field.Len() == 0 && !wasQuoted {
+				quoted = true
+				wasQuoted = true
+			} else {
+				field.WriteRune(r)
+			}
+		case d.config.Delimiter:
+			record = append(record, field.String())
+			field.Reset()
+			wasQuoted = false
+		case '\r':
+			// we ignore carriage returns outside of quoted fields
+		case '\n':
+			if len(record) == 0 && field.Len() == 0 && !wasQuoted {
+				// we skip empty lines
+				empty = true
+				continue
+			}
+			return append(record, field.String()), nil
+		default:
+			field.WriteRune(r)
+		}
+	}
+}
+
+// Reads the header row if the config requires one and returns the column
+// names of the records.
+func (d *CSVDecoder) ReadHeader() ([]string, error) {
+	if d.columns == nil {
+		if d.config.Header {
+			header, err := d.readRecord()
+			if err != nil {
+				return nil, err
+			}
+			d.columns = make([]*CSVColumn, len(header))
+			for i, name := range header {
+				if column := d.config.column(name); column != nil {
+					d.columns[i] = column
+				} else {
+					d.columns[i] = &CSVColumn{Name: name, Type: "string"}
+				}
+			}
+		} else {
+			d.columns = d.config.Columns
+		}
+		d.names = make([]string, len(d.columns))
+		for i, column := range d.columns {
+			d.names[i] = column.Name
+		}
+	}
+	return d.names, nil
+}
+
+// Returns the next item or io.EOF
+func (d *CSVDecoder) Decode() (*Item, error) {
+
+	if _, err := d.ReadHeader(); err != nil {
+		return nil, err
+	}
+
+	record, err := d.readRecord()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(record) > len(d.columns) {
+		return nil, fmt.Errorf("record has %d fields, expected %d", len(record), len(d.columns))
+	}
+
+	values := make(map[string]interface{}, len(d.columns))
+
+	for i, field := range record {
+		column := d.columns[i]
+		if value, err := column.Decode(field); err != nil {
+			return nil, fmt.Errorf("invalid value for column '%s': %w", column.Name, err)
+		} else {
+			values[column.Name] = value
+		}
+	}
+
+	item := MakeItem(values)
+	// we keep the column order so that encoders can preserve it
+	item.SetKeyOrder(d.names)
+
+	return item, nil
+}
+
+// Coerces the value of a field to the type of the column
+func (c *CSVColumn) Decode(field string) (interface{}, error) {
+	if c.Type == "string" {
+		return field, nil
+	}
+	if field == "" {
+		return nil, nil
+	}
+	switch c.Type {
+	case "int":
+		return strconv.ParseInt(field, 10, 64)
+	case "float":
+		return strconv.ParseFloat(field, 64)
+	case "bool":
+		return strconv.ParseBool(field)
+	case "date":
+		t, err := time.Parse(c.Layout, field)
+		if err != nil {
+			return nil, err
+		}
+		return t.UTC().Format(time.RFC3339Nano), nil
+	}
+	return nil, fmt.Errorf("unknown column type: %s", c.Type)
+}
+
+// Formats the value of an item for the column
+func (c *CSVColumn) Encode(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		if c.Type == "date" && c.Layout != time.RFC3339 {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t.Format(c.Layout), nil
+			}
+		}
+		return v, nil
+	case time.Time:
+		if c.Layout != "" {
+			return v.Format(c.Layout), nil
+		}
+		return v.Format(time.RFC3339Nano), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	default:
+		// we encode complex values as JSON
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+}
+
+// Encodes items as RFC 4180 CSV data
+type CSVEncoder struct {
+	writer  io.Writer
+	config  *CSVConfig
+	columns []*CSVColumn
+}
+
+// Creates a new encoder. If the config does not specify any columns, the
+// given columns are used (e.g. from the header of an existing file), and
+// if these are empty as well, the keys of the first item in their original
+// order (see initColumns).
+func MakeCSVEncoder(writer io.Writer, config *CSVConfig, columns []string) *CSVEncoder {
+	encoder := &CSVEncoder{
+		writer: writer,
+		config: config,
+	}
+	if len(config.Columns) > 0 {
+		encoder.columns = config.Columns
+	} else if len(columns) > 0 {
+		encoder.columns = make([]*CSVColumn, len(columns))
+		for i, name := range columns {
+			encoder.columns[i] = &CSVColumn{Name: name, Type: "string"}
+		}
+	}
+	return encoder
+}
+
+func (e *CSVEncoder) needsQuotes(field string) bool {
+	if e.config.QuoteAll {
+		return true
+	}
+	if field == "" {
+		return false
+	}
+	if field[0] == ' ' || field[0] == '\t' {
+		return true
+	}
+	for _, r := range field {
+		if r == e.config.Delimiter || r == e.config.Quote || r == '\r' || r == '\n' {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *CSVEncoder) writeRecord(fields []string) error {
+	var buf bytes.Buffer
+	quote := string(e.config.Quote)
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteRune(e.config.Delimiter)
+		}
+		if e.needsQuotes(field) {
+			buf.WriteString(quote)
+			buf.WriteString(strings.ReplaceAll(field, quote, quote+quote))
+			buf.WriteString(quote)
+		} else {
+			buf.WriteString(field)
+		}
+	}
+	buf.WriteString("\r\n")
+	_, err := e.writer.Write(buf.Bytes())
+	return err
+}
+
+// Determines the columns from the key order of the item, e.g. the header of
+// the CSV file it was read from. Keys without an order (e.g. ones added by
+// actions) follow in sorted order.
+func (e *CSVEncoder) initColumns(item *Item) {
+	if e.columns != nil {
+		return
+	}
+	keys := make([]string, 0, len(item.All()))
+	ordered := make(map[string]bool)
+	for _, key := range item.KeyOrder() {
+		if _, ok := item.Get(key); ok && !ordered[key] {
+			keys = append(keys, key)
+			ordered[key] = true
+		}
+	}
+	unordered := make([]string, 0)
+	for _, key := range item.Keys() {
+		if !ordered[key] {
+			unordered = append(unordered, key)
+		}
+	}
+	sort.Strings(unordered)
+	keys = append(keys, unordered...)
+	e.columns = make([]*CSVColumn, len(keys))
+	for i, key := range keys {
+		e.columns[i] = &CSVColumn{Name: key, Type: "string"}
+	}
+}
+
+// Writes the header row. The item is used to determine the columns if they
+// are not known yet.
+func (e *CSVEncoder) WriteHeader(item *Item) error {
+	e.initColumns(item)
+	names := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		names[i] = column.Name
+	}
+	return e.writeRecord(names)
+}
+
+func (e *CSVEncoder) Encode(item *Item) error {
+	e.initColumns(item)
+	fields := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		value, _ := item.Get(column.Name)
+		if field, err := column.Encode(value); err != nil {
+			return err
+		} else {
+			fields[i] = field
+		}
+	}
+	return e.writeRecord(fields)
+}
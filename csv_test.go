@@ -0,0 +1,174 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func makeTestCSVConfig(t *testing.T, config map[string]interface{}, format string) *CSVConfig {
+	params, err := CSVForm.Validate(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csvConfig, err := MakeCSVConfig(params, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csvConfig
+}
+
+func decodeAll(t *testing.T, input string, config *CSVConfig) []*Item {
+	decoder := MakeCSVDecoder(bufio.NewReader(strings.NewReader(input)), config)
+	items := make([]*Item, 0)
+	for {
+		item, err := decoder.Decode()
+		if err == io.EOF {
+			return items
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+}
+
+func TestCSVDecoder(t *testing.T) {
+
+	config := makeTestCSVConfig(t, map[string]interface{}{
+		"columns": []interface{}{
+			map[string]interface{}{"name": "age", "type": "int"},
+			map[string]interface{}{"name": "score", "type": "float"},
+			map[string]interface{}{"name": "active", "type": "bool"},
+			map[string]interface{}{"name": "born", "type": "date", "layout": "2006-01-02"},
+		},
+	}, "csv")
+
+	input := "name,age,score,active,born\r\n" +
+		"\"Doe, Jane\",42,1.5,true,1980-02-01\r\n" +
+		"\n" +
+		"\"multi\nline \"\"quoted\"\"\",,,false,\n"
+
+	items := decodeAll(t, input, config)
+
+	if len(items) != 2 {
+		t.Fatalf("Expected two items, got %d", len(items))
+	}
+
+	expected := []map[string]interface{}{
+		{"name": "Doe, Jane", "age": int64(42), "score": 1.5, "active": true, "born": "1980-02-01T00:00:00Z"},
+		{"name": "multi\nline \"quoted\"", "age": nil, "score": nil, "active": false, "born": nil},
+	}
+
+	for i, item := range items {
+		for key, value := range expected[i] {
+			if v, _ := item.Get(key); v != value {
+				t.Errorf("Item %d: expected %v for '%s', got %v", i, value, key, v)
+			}
+		}
+	}
+
+	if _, err := MakeCSVDecoder(bufio.NewReader(strings.NewReader("age\nfoo\n")), config).Decode(); err == nil {
+		t.Errorf("Expected an error for an invalid integer")
+	}
+}
+
+func TestTSVWithoutHeader(t *testing.T) {
+
+	config := makeTestCSVConfig(t, map[string]interface{}{
+		"header":  false,
+		"quote":   "'",
+		"columns": []interface{}{"a", "b"},
+	}, "tsv")
+
+	items := decodeAll(t, "1\t'x\ty'\n2\t\n", config)
+
+	if len(items) != 2 {
+		t.Fatalf("Expected two items, got %d", len(items))
+	}
+
+	if b, _ := items[0].Get("b"); b != "x\ty" {
+		t.Errorf("Expected a quoted tab, got %v", b)
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+
+	config := makeTestCSVConfig(t, map[string]interface{}{
+		"columns": []interface{}{"z", "a", "m"},
+	}, "csv")
+
+	var buf bytes.Buffer
+
+	encoder := MakeCSVEncoder(&buf, config, nil)
+
+	items := []*Item{
+		MakeItem(map[string]interface{}{"a": "1,2", "m": "say \"hi\"", "z": int64(3)}),
+		MakeItem(map[string]interface{}{"a": " padded", "m": []interface{}{"x"}}),
+	}
+
+	if err := encoder.WriteHeader(items[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range items {
+		if err := encoder.Encode(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := "z,a,m\r\n3,\"1,2\",\"say \"\"hi\"\"\"\r\n,\" padded\",\"[\"\"x\"\"]\"\r\n"
+
+	if buf.String() != expected {
+		t.Fatalf("Unexpected output: %q", buf.String())
+	}
+
+	decodedItems := decodeAll(t, buf.String(), config)
+
+	if m, _ := decodedItems[0].Get("m"); m != "say \"hi\"" {
+		t.Errorf("Unexpected value after round trip: %v", m)
+	}
+}
+
+func TestCSVColumnOrder(t *testing.T) {
+
+	config := makeTestCSVConfig(t, map[string]interface{}{}, "csv")
+
+	items := decodeAll(t, "z,a,m\r\n1,2,3\r\n", config)
+
+	// a field added by an action follows the original columns
+	items[0].Set("b", "4")
+
+	var buf bytes.Buffer
+
+	encoder := MakeCSVEncoder(&buf, config, nil)
+
+	if err := encoder.WriteHeader(items[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := encoder.Encode(items[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "z,a,m,b\r\n1,2,3,4\r\n"; buf.String() != expected {
+		t.Fatalf("Unexpected output: %q", buf.String())
+	}
+}
@@ -0,0 +1,195 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/kiprotect/go-helpers/errors"
+	"github.com/kiprotect/go-helpers/forms"
+	"time"
+)
+
+// A dead letter contains an item that could not be processed, together with
+// the reason and the config that produced the error. Dead letters are sent to
+// the dead-letter destinations of a config and can be replayed once the
+// config has been fixed.
+type DeadLetter struct {
+	Item          map[string]interface{}
+	Error         string
+	Code          string
+	Action        string
+	Config        string
+	ConfigID      string
+	ConfigVersion string
+	Timestamp     time.Time
+}
+
+// Channel writers that implement this interface receive dead letters for
+// items that failed with the "report" error policy.
+type DeadLetterWriter interface {
+	DeadLetter(*DeadLetter) error
+}
+
+var DeadLetterForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the dead letter form",
+	Fields: []forms.Field{
+		{
+			Name: "item",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsStringMap{},
+			},
+		},
+		{
+			Name: "error",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "code",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "action",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "config",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "config-id",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "config-version",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "timestamp",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsTime{},
+			},
+		},
+	},
+}
+
+// Creates a dead letter for the given (original) item values. We take the
+// code from the innermost structured error and the action name from the
+// error that the processor produces for failing actions.
+func MakeDeadLetter(values map[string]interface{}, err error, config Config) *DeadLetter {
+
+	deadLetter := &DeadLetter{
+		Item:      values,
+		Error:     err.Error(),
+		Timestamp: time.Now().UTC(),
+	}
+
+	deadLetter.Code = ErrorCode(err)
+
+	for err != nil {
+		chainableErr, ok := err.(errors.ChainableError)
+		if !ok {
+			break
+		}
+		if action, ok := chainableErr.Data().(string); ok && chainableErr.Code() == "PROCESS-ACTION" {
+			deadLetter.Action = action
+		}
+		err = chainableErr.Parent()
+	}
+
+	if config != nil {
+		deadLetter.Config = config.Name()
+		deadLetter.ConfigID = hex.EncodeToString(config.ID())
+		deadLetter.ConfigVersion = config.Version()
+	}
+
+	return deadLetter
+}
+
+// Returns the code of the innermost structured error in the chain, or an
+// empty string if the error isn't structured.
+func ErrorCode(err error) string {
+	code := ""
+	for err != nil {
+		chainableErr, ok := err.(errors.ChainableError)
+		if !ok {
+			break
+		}
+		code = chainableErr.Code()
+		err = chainableErr.Parent()
+	}
+	return code
+}
+
+// Restores a dead letter from an item, e.g. when reading it from a file.
+func ParseDeadLetter(item *Item) (*DeadLetter, error) {
+
+	params, err := DeadLetterForm.Validate(item.All())
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid dead letter: %w", err)
+	}
+
+	deadLetter := &DeadLetter{
+		Item:          params["item"].(map[string]interface{}),
+		Error:         params["error"].(string),
+		Code:          params["code"].(string),
+		Action:        params["action"].(string),
+		Config:        params["config"].(string),
+		ConfigID:      params["config-id"].(string),
+		ConfigVersion: params["config-version"].(string),
+	}
+
+	if timestamp, ok := params["timestamp"].(time.Time); ok {
+		deadLetter.Timestamp = timestamp
+	}
+
+	return deadLetter, nil
+}
+
+func (d *DeadLetter) ToItem() *Item {
+	return MakeItem(map[string]interface{}{
+		"item":           d.Item,
+		"error":          d.Error,
+		"code":           d.Code,
+		"action":         d.Action,
+		"config":         d.Config,
+		"config-id":      d.ConfigID,
+		"config-version": d.ConfigVersion,
+		"timestamp":      d.Timestamp.Format(time.RFC3339Nano),
+	})
+}
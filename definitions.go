@@ -27,6 +27,7 @@ type Definitions struct {
 	ActionDefinitions
 	WriterDefinitions
 	ReaderDefinitions
+	FormatDefinitions
 	ControllerDefinitions
 	HookDefinitions
 }
@@ -39,6 +40,7 @@ func (d Definitions) Marshal() map[string]interface{} {
 		"actions":    d.ActionDefinitions,
 		"writers":    d.WriterDefinitions,
 		"readers":    d.ReaderDefinitions,
+		"formats":    d.FormatDefinitions,
 		"hooks":      d.HookDefinitions,
 	}
 }
@@ -57,6 +59,7 @@ func MergeDefinitions(a, b Definitions) Definitions {
 		ActionDefinitions:         ActionDefinitions{},
 		WriterDefinitions:         WriterDefinitions{},
 		ReaderDefinitions:         ReaderDefinitions{},
+		FormatDefinitions:         FormatDefinitions{},
 		ControllerDefinitions:     ControllerDefinitions{},
 		HookDefinitions:           make(HookDefinitions, 0),
 	}
@@ -82,6 +85,9 @@ func MergeDefinitions(a, b Definitions) Definitions {
 		for k, v := range obj.ReaderDefinitions {
 			c.ReaderDefinitions[k] = v
 		}
+		for k, v := range obj.FormatDefinitions {
+			c.FormatDefinitions[k] = v
+		}
 		for k, v := range obj.ControllerDefinitions {
 			c.ControllerDefinitions[k] = v
 		}
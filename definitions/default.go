@@ -21,6 +21,7 @@ import (
 	"github.com/kiprotect/kodex/actions"
 	"github.com/kiprotect/kodex/cmd"
 	"github.com/kiprotect/kodex/controllers"
+	"github.com/kiprotect/kodex/formats"
 	"github.com/kiprotect/kodex/parameters"
 	"github.com/kiprotect/kodex/plugins"
 	"github.com/kiprotect/kodex/readers"
@@ -34,5 +35,6 @@ var DefaultDefinitions = kodex.Definitions{
 	ActionDefinitions:         actions.Actions,
 	WriterDefinitions:         writers.Writers,
 	ReaderDefinitions:         readers.Readers,
+	FormatDefinitions:         formats.Formats,
 	ControllerDefinitions:     controllers.Controllers,
 }
@@ -0,0 +1,123 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"sync"
+)
+
+// A delivery tracks a payload while it passes through the internal channels
+// between the source, stream and destination stages. Each stage derives new
+// payloads from the payload it received, and the original payload is only
+// acknowledged once all derived payloads were acknowledged. If one of them
+// gets rejected we reject the original payload right away, so that the source
+// can redeliver it.
+type Delivery struct {
+	payload Payload
+	pending int
+	done    bool
+	mutex   sync.Mutex
+}
+
+// Creates a delivery for the given payload. The delivery holds a reference
+// itself, which needs to be released via Acknowledge or Reject after all
+// payloads were derived.
+func MakeDelivery(payload Payload) *Delivery {
+	return &Delivery{
+		payload: payload,
+		pending: 1,
+	}
+}
+
+// Returns a new payload that needs to be acknowledged before the original
+// payload can be acknowledged.
+func (d *Delivery) Derive(items []*Item, headers map[string]interface{}, endOfStream bool) Payload {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.pending++
+	return &DerivedPayload{
+		BasicPayload: MakeBasicPayload(items, headers, endOfStream),
+		delivery:     d,
+	}
+}
+
+// Releases the reference held by the delivery itself.
+func (d *Delivery) Acknowledge() error {
+	return d.release()
+}
+
+// Rejects the original payload, regardless of the derived payloads.
+func (d *Delivery) Reject() error {
+
+	d.mutex.Lock()
+
+	if d.done {
+		d.mutex.Unlock()
+		return nil
+	}
+
+	d.done = true
+	d.mutex.Unlock()
+
+	return d.payload.Reject()
+}
+
+// Returns true if the original payload was acknowledged or rejected.
+func (d *Delivery) Done() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.done
+}
+
+func (d *Delivery) release() error {
+
+	d.mutex.Lock()
+
+	if d.done {
+		d.mutex.Unlock()
+		return nil
+	}
+
+	if d.pending--; d.pending > 0 {
+		d.mutex.Unlock()
+		return nil
+	}
+
+	d.done = true
+	d.mutex.Unlock()
+
+	return d.payload.Acknowledge()
+}
+
+// A payload derived from another payload through a delivery.
+type DerivedPayload struct {
+	*BasicPayload
+	delivery *Delivery
+	once     sync.Once
+}
+
+func (p *DerivedPayload) Acknowledge() error {
+	var err error
+	p.once.Do(func() { err = p.delivery.release() })
+	return err
+}
+
+func (p *DerivedPayload) Reject() error {
+	var err error
+	p.once.Do(func() { err = p.delivery.Reject() })
+	return err
+}
@@ -32,10 +32,13 @@ const (
 	ErrorDestination    DestinationStatus = "error"
 	WarningDestination  DestinationStatus = "warning"
 	MessageDestination  DestinationStatus = "message"
+	// receives items that could not be processed, see DeadLetter
+	DeadLetterDestination DestinationStatus = "dead-letter"
 )
 
 type Destination interface {
 	Processable // Processable includes Model
+	ParallelModel
 	Writer() (Writer, error)
 	ConfigData() map[string]interface{}
 	SetConfigData(map[string]interface{}) error
@@ -111,12 +114,14 @@ func (b *BaseDestination) Create(values map[string]interface{}) error {
 func (b *BaseDestination) MarshalJSON() ([]byte, error) {
 
 	data := map[string]interface{}{
-		"name":        b.Self.Name(),
-		"description": b.Self.Description(),
-		"type":        b.Self.DestinationType(),
-		"config":      b.Self.ConfigData(),
-		"project":     b.Self.Project(),
-		"data":        b.Self.Data(),
+		"name":          b.Self.Name(),
+		"description":   b.Self.Description(),
+		"type":          b.Self.DestinationType(),
+		"config":        b.Self.ConfigData(),
+		"project":       b.Self.Project(),
+		"data":          b.Self.Data(),
+		"workers":       b.Self.Workers(),
+		"partition-key": b.Self.PartitionKey(),
 	}
 
 	for k, v := range JSONData(b.Self) {
@@ -141,6 +146,10 @@ func (b *BaseDestination) update(params map[string]interface{}) error {
 			err = b.Self.SetData(value)
 		case "type":
 			err = b.Self.SetDestinationType(value.(string))
+		case "workers":
+			err = b.Self.SetWorkers(int(value.(int64)))
+		case "partition-key":
+			err = b.Self.SetPartitionKey(value.(string))
 		}
 		if err != nil {
 			return err
@@ -188,6 +197,14 @@ var DestinationForm = forms.Form{
 			Name:       "type",
 			Validators: []forms.Validator{forms.IsString{}},
 		},
+		{
+			Name:       "workers",
+			Validators: WorkersValidators,
+		},
+		{
+			Name:       "partition-key",
+			Validators: PartitionKeyValidators,
+		},
 	},
 }
 
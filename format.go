@@ -0,0 +1,100 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"bytes"
+	"github.com/kiprotect/go-helpers/forms"
+	"io"
+)
+
+type FormatDefinition struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	MIMEType    string      `json:"mime-type"`
+	Maker       FormatMaker `json:"-"`
+	Form        forms.Form  `json:"form"`
+}
+
+type FormatMaker func(map[string]interface{}) (Format, error)
+type FormatDefinitions map[string]FormatDefinition
+
+// A format creates encoders and decoders that (de)serialize items.
+type Format interface {
+	Encoder(io.Writer) (ItemEncoder, error)
+	Decoder(io.Reader) (ItemDecoder, error)
+}
+
+// A format that is able to append items to existing data, e.g. CSV, where
+// we need to reuse the header of the existing data.
+type AppendingFormat interface {
+	Format
+	// Returns an encoder that appends to the existing data
+	AppendEncoder(writer io.Writer, existing io.Reader) (ItemEncoder, error)
+}
+
+// A format that stores items in self-contained files, e.g. Parquet. Such
+// files are read with random access and cannot be appended to, so writers
+// keep a single encoder open for each file.
+type FileFormat interface {
+	Format
+	FileDecoder(reader io.ReaderAt, size int64) (ItemDecoder, error)
+}
+
+// A format whose data starts with a header line, e.g. CSV. Readers that
+// resume in the middle of the data need to provide the header to decoders.
+type HeaderFormat interface {
+	Format
+	HasHeader() bool
+}
+
+type ItemEncoder interface {
+	Encode(*Item) error
+	// Writes any buffered data and closing delimiters to the writer
+	Close() error
+}
+
+// Decodes items one at a time and returns io.EOF once the input is exhausted.
+type ItemDecoder interface {
+	Decode() (*Item, error)
+}
+
+// Serializes a single item using the given format
+func (f *Item) Serialize(format Format) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder, err := format.Encoder(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := encoder.Encode(f); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encodes all items using the given encoder and closes it
+func EncodeItems(encoder ItemEncoder, items []*Item) error {
+	for _, item := range items {
+		if err := encoder.Encode(item); err != nil {
+			return err
+		}
+	}
+	return encoder.Close()
+}
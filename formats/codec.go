@@ -0,0 +1,78 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package formats
+
+import (
+	"github.com/kiprotect/kodex"
+	"github.com/ugorji/go/codec"
+	"io"
+	"reflect"
+)
+
+var stringMapType = reflect.TypeOf(map[string]interface{}(nil))
+
+// A binary format based on a codec handle. Items are written as a plain
+// sequence of maps without any framing.
+type CodecFormat struct {
+	handle codec.Handle
+}
+
+func MakeMessagePackFormat(config map[string]interface{}) (kodex.Format, error) {
+	handle := &codec.MsgpackHandle{}
+	handle.MapType = stringMapType
+	handle.WriteExt = true
+	handle.RawToString = true
+	return &CodecFormat{handle: handle}, nil
+}
+
+func MakeCBORFormat(config map[string]interface{}) (kodex.Format, error) {
+	handle := &codec.CborHandle{}
+	handle.MapType = stringMapType
+	return &CodecFormat{handle: handle}, nil
+}
+
+func (f *CodecFormat) Encoder(writer io.Writer) (kodex.ItemEncoder, error) {
+	return &codecEncoder{encoder: codec.NewEncoder(writer, f.handle)}, nil
+}
+
+func (f *CodecFormat) Decoder(reader io.Reader) (kodex.ItemDecoder, error) {
+	return &codecDecoder{decoder: codec.NewDecoder(bufferedReader(reader), f.handle)}, nil
+}
+
+type codecEncoder struct {
+	encoder *codec.Encoder
+}
+
+func (c *codecEncoder) Encode(item *kodex.Item) error {
+	return c.encoder.Encode(item.All())
+}
+
+func (c *codecEncoder) Close() error {
+	return nil
+}
+
+type codecDecoder struct {
+	decoder *codec.Decoder
+}
+
+func (c *codecDecoder) Decode() (*kodex.Item, error) {
+	item := make(map[string]interface{})
+	if err := c.decoder.Decode(&item); err != nil {
+		return nil, err
+	}
+	return kodex.MakeItem(item), nil
+}
@@ -0,0 +1,95 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package formats
+
+import (
+	"github.com/kiprotect/kodex"
+	"io"
+)
+
+// CSV or TSV data, see kodex.CSVConfig for the available options.
+type CSVFormat struct {
+	config *kodex.CSVConfig
+}
+
+func makeCSVFormat(config map[string]interface{}, format string) (kodex.Format, error) {
+	params, err := kodex.CSVForm.Validate(config)
+	if err != nil {
+		return nil, err
+	}
+	csvConfig, err := kodex.MakeCSVConfig(params, format)
+	if err != nil {
+		return nil, err
+	}
+	return &CSVFormat{config: csvConfig}, nil
+}
+
+func MakeCSVFormat(config map[string]interface{}) (kodex.Format, error) {
+	return makeCSVFormat(config, "csv")
+}
+
+func MakeTSVFormat(config map[string]interface{}) (kodex.Format, error) {
+	return makeCSVFormat(config, "tsv")
+}
+
+func (f *CSVFormat) Encoder(writer io.Writer) (kodex.ItemEncoder, error) {
+	return &csvEncoder{
+		encoder:     kodex.MakeCSVEncoder(writer, f.config, nil),
+		writeHeader: f.config.Header,
+	}, nil
+}
+
+// Appends to existing (non-empty) CSV data, reusing the column order of its
+// header.
+func (f *CSVFormat) AppendEncoder(writer io.Writer, existing io.Reader) (kodex.ItemEncoder, error) {
+	var columns []string
+	if f.config.Header && len(f.config.Columns) == 0 {
+		var err error
+		decoder := kodex.MakeCSVDecoder(bufferedReader(existing), f.config)
+		if columns, err = decoder.ReadHeader(); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	return &csvEncoder{encoder: kodex.MakeCSVEncoder(writer, f.config, columns)}, nil
+}
+
+func (f *CSVFormat) HasHeader() bool {
+	return f.config.Header
+}
+
+func (f *CSVFormat) Decoder(reader io.Reader) (kodex.ItemDecoder, error) {
+	return kodex.MakeCSVDecoder(bufferedReader(reader), f.config), nil
+}
+
+type csvEncoder struct {
+	encoder     *kodex.CSVEncoder
+	writeHeader bool
+}
+
+func (c *csvEncoder) Encode(item *kodex.Item) error {
+	if c.writeHeader {
+		if err := c.encoder.WriteHeader(item); err != nil {
+			return err
+		}
+		c.writeHeader = false
+	}
+	return c.encoder.Encode(item)
+}
+
+func (c *csvEncoder) Close() error {
+	return nil
+}
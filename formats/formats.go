@@ -0,0 +1,126 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package formats
+
+import (
+	"github.com/kiprotect/go-helpers/errors"
+	"github.com/kiprotect/kodex"
+)
+
+var Formats = kodex.FormatDefinitions{
+	"json": kodex.FormatDefinition{
+		Name:        "JSON",
+		Description: "A stream of JSON objects, typically separated by newlines",
+		MIMEType:    "application/json",
+		Maker:       MakeJSONFormat,
+	},
+	"ndjson": kodex.FormatDefinition{
+		Name:        "NDJSON",
+		Description: "Newline-delimited JSON objects, one per line",
+		MIMEType:    "application/x-ndjson",
+		Maker:       MakeNDJSONFormat,
+	},
+	"json-array": kodex.FormatDefinition{
+		Name:        "JSON array",
+		Description: "A JSON array of objects that is decoded in a streaming fashion",
+		MIMEType:    "application/json",
+		Maker:       MakeJSONArrayFormat,
+	},
+	"yaml": kodex.FormatDefinition{
+		Name:        "YAML",
+		Description: "A stream of YAML documents",
+		MIMEType:    "application/yaml",
+		Maker:       MakeYAMLFormat,
+	},
+	"msgpack": kodex.FormatDefinition{
+		Name:        "MessagePack",
+		Description: "A stream of MessagePack maps",
+		MIMEType:    "application/msgpack",
+		Maker:       MakeMessagePackFormat,
+	},
+	"cbor": kodex.FormatDefinition{
+		Name:        "CBOR",
+		Description: "A sequence of CBOR maps (RFC 8742)",
+		MIMEType:    "application/cbor-seq",
+		Maker:       MakeCBORFormat,
+	},
+	"parquet": kodex.FormatDefinition{
+		Name:        "Parquet",
+		Description: "Apache Parquet files",
+		MIMEType:    "application/vnd.apache.parquet",
+		Maker:       MakeParquetFormat,
+		Form:        ParquetForm,
+	},
+	"csv": kodex.FormatDefinition{
+		Name:        "CSV",
+		Description: "Comma-separated values (RFC 4180)",
+		MIMEType:    "text/csv",
+		Maker:       MakeCSVFormat,
+		Form:        kodex.CSVForm,
+	},
+	"tsv": kodex.FormatDefinition{
+		Name:        "TSV",
+		Description: "Tab-separated values",
+		MIMEType:    "text/tab-separated-values",
+		Maker:       MakeTSVFormat,
+		Form:        kodex.CSVForm,
+	},
+}
+
+// Returns the definition of the format with the given name. Formats in the
+// given definitions (which may contain formats provided by plugins) take
+// precedence over the built-in ones.
+func Get(definitions *kodex.Definitions, name string) (kodex.FormatDefinition, error) {
+	if definitions != nil {
+		if definition, ok := definitions.FormatDefinitions[name]; ok {
+			return definition, nil
+		}
+	}
+	if definition, ok := Formats[name]; ok {
+		return definition, nil
+	}
+	return kodex.FormatDefinition{}, errors.MakeExternalError("unknown format", "UNKNOWN-FORMAT", map[string]interface{}{"format": name}, nil)
+}
+
+// Looks up the format with the given name and creates it with the config
+func Make(definitions *kodex.Definitions, name string, config map[string]interface{}) (kodex.FormatDefinition, kodex.Format, error) {
+	definition, err := Get(definitions, name)
+	if err != nil {
+		return definition, nil, err
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	format, err := definition.Maker(config)
+	return definition, format, err
+}
+
+// Returns the definitions of the controller of the given stream, if any
+func StreamDefinitions(stream kodex.Stream) *kodex.Definitions {
+	if stream == nil || stream.Project() == nil || stream.Project().Controller() == nil {
+		return nil
+	}
+	return stream.Project().Controller().Definitions()
+}
+
+// Returns the definitions of the controller of the given config, if any
+func ConfigDefinitions(config kodex.Config) *kodex.Definitions {
+	if config == nil {
+		return nil
+	}
+	return StreamDefinitions(config.Stream())
+}
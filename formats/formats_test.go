@@ -0,0 +1,135 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package formats_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/formats"
+	"io"
+	"testing"
+)
+
+func makeItems() []*kodex.Item {
+	return []*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"name": "a, \"b\"", "value": 1.5}),
+		kodex.MakeItem(map[string]interface{}{"name": "c\nd", "value": -2.0}),
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+
+	for name := range formats.Formats {
+
+		t.Run(name, func(t *testing.T) {
+
+			_, format, err := formats.Make(nil, name, nil)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+
+			batches := 2
+
+			// self-contained files cannot be concatenated
+			if _, ok := format.(kodex.FileFormat); ok {
+				batches = 1
+			}
+
+			// we write two batches to make sure that concatenated output
+			// can be decoded as well
+			for i := 0; i < batches; i++ {
+				var encoder kodex.ItemEncoder
+				if appendingFormat, ok := format.(kodex.AppendingFormat); ok && i > 0 {
+					encoder, err = appendingFormat.AppendEncoder(&buf, bytes.NewReader(buf.Bytes()))
+				} else {
+					encoder, err = format.Encoder(&buf)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := kodex.EncodeItems(encoder, makeItems()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			decoder, err := format.Decoder(bytes.NewReader(buf.Bytes()))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := make([]*kodex.Item, 0)
+
+			for i := 0; i < batches; i++ {
+				expected = append(expected, makeItems()...)
+			}
+
+			for i, expectedItem := range expected {
+				item, err := decoder.Decode()
+				if err != nil {
+					t.Fatalf("Cannot decode item %d: %v", i, err)
+				}
+				for _, key := range []string{"name", "value"} {
+					value, _ := item.Get(key)
+					expectedValue, _ := expectedItem.Get(key)
+					if fmt.Sprint(value) != fmt.Sprint(expectedValue) {
+						t.Errorf("Item %d: expected %s to be %v, got %v", i, key, expectedValue, value)
+					}
+				}
+			}
+
+			if _, err := decoder.Decode(); err != io.EOF {
+				t.Fatalf("Expected the end of the data, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, _, err := formats.Make(nil, "xml", nil); err == nil {
+		t.Fatalf("Expected an error")
+	}
+}
+
+func TestDefinitionFormats(t *testing.T) {
+
+	definitions := &kodex.Definitions{
+		FormatDefinitions: kodex.FormatDefinitions{
+			"json": kodex.FormatDefinition{
+				MIMEType: "application/vnd.example+json",
+				Maker:    formats.MakeJSONFormat,
+			},
+		},
+	}
+
+	// formats from the definitions take precedence
+	if definition, err := formats.Get(definitions, "json"); err != nil {
+		t.Fatal(err)
+	} else if definition.MIMEType != "application/vnd.example+json" {
+		t.Errorf("Expected the format from the definitions")
+	}
+
+	if definition, err := formats.Get(definitions, "yaml"); err != nil {
+		t.Fatal(err)
+	} else if definition.MIMEType != "application/yaml" {
+		t.Errorf("Expected the built-in format")
+	}
+}
@@ -0,0 +1,195 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package formats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"io"
+)
+
+// Reads a stream of JSON objects separated by whitespace, which includes
+// newline-delimited JSON as well as pretty-printed objects. Items are
+// written one per line.
+type JSONFormat struct{}
+
+func MakeJSONFormat(config map[string]interface{}) (kodex.Format, error) {
+	return &JSONFormat{}, nil
+}
+
+func (f *JSONFormat) Encoder(writer io.Writer) (kodex.ItemEncoder, error) {
+	return &jsonLineEncoder{writer: writer}, nil
+}
+
+func (f *JSONFormat) Decoder(reader io.Reader) (kodex.ItemDecoder, error) {
+	return &jsonStreamDecoder{decoder: json.NewDecoder(reader)}, nil
+}
+
+// Newline-delimited JSON, where every non-empty line contains exactly one
+// object.
+type NDJSONFormat struct{}
+
+func MakeNDJSONFormat(config map[string]interface{}) (kodex.Format, error) {
+	return &NDJSONFormat{}, nil
+}
+
+func (f *NDJSONFormat) Encoder(writer io.Writer) (kodex.ItemEncoder, error) {
+	return &jsonLineEncoder{writer: writer}, nil
+}
+
+func (f *NDJSONFormat) Decoder(reader io.Reader) (kodex.ItemDecoder, error) {
+	return &jsonLineDecoder{reader: bufferedReader(reader)}, nil
+}
+
+// A JSON array of objects. The decoder reads the array element by element
+// so that large arrays do not need to fit into memory, and accepts several
+// consecutive arrays (e.g. from a file that we appended to).
+type JSONArrayFormat struct{}
+
+func MakeJSONArrayFormat(config map[string]interface{}) (kodex.Format, error) {
+	return &JSONArrayFormat{}, nil
+}
+
+func (f *JSONArrayFormat) Encoder(writer io.Writer) (kodex.ItemEncoder, error) {
+	return &jsonArrayEncoder{writer: writer}, nil
+}
+
+func (f *JSONArrayFormat) Decoder(reader io.Reader) (kodex.ItemDecoder, error) {
+	return &jsonArrayDecoder{decoder: json.NewDecoder(reader)}, nil
+}
+
+func bufferedReader(reader io.Reader) *bufio.Reader {
+	if bufReader, ok := reader.(*bufio.Reader); ok {
+		return bufReader
+	}
+	return bufio.NewReader(reader)
+}
+
+type jsonLineEncoder struct {
+	writer io.Writer
+}
+
+func (j *jsonLineEncoder) Encode(item *kodex.Item) error {
+	data, err := json.Marshal(item.All())
+	if err != nil {
+		return err
+	}
+	_, err = j.writer.Write(append(data, '\n'))
+	return err
+}
+
+func (j *jsonLineEncoder) Close() error {
+	return nil
+}
+
+type jsonStreamDecoder struct {
+	decoder *json.Decoder
+}
+
+func (j *jsonStreamDecoder) Decode() (*kodex.Item, error) {
+	item := make(map[string]interface{})
+	if err := j.decoder.Decode(&item); err != nil {
+		return nil, err
+	}
+	return kodex.MakeItem(item), nil
+}
+
+// Decodes newline-separated JSON objects, skipping empty lines.
+type jsonLineDecoder struct {
+	reader *bufio.Reader
+}
+
+func (j *jsonLineDecoder) Decode() (*kodex.Item, error) {
+	for {
+		line, err := j.reader.ReadBytes('\n')
+		if err != nil && !(err == io.EOF && len(line) > 0) {
+			return nil, err
+		}
+		if len(line) <= 1 {
+			continue
+		}
+		item := make(map[string]interface{})
+		if err := json.Unmarshal(line, &item); err != nil {
+			return nil, err
+		}
+		return kodex.MakeItem(item), nil
+	}
+}
+
+type jsonArrayEncoder struct {
+	writer  io.Writer
+	started bool
+}
+
+func (j *jsonArrayEncoder) Encode(item *kodex.Item) error {
+	data, err := json.Marshal(item.All())
+	if err != nil {
+		return err
+	}
+	prefix := []byte(",\n")
+	if !j.started {
+		prefix = []byte("[\n")
+		j.started = true
+	}
+	_, err = j.writer.Write(append(prefix, data...))
+	return err
+}
+
+func (j *jsonArrayEncoder) Close() error {
+	var err error
+	if j.started {
+		_, err = j.writer.Write([]byte("\n]\n"))
+	} else {
+		_, err = j.writer.Write([]byte("[]\n"))
+	}
+	j.started = false
+	return err
+}
+
+type jsonArrayDecoder struct {
+	decoder *json.Decoder
+	inArray bool
+}
+
+func (j *jsonArrayDecoder) Decode() (*kodex.Item, error) {
+	for {
+		if !j.inArray {
+			token, err := j.decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			if delim, ok := token.(json.Delim); !ok || delim != '[' {
+				return nil, fmt.Errorf("expected a JSON array")
+			}
+			j.inArray = true
+		}
+		if j.decoder.More() {
+			item := make(map[string]interface{})
+			if err := j.decoder.Decode(&item); err != nil {
+				return nil, err
+			}
+			return kodex.MakeItem(item), nil
+		}
+		// we consume the closing bracket
+		if _, err := j.decoder.Token(); err != nil {
+			return nil, err
+		}
+		j.inArray = false
+	}
+}
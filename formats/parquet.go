@@ -0,0 +1,622 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package formats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/deprecated"
+	"io"
+	"sort"
+	"time"
+)
+
+var ParquetColumnForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the Parquet column form",
+	Fields: []forms.Field{
+		{
+			Name: "name",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "type",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "string"},
+				forms.IsIn{Choices: []interface{}{"string", "int", "float", "bool", "timestamp", "bytes", "json"}},
+			},
+		},
+		{
+			// required columns must be present in every item
+			Name: "required",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+	},
+}
+
+var ParquetForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the Parquet form",
+	Fields: []forms.Field{
+		{
+			// the schema used for writing, inferred from the first row group
+			// if empty. Fields of items that are not in the schema are ignored.
+			Name: "schema",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{}},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsStringMap{
+							Form: &ParquetColumnForm,
+						},
+					},
+				},
+			},
+		},
+		{
+			// the number of rows per row group
+			Name: "row-group-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 10000},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			Name: "compression",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "snappy"},
+				forms.IsIn{Choices: []interface{}{"none", "snappy", "zstd"}},
+			},
+		},
+		{
+			// the columns to read, all columns are read if empty
+			Name: "columns",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{}},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsString{},
+					},
+				},
+			},
+		},
+	},
+}
+
+var parquetCompression = map[string]compress.Codec{
+	"none":   &parquet.Uncompressed,
+	"snappy": &parquet.Snappy,
+	"zstd":   &parquet.Zstd,
+}
+
+type ParquetColumn struct {
+	Name     string
+	Type     string
+	Required bool
+}
+
+// Apache Parquet files. As Parquet files end with a footer, encoders only
+// produce a valid file once they are closed, and files cannot be appended to.
+type ParquetFormat struct {
+	Schema       []*ParquetColumn
+	RowGroupSize int64
+	Compression  string
+	Columns      []string
+}
+
+func MakeParquetFormat(config map[string]interface{}) (kodex.Format, error) {
+
+	params, err := ParquetForm.Validate(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	format := &ParquetFormat{
+		Schema:       make([]*ParquetColumn, 0),
+		RowGroupSize: params["row-group-size"].(int64),
+		Compression:  params["compression"].(string),
+		Columns:      make([]string, 0),
+	}
+
+	names := map[string]bool{}
+
+	for _, column := range params["schema"].([]interface{}) {
+		columnMap := column.(map[string]interface{})
+		name := columnMap["name"].(string)
+		if names[name] {
+			return nil, fmt.Errorf("duplicate column: %s", name)
+		}
+		names[name] = true
+		format.Schema = append(format.Schema, &ParquetColumn{
+			Name:     name,
+			Type:     columnMap["type"].(string),
+			Required: columnMap["required"].(bool),
+		})
+	}
+
+	for _, column := range params["columns"].([]interface{}) {
+		format.Columns = append(format.Columns, column.(string))
+	}
+
+	return format, nil
+}
+
+func (f *ParquetFormat) Encoder(writer io.Writer) (kodex.ItemEncoder, error) {
+	return &parquetEncoder{
+		output: writer,
+		format: f,
+	}, nil
+}
+
+// Parquet requires random access, so we read the data into memory first.
+// Use FileDecoder to avoid this.
+func (f *ParquetFormat) Decoder(reader io.Reader) (kodex.ItemDecoder, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return f.FileDecoder(bytes.NewReader(data), int64(len(data)))
+}
+
+func (f *ParquetFormat) FileDecoder(reader io.ReaderAt, size int64) (kodex.ItemDecoder, error) {
+
+	if size == 0 {
+		return &parquetDecoder{}, nil
+	}
+
+	file, err := parquet.OpenFile(reader, size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	schema := file.Schema()
+
+	if len(f.Columns) > 0 {
+		fields := map[string]parquet.Field{}
+		for _, field := range schema.Fields() {
+			fields[field.Name()] = field
+		}
+		group := parquet.Group{}
+		for _, name := range f.Columns {
+			field, ok := fields[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown column: %s", name)
+			}
+			group[name] = field
+		}
+		schema = parquet.NewSchema(schema.Name(), group)
+	}
+
+	decoder := &parquetDecoder{
+		rows: make([]parquet.Row, 128),
+	}
+
+	for _, field := range schema.Fields() {
+		if !field.Leaf() || field.Repeated() {
+			return nil, fmt.Errorf("column %s: only flat columns are supported", field.Name())
+		}
+		decoder.columns = append(decoder.columns, parquetReadColumn{
+			name:   field.Name(),
+			decode: parquetValueDecoder(field.Type()),
+		})
+	}
+
+	decoder.reader = parquet.NewReader(file, schema)
+
+	return decoder, nil
+}
+
+type parquetEncoder struct {
+	output  io.Writer
+	format  *ParquetFormat
+	writer  *parquet.Writer
+	columns []*ParquetColumn
+	pending []*kodex.Item
+	rows    []parquet.Row
+}
+
+func (p *parquetEncoder) Encode(item *kodex.Item) error {
+	if p.writer == nil {
+		// we collect the first row group to infer the schema if necessary
+		p.pending = append(p.pending, item)
+		if len(p.format.Schema) == 0 && int64(len(p.pending)) < p.format.RowGroupSize {
+			return nil
+		}
+		return p.start()
+	}
+	return p.write(item)
+}
+
+func (p *parquetEncoder) start() error {
+
+	p.columns = p.format.Schema
+
+	if len(p.columns) == 0 {
+		p.columns = inferParquetColumns(p.pending)
+	}
+
+	group := parquet.Group{}
+
+	for _, column := range p.columns {
+		group[column.Name] = parquetNode(column)
+	}
+
+	schema := parquet.NewSchema("item", group)
+
+	// the columns of a group are sorted by name
+	p.columns = append([]*ParquetColumn{}, p.columns...)
+	sort.Slice(p.columns, func(i, j int) bool { return p.columns[i].Name < p.columns[j].Name })
+
+	config, err := parquet.NewWriterConfig(
+		schema,
+		parquet.Compression(parquetCompression[p.format.Compression]),
+		parquet.MaxRowsPerRowGroup(p.format.RowGroupSize),
+		parquet.CreatedBy("kodex", kodex.Version, ""),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	p.writer = parquet.NewWriter(p.output, config)
+
+	for _, item := range p.pending {
+		if err := p.write(item); err != nil {
+			return err
+		}
+	}
+
+	p.pending = nil
+
+	return nil
+}
+
+func (p *parquetEncoder) write(item *kodex.Item) error {
+
+	row := make(parquet.Row, len(p.columns))
+
+	for i, column := range p.columns {
+		value, ok := item.Get(column.Name)
+		if !ok || value == nil {
+			if column.Required {
+				return fmt.Errorf("required column %s is missing", column.Name)
+			}
+			row[i] = parquet.NullValue().Level(0, 0, i)
+			continue
+		}
+		parquetValue, err := parquetValueOf(column.Type, value)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", column.Name, err)
+		}
+		definitionLevel := 1
+		if column.Required {
+			definitionLevel = 0
+		}
+		row[i] = parquetValue.Level(0, definitionLevel, i)
+	}
+
+	p.rows = append(p.rows, row)
+
+	if len(p.rows) >= 1024 {
+		return p.flushRows()
+	}
+
+	return nil
+}
+
+func (p *parquetEncoder) flushRows() error {
+	if _, err := p.writer.WriteRows(p.rows); err != nil {
+		return err
+	}
+	p.rows = p.rows[:0]
+	return nil
+}
+
+func (p *parquetEncoder) Close() error {
+	if p.writer == nil {
+		// without items and schema there is nothing to write
+		if len(p.pending) == 0 && len(p.format.Schema) == 0 {
+			return nil
+		}
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+	if err := p.flushRows(); err != nil {
+		return err
+	}
+	return p.writer.Close()
+}
+
+func inferParquetColumns(items []*kodex.Item) []*ParquetColumn {
+	types := map[string]string{}
+	for _, item := range items {
+		for key, value := range item.All() {
+			if value == nil {
+				if _, ok := types[key]; !ok {
+					types[key] = ""
+				}
+				continue
+			}
+			valueType := parquetTypeOf(value)
+			if existingType := types[key]; existingType == "" {
+				types[key] = valueType
+			} else if existingType == "int" && valueType == "float" {
+				types[key] = "float"
+			}
+		}
+	}
+	columns := make([]*ParquetColumn, 0, len(types))
+	for name, columnType := range types {
+		if columnType == "" {
+			columnType = "string"
+		}
+		columns = append(columns, &ParquetColumn{Name: name, Type: columnType})
+	}
+	return columns
+}
+
+func parquetTypeOf(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+		return "int"
+	case float32, float64:
+		return "float"
+	case time.Time:
+		return "timestamp"
+	case []byte:
+		return "bytes"
+	}
+	return "json"
+}
+
+func parquetNode(column *ParquetColumn) parquet.Node {
+	var node parquet.Node
+	switch column.Type {
+	case "int":
+		node = parquet.Int(64)
+	case "float":
+		node = parquet.Leaf(parquet.DoubleType)
+	case "bool":
+		node = parquet.Leaf(parquet.BooleanType)
+	case "timestamp":
+		node = parquet.Timestamp(parquet.Microsecond)
+	case "bytes":
+		node = parquet.Leaf(parquet.ByteArrayType)
+	case "json":
+		node = parquet.JSON()
+	default:
+		node = parquet.String()
+	}
+	if column.Required {
+		return parquet.Required(node)
+	}
+	return parquet.Optional(node)
+}
+
+func parquetValueOf(columnType string, value interface{}) (parquet.Value, error) {
+	switch columnType {
+	case "string":
+		if s, ok := value.(string); ok {
+			return parquet.ByteArrayValue([]byte(s)), nil
+		}
+		return parquet.ByteArrayValue([]byte(fmt.Sprint(value))), nil
+	case "int":
+		switch v := value.(type) {
+		case int:
+			return parquet.Int64Value(int64(v)), nil
+		case int8:
+			return parquet.Int64Value(int64(v)), nil
+		case int16:
+			return parquet.Int64Value(int64(v)), nil
+		case int32:
+			return parquet.Int64Value(int64(v)), nil
+		case int64:
+			return parquet.Int64Value(v), nil
+		case uint8:
+			return parquet.Int64Value(int64(v)), nil
+		case uint16:
+			return parquet.Int64Value(int64(v)), nil
+		case uint32:
+			return parquet.Int64Value(int64(v)), nil
+		case uint64:
+			return parquet.Int64Value(int64(v)), nil
+		case float64:
+			// JSON numbers are decoded as floats
+			if v == float64(int64(v)) {
+				return parquet.Int64Value(int64(v)), nil
+			}
+		}
+	case "float":
+		switch v := value.(type) {
+		case float64:
+			return parquet.DoubleValue(v), nil
+		case float32:
+			return parquet.DoubleValue(float64(v)), nil
+		case int:
+			return parquet.DoubleValue(float64(v)), nil
+		case int64:
+			return parquet.DoubleValue(float64(v)), nil
+		case int32:
+			return parquet.DoubleValue(float64(v)), nil
+		}
+	case "bool":
+		if b, ok := value.(bool); ok {
+			return parquet.BooleanValue(b), nil
+		}
+	case "timestamp":
+		switch v := value.(type) {
+		case time.Time:
+			return parquet.Int64Value(v.UnixMicro()), nil
+		case string:
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return parquet.Int64Value(t.UnixMicro()), nil
+			}
+		}
+	case "bytes":
+		switch v := value.(type) {
+		case []byte:
+			return parquet.ByteArrayValue(v), nil
+		case string:
+			return parquet.ByteArrayValue([]byte(v)), nil
+		}
+	case "json":
+		if data, err := json.Marshal(value); err != nil {
+			return parquet.Value{}, err
+		} else {
+			return parquet.ByteArrayValue(data), nil
+		}
+	}
+	return parquet.Value{}, fmt.Errorf("cannot convert %T to %s", value, columnType)
+}
+
+type parquetReadColumn struct {
+	name   string
+	decode func(parquet.Value) (interface{}, error)
+}
+
+type parquetDecoder struct {
+	reader  *parquet.Reader
+	columns []parquetReadColumn
+	rows    []parquet.Row
+	n, i    int
+	err     error
+}
+
+func (p *parquetDecoder) Decode() (*kodex.Item, error) {
+
+	if p.reader == nil {
+		return nil, io.EOF
+	}
+
+	for p.i >= p.n {
+		if p.err != nil {
+			return nil, p.err
+		}
+		p.n, p.err = p.reader.ReadRows(p.rows)
+		p.i = 0
+		if p.n == 0 && p.err == nil {
+			p.err = io.EOF
+		}
+	}
+
+	row := p.rows[p.i]
+	p.i++
+
+	item := make(map[string]interface{}, len(p.columns))
+
+	for _, value := range row {
+		if value.IsNull() {
+			continue
+		}
+		column := p.columns[value.Column()]
+		if decodedValue, err := column.decode(value); err != nil {
+			return nil, fmt.Errorf("column %s: %w", column.name, err)
+		} else {
+			item[column.name] = decodedValue
+		}
+	}
+
+	return kodex.MakeItem(item), nil
+}
+
+// Returns a function that converts values of the given type to item values
+func parquetValueDecoder(t parquet.Type) func(parquet.Value) (interface{}, error) {
+
+	logicalType := t.LogicalType()
+	convertedType := t.ConvertedType()
+
+	isConverted := func(ct deprecated.ConvertedType) bool {
+		return convertedType != nil && *convertedType == ct
+	}
+
+	switch {
+	case logicalType != nil && logicalType.Timestamp != nil:
+		unit := logicalType.Timestamp.Unit
+		return func(v parquet.Value) (interface{}, error) {
+			switch {
+			case unit.Millis != nil:
+				return time.UnixMilli(v.Int64()).UTC(), nil
+			case unit.Nanos != nil:
+				return time.Unix(0, v.Int64()).UTC(), nil
+			}
+			return time.UnixMicro(v.Int64()).UTC(), nil
+		}
+	case logicalType != nil && logicalType.Date != nil:
+		return func(v parquet.Value) (interface{}, error) {
+			return time.Unix(int64(v.Int32())*86400, 0).UTC(), nil
+		}
+	case logicalType != nil && logicalType.Json != nil, isConverted(deprecated.Json):
+		return func(v parquet.Value) (interface{}, error) {
+			var value interface{}
+			if err := json.Unmarshal(v.ByteArray(), &value); err != nil {
+				return nil, err
+			}
+			return value, nil
+		}
+	case logicalType != nil && (logicalType.UTF8 != nil || logicalType.Enum != nil), isConverted(deprecated.UTF8), isConverted(deprecated.Enum):
+		return func(v parquet.Value) (interface{}, error) {
+			return string(v.ByteArray()), nil
+		}
+	}
+
+	switch t.Kind() {
+	case parquet.Boolean:
+		return func(v parquet.Value) (interface{}, error) {
+			return v.Boolean(), nil
+		}
+	case parquet.Int32:
+		return func(v parquet.Value) (interface{}, error) {
+			return int64(v.Int32()), nil
+		}
+	case parquet.Int64:
+		return func(v parquet.Value) (interface{}, error) {
+			return v.Int64(), nil
+		}
+	case parquet.Float:
+		return func(v parquet.Value) (interface{}, error) {
+			return float64(v.Float()), nil
+		}
+	case parquet.Double:
+		return func(v parquet.Value) (interface{}, error) {
+			return v.Double(), nil
+		}
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		return func(v parquet.Value) (interface{}, error) {
+			return append([]byte{}, v.ByteArray()...), nil
+		}
+	}
+
+	return func(v parquet.Value) (interface{}, error) {
+		return nil, fmt.Errorf("unsupported type: %s", t)
+	}
+}
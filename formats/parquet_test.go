@@ -0,0 +1,223 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package formats_test
+
+import (
+	"bytes"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/formats"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+	"io"
+	"testing"
+	"time"
+)
+
+func encodeParquet(t *testing.T, config map[string]interface{}, items []*kodex.Item) []byte {
+
+	_, f, err := formats.Make(nil, "parquet", config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	encoder, err := f.Encoder(&buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kodex.EncodeItems(encoder, items); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func decodeParquet(t *testing.T, config map[string]interface{}, data []byte) []*kodex.Item {
+
+	_, f, err := formats.Make(nil, "parquet", config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := f.(kodex.FileFormat).FileDecoder(bytes.NewReader(data), int64(len(data)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := make([]*kodex.Item, 0)
+
+	for {
+		item, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func TestParquetSchema(t *testing.T) {
+
+	ts := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	items := make([]*kodex.Item, 0)
+
+	for i := 0; i < 25; i++ {
+		items = append(items, kodex.MakeItem(map[string]interface{}{
+			"id":      float64(i),
+			"name":    "item",
+			"active":  i%2 == 0,
+			"created": ts.Format(time.RFC3339),
+			"tags":    []interface{}{"a", "b"},
+			"ignored": "this field is not in the schema",
+		}))
+	}
+
+	// the last item is missing an optional column
+	items[24].Delete("name")
+
+	data := encodeParquet(t, map[string]interface{}{
+		"row-group-size": 10,
+		"compression":    "zstd",
+		"schema": []interface{}{
+			map[string]interface{}{"name": "id", "type": "int", "required": true},
+			map[string]interface{}{"name": "name"},
+			map[string]interface{}{"name": "active", "type": "bool"},
+			map[string]interface{}{"name": "created", "type": "timestamp"},
+			map[string]interface{}{"name": "tags", "type": "json"},
+		},
+	}, items)
+
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(file.RowGroups()); n != 3 {
+		t.Fatalf("Expected 3 row groups, got %d", n)
+	}
+
+	for _, rowGroup := range file.Metadata().RowGroups {
+		for _, column := range rowGroup.Columns {
+			if column.MetaData.Codec != format.Zstd {
+				t.Fatalf("Expected ZSTD compression, got %v", column.MetaData.Codec)
+			}
+		}
+	}
+
+	decoded := decodeParquet(t, nil, data)
+
+	if len(decoded) != 25 {
+		t.Fatalf("Expected 25 items, got %d", len(decoded))
+	}
+
+	item := decoded[3]
+
+	if id, _ := item.Get("id"); id != int64(3) {
+		t.Errorf("Expected id 3, got %v", id)
+	}
+
+	if active, _ := item.Get("active"); active != false {
+		t.Errorf("Expected active to be false, got %v", active)
+	}
+
+	if created, _ := item.Get("created"); created != ts {
+		t.Errorf("Expected created to be %v, got %v", ts, created)
+	}
+
+	if tags, _ := item.Get("tags"); len(tags.([]interface{})) != 2 {
+		t.Errorf("Expected two tags, got %v", tags)
+	}
+
+	if _, ok := item.Get("ignored"); ok {
+		t.Errorf("Expected fields that are not in the schema to be ignored")
+	}
+
+	if _, ok := decoded[24].Get("name"); ok {
+		t.Errorf("Expected the name to be missing")
+	}
+
+	// required columns must be present
+	items[0].Delete("id")
+
+	_, f, _ := formats.Make(nil, "parquet", map[string]interface{}{
+		"schema": []interface{}{
+			map[string]interface{}{"name": "id", "type": "int", "required": true},
+		},
+	})
+
+	encoder, _ := f.Encoder(io.Discard)
+
+	if err := kodex.EncodeItems(encoder, items); err == nil {
+		t.Fatalf("Expected an error for a missing required column")
+	}
+}
+
+func TestParquetProjection(t *testing.T) {
+
+	items := []*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"a": "1", "b": int64(2), "c": 3.5}),
+		kodex.MakeItem(map[string]interface{}{"a": "4", "b": int64(5), "c": 6.5}),
+	}
+
+	// the schema is inferred from the items
+	data := encodeParquet(t, nil, items)
+
+	decoded := decodeParquet(t, map[string]interface{}{
+		"columns": []interface{}{"c", "a"},
+	}, data)
+
+	if len(decoded) != 2 {
+		t.Fatalf("Expected 2 items, got %d", len(decoded))
+	}
+
+	if _, ok := decoded[1].Get("b"); ok {
+		t.Errorf("Expected column b to be skipped")
+	}
+
+	if a, _ := decoded[1].Get("a"); a != "4" {
+		t.Errorf("Expected a to be 4, got %v", a)
+	}
+
+	if c, _ := decoded[1].Get("c"); c != 6.5 {
+		t.Errorf("Expected c to be 6.5, got %v", c)
+	}
+
+	if _, err := decodeParquetErr(data, "d"); err == nil {
+		t.Errorf("Expected an error for an unknown column")
+	}
+}
+
+func decodeParquetErr(data []byte, column string) (kodex.ItemDecoder, error) {
+	_, f, err := formats.Make(nil, "parquet", map[string]interface{}{
+		"columns": []interface{}{column},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f.Decoder(bytes.NewReader(data))
+}
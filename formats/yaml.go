@@ -0,0 +1,97 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package formats
+
+import (
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"gopkg.in/yaml.v2"
+	"io"
+)
+
+// A stream of YAML documents. Every document is preceded by a separator so
+// that we can append to existing streams.
+type YAMLFormat struct{}
+
+func MakeYAMLFormat(config map[string]interface{}) (kodex.Format, error) {
+	return &YAMLFormat{}, nil
+}
+
+func (f *YAMLFormat) Encoder(writer io.Writer) (kodex.ItemEncoder, error) {
+	return &yamlEncoder{writer: writer}, nil
+}
+
+func (f *YAMLFormat) Decoder(reader io.Reader) (kodex.ItemDecoder, error) {
+	return &yamlDecoder{decoder: yaml.NewDecoder(reader)}, nil
+}
+
+type yamlEncoder struct {
+	writer io.Writer
+}
+
+func (y *yamlEncoder) Encode(item *kodex.Item) error {
+	data, err := yaml.Marshal(item.All())
+	if err != nil {
+		return err
+	}
+	_, err = y.writer.Write(append([]byte("---\n"), data...))
+	return err
+}
+
+func (y *yamlEncoder) Close() error {
+	return nil
+}
+
+type yamlDecoder struct {
+	decoder *yaml.Decoder
+}
+
+func (y *yamlDecoder) Decode() (*kodex.Item, error) {
+	for {
+		var document interface{}
+		if err := y.decoder.Decode(&document); err != nil {
+			return nil, err
+		}
+		// we skip empty documents
+		if document == nil {
+			continue
+		}
+		if item, ok := stringKeys(document).(map[string]interface{}); ok {
+			return kodex.MakeItem(item), nil
+		}
+		return nil, fmt.Errorf("expected a YAML mapping")
+	}
+}
+
+// YAML allows arbitrary keys in mappings, so we convert them to strings
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, mapValue := range v {
+			m[fmt.Sprintf("%v", key)] = stringKeys(mapValue)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, listValue := range v {
+			l[i] = stringKeys(listValue)
+		}
+		return l
+	}
+	return value
+}
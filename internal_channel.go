@@ -28,6 +28,19 @@ type InternalChannel struct {
 	InternalWriter Writer
 }
 
+// Internal readers that implement this interface report the number of
+// payloads that are waiting in the channel.
+type QueuedReader interface {
+	Queued() int
+}
+
+// Internal readers that implement this interface tell us when payloads
+// arrive, so that we do not need to poll them.
+type NotifyingReader interface {
+	// Returns a channel that receives a value whenever a payload arrives
+	Notify() <-chan struct{}
+}
+
 // Adaptor for using an internal channel as a writer
 type InternalWriter struct {
 	*InternalChannel
@@ -140,12 +153,59 @@ func (i *BasicInternalReader) Read() (Payload, error) {
 	return payload, nil
 }
 
+func (i *BasicInternalReader) Notify() <-chan struct{} {
+	if i.Model == nil {
+		return nil
+	}
+	i.Store.Lock()
+	defer i.Store.Unlock()
+	return i.Store.signal(i.Model.Type(), hex.EncodeToString(i.Model.ID()))
+}
+
+func (i *BasicInternalReader) Queued() int {
+	if i.Model == nil {
+		return 0
+	}
+	i.Store.Lock()
+	defer i.Store.Unlock()
+	if modelChannels, ok := i.Store.Items[i.Model.Type()]; ok {
+		return len(modelChannels[hex.EncodeToString(i.Model.ID())])
+	}
+	return 0
+}
+
 func (i *BasicInternalReader) Setup(Stream) error {
 	return fmt.Errorf("setup with stream not supported")
 }
 
+// Removes all payloads from the channel. Payloads are rejected so that their
+// source can redeliver them.
 func (i *BasicInternalReader) Purge() error {
-	return nil
+
+	if i.Model == nil {
+		return nil
+	}
+
+	modelID := hex.EncodeToString(i.Model.ID())
+
+	i.Store.Lock()
+	var payloads []Payload
+	if modelChannels, ok := i.Store.Items[i.Model.Type()]; ok {
+		payloads = modelChannels[modelID]
+		delete(modelChannels, modelID)
+	}
+	i.Store.Unlock()
+
+	var err error
+
+	for _, payload := range payloads {
+		if rejectErr := payload.Reject(); rejectErr != nil {
+			Log.Error(rejectErr)
+			err = rejectErr
+		}
+	}
+
+	return err
 }
 
 func (i *BasicInternalReader) Teardown() error {
@@ -196,6 +256,13 @@ func makeReader(controller Controller, model Model) (ModelReader, error) {
 		reader = &BasicInternalReader{
 			Store: itemStore,
 		}
+	} else if readerType == "wal" {
+		// we create a reader for a write-ahead log on disk
+		channel, err := makeWALChannel(controller, readerConfig)
+		if err != nil {
+			return nil, err
+		}
+		reader = &WALInternalReader{channel}
 	} else {
 		definition, ok := controller.Definitions().ReaderDefinitions[readerType]
 
@@ -226,8 +293,9 @@ func makeReader(controller Controller, model Model) (ModelReader, error) {
 }
 
 type ItemStore struct {
-	mutex sync.Mutex
-	Items map[string]map[string][]Payload
+	mutex   sync.Mutex
+	Items   map[string]map[string][]Payload
+	signals map[string]chan struct{}
 }
 
 func (i *ItemStore) Lock() {
@@ -240,11 +308,25 @@ func (i *ItemStore) Unlock() {
 
 func MakeItemStore() *ItemStore {
 	return &ItemStore{
-		Items: make(map[string]map[string][]Payload),
-		mutex: sync.Mutex{},
+		Items:   make(map[string]map[string][]Payload),
+		signals: make(map[string]chan struct{}),
+		mutex:   sync.Mutex{},
 	}
 }
 
+// Returns the channel on which we signal new payloads for the given model.
+// It buffers a single signal, which is enough for a single reader. The
+// caller needs to hold the lock.
+func (i *ItemStore) signal(modelType, modelID string) chan struct{} {
+	key := modelType + ":" + modelID
+	signal, ok := i.signals[key]
+	if !ok {
+		signal = make(chan struct{}, 1)
+		i.signals[key] = signal
+	}
+	return signal
+}
+
 type BasicInternalWriter struct {
 	Store *ItemStore
 	Model Model
@@ -278,6 +360,11 @@ func (i *BasicInternalWriter) Write(payload Payload) error {
 	}
 	modelPayloads = append(modelPayloads, payload)
 	modelChannels[modelID] = modelPayloads
+	// we notify the reader without blocking, a pending signal suffices
+	select {
+	case i.Store.signal(modelType, modelID) <- struct{}{}:
+	default:
+	}
 	return nil
 }
 
@@ -325,6 +412,13 @@ func makeWriter(controller Controller, model Model) (ModelWriter, error) {
 		writer = &BasicInternalWriter{
 			Store: itemStore,
 		}
+	} else if writerType == "wal" {
+		// we create a writer for a write-ahead log on disk
+		channel, err := makeWALChannel(controller, writerConfig)
+		if err != nil {
+			return nil, err
+		}
+		writer = &WALInternalWriter{channel}
 	} else {
 		definition, ok := controller.Definitions().WriterDefinitions[writerType]
 
@@ -376,6 +470,24 @@ func (s *InternalChannel) Read() (Payload, error) {
 	return s.InternalReader.Read()
 }
 
+// Returns a channel that receives a value when payloads arrive, or nil if the
+// internal reader doesn't support this (in which case we need to poll it).
+func (s *InternalChannel) Notify() <-chan struct{} {
+	if notifyingReader, ok := s.InternalReader.(NotifyingReader); ok {
+		return notifyingReader.Notify()
+	}
+	return nil
+}
+
+// Returns the number of payloads that are waiting in the channel, or false if
+// the internal reader doesn't report it.
+func (s *InternalChannel) Queued() (int, bool) {
+	if queuedReader, ok := s.InternalReader.(QueuedReader); ok {
+		return queuedReader.Queued(), true
+	}
+	return 0, false
+}
+
 // We write items to the internal Internal writer.
 func (s *InternalChannel) Write(payload Payload) error {
 	return s.InternalWriter.Write(payload)
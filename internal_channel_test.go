@@ -62,6 +62,13 @@ func TestAPIReader(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// the reader gets notified about the new payload
+	select {
+	case <-channel.Notify():
+	default:
+		t.Fatal("Expected a notification")
+	}
+
 	var payload kodex.Payload
 
 	i := 0
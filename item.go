@@ -23,6 +23,8 @@ import (
 
 type Item struct {
 	d map[string]interface{}
+	// the order of the keys in the source data, if any
+	keyOrder []string
 }
 
 func MakeItem(d map[string]interface{}) *Item {
@@ -39,6 +41,16 @@ func (f *Item) Keys() []string {
 	return keys
 }
 
+// Returns the order of the keys in the source data (e.g. the columns of a CSV
+// file), or nil if the source data has no order.
+func (f *Item) KeyOrder() []string {
+	return f.keyOrder
+}
+
+func (f *Item) SetKeyOrder(keys []string) {
+	f.keyOrder = keys
+}
+
 func (f *Item) Values() []interface{} {
 	values := make([]interface{}, 0)
 	for _, value := range f.d {
@@ -64,15 +76,6 @@ func (f *Item) Set(key string, value interface{}) {
 	f.d[key] = value
 }
 
-func (f *Item) Serialize(format string) ([]byte, error) {
-	switch format {
-	case "json":
-		return f.SerializeJSON()
-	default:
-		return nil, fmt.Errorf("Unknown format: %s", format)
-	}
-}
-
 func (f *Item) SerializeJSON() ([]byte, error) {
 	return json.Marshal(f.d)
 }
@@ -0,0 +1,123 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "kodex"
+
+// The registry that contains all Kodex metrics (as well as the Go runtime
+// and process metrics).
+var Registry = prometheus.NewRegistry()
+
+var (
+	SourceItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "source_items_total",
+		Help:      "Number of items read from a source.",
+	}, []string{"project", "source"})
+
+	StreamItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_items_total",
+		Help:      "Number of items read from the channel of a stream.",
+	}, []string{"project", "stream"})
+
+	ConfigItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_items_total",
+		Help:      "Number of items processed by a config.",
+	}, []string{"project", "stream", "config"})
+
+	ActionItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "action_items_total",
+		Help:      "Number of items processed by an action.",
+	}, []string{"project", "stream", "config", "action"})
+
+	ActionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "action_duration_seconds",
+		Help:      "Time it takes an action to process a single item.",
+		// most actions take microseconds, so the default buckets are too coarse
+		Buckets: prometheus.ExponentialBuckets(1e-6, 4, 12),
+	}, []string{"project", "stream", "config", "action"})
+
+	DestinationItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "destination_items_total",
+		Help:      "Number of items read from the channel of a destination.",
+	}, []string{"project", "destination"})
+
+	DestinationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "destination_errors_total",
+		Help:      "Number of payloads that could not be written to a destination.",
+	}, []string{"project", "destination"})
+
+	Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Number of processing errors by error code.",
+	}, []string{"code"})
+
+	QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "channel_queue_depth",
+		Help:      "Number of payloads waiting in an internal channel.",
+	}, []string{"project", "type", "name"})
+
+	APIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_requests_total",
+		Help:      "Number of metered API requests by route.",
+	}, []string{"method", "route"})
+
+	APIUsage = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_usage_total",
+		Help:      "Metered API usage (e.g. source items and volume in bytes) by metric.",
+	}, []string{"metric"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		SourceItems,
+		StreamItems,
+		ConfigItems,
+		ActionItems,
+		ActionDuration,
+		DestinationItems,
+		DestinationErrors,
+		Errors,
+		QueueDepth,
+		APIRequests,
+		APIUsage,
+	)
+}
+
+// Returns a handler that serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
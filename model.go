@@ -43,6 +43,16 @@ type PriorityModel interface {
 	SetPriorityAndTime(float64, time.Time) error
 }
 
+// A model whose items can be processed by several workers in parallel. Items
+// with the same value of the partition key always go to the same worker, in
+// order. Zero workers means that the executor uses its default.
+type ParallelModel interface {
+	Workers() int
+	SetWorkers(int) error
+	PartitionKey() string
+	SetPartitionKey(string) error
+}
+
 // A model that allows storing/retrieving statistics
 type StatsModel interface {
 	Stats() (map[string]int64, error)
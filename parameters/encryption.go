@@ -0,0 +1,546 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package parameters
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex"
+	"golang.org/x/crypto/argon2"
+	"os"
+	"strings"
+)
+
+const MASTER_KEY_LENGTH = 32
+const ENCRYPTION_VERSION = 1
+
+// a nonce, the encrypted data key and the authentication tag
+const WRAPPED_KEY_LENGTH = 12 + MASTER_KEY_LENGTH + 16
+
+// argon2id parameters for new stores (as recommended by RFC 9106)
+const ARGON2_TIME = 1
+const ARGON2_MEMORY = 64 * 1024
+const ARGON2_THREADS = 4
+const ARGON2_SALT_LENGTH = 16
+
+var keyHeaderID = []byte("key-header")
+var keyCheck = []byte("kodex parameter store")
+
+var EncryptionForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the encryption form",
+	Fields: []forms.Field{
+		{
+			Name: "key-env",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "key-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+				IsFilename{},
+			},
+		},
+		{
+			Name: "passphrase-env",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "migrate",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+	},
+}
+
+// Provides the master key of an encrypted data store, either directly (from
+// an environment variable or a key file) or derived from a passphrase.
+type MasterKeySource struct {
+	key        []byte
+	passphrase []byte
+}
+
+// The first entry of an encrypted store. It contains the KDF parameters (if
+// the master key is derived from a passphrase) and a value encrypted with the
+// master key, so that we can detect a wrong master key right away.
+type keyHeader struct {
+	KDF     string `json:"kdf,omitempty"`
+	Salt    []byte `json:"salt,omitempty"`
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+	Check   []byte `json:"check"`
+}
+
+// Creates a master key source from a validated encryption config.
+func MakeMasterKeySource(params map[string]interface{}) (*MasterKeySource, error) {
+
+	keyEnv := params["key-env"].(string)
+	keyFile := params["key-file"].(string)
+	passphraseEnv := params["passphrase-env"].(string)
+
+	sources := 0
+
+	for _, source := range []string{keyEnv, keyFile, passphraseEnv} {
+		if source != "" {
+			sources++
+		}
+	}
+
+	if sources != 1 {
+		return nil, fmt.Errorf("exactly one of 'key-env', 'key-file' and 'passphrase-env' is required")
+	}
+
+	if passphraseEnv != "" {
+		passphrase := os.Getenv(passphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("environment variable '%s' is not set", passphraseEnv)
+		}
+		return &MasterKeySource{passphrase: []byte(passphrase)}, nil
+	}
+
+	if keyEnv != "" {
+		value := os.Getenv(keyEnv)
+		if value == "" {
+			return nil, fmt.Errorf("environment variable '%s' is not set", keyEnv)
+		}
+		key, err := decodeMasterKey(value)
+		if err != nil {
+			return nil, err
+		}
+		return &MasterKeySource{key: key}, nil
+	}
+
+	data, err := os.ReadFile(keyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read master key file: %w", err)
+	}
+
+	key, err := decodeMasterKey(string(data))
+
+	if err != nil {
+		// the file can also contain the raw key
+		if len(data) != MASTER_KEY_LENGTH {
+			return nil, err
+		}
+		key = data
+	}
+
+	return &MasterKeySource{key: key}, nil
+}
+
+// Decodes a hex or base64 encoded master key.
+func decodeMasterKey(value string) ([]byte, error) {
+
+	value = strings.TrimSpace(value)
+
+	key, err := hex.DecodeString(value)
+
+	if err != nil {
+		if key, err = base64.StdEncoding.DecodeString(value); err != nil {
+			return nil, fmt.Errorf("master key must be encoded as hex or base64")
+		}
+	}
+
+	if len(key) != MASTER_KEY_LENGTH {
+		return nil, fmt.Errorf("master key must be %d bytes long", MASTER_KEY_LENGTH)
+	}
+
+	return key, nil
+}
+
+// Creates the key header for a new store.
+func (s *MasterKeySource) makeHeader() (*keyHeader, error) {
+
+	header := &keyHeader{}
+	key := s.key
+
+	if s.passphrase != nil {
+		salt, err := kodex.RandomBytes(ARGON2_SALT_LENGTH)
+		if err != nil {
+			return nil, err
+		}
+		header.KDF = "argon2id"
+		header.Salt = salt
+		header.Time = ARGON2_TIME
+		header.Memory = ARGON2_MEMORY
+		header.Threads = ARGON2_THREADS
+		key = argon2.IDKey(s.passphrase, salt, header.Time, header.Memory, header.Threads, MASTER_KEY_LENGTH)
+	}
+
+	check, err := seal(key, keyCheck, keyHeaderID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header.Check = check
+
+	return header, nil
+}
+
+// Returns the master key for the store with the given header. Fails if the
+// key (or passphrase) doesn't match the one that the store was created with.
+func (s *MasterKeySource) open(header *keyHeader) ([]byte, error) {
+
+	key := s.key
+
+	switch header.KDF {
+	case "argon2id":
+		if s.passphrase == nil {
+			return nil, fmt.Errorf("the parameter store requires a passphrase")
+		}
+		// argon2 panics with invalid parameters
+		if header.Time < 1 || header.Threads < 1 || len(header.Salt) == 0 {
+			return nil, fmt.Errorf("invalid key derivation parameters")
+		}
+		key = argon2.IDKey(s.passphrase, header.Salt, header.Time, header.Memory, header.Threads, MASTER_KEY_LENGTH)
+	case "":
+		if s.passphrase != nil {
+			return nil, fmt.Errorf("the parameter store requires a master key, not a passphrase")
+		}
+	default:
+		return nil, fmt.Errorf("unknown key derivation function: %s", header.KDF)
+	}
+
+	if check, err := unseal(key, header.Check, keyHeaderID); err != nil || !bytes.Equal(check, keyCheck) {
+		return nil, fmt.Errorf("invalid master key for the parameter store")
+	}
+
+	return key, nil
+}
+
+// Encrypts the data with AES-GCM and prepends the nonce. The additional data
+// is authenticated but not encrypted.
+func seal(key, data, additionalData []byte) ([]byte, error) {
+
+	aead, err := makeAEAD(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := kodex.RandomBytes(aead.NonceSize())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, data, additionalData), nil
+}
+
+func unseal(key, data, additionalData []byte) ([]byte, error) {
+
+	aead, err := makeAEAD(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("data is too short")
+	}
+
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additionalData)
+}
+
+func makeAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// A data store that encrypts every entry with its own data key, which is in
+// turn encrypted (wrapped) with the master key.
+type EncryptedDataStore struct {
+	store     DataStore
+	source    *MasterKeySource
+	masterKey []byte
+	pending   []*DataEntry
+}
+
+func MakeEncryptedDataStore(store DataStore, source *MasterKeySource) *EncryptedDataStore {
+	return &EncryptedDataStore{
+		store:  store,
+		source: source,
+	}
+}
+
+// Initializes the underlying store and checks the master key. For a new
+// store we write the key header first.
+func (e *EncryptedDataStore) Init() error {
+
+	if err := e.store.Init(); err != nil {
+		return err
+	}
+
+	entries, err := e.store.Read()
+
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+
+		header, err := e.source.makeHeader()
+
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(header)
+
+		if err != nil {
+			return err
+		}
+
+		if err := e.store.Write(&DataEntry{
+			Type: KeyHeaderType,
+			ID:   keyHeaderID,
+			Data: data,
+		}); err != nil {
+			return err
+		}
+
+		// another process might have created the store at the same time, so
+		// we read it again and use whichever header comes first
+		if entries, err = e.store.Read(); err != nil {
+			return err
+		}
+
+		if len(entries) == 0 {
+			return fmt.Errorf("cannot find the key header")
+		}
+	}
+
+	if entries[0].Type != KeyHeaderType {
+		return fmt.Errorf("the parameter store is not encrypted, set 'migrate' to encrypt it")
+	}
+
+	header := &keyHeader{}
+
+	if err := json.Unmarshal(entries[0].Data, header); err != nil {
+		return fmt.Errorf("invalid key header: %w", err)
+	}
+
+	if e.masterKey, err = e.source.open(header); err != nil {
+		return err
+	}
+
+	e.pending = entries[1:]
+
+	return nil
+}
+
+func (e *EncryptedDataStore) Read() ([]*DataEntry, error) {
+
+	entries, err := e.store.Read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if e.pending != nil {
+		entries = append(e.pending, entries...)
+		e.pending = nil
+	}
+
+	decryptedEntries := make([]*DataEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		switch entry.Type {
+		case KeyHeaderType:
+			// headers of other processes that created the store at the same time
+			continue
+		case EncryptedType:
+			decryptedEntry, err := e.decrypt(entry)
+			if err != nil {
+				kodex.Log.Errorf("Error when decrypting entry '%s', skipping: %v", hex.EncodeToString(entry.ID), err)
+				continue
+			}
+			decryptedEntries = append(decryptedEntries, decryptedEntry)
+		default:
+			kodex.Log.Errorf("Unencrypted entry '%s' in encrypted parameter store, skipping", hex.EncodeToString(entry.ID))
+		}
+	}
+
+	return decryptedEntries, nil
+}
+
+func (e *EncryptedDataStore) Write(entry *DataEntry) error {
+	encryptedEntry, err := e.encrypt(entry)
+	if err != nil {
+		return err
+	}
+	return e.store.Write(encryptedEntry)
+}
+
+func (e *EncryptedDataStore) Close() error {
+	return e.store.Close()
+}
+
+// Encrypts the entry with a new data key. The ID stays readable, as we need it
+// to reassemble the entry, but it is authenticated along with the data.
+func (e *EncryptedDataStore) encrypt(entry *DataEntry) (*DataEntry, error) {
+
+	dataKey, err := kodex.RandomBytes(MASTER_KEY_LENGTH)
+
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := seal(e.masterKey, dataKey, entry.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedData, err := seal(dataKey, entry.ToBytes(), entry.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, 1+len(wrappedKey)+len(encryptedData))
+	data = append(data, ENCRYPTION_VERSION)
+	data = append(data, wrappedKey...)
+	data = append(data, encryptedData...)
+
+	return &DataEntry{
+		Type: EncryptedType,
+		ID:   entry.ID,
+		Data: data,
+	}, nil
+}
+
+func (e *EncryptedDataStore) decrypt(entry *DataEntry) (*DataEntry, error) {
+
+	if len(entry.Data) < 1+WRAPPED_KEY_LENGTH {
+		return nil, fmt.Errorf("invalid encrypted entry")
+	}
+
+	if entry.Data[0] != ENCRYPTION_VERSION {
+		return nil, fmt.Errorf("unknown encryption version: %d", entry.Data[0])
+	}
+
+	dataKey, err := unseal(e.masterKey, entry.Data[1:1+WRAPPED_KEY_LENGTH], entry.ID)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot unwrap data key: %w", err)
+	}
+
+	data, err := unseal(dataKey, entry.Data[1+WRAPPED_KEY_LENGTH:], entry.ID)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt entry: %w", err)
+	}
+
+	decryptedEntry := &DataEntry{}
+
+	if err := decryptedEntry.FromBytes(data); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(decryptedEntry.ID, entry.ID) {
+		return nil, fmt.Errorf("entry ID does not match")
+	}
+
+	return decryptedEntry, nil
+}
+
+// Encrypts an existing plaintext store. We write the encrypted entries to a
+// temporary file that then replaces the original one, so no other process
+// may use the store during the migration. Does nothing if the store doesn't
+// exist or is already encrypted.
+func EncryptFileDataStore(filename, format string, source *MasterKeySource) error {
+
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	plaintextStore := MakeFileDataStore(filename, format)
+
+	if err := plaintextStore.Init(); err != nil {
+		return err
+	}
+
+	defer plaintextStore.Close()
+
+	entries, err := plaintextStore.Read()
+
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 || entries[0].Type == KeyHeaderType {
+		return nil
+	}
+
+	tmpFilename := filename + ".tmp"
+
+	if err := os.Remove(tmpFilename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	encryptedStore := MakeEncryptedDataStore(MakeFileDataStore(tmpFilename, format), source)
+
+	if err := encryptedStore.Init(); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Type == KeyHeaderType || entry.Type == EncryptedType {
+			encryptedStore.Close()
+			return fmt.Errorf("the parameter store is partially encrypted")
+		}
+		if err := encryptedStore.Write(entry); err != nil {
+			encryptedStore.Close()
+			return err
+		}
+	}
+
+	if err := encryptedStore.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpFilename, filename); err != nil {
+		return err
+	}
+
+	kodex.Log.Infof("Encrypted %d entries in parameter store '%s'", len(entries), filename)
+
+	return nil
+}
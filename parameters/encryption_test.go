@@ -0,0 +1,245 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package parameters
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/kiprotect/kodex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeEntries(t *testing.T, store DataStore, entries []*DataEntry) {
+	t.Helper()
+	for _, entry := range entries {
+		if err := store.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkEntries(t *testing.T, store DataStore, expected []*DataEntry) {
+	t.Helper()
+	entries, err := store.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %d", len(expected), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Type != expected[i].Type || !bytes.Equal(entry.ID, expected[i].ID) || !bytes.Equal(entry.Data, expected[i].Data) {
+			t.Fatalf("Entry %d does not match", i)
+		}
+	}
+}
+
+func openEncrypted(t *testing.T, filename string, params map[string]interface{}) (*EncryptedDataStore, error) {
+	t.Helper()
+	source, err := MakeMasterKeySource(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := MakeEncryptedDataStore(MakeFileDataStore(filename, "json"), source)
+	t.Cleanup(func() { store.Close() })
+	return store, store.Init()
+}
+
+func keyParams(env string) map[string]interface{} {
+	return map[string]interface{}{
+		"key-env":        env,
+		"key-file":       "",
+		"passphrase-env": "",
+	}
+}
+
+func testEntries() []*DataEntry {
+	return []*DataEntry{
+		{Type: ParametersType, ID: kodex.RandomID(), Data: []byte(`{"key":"secret-key-that-must-not-leak"}`)},
+		{Type: ParameterSetType, ID: kodex.RandomID(), Data: []byte(`{"parameters":[]}`)},
+	}
+}
+
+func TestEncryptedDataStore(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "parameters.kip")
+
+	t.Setenv("TEST_MASTER_KEY", hex.EncodeToString(bytes.Repeat([]byte{1}, MASTER_KEY_LENGTH)))
+	t.Setenv("TEST_WRONG_KEY", hex.EncodeToString(bytes.Repeat([]byte{2}, MASTER_KEY_LENGTH)))
+
+	store, err := openEncrypted(t, filename, keyParams("TEST_MASTER_KEY"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := testEntries()
+	writeEntries(t, store, entries)
+
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(data, []byte("secret")) {
+		t.Fatal("Expected the data to be encrypted")
+	}
+
+	// we reopen the store, as if we restarted
+	reopenedStore, err := openEncrypted(t, filename, keyParams("TEST_MASTER_KEY"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkEntries(t, reopenedStore, entries)
+
+	if _, err := openEncrypted(t, filename, keyParams("TEST_WRONG_KEY")); err == nil {
+		t.Fatal("Expected an error with the wrong master key")
+	}
+
+	// without encryption we refuse to use the store
+	parameterStore, err := MakeFileParameterStore(map[string]interface{}{"filename": filename}, &kodex.Definitions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parameterStore.AllParameters(); err == nil {
+		t.Fatal("Expected an error without a master key")
+	}
+
+	// we create a new encrypted parameter store via its config
+	encryptedParameterStore, err := MakeFileParameterStore(map[string]interface{}{
+		"filename":   filepath.Join(filepath.Dir(filename), "encrypted.kip"),
+		"encryption": map[string]interface{}{"key-env": "TEST_MASTER_KEY"},
+	}, &kodex.Definitions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := encryptedParameterStore.AllParameters(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPassphraseDataStore(t *testing.T) {
+
+	filename := filepath.Join(t.TempDir(), "parameters.kip")
+
+	params := func(env string) map[string]interface{} {
+		return map[string]interface{}{
+			"key-env":        "",
+			"key-file":       "",
+			"passphrase-env": env,
+		}
+	}
+
+	t.Setenv("TEST_PASSPHRASE", "correct horse battery staple")
+	t.Setenv("TEST_WRONG_PASSPHRASE", "incorrect horse battery staple")
+
+	store, err := openEncrypted(t, filename, params("TEST_PASSPHRASE"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := testEntries()
+	writeEntries(t, store, entries)
+
+	reopenedStore, err := openEncrypted(t, filename, params("TEST_PASSPHRASE"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkEntries(t, reopenedStore, entries)
+
+	if _, err := openEncrypted(t, filename, params("TEST_WRONG_PASSPHRASE")); err == nil {
+		t.Fatal("Expected an error with the wrong passphrase")
+	}
+}
+
+func TestEncryptFileDataStore(t *testing.T) {
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "parameters.kip")
+	keyFile := filepath.Join(dir, "master.key")
+
+	if err := os.WriteFile(keyFile, bytes.Repeat([]byte{3}, MASTER_KEY_LENGTH), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	params := map[string]interface{}{
+		"key-env":        "",
+		"key-file":       keyFile,
+		"passphrase-env": "",
+	}
+
+	plaintextStore := MakeFileDataStore(filename, "json")
+
+	if err := plaintextStore.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := testEntries()
+	writeEntries(t, plaintextStore, entries)
+
+	if err := plaintextStore.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// we refuse to use a plaintext store without migrating it
+	if _, err := openEncrypted(t, filename, params); err == nil {
+		t.Fatal("Expected an error for a plaintext store")
+	}
+
+	source, err := MakeMasterKeySource(params)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// migrating twice does nothing the second time
+	for i := 0; i < 2; i++ {
+		if err := EncryptFileDataStore(filename, "json", source); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(data, []byte("secret")) {
+		t.Fatal("Expected the data to be encrypted")
+	}
+
+	store, err := openEncrypted(t, filename, params)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkEntries(t, store, entries)
+}
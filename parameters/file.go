@@ -43,6 +43,9 @@ const (
 	NullType = iota
 	ParametersType
 	ParameterSetType
+	// types of encrypted stores, see EncryptedDataStore
+	KeyHeaderType
+	EncryptedType
 )
 
 /*
@@ -359,6 +362,7 @@ type DataStore interface {
 	// Read data from the store
 	Read() ([]*DataEntry, error)
 	Init() error
+	Close() error
 }
 
 // A file-based data store
@@ -464,6 +468,27 @@ func (f *FileDataStore) Write(entry *DataEntry) error {
 	return f.wfile.Sync()
 }
 
+func (f *FileDataStore) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var err error
+
+	for _, file := range []*os.File{f.wfile, f.rfile} {
+		if file == nil {
+			continue
+		}
+		if closeErr := file.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+
+	f.wfile = nil
+	f.rfile = nil
+
+	return err
+}
+
 type IsFilename struct{}
 
 func (f IsFilename) Validate(value interface{}, values map[string]interface{}) (interface{}, error) {
@@ -498,6 +523,15 @@ var FileParameterStoreForm = forms.Form{
 				forms.IsIn{Choices: []interface{}{"json"}},
 			},
 		},
+		forms.Field{
+			Name: "encryption",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &EncryptionForm,
+				},
+			},
+		},
 		forms.Field{
 			Name: "in-memory-config",
 			Validators: []forms.Validator{
@@ -515,9 +549,32 @@ func MakeFileParameterStore(config map[string]interface{}, definitions *kodex.De
 	if err != nil {
 		return nil, err
 	}
-	dataStore := MakeFileDataStore(params["filename"].(string), params["format"].(string))
+
+	filename := params["filename"].(string)
+	format := params["format"].(string)
+
+	var dataStore DataStore = MakeFileDataStore(filename, format)
+
+	// with encryption, we encrypt all entries before writing them to disk
+	if encryptionParams, ok := params["encryption"].(map[string]interface{}); ok {
+
+		source, err := MakeMasterKeySource(encryptionParams)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if encryptionParams["migrate"].(bool) {
+			if err := EncryptFileDataStore(filename, format, source); err != nil {
+				return nil, fmt.Errorf("cannot encrypt parameter store '%s': %w", filename, err)
+			}
+		}
+
+		dataStore = MakeEncryptedDataStore(dataStore, source)
+	}
+
 	if err := dataStore.Init(); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cannot open parameter store '%s': %w", filename, err)
 	}
 
 	inMemoryStore, err := MakeInMemoryParameterStore(params["in-memory-config"].(map[string]interface{}), definitions)
@@ -544,6 +601,9 @@ func (p *FileParameterStore) update() error {
 		return err
 	} else {
 		for _, entry := range entries {
+			if entry.Type == KeyHeaderType || entry.Type == EncryptedType {
+				return fmt.Errorf("the parameter store is encrypted, please configure a master key")
+			}
 			var data map[string]interface{}
 			if err := json.Unmarshal(entry.Data, &data); err != nil {
 				kodex.Log.Errorf("Error when unmarshalling entry '%s', skipping", hex.EncodeToString(entry.ID))
@@ -0,0 +1,121 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"math"
+)
+
+// The tolerance we allow when comparing spent and total budget, to avoid
+// refusing releases due to floating point rounding.
+const privacyBudgetTolerance = 1e-9
+
+// Tracks the privacy loss of all differentially private releases that were
+// made for a given project or dataset. Instead of storing every release we
+// keep the sums that the supported composition rules need.
+type PrivacyBudget struct {
+	ID          string  `json:"id"`
+	Epsilon     float64 `json:"epsilon"`
+	Delta       float64 `json:"delta"`
+	Composition string  `json:"composition"`
+	// the delta slack used for advanced composition
+	Slack             float64 `json:"slack"`
+	Releases          int64   `json:"releases"`
+	SumEpsilon        float64 `json:"sum_epsilon"`
+	SumDelta          float64 `json:"sum_delta"`
+	SumEpsilonSquared float64 `json:"sum_epsilon_squared"`
+	SumEpsilonExpM1   float64 `json:"sum_epsilon_exp_m1"`
+}
+
+type PrivacyBudgetLedger interface {
+	// Reserve the given budget, returns false if the budget is exhausted
+	Reserve(id string, epsilon, delta float64) (bool, error)
+	// Return the budget with the given ID
+	Budget(id string) (*PrivacyBudget, error)
+	// Return all budgets known to the ledger
+	Budgets() ([]*PrivacyBudget, error)
+	// Reset the spent budget with the given ID
+	Reset(id string) error
+}
+
+// Returns the total privacy loss according to the composition rule of the
+// budget. For advanced composition we use the bound of Dwork, Rothblum and
+// Vadhan, falling back to basic composition if the latter is tighter or if
+// the slack does not fit into the total delta.
+func (p *PrivacyBudget) Spent() (float64, float64) {
+	if p.Composition == "advanced" && p.Slack > 0 && p.SumDelta+p.Slack <= p.Delta+privacyBudgetTolerance {
+		epsilon := math.Sqrt(2*math.Log(1/p.Slack)*p.SumEpsilonSquared) + p.SumEpsilonExpM1
+		if epsilon < p.SumEpsilon {
+			return epsilon, p.SumDelta + p.Slack
+		}
+	}
+	return p.SumEpsilon, p.SumDelta
+}
+
+// Returns the remaining epsilon and delta of the budget
+func (p *PrivacyBudget) Remaining() (float64, float64) {
+	epsilon, delta := p.Spent()
+	return math.Max(0, p.Epsilon-epsilon), math.Max(0, p.Delta-delta)
+}
+
+// Adds a release with the given epsilon and delta to the budget if this does
+// not exceed it. Returns whether the release was added.
+func (p *PrivacyBudget) Reserve(epsilon, delta float64) bool {
+	updated := *p
+	updated.add(epsilon, delta)
+	spentEpsilon, spentDelta := updated.Spent()
+	if spentEpsilon > p.Epsilon+privacyBudgetTolerance || spentDelta > p.Delta+privacyBudgetTolerance {
+		return false
+	}
+	*p = updated
+	return true
+}
+
+// Resets the spent budget
+func (p *PrivacyBudget) Reset() {
+	p.Releases = 0
+	p.SumEpsilon = 0
+	p.SumDelta = 0
+	p.SumEpsilonSquared = 0
+	p.SumEpsilonExpM1 = 0
+}
+
+func (p *PrivacyBudget) add(epsilon, delta float64) {
+	p.Releases++
+	p.SumEpsilon += epsilon
+	p.SumDelta += delta
+	p.SumEpsilonSquared += epsilon * epsilon
+	p.SumEpsilonExpM1 += epsilon * math.Expm1(epsilon)
+}
+
+// Returns a summary of the budget that includes the spent and remaining
+// epsilon and delta, as shown by the API and the CLI.
+func (p *PrivacyBudget) Summary() map[string]interface{} {
+	spentEpsilon, spentDelta := p.Spent()
+	remainingEpsilon, remainingDelta := p.Remaining()
+	return map[string]interface{}{
+		"id":                p.ID,
+		"epsilon":           p.Epsilon,
+		"delta":             p.Delta,
+		"composition":       p.Composition,
+		"releases":          p.Releases,
+		"spent_epsilon":     spentEpsilon,
+		"spent_delta":       spentDelta,
+		"remaining_epsilon": remainingEpsilon,
+		"remaining_delta":   remainingDelta,
+	}
+}
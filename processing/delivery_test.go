@@ -0,0 +1,284 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"context"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"sync"
+	"testing"
+)
+
+type testPayload struct {
+	*kodex.BasicPayload
+	acknowledged int
+	rejected     int
+	mutex        sync.Mutex
+}
+
+func (p *testPayload) Acknowledge() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.acknowledged++
+	return nil
+}
+
+func (p *testPayload) Reject() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rejected++
+	return nil
+}
+
+func (p *testPayload) check(t *testing.T, acknowledged, rejected int) {
+	t.Helper()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.acknowledged != acknowledged || p.rejected != rejected {
+		t.Fatalf("Expected %d acknowledgements and %d rejections, got %d and %d", acknowledged, rejected, p.acknowledged, p.rejected)
+	}
+}
+
+type testWriter struct {
+	err   error
+	items []*kodex.Item
+}
+
+func (w *testWriter) Setup(kodex.Config) error {
+	return nil
+}
+
+func (w *testWriter) Teardown() error {
+	return nil
+}
+
+func (w *testWriter) Write(payload kodex.Payload) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.items = append(w.items, payload.Items()...)
+	return nil
+}
+
+// The stages of the local processing pipeline, which we drive by hand to
+// simulate crashes between them.
+type testPipeline struct {
+	model               kodex.Stream
+	source              *LocalSourceWorker
+	stream              *LocalStreamWorker
+	streamChannel       *kodex.InternalChannel
+	destinationChannels []*kodex.InternalChannel
+}
+
+func makeTestPipeline(t *testing.T) *testPipeline {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Stream{Name: "test", Project: "project"}, "stream"},
+		pt.FC{&pf.Config{Name: "test", Stream: "stream", Status: kodex.ActiveConfig}, "config"},
+		pt.FC{&pf.Destination{Name: "first", Project: "project", DestinationType: "in-memory", Config: map[string]interface{}{}}, "a"},
+		pt.FC{&pf.Destination{Name: "second", Project: "project", DestinationType: "in-memory", Config: map[string]interface{}{}}, "b"},
+		pt.FC{&pf.DestinationAdder{Destination: "a", Config: "config", Status: "active", Name: "first"}, "aAdder"},
+		pt.FC{&pf.DestinationAdder{Destination: "b", Config: "config", Status: "active", Name: "second"}, "bAdder"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	t.Cleanup(func() { pt.TeardownFixtures(fixtureConfig, fixtures) })
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := fixtures["controller"].(kodex.Controller)
+	stream := fixtures["stream"].(kodex.Stream)
+	config := fixtures["config"].(kodex.Config)
+
+	pipeline := &testPipeline{
+		model:         stream,
+		streamChannel: kodex.MakeInternalChannel(),
+	}
+
+	if pipeline.source, err = MakeLocalSourceWorker(nil, []kodex.Stream{stream}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pipeline.streamChannel.Setup(controller, stream); err != nil {
+		t.Fatal(err)
+	}
+
+	contexts, err := makeContexts([]kodex.Config{config})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pipeline.stream, err = MakeLocalStreamWorker(nil, contexts, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	destinations, err := config.Destinations()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, destinationMaps := range destinations {
+		for _, destinationMap := range destinationMaps {
+			channel := kodex.MakeInternalChannel()
+			if err := channel.Setup(controller, destinationMap); err != nil {
+				t.Fatal(err)
+			}
+			pipeline.destinationChannels = append(pipeline.destinationChannels, channel)
+		}
+	}
+
+	if len(pipeline.destinationChannels) != 2 {
+		t.Fatalf("Expected two destinations, got %d", len(pipeline.destinationChannels))
+	}
+
+	return pipeline
+}
+
+func readTestPayload(t *testing.T, channel *kodex.InternalChannel) kodex.Payload {
+	t.Helper()
+	payload, err := channel.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload == nil {
+		t.Fatal("Expected a payload")
+	}
+	return payload
+}
+
+// Passes a payload from the source to the stream stage.
+func (p *testPipeline) source2stream(t *testing.T) *testPayload {
+	payload := &testPayload{
+		BasicPayload: kodex.MakeBasicPayload([]*kodex.Item{
+			kodex.MakeItem(map[string]interface{}{"foo": "bar"}),
+		}, map[string]interface{}{}, false),
+	}
+	if err := p.source.ProcessPayload(payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// Processes the payload in the stream stage and passes the results to the
+// destination stage.
+func (p *testPipeline) stream2destinations(t *testing.T) {
+	if err := p.stream.ProcessPayload(readTestPayload(t, p.streamChannel)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (p *testPipeline) write(t *testing.T, i int, writer kodex.Writer) error {
+	worker, err := MakeLocalDestinationWorker(context.Background(), nil, writer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return worker.ProcessPayload(readTestPayload(t, p.destinationChannels[i]))
+}
+
+func TestDelivery(t *testing.T) {
+
+	pipeline := makeTestPipeline(t)
+
+	payload := pipeline.source2stream(t)
+	payload.check(t, 0, 0)
+
+	pipeline.stream2destinations(t)
+	payload.check(t, 0, 0)
+
+	writer := &testWriter{}
+
+	if err := pipeline.write(t, 0, writer); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second destination hasn't written the items yet
+	payload.check(t, 0, 0)
+
+	if err := pipeline.write(t, 1, writer); err != nil {
+		t.Fatal(err)
+	}
+
+	payload.check(t, 1, 0)
+
+	if len(writer.items) != 2 {
+		t.Fatalf("Expected two items, got %d", len(writer.items))
+	}
+}
+
+func TestDeliveryCrashBeforeStream(t *testing.T) {
+
+	pipeline := makeTestPipeline(t)
+
+	payload := pipeline.source2stream(t)
+
+	// the stream stage shuts down without reading the payload
+	if err := pipeline.streamChannel.Purge(); err != nil {
+		t.Fatal(err)
+	}
+
+	payload.check(t, 0, 1)
+}
+
+func TestDeliveryCrashBeforeDestination(t *testing.T) {
+
+	pipeline := makeTestPipeline(t)
+
+	payload := pipeline.source2stream(t)
+	pipeline.stream2destinations(t)
+
+	if err := pipeline.write(t, 0, &testWriter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second destination shuts down without reading the payload
+	if err := pipeline.destinationChannels[1].Purge(); err != nil {
+		t.Fatal(err)
+	}
+
+	payload.check(t, 0, 1)
+}
+
+func TestDeliveryFailingDestination(t *testing.T) {
+
+	pipeline := makeTestPipeline(t)
+
+	payload := pipeline.source2stream(t)
+	pipeline.stream2destinations(t)
+
+	if err := pipeline.write(t, 0, &testWriter{err: fmt.Errorf("disk full")}); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	payload.check(t, 0, 1)
+
+	// a late acknowledgement doesn't change the outcome
+	if err := pipeline.write(t, 1, &testWriter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	payload.check(t, 0, 1)
+}
@@ -17,6 +17,7 @@
 package processing
 
 import (
+	"context"
 	"github.com/kiprotect/kodex"
 )
 
@@ -24,9 +25,23 @@ type Supervisor interface {
 	ExecutorStopped(Executor, kodex.Processable)
 }
 
+// Executors process a stream, source or destination until they are stopped
+// or reach the end of the stream. Cancelling the context passed to Start
+// stops an executor gracefully. A graceful stop processes all payloads the
+// executor has received, a hard stop rejects them so that they can be
+// redelivered. Done returns a channel that is closed once the executor has
+// stopped.
 type Executor interface {
-	Start(Supervisor, kodex.Processable) error
+	Start(context.Context, Supervisor, kodex.Processable) error
 	Stop(graceful bool) error
 	Stopped() bool
+	Done() <-chan struct{}
 	ID() []byte
 }
+
+// An executor that counts the items it received, which we use to detect idle
+// executors and to prioritize work.
+type CountingExecutor interface {
+	Executor
+	ItemsProcessed() int64
+}
@@ -0,0 +1,168 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"context"
+	"github.com/kiprotect/kodex"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An executor that ignores graceful stops if it's stuck
+type testExecutor struct {
+	stuck bool
+	hard  bool
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (e *testExecutor) Start(context.Context, Supervisor, kodex.Processable) error {
+	return nil
+}
+
+func (e *testExecutor) Stop(graceful bool) error {
+	if graceful && e.stuck {
+		return nil
+	}
+	e.once.Do(func() {
+		e.hard = !graceful
+		close(e.done)
+	})
+	return nil
+}
+
+func (e *testExecutor) Stopped() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *testExecutor) Done() <-chan struct{} {
+	return e.done
+}
+
+func (e *testExecutor) ID() []byte {
+	return []byte("test")
+}
+
+// A writer that blocks until its context is cancelled
+type blockingWriter struct {
+	testWriter
+}
+
+func (w *blockingWriter) WriteContext(ctx context.Context, payload kodex.Payload) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStreamExecutorCancel(t *testing.T) {
+
+	pipeline := makeTestPipeline(t)
+	payload := pipeline.source2stream(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	executor := MakeLocalStreamExecutor(1, []byte("test"))
+
+	if err := executor.Start(ctx, nil, pipeline.model); err != nil {
+		t.Fatal(err)
+	}
+
+	// the executor processes the payload in its channel before it stops
+	cancel()
+
+	select {
+	case <-executor.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Executor did not stop")
+	}
+
+	if !executor.Stopped() {
+		t.Fatal("Expected the executor to be stopped")
+	}
+
+	// the destinations receive an empty payload first, which the executor
+	// sends to advance stateful actions
+	for _, channel := range pipeline.destinationChannels {
+		for {
+			payload, err := channel.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payload == nil {
+				break
+			}
+			if err := payload.Acknowledge(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	payload.check(t, 1, 0)
+}
+
+func TestDrain(t *testing.T) {
+
+	graceful := &testExecutor{done: make(chan struct{})}
+	stuck := &testExecutor{done: make(chan struct{}), stuck: true}
+
+	deadline, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if drain(deadline, []Executor{graceful, stuck}) {
+		t.Fatal("Expected a hard stop")
+	}
+
+	if graceful.hard || !stuck.hard {
+		t.Fatal("Expected only the stuck executor to be stopped hard")
+	}
+
+	if !drain(context.Background(), []Executor{&testExecutor{done: make(chan struct{})}}) {
+		t.Fatal("Expected a graceful stop")
+	}
+}
+
+func TestDestinationWorkerCancel(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	worker, err := MakeLocalDestinationWorker(ctx, nil, &blockingWriter{}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := &testPayload{
+		BasicPayload: kodex.MakeBasicPayload([]*kodex.Item{
+			kodex.MakeItem(map[string]interface{}{"foo": "bar"}),
+		}, map[string]interface{}{}, false),
+	}
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if err := worker.ProcessPayload(payload); err != context.Canceled {
+		t.Fatalf("Expected a cancellation error, got %v", err)
+	}
+
+	// the payload can be redelivered
+	payload.check(t, 0, 1)
+}
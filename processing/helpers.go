@@ -17,29 +17,263 @@
 package processing
 
 import (
+	"context"
+	"fmt"
 	"github.com/kiprotect/kodex"
+	"github.com/prometheus/client_golang/prometheus"
+	"hash/fnv"
 	"time"
 )
 
-func ProcessStream(stream kodex.Stream, timeout time.Duration) error {
+// Wraps a payload that ends the stream so that we can pass it on as a regular
+// payload. Acknowledging or rejecting it still acts on the original payload.
+type continuedPayload struct {
+	kodex.Payload
+}
+
+func (c *continuedPayload) EndOfStream() bool {
+	return false
+}
+
+// Splits the items into n partitions by the value of the partition key, so
+// that items with the same value always end up in the same partition. Items
+// without the key end up in the first partition.
+func partition(items []*kodex.Item, key string, n int) [][]*kodex.Item {
+
+	partitions := make([][]*kodex.Item, n)
+
+	for _, item := range items {
+		i := 0
+		if value, ok := item.Get(key); ok {
+			hash := fnv.New32a()
+			fmt.Fprint(hash, value)
+			i = int(hash.Sum32() % uint32(n))
+		}
+		partitions[i] = append(partitions[i], item)
+	}
+
+	return partitions
+}
+
+// Sends the items of the payload to the channels of the workers that are
+// responsible for their partitions, so that items with the same key are
+// always processed by the same worker, in order. The payload is acknowledged
+// once all workers acknowledged their part of it. If the context is
+// cancelled before we could hand over all parts, we reject the payload.
+func dispatch(ctx context.Context, payload kodex.Payload, key string, channels []chan kodex.Payload) error {
+
+	delivery := kodex.MakeDelivery(payload)
+
+	for i, items := range partition(payload.Items(), key, len(channels)) {
+
+		// payloads without items (e.g. to advance stateful actions) go to
+		// the first worker
+		if len(items) == 0 && (i > 0 || len(payload.Items()) > 0) {
+			continue
+		}
+
+		derivedPayload := delivery.Derive(items, payload.Headers(), payload.EndOfStream())
+
+		select {
+		case channels[i] <- derivedPayload:
+		case <-ctx.Done():
+			if err := derivedPayload.Reject(); err != nil {
+				kodex.Log.Error(err)
+			}
+			delivery.Acknowledge()
+			return ctx.Err()
+		}
+	}
+
+	return delivery.Acknowledge()
+}
+
+// Runs the loop of a worker, which processes the payloads it receives on its
+// payload channel. With a pool, the worker submits its channel to the pool
+// whenever it can accept another payload. Once the worker receives a stop
+// signal, it processes the payloads that are still buffered, removes its
+// channel from the pool, closes it and acknowledges the stop. Executors stop
+// dispatching payloads before they stop their workers.
+func runWorker(pool chan chan kodex.Payload, payloadChannel chan kodex.Payload, stop chan bool, process func(kodex.Payload)) {
+
+	if pool != nil {
+		pool <- payloadChannel
+	}
+
+	for {
+		select {
+		case payload := <-payloadChannel:
+			process(payload)
+			if pool != nil {
+				pool <- payloadChannel
+			}
+		case <-stop:
+			// we are the only reader, so this never blocks
+			for len(payloadChannel) > 0 {
+				process(<-payloadChannel)
+			}
+			removeFromPool(pool, payloadChannel)
+			close(payloadChannel)
+			stop <- true
+			return
+		}
+	}
+}
+
+// Removes the channel from the pool, resubmitting the other channels
+func removeFromPool(pool chan chan kodex.Payload, payloadChannel chan kodex.Payload) {
+
+	if pool == nil {
+		return
+	}
+
+	channels := make([]chan kodex.Payload, 0)
+
+loop:
+	for {
+		select {
+		case channel := <-pool:
+			if channel == payloadChannel {
+				break loop
+			}
+			channels = append(channels, channel)
+		default:
+			// no more worker channels
+			break loop
+		}
+	}
+
+	for _, channel := range channels {
+		pool <- channel
+	}
+}
+
+// How often we poll sources and internal channels that can't notify us when
+// new payloads arrive
+const pollInterval = 10 * time.Millisecond
+
+// Tells an executor when payloads might have arrived in an internal channel.
+// Internal readers that can't notify us are polled instead.
+type channelWatcher struct {
+	notify <-chan struct{}
+	ticker *time.Ticker
+}
+
+func watchChannel(channel *kodex.InternalChannel) *channelWatcher {
+	watcher := &channelWatcher{
+		notify: channel.Notify(),
+	}
+	if watcher.notify == nil {
+		watcher.ticker = time.NewTicker(pollInterval)
+	}
+	return watcher
+}
+
+// Blocks until payloads might have arrived or one of the contexts is done.
+func (w *channelWatcher) wait(ctx, abortCtx context.Context) {
+	var poll <-chan time.Time
+	if w.ticker != nil {
+		poll = w.ticker.C
+	}
+	select {
+	case <-w.notify:
+	case <-poll:
+	case <-ctx.Done():
+	case <-abortCtx.Done():
+	}
+}
+
+func (w *channelWatcher) stop() {
+	if w.ticker != nil {
+		w.ticker.Stop()
+	}
+}
+
+func closedChannel() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+// Stops a run of an executor gracefully when its context is cancelled.
+func stopWhenCancelled(ctx context.Context, done chan struct{}, stop func(chan struct{}, bool) error) {
+	select {
+	case <-ctx.Done():
+		stop(done, true)
+	case <-done:
+	}
+}
+
+// Waits until all executors stopped or the context is cancelled. Returns
+// false in the latter case.
+func wait(ctx context.Context, executors []Executor) bool {
+	for _, executor := range executors {
+		select {
+		case <-executor.Done():
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// Stops the executors gracefully and stops those that are still running
+// when the deadline context is done hard. Returns false if we had to stop
+// any executor hard.
+func drain(deadline context.Context, executors []Executor) bool {
+
+	for _, executor := range executors {
+		go func(executor Executor) {
+			if err := executor.Stop(true); err != nil {
+				kodex.Log.Error(err)
+			}
+		}(executor)
+	}
+
+	graceful := true
+
+	for _, executor := range executors {
+		select {
+		case <-executor.Done():
+		case <-deadline.Done():
+			graceful = false
+			if err := executor.Stop(false); err != nil {
+				kodex.Log.Error(err)
+			}
+			<-executor.Done()
+		}
+	}
+
+	return graceful
+}
+
+// Processes a stream until all its sources reached the end of their data.
+// If the context is cancelled before, we stop reading from the sources and
+// give the stream executor and the destination writers up to the given
+// timeout to process the payloads that are in flight before we stop them
+// hard, in which case unprocessed payloads are rejected. A zero timeout
+// waits indefinitely. Returns the error of the context if it was cancelled.
+func ProcessStream(ctx context.Context, stream kodex.Stream, timeout time.Duration) error {
 
 	// we get all the sources for the stream
 	sourceMaps, _ := stream.Sources()
 
-	// we create readers for all the sources
+	// we create readers for all the sources, which stop when the context is
+	// cancelled
 	sourceReaders := make([]Executor, 0)
 	for _, sourceMap := range sourceMaps {
 		sourceReader := MakeLocalSourceReader(1, []byte("test"))
-		if err := sourceReader.Start(nil, sourceMap); err != nil {
+		if err := sourceReader.Start(ctx, nil, sourceMap); err != nil {
 			return err
 		}
 		sourceReaders = append(sourceReaders, sourceReader)
 	}
 
-	// we process the stream using a local stream executor
+	// we process the stream using a local stream executor, which we stop
+	// ourselves once the sources stopped
 	streamExecutor := MakeLocalStreamExecutor(1, []byte("test"))
 
-	if err := streamExecutor.Start(nil, stream); err != nil {
+	if err := streamExecutor.Start(context.Background(), nil, stream); err != nil {
 		return err
 	}
 
@@ -65,56 +299,48 @@ func ProcessStream(stream kodex.Stream, timeout time.Duration) error {
 
 	for _, destinationMap := range destinationMaps {
 		destinationWriter := MakeLocalDestinationWriter(1, []byte("test"))
-		if err := destinationWriter.Start(nil, destinationMap); err != nil {
+		if err := destinationWriter.Start(context.Background(), nil, destinationMap); err != nil {
 			return err
 		}
 		destinationWriters = append(destinationWriters, destinationWriter)
 	}
 
 	// we wait for all source readers to finish their work
-	for {
-		allStopped := true
-		for _, sourceReader := range sourceReaders {
-			if !sourceReader.Stopped() {
-				allStopped = false
-				break
-			}
-		}
-		if allStopped {
-			break
-		}
-		time.Sleep(time.Millisecond)
-	}
+	wait(context.Background(), sourceReaders)
 
 	kodex.Log.Infof("Readers stopped...")
 
-	// we wait for the stream executor to finish its work
-	for {
-		if streamExecutor.Stopped() {
-			break
+	// the executors stop by themselves when they reach the end of the stream
+	if wait(ctx, []Executor{streamExecutor}) {
+		kodex.Log.Infof("Stream executors stopped...")
+		if wait(ctx, destinationWriters) {
+			kodex.Log.Infof("Destination writers stopped...")
+			return nil
 		}
-		time.Sleep(time.Millisecond)
 	}
 
-	kodex.Log.Infof("Stream executors stopped...")
+	kodex.Log.Infof("Stopping executors...")
 
-	// we wait for all destination writers to finish their work
-	for {
-		allStopped := true
-		for _, destinationWriter := range destinationWriters {
-			if !destinationWriter.Stopped() {
-				allStopped = false
-				break
-			}
-		}
-		if allStopped {
-			break
-		}
-		time.Sleep(time.Millisecond)
+	deadline := context.Background()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		deadline, cancel = context.WithTimeout(deadline, timeout)
+		defer cancel()
 	}
 
-	kodex.Log.Infof("Destination writers stopped...")
+	if !drain(deadline, []Executor{streamExecutor}) || !drain(deadline, destinationWriters) {
+		kodex.Log.Warningf("Executors did not stop within %v, unprocessed payloads were rejected", timeout)
+	}
 
-	return nil
+	return ctx.Err()
 
 }
+
+// Sets the gauge to the number of payloads waiting in the channel (if the
+// channel reports it).
+func updateQueueDepth(gauge prometheus.Gauge, channel *kodex.InternalChannel) {
+	if queued, ok := channel.Queued(); ok {
+		gauge.Set(float64(queued))
+	}
+}
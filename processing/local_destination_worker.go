@@ -17,27 +17,34 @@
 package processing
 
 import (
+	"context"
 	"github.com/kiprotect/kodex"
+	"github.com/prometheus/client_golang/prometheus"
 	"sync"
-	"time"
 )
 
 type LocalDestinationWorker struct {
+	ctx            context.Context
 	pool           chan chan kodex.Payload
 	started        bool
 	ItemsProcessed int
 	writer         kodex.Writer
 	channels       []*kodex.InternalChannel
 	executor       Executor
+	errors         prometheus.Counter
 	mutex          sync.Mutex
 	payloadChannel chan kodex.Payload
 	stop           chan bool
 }
 
-func MakeLocalDestinationWorker(pool chan chan kodex.Payload,
+// Creates a worker that writes payloads to the writer. Cancelling the
+// context interrupts writes (if the writer supports it).
+func MakeLocalDestinationWorker(ctx context.Context,
+	pool chan chan kodex.Payload,
 	writer kodex.Writer,
 	executor Executor) (*LocalDestinationWorker, error) {
 	return &LocalDestinationWorker{
+		ctx:            ctx,
 		pool:           pool,
 		payloadChannel: make(chan kodex.Payload, 100),
 		stop:           make(chan bool),
@@ -52,50 +59,12 @@ func (w *LocalDestinationWorker) Start() {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	stop := false
 	w.started = true
 
-	go func() {
-		w.pool <- w.payloadChannel
-		for {
-			select {
-			case payload := <-w.payloadChannel:
-				w.ItemsProcessed += len(payload.Items())
-				w.ProcessPayload(payload)
-				w.pool <- w.payloadChannel
-			case <-w.stop:
-				stop = true
-			case <-time.After(time.Millisecond):
-				if stop && len(w.payloadChannel) == 0 {
-					// we remove the worker channel from the pool of channels
-					channels := make([]chan kodex.Payload, 0)
-				loop:
-					for {
-						select {
-						case wc := <-w.pool:
-							if wc != w.payloadChannel {
-								channels = append(channels, wc)
-							} else {
-								// we have found the channel, we break
-								break loop
-							}
-						default: // no more worker channels
-							break loop
-						}
-					}
-					// we resubmit the other channels
-					for _, channel := range channels {
-						w.pool <- channel
-					}
-					// we close the payload channel
-					close(w.payloadChannel)
-					w.started = false
-					w.stop <- true
-					return
-				}
-			}
-		}
-	}()
+	go runWorker(w.pool, w.payloadChannel, w.stop, func(payload kodex.Payload) {
+		w.ItemsProcessed += len(payload.Items())
+		w.ProcessPayload(payload)
+	})
 }
 
 func (w *LocalDestinationWorker) Stop() {
@@ -128,6 +97,9 @@ func (w *LocalDestinationWorker) ProcessPayload(payload kodex.Payload) error {
 	// we send the items from the payload to the designated internal queues
 
 	handleError := func(err error) error {
+		if w.errors != nil {
+			w.errors.Inc()
+		}
 		if err := payload.Reject(); err != nil {
 			kodex.Log.Error(err)
 		}
@@ -135,7 +107,7 @@ func (w *LocalDestinationWorker) ProcessPayload(payload kodex.Payload) error {
 		return err
 	}
 
-	if err := w.writer.Write(payload); err != nil {
+	if err := kodex.WriteContext(w.ctx, w.writer, payload); err != nil {
 		return handleError(err)
 	}
 
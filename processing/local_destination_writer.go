@@ -17,10 +17,12 @@
 package processing
 
 import (
+	"context"
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/metrics"
 	"sync"
-	"time"
+	"sync/atomic"
 )
 
 type LocalDestinationWriter struct {
@@ -28,22 +30,27 @@ type LocalDestinationWriter struct {
 	workers               []*LocalDestinationWorker
 	id                    []byte
 	pool                  chan chan kodex.Payload
+	partitionKey          string
 	destinationMap        kodex.DestinationMap
 	writer                kodex.Writer
 	endOfStream           bool
 	channel               *kodex.InternalChannel
-	stopChannel           chan bool
+	cancel                context.CancelFunc
+	abort                 context.CancelFunc
+	readDone              chan struct{}
+	done                  chan struct{}
 	mutex                 sync.Mutex
 	supervisor            Supervisor
 	stopped               bool
 	stopping              bool
 	payloadChannel        chan kodex.Payload
+	itemsProcessed        int64
 }
 
 func MakeLocalDestinationWriter(maxDestinationWorkers int,
 	id []byte) *LocalDestinationWriter {
 	return &LocalDestinationWriter{
-		stopChannel:           make(chan bool),
+		done:                  closedChannel(),
 		stopped:               true,
 		id:                    id,
 		payloadChannel:        make(chan kodex.Payload, maxDestinationWorkers*8),
@@ -51,11 +58,17 @@ func MakeLocalDestinationWriter(maxDestinationWorkers int,
 	}
 }
 
+func (d *LocalDestinationWriter) ItemsProcessed() int64 {
+	return atomic.LoadInt64(&d.itemsProcessed)
+}
+
 func (d *LocalDestinationWriter) ID() []byte {
 	return d.id
 }
 
-func (d *LocalDestinationWriter) Start(supervisor Supervisor, processable kodex.Processable) error {
+// Starts writing to the destination. Cancelling the context stops the writer
+// gracefully, i.e. it writes the payloads in its channel before it stops.
+func (d *LocalDestinationWriter) Start(ctx context.Context, supervisor Supervisor, processable kodex.Processable) error {
 
 	destinationMap, ok := processable.(kodex.DestinationMap)
 
@@ -90,14 +103,20 @@ func (d *LocalDestinationWriter) Start(supervisor Supervisor, processable kodex.
 		return err
 	}
 
-	return d.run()
+	return d.run(ctx)
 }
 
 func (d *LocalDestinationWriter) Stop(graceful bool) error {
-	return d.stop(graceful)
+	return d.stop(nil, graceful)
+}
+
+func (d *LocalDestinationWriter) Done() <-chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.done
 }
 
-func (d *LocalDestinationWriter) run() error {
+func (d *LocalDestinationWriter) run(ctx context.Context) error {
 
 	d.workers = make([]*LocalDestinationWorker, 0)
 
@@ -105,20 +124,44 @@ func (d *LocalDestinationWriter) run() error {
 		return fmt.Errorf("no destination map defined")
 	}
 
-	d.pool = make(chan chan kodex.Payload, d.maxDestinationWorkers)
+	destination := d.destinationMap.Destination()
+	workers := d.maxDestinationWorkers
+
+	if n := destination.Workers(); n > 0 {
+		workers = n
+	}
 
-	for i := 0; i < d.maxDestinationWorkers; i++ {
-		worker, err := MakeLocalDestinationWorker(d.pool, d.writer, d)
+	// with a partition key we send items to the workers by partition,
+	// otherwise any free worker from the pool receives the next payload
+	d.partitionKey = destination.PartitionKey()
+	d.pool = nil
+
+	if d.partitionKey == "" || workers == 1 {
+		d.pool = make(chan chan kodex.Payload, workers)
+	}
+
+	// writes are only interrupted by a hard stop
+	abortCtx, abort := context.WithCancel(context.Background())
+
+	for i := 0; i < workers; i++ {
+		worker, err := MakeLocalDestinationWorker(abortCtx, d.pool, d.writer, d)
 		if err != nil {
+			abort()
 			return err
 		}
+		worker.errors = metrics.DestinationErrors.WithLabelValues(destination.Project().Name(), destination.Name())
 		worker.Start()
 		d.workers = append(d.workers, worker)
 	}
 
+	ctx, d.cancel = context.WithCancel(ctx)
+	d.abort = abort
+	d.readDone = make(chan struct{})
+	d.done = make(chan struct{})
 	d.stopped = false
 
-	go d.write()
+	go d.write(ctx, abortCtx, d.readDone, d.done)
+	go stopWhenCancelled(ctx, d.done, d.stop)
 
 	return nil
 }
@@ -129,31 +172,47 @@ func (d *LocalDestinationWriter) Stopped() bool {
 	return d.stopped
 }
 
-func (d *LocalDestinationWriter) stop(graceful bool) error {
+// Stops the run that closes the given done channel (or the current run if
+// it's nil). A graceful stop writes all payloads in the channel first, a
+// hard stop rejects them and interrupts writes that are in progress. A hard
+// stop also aborts a graceful stop that is in progress.
+func (d *LocalDestinationWriter) stop(done chan struct{}, graceful bool) error {
 
-	if d.stopping || d.stopped {
+	d.mutex.Lock()
+
+	if d.stopped || (done != nil && done != d.done) {
+		d.mutex.Unlock()
 		return nil
 	}
 
-	d.mutex.Lock()
+	done = d.done
 
-	destinationMap := d.destinationMap
-	supervisor := d.supervisor
+	if !graceful {
+		d.abort()
+		// we reject all payloads that we haven't read yet so that their
+		// sources can redeliver them
+		if err := d.channel.Purge(); err != nil {
+			kodex.Log.Error(err)
+		}
+	}
+
+	if d.stopping {
+		// another goroutine is already stopping the writer
+		d.mutex.Unlock()
+		<-done
+		return nil
+	}
 
 	d.stopping = true
 
-	defer func() {
-		d.mutex.Unlock()
-		d.destinationMap = nil
-		d.writer = nil
-		d.stopped = true
-		d.stopping = false
-		d.supervisor = nil
-	}()
+	destinationMap := d.destinationMap
+	supervisor := d.supervisor
+
+	d.mutex.Unlock()
 
 	// first we stop the destination writer to stop reading more payloads..
-	d.stopChannel <- true
-	<-d.stopChannel
+	d.cancel()
+	<-d.readDone
 
 	itemsProcessed := 0
 
@@ -163,7 +222,10 @@ func (d *LocalDestinationWriter) stop(graceful bool) error {
 		// to ensure it will be processed as the last payload
 		if d.endOfStream && i == len(d.workers)-1 {
 			endOfStreamPayload := kodex.MakeBasicPayload([]*kodex.Item{}, map[string]interface{}{}, true)
-			workerChannel := <-d.pool
+			workerChannel := worker.payloadChannel
+			if d.pool != nil {
+				workerChannel = <-d.pool
+			}
 			workerChannel <- endOfStreamPayload
 		}
 		worker.Stop()
@@ -181,6 +243,16 @@ func (d *LocalDestinationWriter) stop(graceful bool) error {
 		kodex.Log.Error(err)
 	}
 
+	d.mutex.Lock()
+	d.abort()
+	d.destinationMap = nil
+	d.writer = nil
+	d.stopped = true
+	d.stopping = false
+	d.supervisor = nil
+	close(done)
+	d.mutex.Unlock()
+
 	if supervisor != nil {
 		supervisor.ExecutorStopped(d, destinationMap)
 	}
@@ -189,69 +261,104 @@ func (d *LocalDestinationWriter) stop(graceful bool) error {
 
 }
 
-func (d *LocalDestinationWriter) write() {
+// Reads payloads from the channel until the context is cancelled and the
+// channel is empty, or until the abort context is cancelled.
+func (d *LocalDestinationWriter) write(ctx, abortCtx context.Context, readDone, done chan struct{}) {
 
-	stopping := false
+	defer close(readDone)
 
-	stop := func() {
-		if !stopping {
-			stopping = true
-			go d.stop(true)
-		}
-	}
+	destination := d.destinationMap.Destination()
+	labels := []string{destination.Project().Name(), destination.Name()}
+	destinationItems := metrics.DestinationItems.WithLabelValues(labels...)
+	queueDepth := metrics.QueueDepth.WithLabelValues(labels[0], "destination", labels[1])
+
+	// we only report the queue depth while we read from the channel
+	defer metrics.QueueDepth.DeleteLabelValues(labels[0], "destination", labels[1])
 
 	itemsProcessed := 0
+	stopping := false
+
+	watcher := watchChannel(d.channel)
+	defer watcher.stop()
 
 	for {
-		var payload kodex.Payload
-		var err error
 
 		select {
-		case <-d.stopChannel:
-			// we stop reading any more payloads and return...
+		case <-abortCtx.Done():
+			return
+		case <-ctx.Done():
 			stopping = true
-		case <-time.After(time.Millisecond):
-			break
+		default:
 		}
 
 		// to do: check if the destination was updated and if yes break out of
 		// the loop (to reload configuration)
 
-		if payload, err = d.channel.Read(); err != nil {
+		payload, err := d.channel.Read()
+
+		if err != nil {
 			kodex.Log.Error(err)
-			stop()
-			continue
+			go d.stop(done, true)
+			return
 		}
 
+		updateQueueDepth(queueDepth, d.channel)
+
 		// we didn't receive any new items...
 		if payload == nil {
 			if stopping {
-				d.stopChannel <- true
 				kodex.Log.Debugf("%d items processed in stream", itemsProcessed)
 				return
 			}
+			watcher.wait(ctx, abortCtx)
 			continue
 		}
 
 		itemsProcessed += len(payload.Items())
+		atomic.AddInt64(&d.itemsProcessed, int64(len(payload.Items())))
+		destinationItems.Add(float64(len(payload.Items())))
 
 		if payload.EndOfStream() {
 			// we replace the "end of stream payload" and instead send a replacement
 			// payload during the stop process to ensure that it will be processed last
-			replacedPayload := kodex.MakeBasicPayload(payload.Items(), payload.Headers(), false)
-			workerChannel := <-d.pool
-			workerChannel <- replacedPayload
+			if !d.dispatch(abortCtx, &continuedPayload{payload}) {
+				return
+			}
 			d.mutex.Lock()
 			d.endOfStream = true
 			d.mutex.Unlock()
-		} else {
-			workerChannel := <-d.pool
-			workerChannel <- payload
+			// we write the remaining payloads and stop
+			stopping = true
+			go d.stop(done, true)
+			continue
 		}
 
-		if payload.EndOfStream() {
-			stop()
-			continue
+		if !d.dispatch(abortCtx, payload) {
+			return
+		}
+	}
+}
+
+// Hands the payload over to the workers. Returns false if we were aborted
+// before, in which case the payload is rejected.
+func (d *LocalDestinationWriter) dispatch(abortCtx context.Context, payload kodex.Payload) bool {
+
+	if d.pool == nil {
+		channels := make([]chan kodex.Payload, len(d.workers))
+		for i, worker := range d.workers {
+			channels[i] = worker.payloadChannel
+		}
+		return dispatch(abortCtx, payload, d.partitionKey, channels) == nil
+	}
+
+	select {
+	case workerChannel := <-d.pool:
+		workerChannel <- payload
+		return true
+	case <-abortCtx.Done():
+		if err := payload.Reject(); err != nil {
+			kodex.Log.Error(err)
 		}
+		return false
 	}
 }
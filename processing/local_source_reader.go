@@ -17,9 +17,12 @@
 package processing
 
 import (
+	"context"
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/metrics"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,19 +34,22 @@ type LocalSourceReader struct {
 	sourceMap        kodex.SourceMap
 	reader           kodex.Reader
 	configs          []kodex.Config
-	stopChannel      chan bool
+	cancel           context.CancelFunc
+	readDone         chan struct{}
+	done             chan struct{}
 	endOfStream      bool
 	mutex            sync.Mutex
 	supervisor       Supervisor
 	stopped          bool
 	stopping         bool
 	payloadChannel   chan kodex.Payload
+	itemsProcessed   int64
 }
 
 func MakeLocalSourceReader(maxSourceWorkers int,
 	id []byte) *LocalSourceReader {
 	return &LocalSourceReader{
-		stopChannel:      make(chan bool, 1),
+		done:             closedChannel(),
 		stopped:          true,
 		id:               id,
 		payloadChannel:   make(chan kodex.Payload, maxSourceWorkers*8),
@@ -51,11 +57,16 @@ func MakeLocalSourceReader(maxSourceWorkers int,
 	}
 }
 
+func (d *LocalSourceReader) ItemsProcessed() int64 {
+	return atomic.LoadInt64(&d.itemsProcessed)
+}
+
 func (d *LocalSourceReader) ID() []byte {
 	return d.id
 }
 
-func (d *LocalSourceReader) Start(supervisor Supervisor, processable kodex.Processable) error {
+// Starts reading from the source. Cancelling the context stops the reader.
+func (d *LocalSourceReader) Start(ctx context.Context, supervisor Supervisor, processable kodex.Processable) error {
 
 	sourceMap, ok := processable.(kodex.SourceMap)
 
@@ -83,14 +94,20 @@ func (d *LocalSourceReader) Start(supervisor Supervisor, processable kodex.Proce
 		return err
 	}
 
-	return d.run()
+	return d.run(ctx)
 }
 
 func (d *LocalSourceReader) Stop(graceful bool) error {
-	return d.stop(graceful)
+	return d.stop(nil, graceful)
+}
+
+func (d *LocalSourceReader) Done() <-chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.done
 }
 
-func (d *LocalSourceReader) run() error {
+func (d *LocalSourceReader) run(ctx context.Context) error {
 
 	d.workers = make([]*LocalSourceWorker, 0)
 
@@ -116,9 +133,13 @@ func (d *LocalSourceReader) run() error {
 		d.workers = append(d.workers, worker)
 	}
 
+	ctx, d.cancel = context.WithCancel(ctx)
+	d.readDone = make(chan struct{})
+	d.done = make(chan struct{})
 	d.stopped = false
 
-	go d.read()
+	go d.read(ctx, d.readDone, d.done)
+	go stopWhenCancelled(ctx, d.done, d.stop)
 
 	return nil
 }
@@ -133,33 +154,37 @@ func (d *LocalSourceReader) SourceMap() kodex.SourceMap {
 	return d.sourceMap
 }
 
-func (d *LocalSourceReader) stop(graceful bool) error {
+// Stops the run that closes the given done channel (or the current run if
+// it's nil). Reading is always stopped at once, so graceful and hard stops
+// behave the same for sources.
+func (d *LocalSourceReader) stop(done chan struct{}, graceful bool) error {
 
-	if d.stopping || d.stopped {
+	d.mutex.Lock()
+
+	if d.stopped || (done != nil && done != d.done) {
+		d.mutex.Unlock()
 		return nil
 	}
 
-	d.mutex.Lock()
+	done = d.done
 
-	sourceMap := d.sourceMap
-	supervisor := d.supervisor
-	defer func() {
-		d.sourceMap = nil
-		d.reader = nil
-		d.stopped = true
-		d.stopping = false
-		d.supervisor = nil
+	if d.stopping {
+		// another goroutine is already stopping the reader
 		d.mutex.Unlock()
-		if supervisor != nil {
-			supervisor.ExecutorStopped(d, sourceMap)
-		}
-	}()
+		<-done
+		return nil
+	}
 
 	d.stopping = true
 
+	sourceMap := d.sourceMap
+	supervisor := d.supervisor
+
+	d.mutex.Unlock()
+
 	// first we stop the source reader to stop reading more payloads..
-	d.stopChannel <- true
-	<-d.stopChannel
+	d.cancel()
+	<-d.readDone
 
 	itemsProcessed := 0
 
@@ -183,68 +208,91 @@ func (d *LocalSourceReader) stop(graceful bool) error {
 		kodex.Log.Error(err)
 	}
 
+	d.mutex.Lock()
+	d.sourceMap = nil
+	d.reader = nil
+	d.stopped = true
+	d.stopping = false
+	d.supervisor = nil
+	close(done)
+	d.mutex.Unlock()
+
+	if supervisor != nil {
+		supervisor.ExecutorStopped(d, sourceMap)
+	}
+
 	return nil
 
 }
 
-func (d *LocalSourceReader) read() {
-	stopping := false
-	stopRequested := false
+func (d *LocalSourceReader) read(ctx context.Context, readDone, done chan struct{}) {
 
-	stop := func() {
-		if !stopRequested && !stopping {
-			stopRequested = true
-			go d.stop(true)
-		}
-	}
+	defer close(readDone)
+
+	source := d.sourceMap.Source()
+	sourceItems := metrics.SourceItems.WithLabelValues(source.Project().Name(), source.Name())
+
+	// readers that poll for data return no payload if there is none, in
+	// which case we wait a bit before we try again
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
 
 	for {
-		var payload kodex.Payload
-		var err error
 
 		select {
-		case <-d.stopChannel:
-			// we stop...
-			d.stopChannel <- true
+		case <-ctx.Done():
 			return
-		case <-time.After(time.Millisecond):
-			break
+		default:
 		}
 
 		// to do: check if the source was updated and if yes break out of
 		// the loop (to reload configuration)
 
-		if payload, err = d.reader.Read(); err != nil {
-			stop()
+		payload, err := kodex.ReadContext(ctx, d.reader)
+
+		if err != nil {
+			if ctx.Err() == nil {
+				kodex.Log.Error(err)
+				go d.stop(done, true)
+			}
+			return
+		}
+
+		if payload == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
 			continue
 		}
 
-		// we didn't receive any new items...
-		if payload == nil || len(payload.Items()) == 0 {
-			if stopping {
+		atomic.AddInt64(&d.itemsProcessed, int64(len(payload.Items())))
+		sourceItems.Add(float64(len(payload.Items())))
 
-				return
+		var workerChannel chan kodex.Payload
+
+		select {
+		case workerChannel = <-d.pool:
+		case <-ctx.Done():
+			// the source can redeliver the payload
+			if err := payload.Reject(); err != nil {
+				kodex.Log.Error(err)
 			}
+			return
 		}
 
 		if payload.EndOfStream() {
 			// we replace the "end of stream payload" and instead send a replacement
 			// payload during the stop process to ensure that it will be processed last
-			replacedPayload := kodex.MakeBasicPayload(payload.Items(), payload.Headers(), false)
-			workerChannel := <-d.pool
-			workerChannel <- replacedPayload
+			workerChannel <- &continuedPayload{payload}
 			d.mutex.Lock()
 			d.endOfStream = true
 			d.mutex.Unlock()
-		} else {
-			workerChannel := <-d.pool
-			workerChannel <- payload
-		}
-
-		if payload.EndOfStream() {
-			stop()
-			continue
+			go d.stop(done, true)
+			return
 		}
 
+		workerChannel <- payload
 	}
 }
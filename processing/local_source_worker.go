@@ -19,7 +19,6 @@ package processing
 import (
 	"github.com/kiprotect/kodex"
 	"sync"
-	"time"
 )
 
 type LocalSourceWorker struct {
@@ -69,54 +68,12 @@ func (w *LocalSourceWorker) Start() {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	stop := false
 	w.started = true
 
-	go func() {
-		// we submit our payload channel to the worker pool
-		// the source reader will fetch it from the pool and submit items
-		// to it. If we want to accept more work we will have to submit the
-		// channel again.
-		w.pool <- w.payloadChannel
-		for {
-			select {
-			case payload := <-w.payloadChannel:
-				w.ItemsProcessed += len(payload.Items())
-				w.ProcessPayload(payload)
-				w.pool <- w.payloadChannel
-			case <-w.stop:
-				stop = true
-			case <-time.After(time.Millisecond):
-				if stop && len(w.payloadChannel) == 0 {
-					// we remove the worker channel from the pool of channels
-					channels := make([]chan kodex.Payload, 0)
-				loop:
-					for {
-						select {
-						case wc := <-w.pool:
-							if wc != w.payloadChannel {
-								channels = append(channels, wc)
-							} else {
-								// we have found the channel, we break
-								break loop
-							}
-						default: // no more worker channels
-							break loop
-						}
-					}
-					// we resubmit the other channels
-					for _, channel := range channels {
-						w.pool <- channel
-					}
-					// we close the payload channel
-					close(w.payloadChannel)
-					w.started = false
-					w.stop <- true
-					return
-				}
-			}
-		}
-	}()
+	go runWorker(w.pool, w.payloadChannel, w.stop, func(payload kodex.Payload) {
+		w.ItemsProcessed += len(payload.Items())
+		w.ProcessPayload(payload)
+	})
 }
 
 func (w *LocalSourceWorker) Stop() {
@@ -146,19 +103,23 @@ func (w *LocalSourceWorker) Stop() {
 
 func (w *LocalSourceWorker) ProcessPayload(payload kodex.Payload) error {
 
-	// we send the items from the payload to the designated internal queues
+	// we send the items from the payload to the designated internal queues.
+	// The payload is acknowledged only after all streams (and in turn all
+	// their destinations) have acknowledged the payloads we derive from it.
 
-	handleError := func(err error) error {
-		kodex.Log.Error(err)
-		return err
-	}
+	delivery := kodex.MakeDelivery(payload)
 
 	for _, channel := range w.channels {
-		if err := channel.Write(payload); err != nil {
-			return handleError(err)
+		if err := channel.Write(delivery.Derive(payload.Items(), payload.Headers(), payload.EndOfStream())); err != nil {
+			kodex.Log.Error(err)
+			if err := delivery.Reject(); err != nil {
+				kodex.Log.Error(err)
+			}
+			return err
 		}
 	}
 
-	return nil
+	// the payload was handed over to all streams
+	return delivery.Acknowledge()
 
 }
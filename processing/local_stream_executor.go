@@ -17,11 +17,13 @@
 package processing
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/metrics"
 	"sync"
-	"time"
+	"sync/atomic"
 )
 
 type LocalStreamExecutor struct {
@@ -29,22 +31,27 @@ type LocalStreamExecutor struct {
 	workers          []*LocalStreamWorker
 	id               []byte
 	pool             chan chan kodex.Payload
+	partitionKey     string
 	stream           kodex.Stream
 	channel          *kodex.InternalChannel
 	contexts         []*ConfigContext
-	stopChannel      chan bool
+	cancel           context.CancelFunc
+	abort            context.CancelFunc
+	readDone         chan struct{}
+	done             chan struct{}
 	mutex            sync.Mutex
 	supervisor       Supervisor
 	endOfStream      bool
 	stopped          bool
 	stopping         bool
 	payloadChannel   chan kodex.Payload
+	itemsProcessed   int64
 }
 
 func MakeLocalStreamExecutor(maxStreamWorkers int,
 	id []byte) *LocalStreamExecutor {
 	return &LocalStreamExecutor{
-		stopChannel:      make(chan bool),
+		done:             closedChannel(),
 		stopped:          true,
 		id:               id,
 		payloadChannel:   make(chan kodex.Payload, maxStreamWorkers*8),
@@ -52,11 +59,17 @@ func MakeLocalStreamExecutor(maxStreamWorkers int,
 	}
 }
 
+func (d *LocalStreamExecutor) ItemsProcessed() int64 {
+	return atomic.LoadInt64(&d.itemsProcessed)
+}
+
 func (d *LocalStreamExecutor) ID() []byte {
 	return d.id
 }
 
-func (d *LocalStreamExecutor) Start(supervisor Supervisor, processable kodex.Processable) error {
+// Starts processing the stream. Cancelling the context stops the executor
+// gracefully, i.e. it processes the payloads in its channel before it stops.
+func (d *LocalStreamExecutor) Start(ctx context.Context, supervisor Supervisor, processable kodex.Processable) error {
 
 	stream, ok := processable.(kodex.Stream)
 
@@ -82,14 +95,20 @@ func (d *LocalStreamExecutor) Start(supervisor Supervisor, processable kodex.Pro
 		return err
 	}
 
-	return d.run()
+	return d.run(ctx)
 }
 
 func (d *LocalStreamExecutor) Stop(graceful bool) error {
-	return d.stop(graceful)
+	return d.stop(nil, graceful)
+}
+
+func (d *LocalStreamExecutor) Done() <-chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.done
 }
 
-func (d *LocalStreamExecutor) run() error {
+func (d *LocalStreamExecutor) run(ctx context.Context) error {
 
 	d.workers = make([]*LocalStreamWorker, 0)
 
@@ -112,7 +131,20 @@ func (d *LocalStreamExecutor) run() error {
 		}
 	}
 
-	d.pool = make(chan chan kodex.Payload, d.maxStreamWorkers)
+	workers := d.maxStreamWorkers
+
+	if n := d.stream.Workers(); n > 0 {
+		workers = n
+	}
+
+	// with a partition key we send items to the workers by partition,
+	// otherwise any free worker from the pool receives the next payload
+	d.partitionKey = d.stream.PartitionKey()
+	d.pool = nil
+
+	if d.partitionKey == "" || workers == 1 {
+		d.pool = make(chan chan kodex.Payload, workers)
+	}
 
 	d.contexts, err = makeContexts(activeConfigs)
 
@@ -120,7 +152,7 @@ func (d *LocalStreamExecutor) run() error {
 		return err
 	}
 
-	for i := 0; i < d.maxStreamWorkers; i++ {
+	for i := 0; i < workers; i++ {
 		worker, err := MakeLocalStreamWorker(d.pool, d.contexts, false, d)
 		if err != nil {
 			return err
@@ -129,9 +161,15 @@ func (d *LocalStreamExecutor) run() error {
 		d.workers = append(d.workers, worker)
 	}
 
+	ctx, d.cancel = context.WithCancel(ctx)
+	abortCtx, abort := context.WithCancel(context.Background())
+	d.abort = abort
+	d.readDone = make(chan struct{})
+	d.done = make(chan struct{})
 	d.stopped = false
 
-	go d.read()
+	go d.read(ctx, abortCtx, d.readDone, d.done)
+	go stopWhenCancelled(ctx, d.done, d.stop)
 
 	return nil
 }
@@ -173,37 +211,47 @@ func makeContexts(configs []kodex.Config) ([]*ConfigContext, error) {
 	return contexts, nil
 }
 
-func (d *LocalStreamExecutor) stop(graceful bool) error {
-
-	if d.stopping || d.stopped {
-		return nil
-	}
+// Stops the run that closes the given done channel (or the current run if
+// it's nil). A graceful stop processes all payloads in the channel first,
+// a hard stop rejects them. A hard stop also aborts a graceful stop that is
+// in progress.
+func (d *LocalStreamExecutor) stop(done chan struct{}, graceful bool) error {
 
 	d.mutex.Lock()
 
-	stream := d.stream
-	supervisor := d.supervisor
-
-	defer func() {
+	if d.stopped || (done != nil && done != d.done) {
+		d.mutex.Unlock()
+		return nil
+	}
 
-		d.stream = nil
-		d.contexts = nil
-		d.channel = nil
-		d.stopped = true
-		d.stopping = false
-		d.supervisor = nil
+	done = d.done
 
-		if supervisor != nil {
-			supervisor.ExecutorStopped(d, stream)
+	if !graceful {
+		d.abort()
+		// we reject all payloads that we haven't read yet so that their
+		// sources can redeliver them
+		if err := d.channel.Purge(); err != nil {
+			kodex.Log.Error(err)
 		}
+	}
 
+	if d.stopping {
+		// another goroutine is already stopping the executor
 		d.mutex.Unlock()
-	}()
+		<-done
+		return nil
+	}
 
 	d.stopping = true
 
-	d.stopChannel <- true
-	<-d.stopChannel
+	stream := d.stream
+	supervisor := d.supervisor
+
+	d.mutex.Unlock()
+
+	// first we stop reading more payloads...
+	d.cancel()
+	<-d.readDone
 
 	itemsProcessed := 0
 
@@ -213,7 +261,10 @@ func (d *LocalStreamExecutor) stop(graceful bool) error {
 		// to ensure it will be processed as the last payload
 		if d.endOfStream && i == len(d.workers)-1 {
 			endOfStreamPayload := kodex.MakeBasicPayload([]*kodex.Item{}, map[string]interface{}{}, true)
-			workerChannel := <-d.pool
+			workerChannel := worker.payloadChannel
+			if d.pool != nil {
+				workerChannel = <-d.pool
+			}
 			workerChannel <- endOfStreamPayload
 		}
 		worker.Stop()
@@ -248,76 +299,127 @@ func (d *LocalStreamExecutor) stop(graceful bool) error {
 		}
 	}
 
+	d.mutex.Lock()
+	d.abort()
+	d.stream = nil
+	d.contexts = nil
+	d.channel = nil
+	d.stopped = true
+	d.stopping = false
+	d.supervisor = nil
+	close(done)
+	d.mutex.Unlock()
+
+	if supervisor != nil {
+		supervisor.ExecutorStopped(d, stream)
+	}
+
 	return nil
 
 }
 
-func (d *LocalStreamExecutor) read() {
+// Reads payloads from the channel until the context is cancelled and the
+// channel is empty, or until the abort context is cancelled.
+func (d *LocalStreamExecutor) read(ctx, abortCtx context.Context, readDone, done chan struct{}) {
 
-	stopping := false
+	defer close(readDone)
 
-	stop := func() {
-		if !stopping {
-			stopping = true
-			go d.stop(true)
-		}
-	}
+	labels := []string{d.stream.Project().Name(), d.stream.Name()}
+	streamItems := metrics.StreamItems.WithLabelValues(labels...)
+	queueDepth := metrics.QueueDepth.WithLabelValues(labels[0], "stream", labels[1])
+
+	// we only report the queue depth while we read from the channel
+	defer metrics.QueueDepth.DeleteLabelValues(labels[0], "stream", labels[1])
 
 	// we generate an empty payload that we send to one of the processors, which triggers
 	// e.g. the 'Advance()' method for stateful actions...
 	payload := kodex.MakeBasicPayload([]*kodex.Item{}, map[string]interface{}{}, false)
 
-	workerChannel := <-d.pool
-	workerChannel <- payload
+	if !d.dispatch(abortCtx, payload) {
+		return
+	}
 
 	itemsProcessed := 0
+	stopping := false
+
+	watcher := watchChannel(d.channel)
+	defer watcher.stop()
 
 	for {
-		var payload kodex.Payload
-		var err error
 
 		select {
-		case <-d.stopChannel:
+		case <-abortCtx.Done():
+			return
+		case <-ctx.Done():
 			stopping = true
-		case <-time.After(time.Millisecond):
-			break
+		default:
 		}
 
-		if payload, err = d.channel.Read(); err != nil {
+		payload, err := d.channel.Read()
+
+		if err != nil {
 			kodex.Log.Error(err)
-			stop()
-			continue
+			go d.stop(done, true)
+			return
 		}
 
+		updateQueueDepth(queueDepth, d.channel)
+
 		// we didn't receive any new items...
 		if payload == nil {
 			if stopping {
-				d.stopChannel <- true
 				kodex.Log.Debugf("%d items processed in stream", itemsProcessed)
 				return
 			}
+			watcher.wait(ctx, abortCtx)
 			continue
 		}
 
 		itemsProcessed += len(payload.Items())
+		atomic.AddInt64(&d.itemsProcessed, int64(len(payload.Items())))
+		streamItems.Add(float64(len(payload.Items())))
 
 		if payload.EndOfStream() {
 			// we replace the "end of stream payload" and instead send a replacement
 			// payload during the stop process to ensure that it will be processed last
-			replacedPayload := kodex.MakeBasicPayload(payload.Items(), payload.Headers(), false)
-			workerChannel := <-d.pool
-			workerChannel <- replacedPayload
+			if !d.dispatch(abortCtx, &continuedPayload{payload}) {
+				return
+			}
 			d.mutex.Lock()
 			d.endOfStream = true
 			d.mutex.Unlock()
-		} else {
-			workerChannel := <-d.pool
-			workerChannel <- payload
+			// we process the remaining payloads and stop
+			stopping = true
+			go d.stop(done, true)
+			continue
 		}
 
-		if payload.EndOfStream() {
-			stop()
-			continue
+		if !d.dispatch(abortCtx, payload) {
+			return
+		}
+	}
+}
+
+// Hands the payload over to the workers. Returns false if we were aborted
+// before, in which case the payload is rejected.
+func (d *LocalStreamExecutor) dispatch(abortCtx context.Context, payload kodex.Payload) bool {
+
+	if d.pool == nil {
+		channels := make([]chan kodex.Payload, len(d.workers))
+		for i, worker := range d.workers {
+			channels[i] = worker.payloadChannel
+		}
+		return dispatch(abortCtx, payload, d.partitionKey, channels) == nil
+	}
+
+	select {
+	case workerChannel := <-d.pool:
+		workerChannel <- payload
+		return true
+	case <-abortCtx.Done():
+		if err := payload.Reject(); err != nil {
+			kodex.Log.Error(err)
 		}
+		return false
 	}
 }
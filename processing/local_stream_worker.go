@@ -19,7 +19,6 @@ package processing
 import (
 	"github.com/kiprotect/kodex"
 	"sync"
-	"time"
 )
 
 type ConfigContext struct {
@@ -49,7 +48,7 @@ func MakeLocalStreamWorker(pool chan chan kodex.Payload,
 	return &LocalStreamWorker{
 		pool:              pool,
 		acknowledgeFailed: acknowledgeFailed,
-		payloadChannel:    make(chan kodex.Payload),
+		payloadChannel:    make(chan kodex.Payload, 100),
 		stop:              make(chan bool),
 		contexts:          contexts,
 		started:           false,
@@ -60,49 +59,11 @@ func MakeLocalStreamWorker(pool chan chan kodex.Payload,
 func (w *LocalStreamWorker) Start() {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
-	stop := false
 	w.started = true
-	go func() {
-		w.pool <- w.payloadChannel
-		for {
-			select {
-			case payload := <-w.payloadChannel:
-				w.ItemsProcessed += len(payload.Items())
-				w.ProcessPayload(payload)
-				w.pool <- w.payloadChannel
-				break
-			case <-w.stop:
-				stop = true
-			case <-time.After(time.Millisecond):
-				if stop && len(w.payloadChannel) == 0 {
-					// we remove the worker channel from the pool of channels
-					channels := make([]chan kodex.Payload, 0)
-				loop:
-					for {
-						select {
-						case wc := <-w.pool:
-							if wc != w.payloadChannel {
-								channels = append(channels, wc)
-							} else {
-								// we have found the channel, we break
-								break loop
-							}
-						default: // no more worker channels
-							break loop
-						}
-					}
-					// we resubmit the other channels
-					for _, channel := range channels {
-						w.pool <- channel
-					}
-					// we close the payload channel
-					close(w.payloadChannel)
-					w.stop <- true
-					return
-				}
-			}
-		}
-	}()
+	go runWorker(w.pool, w.payloadChannel, w.stop, func(payload kodex.Payload) {
+		w.ItemsProcessed += len(payload.Items())
+		w.ProcessPayload(payload)
+	})
 }
 
 func (w *LocalStreamWorker) Stop() {
@@ -126,18 +87,33 @@ func (w *LocalStreamWorker) Stop() {
 
 func (w *LocalStreamWorker) ProcessPayload(payload kodex.Payload) error {
 
+	// the payload is acknowledged once all destinations have acknowledged
+	// the payloads we derive from it
+	delivery := kodex.MakeDelivery(payload)
+
 	handleError := func(err error) error {
 		kodex.Log.Error(err)
 		if w.acknowledgeFailed {
 			kodex.Log.Warning("Acknowledging failed payload...")
-			payload.Acknowledge()
+			delivery.Acknowledge()
 		} else {
 			kodex.Log.Warning("Rejecting failed payload...")
-			payload.Reject()
+			delivery.Reject()
 		}
 		return err
 	}
 
+	// payloads that we could not hand over to a destination are released
+	// right away, as we either reject or acknowledge the payload anyway
+	write := func(writer kodex.Writer, items []*kodex.Item) error {
+		derivedPayload := delivery.Derive(items, payload.Headers(), payload.EndOfStream())
+		if err := writer.Write(derivedPayload); err != nil {
+			derivedPayload.Acknowledge()
+			return err
+		}
+		return nil
+	}
+
 	var items, newItems []*kodex.Item
 	var err error
 
@@ -176,7 +152,7 @@ func (w *LocalStreamWorker) ProcessPayload(payload kodex.Payload) error {
 
 					// we always announce the end of the stream to the destination writer...
 					if payload.EndOfStream() {
-						if err := writer.Write(kodex.MakeBasicPayload([]*kodex.Item{}, payload.Headers(), payload.EndOfStream())); err != nil {
+						if err := write(writer, []*kodex.Item{}); err != nil {
 							kodex.Log.Error("error writing end of stream message...")
 							return handleError(err)
 						}
@@ -185,7 +161,7 @@ func (w *LocalStreamWorker) ProcessPayload(payload kodex.Payload) error {
 					continue
 				}
 
-				if err := writer.Write(kodex.MakeBasicPayload(newItems, payload.Headers(), payload.EndOfStream())); err != nil {
+				if err := write(writer, newItems); err != nil {
 					kodex.Log.Error("error writing items...")
 					return handleError(err)
 				}
@@ -196,8 +172,6 @@ func (w *LocalStreamWorker) ProcessPayload(payload kodex.Payload) error {
 
 	}
 
-	payload.Acknowledge()
-
-	return nil
+	return delivery.Acknowledge()
 
 }
@@ -0,0 +1,141 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"github.com/kiprotect/kodex"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"github.com/kiprotect/kodex/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+)
+
+func TestProcessingMetrics(t *testing.T) {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "metrics"}, "project"},
+		pt.FC{&pf.Stream{Name: "metrics", Project: "project"}, "stream"},
+		pt.FC{&pf.Config{Name: "metrics", Stream: "stream", Status: kodex.ActiveConfig}, "config"},
+		pt.FC{&pf.ActionConfig{Name: "pseudonymize", Project: "project", Type: "pseudonymize", Config: map[string]interface{}{
+			"key":    "foo",
+			"method": "merengue",
+			"type":   "pseudonymize",
+		}}, "actionConfig"},
+		pt.FC{&pf.ActionMap{Action: "actionConfig", Config: "config", Index: 0}, "actionMap"},
+		pt.FC{&pf.Destination{Name: "metrics", Project: "project", DestinationType: "in-memory", Config: map[string]interface{}{}}, "destination"},
+		pt.FC{&pf.DestinationAdder{Destination: "destination", Config: "config", Status: "active", Name: "metrics"}, "destinationAdder"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := fixtures["controller"].(kodex.Controller)
+	config := fixtures["config"].(kodex.Config)
+
+	contexts, err := makeContexts([]kodex.Config{config})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worker, err := MakeLocalStreamWorker(nil, contexts, false, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := []*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"foo": "bar"}),
+		kodex.MakeItem(map[string]interface{}{"foo": "baz"}),
+	}
+
+	if err := worker.ProcessPayload(kodex.MakeBasicPayload(items, map[string]interface{}{}, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testutil.ToFloat64(metrics.ConfigItems.WithLabelValues("metrics", "metrics", "metrics")); n != 2 {
+		t.Fatalf("Expected 2 config items, got %v", n)
+	}
+
+	if n := testutil.ToFloat64(metrics.ActionItems.WithLabelValues("metrics", "metrics", "metrics", "pseudonymize")); n != 2 {
+		t.Fatalf("Expected 2 action items, got %v", n)
+	}
+
+	if n := actionDurations(t, "metrics", "pseudonymize"); n != 2 {
+		t.Fatalf("Expected 2 action durations, got %d", n)
+	}
+
+	destinations, err := config.Destinations()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channel := kodex.MakeInternalChannel()
+
+	if err := channel.Setup(controller, destinations["metrics"][0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if queued, ok := channel.Queued(); !ok || queued != 1 {
+		t.Fatalf("Expected one queued payload, got %d", queued)
+	}
+
+	gauge := metrics.QueueDepth.WithLabelValues("metrics", "destination", "metrics")
+	defer metrics.QueueDepth.DeleteLabelValues("metrics", "destination", "metrics")
+
+	updateQueueDepth(gauge, channel)
+
+	if n := testutil.ToFloat64(gauge); n != 1 {
+		t.Fatalf("Expected a queue depth of 1, got %v", n)
+	}
+}
+
+// Returns the number of durations observed for the given action.
+func actionDurations(t *testing.T, project, action string) uint64 {
+	t.Helper()
+
+	families, err := metrics.Registry.Gather()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "kodex_action_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["project"] == project && labels["action"] == action {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+
+	return 0
+}
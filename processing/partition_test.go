@@ -0,0 +1,157 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"context"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"testing"
+	"time"
+)
+
+func TestPartition(t *testing.T) {
+
+	items := make([]*kodex.Item, 0)
+
+	for i := 0; i < 100; i++ {
+		items = append(items, kodex.MakeItem(map[string]interface{}{"key": fmt.Sprintf("k%d", i%10), "i": i}))
+	}
+
+	// items without the key go to the first partition
+	items = append(items, kodex.MakeItem(map[string]interface{}{"i": 100}))
+
+	partitions := partition(items, "key", 4)
+
+	if len(partitions) != 4 {
+		t.Fatalf("Expected 4 partitions, got %d", len(partitions))
+	}
+
+	found := false
+	keys := map[interface{}]int{}
+	n := 0
+
+	for i, partitionItems := range partitions {
+		last := -1
+		for _, item := range partitionItems {
+			n++
+			key, ok := item.Get("key")
+			if !ok {
+				found = i == 0
+				continue
+			}
+			if j, ok := keys[key]; ok && j != i {
+				t.Fatalf("Key %v is in partitions %d and %d", key, i, j)
+			}
+			keys[key] = i
+			// items keep their order
+			if value, _ := item.Get("i"); value.(int) < last {
+				t.Fatalf("Items are out of order")
+			} else {
+				last = value.(int)
+			}
+		}
+	}
+
+	if n != len(items) || !found {
+		t.Fatalf("Expected all items in the partitions")
+	}
+}
+
+func TestStreamExecutorPartitions(t *testing.T) {
+
+	pipeline := makeTestPipeline(t)
+
+	if err := pipeline.model.SetWorkers(4); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pipeline.model.SetPartitionKey("key"); err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := make([]*testPayload, 0)
+
+	for i := 0; i < 20; i++ {
+		items := make([]*kodex.Item, 0)
+		for j := 0; j < 10; j++ {
+			items = append(items, kodex.MakeItem(map[string]interface{}{"key": int64(j % 5), "i": int64(i*10 + j)}))
+		}
+		payload := &testPayload{BasicPayload: kodex.MakeBasicPayload(items, map[string]interface{}{}, false)}
+		if err := pipeline.source.ProcessPayload(payload); err != nil {
+			t.Fatal(err)
+		}
+		payloads = append(payloads, payload)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	executor := MakeLocalStreamExecutor(1, []byte("test"))
+
+	if err := executor.Start(ctx, nil, pipeline.model); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(executor.workers) != 4 {
+		t.Fatalf("Expected 4 workers, got %d", len(executor.workers))
+	}
+
+	cancel()
+
+	select {
+	case <-executor.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Executor did not stop")
+	}
+
+	for _, channel := range pipeline.destinationChannels {
+
+		last := map[interface{}]int64{}
+		n := 0
+
+		for {
+			payload, err := channel.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payload == nil {
+				break
+			}
+			for _, item := range payload.Items() {
+				key, _ := item.Get("key")
+				i, _ := item.Get("i")
+				if previous, ok := last[key]; ok && i.(int64) < previous {
+					t.Fatalf("Items with key %v are out of order", key)
+				}
+				last[key] = i.(int64)
+				n++
+			}
+			if err := payload.Acknowledge(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if n != 200 {
+			t.Fatalf("Expected 200 items, got %d", n)
+		}
+	}
+
+	// the payloads are acknowledged once all partitions were written
+	for _, payload := range payloads {
+		payload.check(t, 1, 0)
+	}
+}
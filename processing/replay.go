@@ -0,0 +1,189 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/kiprotect/kodex"
+)
+
+// Reads all dead letters from the given reader, which should already be set
+// up (e.g. a file reader for the output of a dead-letter destination).
+func ReadDeadLetters(reader kodex.Reader) ([]*kodex.DeadLetter, error) {
+
+	deadLetters := make([]*kodex.DeadLetter, 0)
+
+	for {
+		payload, err := reader.Read()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if payload == nil {
+			return deadLetters, nil
+		}
+
+		for _, item := range payload.Items() {
+			if deadLetter, err := kodex.ParseDeadLetter(item); err != nil {
+				return nil, err
+			} else {
+				deadLetters = append(deadLetters, deadLetter)
+			}
+		}
+
+		if err := payload.Acknowledge(); err != nil {
+			return nil, err
+		}
+
+		if payload.EndOfStream() {
+			return deadLetters, nil
+		}
+	}
+}
+
+// Pushes dead letters back through the current version of the configs that
+// produced them and writes the resulting items to the active destinations of
+// the configs. Items that fail again end up in the dead-letter destinations
+// again. Returns the number of items that were written.
+func Replay(stream kodex.Stream, deadLetters []*kodex.DeadLetter) (int, error) {
+
+	configs, err := stream.Configs()
+
+	if err != nil {
+		return 0, err
+	}
+
+	itemsByConfig := make([][]*kodex.Item, len(configs))
+
+	// we first make sure that we have a config for every dead letter
+	for _, deadLetter := range deadLetters {
+
+		found := false
+
+		// we prefer the config ID but fall back to the name, as IDs change
+		// e.g. when configs are recreated from a blueprint
+		for _, byName := range []bool{false, true} {
+			for i, config := range configs {
+				if (!byName && deadLetter.ConfigID == hex.EncodeToString(config.ID())) || (byName && deadLetter.Config == config.Name()) {
+					itemsByConfig[i] = append(itemsByConfig[i], kodex.MakeItem(deadLetter.Item))
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+
+		if !found {
+			return 0, fmt.Errorf("config '%s' not found in stream '%s'", deadLetter.Config, stream.Name())
+		}
+	}
+
+	written := 0
+
+	for i, config := range configs {
+
+		if len(itemsByConfig[i]) == 0 {
+			continue
+		}
+
+		kodex.Log.Infof("Replaying %d items through config '%s' (version %s)...", len(itemsByConfig[i]), config.Name(), config.Version())
+
+		n, err := replayConfig(config, itemsByConfig[i])
+
+		written += n
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+func replayConfig(config kodex.Config, items []*kodex.Item) (int, error) {
+
+	processor, err := config.Processor(false)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err := processor.Setup(); err != nil {
+		return 0, err
+	}
+
+	defer processor.Teardown()
+
+	newItems, err := processor.Process(items, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	finalizedItems, err := processor.Finalize()
+
+	if err != nil {
+		return 0, err
+	}
+
+	newItems = append(newItems, finalizedItems...)
+
+	if len(newItems) == 0 {
+		return 0, nil
+	}
+
+	destinations, err := config.Destinations()
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, destinationMaps := range destinations {
+		for _, destinationMap := range destinationMaps {
+
+			if destinationMap.Status() != kodex.ActiveDestination {
+				continue
+			}
+
+			writer, err := destinationMap.Destination().Writer()
+
+			if err != nil {
+				return 0, err
+			}
+
+			if err := writer.Setup(config); err != nil {
+				return 0, err
+			}
+
+			err = writer.Write(kodex.MakeBasicPayload(newItems, map[string]interface{}{}, false))
+
+			if teardownErr := writer.Teardown(); err == nil {
+				err = teardownErr
+			}
+
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return len(newItems), nil
+}
@@ -0,0 +1,171 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"github.com/kiprotect/kodex"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"github.com/kiprotect/kodex/readers"
+	"path/filepath"
+	"testing"
+)
+
+func makeTestFileReader(t *testing.T, path string) kodex.Reader {
+
+	reader, err := readers.MakeFileReader(map[string]interface{}{
+		"path":   path,
+		"format": "json",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { reader.Teardown() })
+
+	return reader
+}
+
+func readTestItems(t *testing.T, path string) []*kodex.Item {
+
+	reader := makeTestFileReader(t, path)
+	items := make([]*kodex.Item, 0)
+
+	for {
+		payload, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload == nil {
+			return items
+		}
+		items = append(items, payload.Items()...)
+		if payload.EndOfStream() {
+			return items
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+
+	path := t.TempDir()
+
+	destinationConfig := func(name string) map[string]interface{} {
+		return map[string]interface{}{
+			"path":      path,
+			"base-name": name,
+			"format":    "json",
+		}
+	}
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Stream{Name: "test", Project: "project"}, "stream"},
+		pt.FC{&pf.Config{Name: "test", Stream: "stream"}, "config"},
+		pt.FC{&pf.ActionConfig{Name: "pseudonymize", Project: "project", Type: "pseudonymize", Config: map[string]interface{}{
+			"key":    "foo",
+			"method": "merengue",
+			"type":   "pseudonymize",
+		}}, "actionConfig"},
+		pt.FC{&pf.ActionMap{Action: "actionConfig", Config: "config", Index: 0}, "actionMap"},
+		pt.FC{&pf.Destination{Name: "items", Project: "project", DestinationType: "file", Config: destinationConfig("items")}, "items"},
+		pt.FC{&pf.Destination{Name: "dead-letters", Project: "project", DestinationType: "file", Config: destinationConfig("dead-letters")}, "deadLetters"},
+		pt.FC{&pf.DestinationAdder{Destination: "items", Config: "config", Status: "active", Name: "items"}, "itemsAdder"},
+		pt.FC{&pf.DestinationAdder{Destination: "deadLetters", Config: "config", Status: "dead-letter", Name: "dead-letters"}, "deadLettersAdder"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := fixtures["stream"].(kodex.Stream)
+	config := fixtures["config"].(kodex.Config)
+
+	processor, err := config.Processor(false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second item can't be pseudonymized
+	newItems, err := processor.Process([]*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"foo": "bar"}),
+		kodex.MakeItem(map[string]interface{}{"bar": "baz"}),
+	}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(newItems) != 1 {
+		t.Fatalf("Expected one processed item, got %d", len(newItems))
+	}
+
+	deadLetters, err := ReadDeadLetters(makeTestFileReader(t, filepath.Join(path, "dead-letters.json")))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deadLetters) != 1 {
+		t.Fatalf("Expected one dead letter, got %d", len(deadLetters))
+	}
+
+	deadLetter := deadLetters[0]
+
+	if deadLetter.Item["bar"] != "baz" || deadLetter.Action != "pseudonymize" || deadLetter.Code != "PROCESS-ACTION" || deadLetter.Config != "test" || deadLetter.Timestamp.IsZero() {
+		t.Fatalf("Unexpected dead letter: %+v", deadLetter)
+	}
+
+	// we fix the config by removing the failing action
+	if err := config.RemoveActionConfig(fixtures["actionConfig"].(kodex.ActionConfig)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := Replay(stream, deadLetters); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("Expected one replayed item, got %d", n)
+	}
+
+	items := readTestItems(t, filepath.Join(path, "items.json"))
+
+	if len(items) != 1 {
+		t.Fatalf("Expected one item, got %d", len(items))
+	}
+
+	if value, _ := items[0].Get("bar"); value != "baz" {
+		t.Fatalf("Unexpected item: %v", items[0].All())
+	}
+
+	// dead letters for unknown configs are rejected
+	deadLetter.Config, deadLetter.ConfigID = "unknown", ""
+
+	if _, err := Replay(stream, deadLetters); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
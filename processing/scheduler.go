@@ -0,0 +1,445 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/kiprotect/kodex"
+	"sync"
+	"time"
+)
+
+// How much weight the throughput of the last ping interval gets when we
+// update the priority of a processable entity
+const priorityWeight = 0.3
+
+type SchedulerOptions struct {
+	// how many streams, sources and destinations we process at the same time
+	MaxStreams      int
+	MaxSources      int
+	MaxDestinations int
+	// the number of workers per stream and destination, unless they
+	// specify their own
+	Workers int
+	// how often we look for new work
+	PollInterval time.Duration
+	// how often we renew our leases and update priorities
+	PingInterval time.Duration
+	// we release entities that didn't process any items for this long
+	IdleTimeout time.Duration
+	// how long executors that we release or stop because the context was
+	// cancelled can process the payloads in flight before we stop them hard,
+	// zero waits indefinitely
+	DrainTimeout time.Duration
+}
+
+type scheduledExecutor struct {
+	executor    Executor
+	processable kodex.Processable
+	// the number of processed items at the last poll and ping
+	lastItems     int64
+	lastPingItems int64
+	lastActive    time.Time
+	lastPing      time.Time
+	idle          time.Duration
+	// set if we stopped the executor ourselves
+	released bool
+}
+
+// The scheduler continuously acquires the most urgent streams, sources and
+// destinations from the controller and processes them with local executors.
+// It renews its leases by sending pings with processing statistics, updates
+// priorities based on the observed throughput and releases entities that
+// are idle, so that many projects can share a single node.
+type Scheduler struct {
+	controller  kodex.Controller
+	options     SchedulerOptions
+	processorID []byte
+	executors   map[string]*scheduledExecutor
+	// entities that finished processing (e.g. reached the end of their stream)
+	finished map[string]bool
+	mutex    sync.Mutex
+	// the context we were started with, which we pass on to the executors
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func MakeScheduler(controller kodex.Controller, options SchedulerOptions) *Scheduler {
+	return &Scheduler{
+		controller:  controller,
+		options:     options,
+		processorID: kodex.RandomID(),
+		executors:   make(map[string]*scheduledExecutor),
+		finished:    make(map[string]bool),
+	}
+}
+
+func key(processable kodex.Processable) string {
+	return processable.Type() + ":" + hex.EncodeToString(processable.ID())
+}
+
+func (s *Scheduler) ProcessorID() []byte {
+	return s.processorID
+}
+
+// Starts scheduling work until the context is cancelled or the scheduler is
+// stopped. If the context is cancelled we stop all executors just like Stop
+// does, using the drain timeout from the options.
+func (s *Scheduler) Start(ctx context.Context) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cancel != nil {
+		return
+	}
+
+	s.ctx = ctx
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(1)
+
+	go s.run(ctx)
+}
+
+// Stops the scheduler and all executors, releasing all entities. We first
+// stop the sources so that the streams and destinations can process the
+// remaining payloads. Executors that didn't stop within the timeout are
+// stopped hard, a zero timeout waits indefinitely.
+func (s *Scheduler) Stop(timeout time.Duration) {
+
+	s.mutex.Lock()
+
+	if s.cancel == nil {
+		s.mutex.Unlock()
+		return
+	}
+
+	s.cancel()
+	s.cancel = nil
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+
+	s.stopAll(timeout)
+}
+
+// Stops all executors in order, see Stop.
+func (s *Scheduler) stopAll(timeout time.Duration) {
+
+	deadline, cancel := drainDeadline(timeout)
+	defer cancel()
+
+	for _, processableType := range []string{"source_map", "stream", "destination_map"} {
+		if !s.stopExecutors(deadline, func(scheduled *scheduledExecutor) bool {
+			return scheduled.processable.Type() == processableType
+		}) {
+			kodex.Log.Warningf("Executors did not stop within %v, unprocessed payloads were rejected", timeout)
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context) {
+
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.poll()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			// if we weren't stopped via Stop, the context we were started
+			// with was cancelled, so we stop the executors ourselves
+			if s.context().Err() != nil {
+				s.stopAll(s.options.DrainTimeout)
+			}
+			return
+		}
+	}
+}
+
+func (s *Scheduler) context() context.Context {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.ctx
+}
+
+// Returns a context that is done after the given timeout, or never if the
+// timeout is zero.
+func drainDeadline(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// Returns the number of entities of the given type that we process.
+func (s *Scheduler) Running(processableType string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.running(processableType)
+}
+
+func (s *Scheduler) poll() {
+
+	now := time.Now()
+
+	s.mutex.Lock()
+
+	for _, scheduled := range s.executors {
+
+		items := s.itemsProcessed(scheduled)
+
+		if items != scheduled.lastItems {
+			scheduled.lastItems = items
+			scheduled.lastActive = now
+		} else {
+			scheduled.idle += s.options.PollInterval
+		}
+
+		if now.Sub(scheduled.lastPing) >= s.options.PingInterval {
+			s.ping(scheduled, now)
+		}
+	}
+
+	s.mutex.Unlock()
+
+	deadline, cancel := drainDeadline(s.options.DrainTimeout)
+	defer cancel()
+
+	// we release entities that are idle so that others get a chance
+	if !s.stopExecutors(deadline, func(scheduled *scheduledExecutor) bool {
+		return s.options.IdleTimeout > 0 && now.Sub(scheduled.lastActive) >= s.options.IdleTimeout
+	}) {
+		kodex.Log.Warningf("Idle executors did not stop within %v, unprocessed payloads were rejected", s.options.DrainTimeout)
+	}
+
+	s.schedule()
+}
+
+func (s *Scheduler) itemsProcessed(scheduled *scheduledExecutor) int64 {
+	if counter, ok := scheduled.executor.(CountingExecutor); ok {
+		return counter.ItemsProcessed()
+	}
+	return 0
+}
+
+// Renews the lease of an entity and updates its priority based on its
+// throughput since the last ping.
+func (s *Scheduler) ping(scheduled *scheduledExecutor, now time.Time) {
+
+	duration := now.Sub(scheduled.lastPing)
+
+	if duration <= 0 {
+		return
+	}
+
+	items := scheduled.lastItems - scheduled.lastPingItems
+
+	idleFraction := float64(scheduled.idle) / float64(duration)
+
+	if idleFraction > 1 {
+		idleFraction = 1
+	}
+
+	stats := kodex.ProcessingStats{
+		From:           scheduled.lastPing,
+		To:             now,
+		IdleFraction:   idleFraction,
+		ItemsProcessed: items,
+	}
+
+	if err := s.controller.Ping(scheduled.processable, stats); err != nil {
+		kodex.Log.Errorf("Cannot renew lease for %s: %v", key(scheduled.processable), err)
+	}
+
+	if priorityModel, ok := scheduled.processable.(kodex.PriorityModel); ok {
+		throughput := float64(items) / duration.Seconds()
+		priority := (1-priorityWeight)*priorityModel.Priority() + priorityWeight*throughput
+		if err := priorityModel.SetPriorityAndTime(priority, now); err != nil {
+			kodex.Log.Error(err)
+		}
+	}
+
+	scheduled.lastPing = now
+	scheduled.lastPingItems = scheduled.lastItems
+	scheduled.idle = 0
+}
+
+// Stops all executors for which the filter returns true, see drain.
+func (s *Scheduler) stopExecutors(deadline context.Context, filter func(*scheduledExecutor) bool) bool {
+
+	s.mutex.Lock()
+
+	executors := make([]Executor, 0)
+
+	for _, scheduled := range s.executors {
+		if !scheduled.released && filter(scheduled) {
+			kodex.Log.Debugf("Releasing %s", key(scheduled.processable))
+			scheduled.released = true
+			executors = append(executors, scheduled.executor)
+		}
+	}
+
+	s.mutex.Unlock()
+
+	// executors notify us when they stopped, so we can't hold the lock here
+	return drain(deadline, executors)
+}
+
+func (s *Scheduler) schedule() {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// we ask for more entities than we have capacity for, as some of them
+	// might have finished already
+	if n := s.options.MaxSources - s.running("source_map"); n > 0 {
+		if sources, err := s.controller.SourcesByUrgency(n + len(s.finished)); err != nil {
+			kodex.Log.Error(err)
+		} else {
+			for _, source := range sources {
+				// we read sources with a single worker, as more workers would
+				// reorder the payloads before they reach the stream
+				if n > 0 && s.acquire(source, MakeLocalSourceReader(1, s.processorID)) {
+					n--
+				}
+			}
+		}
+	}
+
+	if n := s.options.MaxStreams - s.running("stream"); n > 0 {
+		if streams, err := s.controller.StreamsByUrgency(n + len(s.finished)); err != nil {
+			kodex.Log.Error(err)
+		} else {
+			for _, stream := range streams {
+				if n > 0 && s.acquire(stream, MakeLocalStreamExecutor(s.options.Workers, s.processorID)) {
+					n--
+				}
+			}
+		}
+	}
+
+	if n := s.options.MaxDestinations - s.running("destination_map"); n > 0 {
+		if destinations, err := s.controller.DestinationsByUrgency(n + len(s.finished)); err != nil {
+			kodex.Log.Error(err)
+		} else {
+			for _, destination := range destinations {
+				if n > 0 && s.acquire(destination, MakeLocalDestinationWriter(s.options.Workers, s.processorID)) {
+					n--
+				}
+			}
+		}
+	}
+}
+
+func (s *Scheduler) running(processableType string) int {
+	n := 0
+	for _, scheduled := range s.executors {
+		if scheduled.processable.Type() == processableType {
+			n++
+		}
+	}
+	return n
+}
+
+// Acquires an entity and starts the executor for it. Returns true if we
+// started processing the entity.
+func (s *Scheduler) acquire(processable kodex.Processable, executor Executor) bool {
+
+	k := key(processable)
+
+	if _, ok := s.executors[k]; ok || s.finished[k] {
+		return false
+	}
+
+	if ok, err := s.controller.Acquire(processable, s.processorID); err != nil {
+		kodex.Log.Error(err)
+		return false
+	} else if !ok {
+		// another processor got it first
+		return false
+	}
+
+	now := time.Now()
+
+	s.executors[k] = &scheduledExecutor{
+		executor:    executor,
+		processable: processable,
+		lastActive:  now,
+		lastPing:    now,
+	}
+
+	kodex.Log.Debugf("Acquired %s", k)
+
+	if err := executor.Start(s.executorContext(processable), s, processable); err != nil {
+		kodex.Log.Errorf("Cannot start processing %s: %v", k, err)
+		delete(s.executors, k)
+		// we don't try again
+		s.finished[k] = true
+		if _, err := s.controller.Release(processable, s.processorID); err != nil {
+			kodex.Log.Error(err)
+		}
+		return false
+	}
+
+	return true
+}
+
+// Sources stop reading when the context we were started with is cancelled.
+// Streams and destinations get the same context without the cancellation,
+// as we stop them ourselves after the sources so that they can process the
+// payloads that are still in flight.
+func (s *Scheduler) executorContext(processable kodex.Processable) context.Context {
+	if processable.Type() == "source_map" {
+		return s.ctx
+	}
+	return context.WithoutCancel(s.ctx)
+}
+
+// Called by executors when they stopped, either because we stopped them or
+// because they finished processing.
+func (s *Scheduler) ExecutorStopped(executor Executor, processable kodex.Processable) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	k := key(processable)
+
+	scheduled, ok := s.executors[k]
+
+	if !ok || scheduled.executor != executor {
+		return
+	}
+
+	delete(s.executors, k)
+
+	if !scheduled.released {
+		// the executor stopped by itself, e.g. because the source has no
+		// more data, so we don't process the entity again
+		s.finished[k] = true
+	}
+
+	if _, err := s.controller.Release(processable, s.processorID); err != nil {
+		kodex.Log.Error(err)
+	}
+}
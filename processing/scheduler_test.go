@@ -0,0 +1,239 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/kiprotect/kodex"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Counts the calls the scheduler makes to the controller.
+type countingController struct {
+	kodex.Controller
+	mutex    sync.Mutex
+	acquired map[string]int
+	released map[string]int
+	pings    map[string]int
+}
+
+func (c *countingController) count(counts map[string]int, processable kodex.Processable) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	counts[processable.Type()]++
+}
+
+func (c *countingController) get(counts map[string]int, processableType string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return counts[processableType]
+}
+
+func (c *countingController) Acquire(processable kodex.Processable, processorID []byte) (bool, error) {
+	ok, err := c.Controller.Acquire(processable, processorID)
+	if ok {
+		c.count(c.acquired, processable)
+	}
+	return ok, err
+}
+
+func (c *countingController) Release(processable kodex.Processable, processorID []byte) (bool, error) {
+	c.count(c.released, processable)
+	return c.Controller.Release(processable, processorID)
+}
+
+func (c *countingController) Ping(processable kodex.Processable, stats kodex.ProcessingStats) error {
+	c.count(c.pings, processable)
+	return c.Controller.Ping(processable, stats)
+}
+
+func makeTestScheduler(t *testing.T, ctx context.Context, fixtureConfig []pt.FC, options SchedulerOptions) (*Scheduler, *countingController, map[string]interface{}) {
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	t.Cleanup(func() { pt.TeardownFixtures(fixtureConfig, fixtures) })
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := &countingController{
+		Controller: fixtures["controller"].(kodex.Controller),
+		acquired:   make(map[string]int),
+		released:   make(map[string]int),
+		pings:      make(map[string]int),
+	}
+
+	scheduler := MakeScheduler(controller, options)
+	scheduler.Start(ctx)
+	t.Cleanup(func() { scheduler.Stop(time.Second) })
+
+	return scheduler, controller, fixtures
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
+
+func TestScheduler(t *testing.T) {
+
+	path := t.TempDir()
+
+	input, err := os.Create(filepath.Join(path, "input.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(input)
+
+	for i := 0; i < 10; i++ {
+		encoder.Encode(map[string]interface{}{"i": i})
+	}
+
+	input.Close()
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Stream{Name: "test", Project: "project"}, "stream"},
+		pt.FC{&pf.Config{Name: "test", Stream: "stream", Status: kodex.ActiveConfig}, "config"},
+		pt.FC{&pf.Source{Name: "input", Project: "project", SourceType: "file", Config: map[string]interface{}{
+			"path":   filepath.Join(path, "input.json"),
+			"format": "json",
+		}}, "source"},
+		pt.FC{&pf.SourceAdder{Source: "source", Stream: "stream", Status: "active"}, "sourceAdder"},
+		pt.FC{&pf.Destination{Name: "output", Project: "project", DestinationType: "file", Config: map[string]interface{}{
+			"path":      path,
+			"base-name": "output",
+			"format":    "json",
+		}}, "destination"},
+		pt.FC{&pf.DestinationAdder{Destination: "destination", Config: "config", Status: "active", Name: "output"}, "destinationAdder"},
+	}
+
+	scheduler, controller, _ := makeTestScheduler(t, context.Background(), fixtureConfig, SchedulerOptions{
+		MaxStreams:      1,
+		MaxSources:      1,
+		MaxDestinations: 1,
+		Workers:         1,
+		PollInterval:    5 * time.Millisecond,
+		PingInterval:    5 * time.Millisecond,
+	})
+
+	// all executors stop once they reach the end of the stream
+	waitFor(t, func() bool {
+		for _, processableType := range []string{"source_map", "stream", "destination_map"} {
+			if controller.get(controller.released, processableType) == 0 {
+				return false
+			}
+		}
+		return true
+	})
+
+	time.Sleep(50 * time.Millisecond)
+
+	for _, processableType := range []string{"source_map", "stream", "destination_map"} {
+		// finished entities are not processed again
+		if n := controller.get(controller.acquired, processableType); n != 1 {
+			t.Errorf("Expected %s to be acquired once, got %d", processableType, n)
+		}
+		if n := scheduler.Running(processableType); n != 0 {
+			t.Errorf("Expected no running %s, got %d", processableType, n)
+		}
+	}
+
+	if items := readTestItems(t, filepath.Join(path, "output.json")); len(items) != 10 {
+		t.Fatalf("Expected 10 items, got %d", len(items))
+	}
+}
+
+func TestSchedulerIdle(t *testing.T) {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Stream{Name: "first", Project: "project"}, "first"},
+		pt.FC{&pf.Stream{Name: "second", Project: "project"}, "second"},
+	}
+
+	_, controller, fixtures := makeTestScheduler(t, context.Background(), fixtureConfig, SchedulerOptions{
+		MaxStreams:   1,
+		Workers:      1,
+		PollInterval: 5 * time.Millisecond,
+		PingInterval: 10 * time.Millisecond,
+		IdleTimeout:  20 * time.Millisecond,
+	})
+
+	// idle streams are released, so both streams get processed in turn
+	waitFor(t, func() bool {
+		return controller.get(controller.acquired, "stream") >= 4 && controller.get(controller.pings, "stream") > 0
+	})
+
+	for _, name := range []string{"first", "second"} {
+		stream := fixtures[name].(kodex.Stream)
+		if stream.PriorityTime().IsZero() {
+			t.Errorf("Expected the priority time of stream %s to be set", name)
+		}
+	}
+}
+
+func TestSchedulerContext(t *testing.T) {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Stream{Name: "test", Project: "project"}, "stream"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	scheduler, controller, _ := makeTestScheduler(t, ctx, fixtureConfig, SchedulerOptions{
+		MaxStreams:   1,
+		Workers:      1,
+		PollInterval: 5 * time.Millisecond,
+		PingInterval: 5 * time.Millisecond,
+		DrainTimeout: time.Second,
+	})
+
+	waitFor(t, func() bool {
+		return scheduler.Running("stream") == 1
+	})
+
+	// cancelling the context stops and releases the executors
+	cancel()
+
+	waitFor(t, func() bool {
+		return scheduler.Running("stream") == 0 && controller.get(controller.released, "stream") == 1
+	})
+}
@@ -19,6 +19,9 @@ package kodex
 import (
 	"encoding/hex"
 	"github.com/kiprotect/go-helpers/errors"
+	"github.com/kiprotect/kodex/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
 )
 
 type Processor struct {
@@ -28,6 +31,9 @@ type Processor struct {
 	config        Config
 	key, salt     []byte
 	id            string
+	items         prometheus.Counter
+	actionItems   []prometheus.Counter
+	actionTimes   []prometheus.Observer
 }
 
 func (p *Processor) ParameterSet() *ParameterSet {
@@ -112,9 +118,31 @@ func MakeProcessor(parameterSet *ParameterSet, channelWriter ChannelWriter, conf
 		config:        config,
 		id:            hex.EncodeToString(RandomID()),
 	}
+	processor.setupMetrics()
 	return &processor, nil
 }
 
+// We look up the metrics of the config and its actions once, so that we
+// don't need to do it for every item.
+func (p *Processor) setupMetrics() {
+
+	var project, stream, config string
+
+	if p.config != nil {
+		config = p.config.Name()
+		stream = p.config.Stream().Name()
+		project = p.config.Stream().Project().Name()
+	}
+
+	p.items = metrics.ConfigItems.WithLabelValues(project, stream, config)
+
+	for _, action := range p.parameterSet.Actions() {
+		labels := []string{project, stream, config, action.Name()}
+		p.actionItems = append(p.actionItems, metrics.ActionItems.WithLabelValues(labels...))
+		p.actionTimes = append(p.actionTimes, metrics.ActionDuration.WithLabelValues(labels...))
+	}
+}
+
 func (p *Processor) SetWriter(channelWriter ChannelWriter) {
 	p.channelWriter = channelWriter
 }
@@ -231,8 +259,9 @@ func (p *Processor) processItem(item *Item, paramsMap map[string]interface{}, un
 		return nil, errors.MakeExternalError("error setting action params", "SET-ACTION-PARAMS", nil, err)
 	}
 	newItem := item
-	for _, action := range p.parameterSet.Actions() {
+	for i, action := range p.parameterSet.Actions() {
 		err = nil
+		start := time.Now()
 		if undo {
 			if undoableAction, ok := action.(UndoableAction); ok {
 				// not all actions that have an Undo function are always
@@ -248,6 +277,8 @@ func (p *Processor) processItem(item *Item, paramsMap map[string]interface{}, un
 				newItem, err = doableAction.Do(newItem, p.channelWriter)
 			}
 		}
+		p.actionTimes[i].Observe(time.Since(start).Seconds())
+		p.actionItems[i].Inc()
 		if err != nil {
 			itemError := errors.MakeExternalError("error processing action", "PROCESS-ACTION", action.Name(), err)
 			return nil, itemError
@@ -317,9 +348,22 @@ func (p *Processor) process(items []*Item, paramsMap map[string]interface{}, und
 	} else {
 		newItems = append(newItems, advanceItems...)
 	}
+	deadLetterWriter, _ := p.channelWriter.(DeadLetterWriter)
 	for _, item := range items {
+		var original map[string]interface{}
+		// actions modify items in place, so we keep a (shallow) copy of the
+		// original values in case we need to send the item to the dead-letter
+		// destinations
+		if deadLetterWriter != nil && p.errorPolicy == ReportErrors {
+			original = make(map[string]interface{}, len(item.All()))
+			for key, value := range item.All() {
+				original[key] = value
+			}
+		}
+		p.items.Inc()
 		newItem, err := p.processItem(item, paramsMap, undo)
 		if err != nil {
+			metrics.Errors.WithLabelValues(ErrorCode(err)).Inc()
 			switch p.errorPolicy {
 			case ReportErrors:
 				itemError := errors.MakeExternalError("error processing item", "PROCESS-ITEM", nil, err)
@@ -333,6 +377,11 @@ func (p *Processor) process(items []*Item, paramsMap map[string]interface{}, und
 				if err := p.channelWriter.Error(item, itemError); err != nil {
 					return newItems, err
 				}
+				if original != nil {
+					if err := deadLetterWriter.DeadLetter(MakeDeadLetter(original, err, p.config)); err != nil {
+						return newItems, err
+					}
+				}
 				continue
 			case AbortOnError:
 				return newItems, errors.MakeExternalError("error processing item", "PROCESS-ITEM", map[string]interface{}{"item": item.All()}, err)
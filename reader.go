@@ -17,6 +17,7 @@
 package kodex
 
 import (
+	"context"
 	"fmt"
 	"github.com/kiprotect/go-helpers/forms"
 )
@@ -49,6 +50,21 @@ type PeekingReader interface {
 	Reader
 }
 
+// A reader that can be interrupted while it waits for data, e.g. when the
+// executor reading from it is stopped.
+type ContextReader interface {
+	Reader
+	ReadContext(context.Context) (Payload, error)
+}
+
+// Reads a payload, using the context if the reader supports it.
+func ReadContext(ctx context.Context, reader Reader) (Payload, error) {
+	if contextReader, ok := reader.(ContextReader); ok {
+		return contextReader.ReadContext(ctx)
+	}
+	return reader.Read()
+}
+
 // A reader that is able to write objects for a specific model such as a stream.
 type ModelReader interface {
 	Reader
@@ -19,11 +19,12 @@ package readers
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
+	"context"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/compression"
+	"github.com/kiprotect/kodex/formats"
 	"github.com/kiprotect/kodex/writers"
 	"github.com/streadway/amqp"
 	"io"
@@ -53,11 +54,11 @@ func MakeAMQPReader(config map[string]interface{}) (kodex.Reader, error) {
 
 type AMQPPayload struct {
 	delivery     amqp.Delivery
-	compressed   bool
+	compression  string
 	rejected     bool
 	acknowledged bool
 	endOfStream  bool
-	format       string
+	format       kodex.Format
 	items        []*kodex.Item
 	headers      map[string]interface{}
 }
@@ -123,6 +124,12 @@ func (a *AMQPReader) MakeAMQPPayload(delivery amqp.Delivery) (*AMQPPayload, erro
 		}
 	}
 
+	format, err := a.ItemFormat()
+
+	if err != nil {
+		return nil, err
+	}
+
 	var endOfStream bool
 
 	if eos, ok := delivery.Headers["endOfStream"]; ok {
@@ -133,8 +140,8 @@ func (a *AMQPReader) MakeAMQPPayload(delivery amqp.Delivery) (*AMQPPayload, erro
 
 	payload := AMQPPayload{
 		delivery:    delivery,
-		compressed:  a.Compress,
-		format:      a.Format,
+		compression: a.Compress,
+		format:      format,
 		endOfStream: endOfStream,
 		items:       make([]*kodex.Item, 0),
 		headers:     delivery.Headers,
@@ -146,20 +153,20 @@ func (a *AMQPReader) MakeAMQPPayload(delivery amqp.Delivery) (*AMQPPayload, erro
 }
 
 func (a *AMQPPayload) getReader() (*bufio.Reader, error) {
-	bytesReader := bytes.NewReader(a.delivery.Body)
+	codec := a.compression
 
-	var reader *bufio.Reader
+	// the writer tells us which codec it used
+	if name, ok := a.delivery.Headers["compress"].(string); ok {
+		codec = name
+	}
 
-	if a.compressed {
-		gzReader, err := gzip.NewReader(bytesReader)
-		if err != nil {
-			return nil, err
-		}
-		reader = bufio.NewReader(gzReader)
-	} else {
-		reader = bufio.NewReader(bytesReader)
+	reader, err := compression.NewReader(bytes.NewReader(a.delivery.Body), codec, "")
+
+	if err != nil {
+		return nil, err
 	}
-	return reader, nil
+
+	return bufio.NewReader(reader), nil
 
 }
 
@@ -176,6 +183,9 @@ func (a *AMQPReader) Peek() (kodex.Payload, error) {
 }
 
 func (a *AMQPReader) Setup(stream kodex.Stream) error {
+	if err := a.SetupFormat(formats.StreamDefinitions(stream)); err != nil {
+		return err
+	}
 	return a.AMQPBase.SetupWithModel(nil)
 }
 
@@ -187,33 +197,28 @@ func (a *AMQPPayload) readItems() error {
 		return err
 	}
 
-	items := make([]*kodex.Item, 0)
+	decoder, err := a.format.Decoder(reader)
 
-	var lastErr error
+	if err != nil {
+		return err
+	}
+
+	items := make([]*kodex.Item, 0)
 
 	for {
-		item := make(map[string]interface{})
-		line, err := reader.ReadBytes('\n')
-		if err != nil && !(err == io.EOF && len(line) > 0) {
-			break
-		}
-		switch a.format {
-		case "json":
-			err := json.Unmarshal(line, &item)
-			if err != nil {
-				kodex.Log.Errorf("Error unmarshaling item with format JSON.")
-				kodex.Log.Error(err)
-				lastErr = err
-				continue
-			}
-			item := kodex.MakeItem(item)
-			items = append(items, item)
+		item, err := decoder.Decode()
+		if err == io.EOF {
 			break
+		} else if err != nil {
+			kodex.Log.Errorf("Error decoding item.")
+			kodex.Log.Error(err)
+			return err
 		}
+		items = append(items, item)
 	}
 
 	a.items = items
-	return lastErr
+	return nil
 
 }
 
@@ -232,6 +237,10 @@ func (a *AMQPReader) consume() error {
 }
 
 func (a *AMQPReader) Read() (kodex.Payload, error) {
+	return a.ReadContext(context.Background())
+}
+
+func (a *AMQPReader) ReadContext(ctx context.Context) (kodex.Payload, error) {
 
 	if a.deliveries == nil {
 		if err := a.consume(); err != nil {
@@ -245,6 +254,8 @@ func (a *AMQPReader) Read() (kodex.Payload, error) {
 	select {
 	case delivery = <-a.deliveries:
 		found = true
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	// notice: this time is really important
 	case <-time.After(time.Second * 1):
 		break
@@ -19,19 +19,19 @@ package readers
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
-	"encoding/json"
 	"github.com/kiprotect/kodex"
-	"io"
+	"github.com/kiprotect/kodex/compression"
 )
 
 type BytesReader struct {
-	Input      []byte
-	Reader     *bufio.Reader
-	Format     string
-	Compressed bool
-	Headers    map[string]interface{}
-	ChunkSize  int
+	Input        []byte
+	Reader       *bufio.Reader
+	Format       string
+	FormatConfig map[string]interface{}
+	Compression  string
+	Headers      map[string]interface{}
+	ChunkSize    int
+	decoder      kodex.ItemDecoder
 }
 
 type BytesPayload struct {
@@ -84,24 +84,10 @@ func (s *BytesReader) Read() (kodex.Payload, error) {
 		return nil, err
 	}
 
-	items := make([]*kodex.Item, 0)
-
-	for i := 0; i < s.ChunkSize; i++ {
-		item := make(map[string]interface{})
-		line, err := s.Reader.ReadBytes('\n')
-		if err != nil && !(err == io.EOF && len(line) > 0) {
-			break
-		}
-		switch s.Format {
-		case "json":
-			err := json.Unmarshal(line, &item)
-			if err != nil {
-				return nil, err
-			}
-			item := kodex.MakeItem(item)
-			items = append(items, item)
-			break
-		}
+	items, _, err := readItems(s.decoder, s.ChunkSize)
+
+	if err != nil {
+		return nil, err
 	}
 
 	if len(items) == 0 {
@@ -116,18 +102,15 @@ func (s *BytesReader) Read() (kodex.Payload, error) {
 
 func (b *BytesReader) Setup(stream kodex.Stream) error {
 
-	bytesReader := bytes.NewReader(b.Input)
+	reader, err := compression.NewReader(bytes.NewReader(b.Input), b.Compression, "")
 
-	if b.Compressed {
-		gzReader, err := gzip.NewReader(bytesReader)
-		if err != nil {
-			return err
-		}
-		b.Reader = bufio.NewReader(gzReader)
-	} else {
-		b.Reader = bufio.NewReader(bytesReader)
+	if err != nil {
+		return err
 	}
-	return nil
+
+	b.Reader = bufio.NewReader(reader)
+	b.decoder, err = makeItemDecoder(stream, b.Reader, b.Format, b.FormatConfig)
+	return err
 }
 
 func MakeBytesReader(config map[string]interface{}) (kodex.Reader, error) {
@@ -136,10 +119,12 @@ func MakeBytesReader(config map[string]interface{}) (kodex.Reader, error) {
 		return nil, err
 	} else {
 		return &BytesReader{
-			Input:     params["input"].([]byte),
-			ChunkSize: int(params["chunk-size"].(int64)),
-			Headers:   params["headers"].(map[string]interface{}),
-			Format:    params["format"].(string),
+			Input:        params["input"].([]byte),
+			ChunkSize:    int(params["chunk-size"].(int64)),
+			Headers:      params["headers"].(map[string]interface{}),
+			Format:       params["format"].(string),
+			FormatConfig: params["format-config"].(map[string]interface{}),
+			Compression:  params["compressed"].(string),
 		}, nil
 	}
 }
@@ -0,0 +1,54 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/formats"
+	"io"
+)
+
+// Creates the given format, which we look up in the definitions of the
+// stream's controller and the built-in formats.
+func makeFormat(stream kodex.Stream, format string, config map[string]interface{}) (kodex.Format, error) {
+	_, f, err := formats.Make(formats.StreamDefinitions(stream), format, config)
+	return f, err
+}
+
+func makeItemDecoder(stream kodex.Stream, reader io.Reader, format string, config map[string]interface{}) (kodex.ItemDecoder, error) {
+	f, err := makeFormat(stream, format, config)
+	if err != nil {
+		return nil, err
+	}
+	return f.Decoder(reader)
+}
+
+// Reads up to n items from the decoder. Also returns whether the end of the
+// input was reached.
+func readItems(decoder kodex.ItemDecoder, n int) ([]*kodex.Item, bool, error) {
+	items := make([]*kodex.Item, 0)
+	for i := 0; i < n; i++ {
+		item, err := decoder.Decode()
+		if err == io.EOF {
+			return items, true, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		items = append(items, item)
+	}
+	return items, false, nil
+}
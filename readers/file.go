@@ -18,29 +18,32 @@ package readers
 
 import (
 	"bufio"
-	"compress/gzip"
-	"encoding/json"
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/compression"
 	"io"
 	"os"
 )
 
 type FileReader struct {
-	Reader     *bufio.Reader
-	File       *os.File
-	GzReader   *gzip.Reader
-	Format     string
-	Compressed bool
-	Headers    map[string]interface{}
-	Path       string
-	ChunkSize  int
+	Reader       *bufio.Reader
+	File         *os.File
+	Decompressor io.ReadCloser
+	Format       string
+	FormatConfig map[string]interface{}
+	Compression  string
+	Headers      map[string]interface{}
+	Path         string
+	ChunkSize    int
+	decoder      kodex.ItemDecoder
 }
 
 type FilePayload struct {
 	items       []*kodex.Item
 	endOfStream bool
 	headers     map[string]interface{}
+	// called when the payload gets acknowledged, if set
+	acknowledge func() error
 }
 
 func (f *FilePayload) EndOfStream() bool {
@@ -52,6 +55,9 @@ func (f *FilePayload) Items() []*kodex.Item {
 }
 
 func (f *FilePayload) Acknowledge() error {
+	if f.acknowledge != nil {
+		return f.acknowledge()
+	}
 	return nil
 }
 
@@ -76,31 +82,48 @@ func (s *FileReader) Setup(stream kodex.Stream) error {
 		return fmt.Errorf("reader path is not a file")
 	}
 
-	var reader io.Reader
+	format, err := makeFormat(stream, s.Format, s.FormatConfig)
+
+	if err != nil {
+		return err
+	}
 
 	if s.File, err = os.Open(s.Path); err != nil {
 		return err
 	}
 
-	if s.Compressed {
-		s.GzReader, err = gzip.NewReader(reader)
-		if err != nil {
+	s.Reader = bufio.NewReader(s.File)
+
+	codec, err := compression.Resolve(s.Compression, s.Path, s.Reader)
+
+	if err != nil {
+		return err
+	}
+
+	// formats like Parquet read directly from the file
+	if fileFormat, ok := format.(kodex.FileFormat); ok && codec == nil {
+		s.decoder, err = fileFormat.FileDecoder(s.File, info.Size())
+		return err
+	}
+
+	if codec != nil {
+		if s.Decompressor, err = codec.Reader(s.Reader); err != nil {
 			return err
 		}
-		reader = s.GzReader
-	} else {
-		reader = s.File
+		s.Reader = bufio.NewReader(s.Decompressor)
 	}
 
-	s.Reader = bufio.NewReader(reader)
+	if s.decoder, err = format.Decoder(s.Reader); err != nil {
+		return err
+	}
 
 	return nil
 
 }
 
 func (s *FileReader) Teardown() error {
-	if s.GzReader != nil {
-		if err := s.GzReader.Close(); err != nil {
+	if s.Decompressor != nil {
+		if err := s.Decompressor.Close(); err != nil {
 			return err
 		}
 	}
@@ -130,38 +153,20 @@ func (s *FileReader) Read() (kodex.Payload, error) {
 		return nil, err
 	}
 
-	items := make([]*kodex.Item, 0)
-
-	endOfFile := false
+	items, endOfFile, err := readItems(s.decoder, s.ChunkSize)
 
-	for i := 0; i < s.ChunkSize; i++ {
-		item := make(map[string]interface{})
-		line, err := s.Reader.ReadBytes('\n')
-		if err == io.EOF {
-			endOfFile = true
-			if len(line) == 0 {
-				break
-			}
-		} else if err != nil {
-			return nil, err
-		}
-		if len(line) <= 1 {
-			continue
-		}
-		switch s.Format {
-		case "json":
-			err := json.Unmarshal(line, &item)
-			if err != nil {
-				return nil, err
-			}
-			item := kodex.MakeItem(item)
-			items = append(items, item)
-		}
+	if err != nil {
+		return nil, err
 	}
 
 	kodex.Log.Debugf("Read %d items...", len(items))
 
 	if len(items) == 0 {
+		// if the last chunk was full we announce the end of the file now
+		if endOfFile {
+			payload.endOfStream = true
+			return payload, nil
+		}
 		return nil, nil
 	}
 
@@ -177,11 +182,12 @@ func MakeFileReader(config map[string]interface{}) (kodex.Reader, error) {
 		return nil, err
 	} else {
 		return &FileReader{
-			Path:       params["path"].(string),
-			ChunkSize:  int(params["chunk-size"].(int64)),
-			Headers:    params["headers"].(map[string]interface{}),
-			Format:     params["format"].(string),
-			Compressed: params["compressed"].(bool),
+			Path:         params["path"].(string),
+			ChunkSize:    int(params["chunk-size"].(int64)),
+			Headers:      params["headers"].(map[string]interface{}),
+			Format:       params["format"].(string),
+			FormatConfig: params["format-config"].(map[string]interface{}),
+			Compression:  params["compressed"].(string),
 		}, nil
 	}
 }
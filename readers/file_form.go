@@ -18,6 +18,7 @@ package readers
 
 import (
 	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/compression"
 )
 
 var FileReaderForm = forms.Form{
@@ -34,14 +35,22 @@ var FileReaderForm = forms.Form{
 			Name: "format",
 			Validators: []forms.Validator{
 				forms.IsRequired{},
-				forms.IsIn{Choices: []interface{}{"json"}},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "format-config",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
 			},
 		},
 		{
+			// a compression codec, "auto" to detect it or "none"
 			Name: "compressed",
 			Validators: []forms.Validator{
 				forms.IsOptional{Default: false},
-				forms.IsBoolean{},
+				compression.IsCodec{},
 			},
 		},
 		{
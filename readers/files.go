@@ -0,0 +1,693 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// we identify files by the hash of their first bytes, so that we notice if
+// a file was replaced or truncated
+const fingerprintSize = 1024
+
+// Reads all files matching a glob pattern. In follow mode, the reader keeps
+// watching the files for new data. The position in every file is stored in a
+// state file once the items read up to that position have been acknowledged,
+// so that we can resume reading after a restart without losing items.
+//
+// To detect rotation we identify files by their device and inode numbers
+// (where available) and their fingerprint. Rotated files should keep matching
+// the glob pattern, otherwise data written to them right before the rotation
+// might not be read.
+type FilesReader struct {
+	Glob          string
+	Order         string
+	Format        string
+	FormatConfig  map[string]interface{}
+	Follow        bool
+	PollInterval  time.Duration
+	InactiveAfter time.Duration
+	StateFile     string
+	OnComplete    string
+	MoveTo        string
+	ChunkSize     int
+	Headers       map[string]interface{}
+	format        kodex.Format
+	state         *FilesState
+	current       *openRegion
+	endOfStream   bool
+	checkpoints   []*filesCheckpoint
+	mutex         sync.Mutex
+}
+
+// The read state after a given payload. Once the payload and all payloads
+// before it are acknowledged we save the state and complete the files that
+// were read completely.
+type filesCheckpoint struct {
+	state        *FilesState
+	completed    []*FileState
+	acknowledged bool
+}
+
+// The read state of all files
+type FilesState struct {
+	Files map[string]*FileState `json:"files"`
+}
+
+// The read state of a single file. We read files in regions, Offset is the
+// start of the current region and Items the number of items that we have
+// already read from that region.
+type FileState struct {
+	Path            string `json:"path"`
+	ID              string `json:"id,omitempty"`
+	Fingerprint     string `json:"fingerprint"`
+	FingerprintSize int64  `json:"fingerprint-size"`
+	Offset          int64  `json:"offset"`
+	Items           int    `json:"items"`
+	Done            bool   `json:"done"`
+}
+
+// A region of a file that we're currently reading
+type openRegion struct {
+	file    *os.File
+	state   *FileState
+	end     int64
+	decoder kodex.ItemDecoder
+}
+
+type matchingFile struct {
+	path string
+	info os.FileInfo
+}
+
+func (f *FilesReader) Purge() error {
+	return nil
+}
+
+func (f *FilesReader) Setup(stream kodex.Stream) error {
+
+	var err error
+
+	if f.format, err = makeFormat(stream, f.Format, f.FormatConfig); err != nil {
+		return err
+	}
+
+	if _, ok := f.format.(kodex.FileFormat); ok && f.Follow {
+		return fmt.Errorf("format '%s' cannot be used in follow mode", f.Format)
+	}
+
+	if _, err := filepath.Match(f.Glob, ""); err != nil {
+		return err
+	}
+
+	if f.OnComplete == "move" {
+		if err := os.MkdirAll(f.MoveTo, 0700); err != nil {
+			return err
+		}
+	}
+
+	f.endOfStream = false
+
+	f.mutex.Lock()
+	f.checkpoints = nil
+	f.mutex.Unlock()
+
+	return f.loadState()
+}
+
+// The state file is updated when payloads get acknowledged, so we only need
+// to close the current file.
+func (f *FilesReader) Teardown() error {
+	f.closeRegion()
+	return nil
+}
+
+func (f *FilesReader) Read() (kodex.Payload, error) {
+	return f.ReadContext(context.Background())
+}
+
+func (f *FilesReader) ReadContext(ctx context.Context) (kodex.Payload, error) {
+
+	if f.current == nil {
+
+		if f.endOfStream {
+			return nil, nil
+		}
+
+		found, err := f.nextRegion()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !found {
+			if f.Follow {
+				select {
+				case <-time.After(f.PollInterval):
+				case <-ctx.Done():
+				}
+				return nil, nil
+			}
+			// all files have been processed
+			f.endOfStream = true
+			return &FilePayload{
+				items:       []*kodex.Item{},
+				endOfStream: true,
+				headers:     f.Headers,
+			}, nil
+		}
+	}
+
+	region := f.current
+
+	items, endOfRegion, err := readItems(region.decoder, f.ChunkSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	region.state.Items += len(items)
+
+	var completed []*FileState
+
+	if endOfRegion {
+		if completed, err = f.completeRegion(); err != nil {
+			return nil, err
+		}
+	}
+
+	kodex.Log.Debugf("Read %d items from %s...", len(items), region.state.Path)
+
+	if len(items) == 0 {
+		if endOfRegion {
+			// there is nothing to acknowledge, so we commit right away
+			return nil, f.commit(f.checkpoint(completed))
+		}
+		return nil, nil
+	}
+
+	checkpoint := f.checkpoint(completed)
+
+	return &FilePayload{
+		items:       items,
+		headers:     f.Headers,
+		acknowledge: func() error { return f.commit(checkpoint) },
+	}, nil
+}
+
+// Records the current read state, which we save once all items read up to
+// now have been acknowledged.
+func (f *FilesReader) checkpoint(completed []*FileState) *filesCheckpoint {
+
+	state := &FilesState{Files: make(map[string]*FileState, len(f.state.Files))}
+
+	for path, fileState := range f.state.Files {
+		stateCopy := *fileState
+		state.Files[path] = &stateCopy
+	}
+
+	checkpoint := &filesCheckpoint{
+		state:     state,
+		completed: completed,
+	}
+
+	f.mutex.Lock()
+	f.checkpoints = append(f.checkpoints, checkpoint)
+	f.mutex.Unlock()
+
+	return checkpoint
+}
+
+// Marks the given checkpoint as acknowledged. Payloads can be acknowledged
+// in any order, so we save the latest state for which all checkpoints
+// before it are acknowledged as well.
+func (f *FilesReader) commit(checkpoint *filesCheckpoint) error {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	checkpoint.acknowledged = true
+
+	var last *filesCheckpoint
+
+	for len(f.checkpoints) > 0 && f.checkpoints[0].acknowledged {
+		last = f.checkpoints[0]
+		f.checkpoints = f.checkpoints[1:]
+		for _, state := range last.completed {
+			if err := f.complete(state); err != nil {
+				return err
+			}
+			if f.OnComplete == "move" || f.OnComplete == "delete" {
+				delete(last.state.Files, state.Path)
+			}
+		}
+	}
+
+	if last == nil {
+		return nil
+	}
+
+	return f.saveState(last.state)
+}
+
+// Finds the next file with unread data and opens the unread region.
+func (f *FilesReader) nextRegion() (bool, error) {
+
+	files, err := f.match()
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, file := range files {
+
+		state := f.state.Files[file.path]
+		size := file.info.Size()
+
+		if size == state.Offset && (state.Done || f.Follow) {
+			if f.Follow && !state.Done && f.InactiveAfter > 0 && time.Since(file.info.ModTime()) > f.InactiveAfter {
+				// the file is complete
+				state.Done = true
+				if err := f.commit(f.checkpoint([]*FileState{state})); err != nil {
+					return false, err
+				}
+			}
+			continue
+		}
+
+		end := size
+
+		if f.Follow {
+			// we only read complete lines
+			if end, err = lastLineEnd(file.path, state.Offset, size); err != nil {
+				return false, err
+			} else if end == state.Offset {
+				continue
+			}
+		}
+
+		if err := f.openRegion(state, end); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (f *FilesReader) openRegion(state *FileState, end int64) error {
+
+	file, err := os.Open(state.Path)
+
+	if err != nil {
+		return err
+	}
+
+	region := &openRegion{
+		file:  file,
+		state: state,
+		end:   end,
+	}
+
+	if fileFormat, ok := f.format.(kodex.FileFormat); ok {
+		region.decoder, err = fileFormat.FileDecoder(file, end)
+	} else {
+		var reader io.Reader = io.NewSectionReader(file, state.Offset, end-state.Offset)
+		// decoders that continue in the middle of a file need the header
+		if headerFormat, ok := f.format.(kodex.HeaderFormat); ok && headerFormat.HasHeader() && state.Offset > 0 {
+			header, err := firstLine(file)
+			if err != nil {
+				file.Close()
+				return err
+			}
+			reader = io.MultiReader(bytes.NewReader(header), reader)
+		}
+		region.decoder, err = f.format.Decoder(reader)
+	}
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	// we skip the items that we have already read before
+	for i := 0; i < state.Items; i++ {
+		if _, err := region.decoder.Decode(); err == io.EOF {
+			break
+		} else if err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	f.current = region
+
+	return nil
+}
+
+func (f *FilesReader) closeRegion() {
+	if f.current != nil {
+		f.current.file.Close()
+		f.current = nil
+	}
+}
+
+// Closes the current region and returns the file state if the file is
+// complete, so that we can move or delete it once all its items have been
+// acknowledged.
+func (f *FilesReader) completeRegion() ([]*FileState, error) {
+
+	region := f.current
+	state := region.state
+
+	f.closeRegion()
+
+	state.Offset = region.end
+	state.Items = 0
+
+	if state.FingerprintSize < fingerprintSize {
+		if err := state.updateFingerprint(); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Follow {
+		// the file might have been complete before and received new data
+		state.Done = false
+		return nil, nil
+	}
+
+	state.Done = true
+
+	return []*FileState{state}, nil
+}
+
+// Moves or deletes a file that we have completely read. We keep the state of
+// the file until the next match, as the file might still be read from.
+func (f *FilesReader) complete(state *FileState) error {
+
+	switch f.OnComplete {
+	case "move":
+		if err := os.Rename(state.Path, filepath.Join(f.MoveTo, filepath.Base(state.Path))); err != nil {
+			return err
+		}
+	case "delete":
+		if err := os.Remove(state.Path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the files matching the glob pattern and updates their state. Files
+// that were renamed keep their state, files that were replaced or truncated
+// are read from the beginning.
+func (f *FilesReader) match() ([]*matchingFile, error) {
+
+	paths, err := filepath.Glob(f.Glob)
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*matchingFile, 0, len(paths))
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			// the file might have been removed in the meantime
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if f.StateFile != "" {
+			if same, err := sameFile(path, f.StateFile); err == nil && same {
+				continue
+			}
+		}
+		files = append(files, &matchingFile{path: path, info: info})
+	}
+
+	switch f.Order {
+	case "modified":
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].info.ModTime().Before(files[j].info.ModTime())
+		})
+	default:
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].path < files[j].path
+		})
+	}
+
+	byID := make(map[string]*FileState)
+
+	for _, state := range f.state.Files {
+		if state.ID != "" {
+			byID[state.ID] = state
+		}
+	}
+
+	states := make(map[string]*FileState)
+
+	for _, file := range files {
+
+		id := fileID(file.info)
+
+		var state *FileState
+
+		if id != "" {
+			state = byID[id]
+		} else {
+			state = f.state.Files[file.path]
+		}
+
+		if state != nil {
+			state.Path = file.path
+			if file.info.Size() < state.Offset {
+				// the file was truncated
+				state = nil
+			} else if ok, err := state.matchesFingerprint(); err != nil {
+				return nil, err
+			} else if !ok {
+				// the file was replaced
+				state = nil
+			}
+		}
+
+		if state == nil {
+			state = &FileState{
+				Path: file.path,
+				ID:   id,
+			}
+			if err := state.updateFingerprint(); err != nil {
+				return nil, err
+			}
+		}
+
+		states[file.path] = state
+	}
+
+	f.state.Files = states
+
+	return files, nil
+}
+
+func (f *FileState) fingerprint(size int64) (string, int64, error) {
+
+	file, err := os.Open(f.Path)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, size))
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:]), int64(len(data)), nil
+}
+
+func (f *FileState) updateFingerprint() error {
+	var err error
+	f.Fingerprint, f.FingerprintSize, err = f.fingerprint(fingerprintSize)
+	return err
+}
+
+func (f *FileState) matchesFingerprint() (bool, error) {
+	fingerprint, size, err := f.fingerprint(f.FingerprintSize)
+	if err != nil {
+		return false, err
+	}
+	return size == f.FingerprintSize && fingerprint == f.Fingerprint, nil
+}
+
+func (f *FilesReader) loadState() error {
+
+	f.state = &FilesState{Files: map[string]*FileState{}}
+
+	if f.StateFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.StateFile)
+
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, f.state); err != nil {
+		return fmt.Errorf("invalid state file: %w", err)
+	}
+
+	if f.state.Files == nil {
+		f.state.Files = map[string]*FileState{}
+	}
+
+	return nil
+}
+
+// Writes the state to a temporary file that we then move into place, so that
+// the state file is always complete.
+func (f *FilesReader) saveState(state *FilesState) error {
+
+	if f.StateFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+
+	if err != nil {
+		return err
+	}
+
+	tmpPath := f.StateFile + ".tmp"
+
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, f.StateFile)
+}
+
+// Returns the position after the last newline in the given range of the file.
+func lastLineEnd(path string, start, end int64) (int64, error) {
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	buf := make([]byte, 4096)
+
+	for pos := end; pos > start; {
+		n := int64(len(buf))
+		if pos-start < n {
+			n = pos - start
+		}
+		pos -= n
+		if _, err := file.ReadAt(buf[:n], pos); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+	}
+
+	return start, nil
+}
+
+// Returns the first line of the file, including the newline.
+func firstLine(file *os.File) ([]byte, error) {
+
+	line := make([]byte, 0, 256)
+	buf := make([]byte, 256)
+
+	for pos := int64(0); ; pos += int64(len(buf)) {
+		n, err := file.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return append(line, buf[:i+1]...), nil
+		}
+		line = append(line, buf[:n]...)
+		if err == io.EOF {
+			return append(line, '\n'), nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func sameFile(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(infoA, infoB), nil
+}
+
+func MakeFilesReader(config map[string]interface{}) (kodex.Reader, error) {
+	if params, err := FilesReaderForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		if params["on-complete"] == "move" && params["move-to"] == "" {
+			return nil, fmt.Errorf("move-to is required to move complete files")
+		}
+		return &FilesReader{
+			Glob:          params["glob"].(string),
+			Order:         params["order"].(string),
+			Format:        params["format"].(string),
+			FormatConfig:  params["format-config"].(map[string]interface{}),
+			Follow:        params["follow"].(bool),
+			PollInterval:  time.Duration(params["poll-interval"].(float64) * float64(time.Second)),
+			InactiveAfter: time.Duration(params["inactive-after"].(float64) * float64(time.Second)),
+			StateFile:     params["state-file"].(string),
+			OnComplete:    params["on-complete"].(string),
+			MoveTo:        params["move-to"].(string),
+			ChunkSize:     int(params["chunk-size"].(int64)),
+			Headers:       params["headers"].(map[string]interface{}),
+		}, nil
+	}
+}
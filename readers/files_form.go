@@ -0,0 +1,123 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+var FilesReaderForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the files reader form",
+	Fields: []forms.Field{
+		{
+			// a glob pattern like /var/log/app/*.log
+			Name: "glob",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			// the order in which matching files are processed
+			Name: "order",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "name"},
+				forms.IsIn{Choices: []interface{}{"name", "modified"}},
+			},
+		},
+		{
+			Name: "format",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "json"},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "format-config",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+		{
+			// keep watching files for new data. In this mode records need to
+			// be terminated by a newline, as we only read complete lines.
+			Name: "follow",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+		{
+			// how often we check for new data in follow mode (in seconds)
+			Name: "poll-interval",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 1.0},
+				forms.IsFloat{HasMin: true, Min: 0.01},
+			},
+		},
+		{
+			// in follow mode, files that were read completely and have not
+			// been modified for this many seconds are considered complete.
+			// Zero means that files are never complete.
+			Name: "inactive-after",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0.0},
+				forms.IsFloat{HasMin: true, Min: 0},
+			},
+		},
+		{
+			// the file in which we store the read position of every file, so
+			// that we can resume after a restart
+			Name: "state-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// what to do with files once they are complete
+			Name: "on-complete",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "keep"},
+				forms.IsIn{Choices: []interface{}{"keep", "move", "delete"}},
+			},
+		},
+		{
+			// the directory to move complete files to
+			Name: "move-to",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "chunk-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 10},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 10000},
+			},
+		},
+		{
+			Name: "headers",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+	},
+}
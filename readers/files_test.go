@@ -0,0 +1,330 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func makeTestFilesReader(t *testing.T, config map[string]interface{}) *FilesReader {
+	reader, err := MakeFilesReader(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+	return reader.(*FilesReader)
+}
+
+// Reads payloads until the end of the stream or, in follow mode, until there
+// are no more items.
+func readAllItems(t *testing.T, reader *FilesReader) []*kodex.Item {
+	items := make([]*kodex.Item, 0)
+	empty := 0
+	for empty < 3 {
+		payload, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload == nil {
+			empty++
+			continue
+		}
+		empty = 0
+		if err := payload.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, payload.Items()...)
+		if payload.EndOfStream() {
+			break
+		}
+	}
+	return items
+}
+
+func appendLines(t *testing.T, path string, from, to int) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := from; i < to; i++ {
+		if _, err := fmt.Fprintf(f, "{\"i\": %d}\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkItems(t *testing.T, items []*kodex.Item, from, to int) {
+	if len(items) != to-from {
+		t.Fatalf("Expected %d items, got %d", to-from, len(items))
+	}
+	for j, item := range items {
+		if i, _ := item.Get("i"); i != float64(from+j) {
+			t.Fatalf("Expected item %d, got %v", from+j, i)
+		}
+	}
+}
+
+func TestFilesReader(t *testing.T) {
+
+	dir := t.TempDir()
+
+	appendLines(t, filepath.Join(dir, "b.log"), 10, 25)
+	appendLines(t, filepath.Join(dir, "a.log"), 0, 10)
+	appendLines(t, filepath.Join(dir, "c.txt"), 100, 110)
+
+	config := map[string]interface{}{
+		"glob":       filepath.Join(dir, "*.log"),
+		"state-file": filepath.Join(dir, "state.json"),
+		"chunk-size": 4,
+	}
+
+	reader := makeTestFilesReader(t, config)
+
+	// we stop in the middle of the second file
+	items := make([]*kodex.Item, 0)
+	for len(items) < 16 {
+		payload, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload != nil {
+			if err := payload.Acknowledge(); err != nil {
+				t.Fatal(err)
+			}
+			items = append(items, payload.Items()...)
+		}
+	}
+
+	if err := reader.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// we resume where we stopped
+	reader = makeTestFilesReader(t, config)
+	items = append(items, readAllItems(t, reader)...)
+	checkItems(t, items, 0, 25)
+
+	if err := reader.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// all files have been read
+	reader = makeTestFilesReader(t, config)
+	checkItems(t, readAllItems(t, reader), 0, 0)
+}
+
+func TestFilesReaderAcknowledge(t *testing.T) {
+
+	dir := t.TempDir()
+
+	appendLines(t, filepath.Join(dir, "a.log"), 0, 8)
+
+	config := map[string]interface{}{
+		"glob":        filepath.Join(dir, "*.log"),
+		"state-file":  filepath.Join(dir, "state.json"),
+		"chunk-size":  4,
+		"on-complete": "delete",
+	}
+
+	reader := makeTestFilesReader(t, config)
+
+	payloads := make([]kodex.Payload, 0)
+
+	for i := 0; i < 2; i++ {
+		payload, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		} else if payload == nil {
+			t.Fatalf("Expected a payload")
+		}
+		payloads = append(payloads, payload)
+	}
+
+	// the second payload is acknowledged before the first one, so we cannot
+	// save its position yet
+	if err := payloads[1].Acknowledge(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "a.log")); err != nil {
+		t.Fatalf("The file should only be deleted once all its items are acknowledged")
+	}
+
+	// we simulate a crash, all items should be read again
+	reader.Teardown()
+	reader = makeTestFilesReader(t, config)
+
+	payload, err := reader.Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkItems(t, payload.Items(), 0, 4)
+
+	if err := payload.Acknowledge(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader.Teardown()
+	reader = makeTestFilesReader(t, config)
+	checkItems(t, readAllItems(t, reader), 4, 8)
+	reader.Teardown()
+
+	if _, err := os.Stat(filepath.Join(dir, "a.log")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the file to be deleted")
+	}
+}
+
+func TestFilesReaderFollow(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	appendLines(t, path, 0, 5)
+
+	reader := makeTestFilesReader(t, map[string]interface{}{
+		"glob":          filepath.Join(dir, "app.log*"),
+		"order":         "modified",
+		"follow":        true,
+		"poll-interval": 0.01,
+	})
+
+	defer reader.Teardown()
+
+	checkItems(t, readAllItems(t, reader), 0, 5)
+
+	// incomplete lines are not read until they are complete
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("{\"i\": 5}\n{\"i\""))
+	checkItems(t, readAllItems(t, reader), 5, 6)
+	f.Write([]byte(": 6}\n"))
+	f.Close()
+	checkItems(t, readAllItems(t, reader), 6, 7)
+
+	// the file is rotated, we read the remaining data from the rotated
+	// file and the new file
+	appendLines(t, path, 7, 9)
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	// modification times have a limited resolution
+	time.Sleep(20 * time.Millisecond)
+	appendLines(t, path, 9, 12)
+	checkItems(t, readAllItems(t, reader), 7, 12)
+
+	// the file is truncated, we read it from the beginning
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, path, 12, 13)
+	checkItems(t, readAllItems(t, reader), 12, 13)
+}
+
+func TestFilesReaderHeader(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.csv")
+
+	if err := os.WriteFile(path, []byte("i,name\n0,a\n1,b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := makeTestFilesReader(t, map[string]interface{}{
+		"glob":          path,
+		"format":        "csv",
+		"follow":        true,
+		"poll-interval": 0.01,
+	})
+
+	defer reader.Teardown()
+
+	if items := readAllItems(t, reader); len(items) != 2 {
+		t.Fatalf("Expected 2 items, got %d", len(items))
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("2,c\n"))
+	f.Close()
+
+	items := readAllItems(t, reader)
+
+	if len(items) != 1 {
+		t.Fatalf("Expected 1 item, got %d", len(items))
+	}
+
+	if name, _ := items[0].Get("name"); name != "c" {
+		t.Errorf("Expected name c, got %v", name)
+	}
+}
+
+func TestFilesReaderOnComplete(t *testing.T) {
+
+	dir := t.TempDir()
+	done := filepath.Join(dir, "done")
+
+	appendLines(t, filepath.Join(dir, "a.log"), 0, 3)
+	appendLines(t, filepath.Join(dir, "b.log"), 3, 5)
+
+	reader := makeTestFilesReader(t, map[string]interface{}{
+		"glob":        filepath.Join(dir, "*.log"),
+		"on-complete": "move",
+		"move-to":     done,
+	})
+
+	checkItems(t, readAllItems(t, reader), 0, 5)
+	reader.Teardown()
+
+	for _, name := range []string{"a.log", "b.log"} {
+		if _, err := os.Stat(filepath.Join(done, name)); err != nil {
+			t.Errorf("Expected %s to be moved: %v", name, err)
+		}
+	}
+
+	reader = makeTestFilesReader(t, map[string]interface{}{
+		"glob":        filepath.Join(done, "*.log"),
+		"on-complete": "delete",
+	})
+
+	checkItems(t, readAllItems(t, reader), 0, 5)
+	reader.Teardown()
+
+	if matches, _ := filepath.Glob(filepath.Join(done, "*")); len(matches) != 0 {
+		t.Errorf("Expected files to be deleted, found %v", matches)
+	}
+
+	if _, err := MakeFilesReader(map[string]interface{}{
+		"glob":        "*.log",
+		"on-complete": "move",
+	}); err == nil {
+		t.Errorf("Expected an error without a move-to directory")
+	}
+}
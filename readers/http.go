@@ -0,0 +1,391 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/formats"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Accepts items via HTTP POST requests. Every request becomes a payload and
+// we only respond once the payload was acknowledged (200) or rejected (503),
+// so producers can't submit data faster than we process it.
+type HTTPReader struct {
+	Address       string
+	Path          string
+	Formats       []string
+	FormatConfigs map[string]interface{}
+	Tokens        []string
+	TLS           map[string]interface{}
+	MaxBodySize   int64
+	Timeout       time.Duration
+	Headers       map[string]interface{}
+	formats       []*httpFormat
+	server        *http.Server
+	listener      net.Listener
+	payloads      chan *HTTPPayload
+	stop          chan bool
+	mutex         sync.Mutex
+}
+
+type httpFormat struct {
+	definition kodex.FormatDefinition
+	format     kodex.Format
+}
+
+type HTTPPayload struct {
+	items   []*kodex.Item
+	headers map[string]interface{}
+	result  chan error
+	once    sync.Once
+}
+
+func (f *HTTPPayload) EndOfStream() bool {
+	return false
+}
+
+func (f *HTTPPayload) Items() []*kodex.Item {
+	return f.items
+}
+
+func (f *HTTPPayload) Headers() map[string]interface{} {
+	return f.headers
+}
+
+func (f *HTTPPayload) Acknowledge() error {
+	f.once.Do(func() { f.result <- nil })
+	return nil
+}
+
+func (f *HTTPPayload) Reject() error {
+	f.once.Do(func() { f.result <- fmt.Errorf("payload was rejected") })
+	return nil
+}
+
+func (h *HTTPReader) Purge() error {
+	return nil
+}
+
+func (h *HTTPReader) Setup(stream kodex.Stream) error {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.server != nil {
+		return nil
+	}
+
+	h.formats = make([]*httpFormat, 0, len(h.Formats))
+
+	for _, name := range h.Formats {
+		config, _ := h.FormatConfigs[name].(map[string]interface{})
+		definition, format, err := formats.Make(formats.StreamDefinitions(stream), name, config)
+		if err != nil {
+			return err
+		}
+		h.formats = append(h.formats, &httpFormat{definition: definition, format: format})
+	}
+
+	listener, err := net.Listen("tcp", h.Address)
+
+	if err != nil {
+		return err
+	}
+
+	if h.TLS != nil {
+		if tlsConfig, err := makeTLSConfig(h.TLS); err != nil {
+			listener.Close()
+			return err
+		} else {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(h.Path, h)
+
+	h.listener = listener
+	h.payloads = make(chan *HTTPPayload)
+	h.stop = make(chan bool)
+	h.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			kodex.Log.Error(err)
+		}
+	}(h.server)
+
+	return nil
+}
+
+// Returns the address we listen on, which is useful if the port was chosen
+// automatically.
+func (h *HTTPReader) Addr() net.Addr {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.listener == nil {
+		return nil
+	}
+	return h.listener.Addr()
+}
+
+func (h *HTTPReader) Teardown() error {
+
+	h.mutex.Lock()
+
+	server := h.server
+
+	if server == nil {
+		h.mutex.Unlock()
+		return nil
+	}
+
+	// requests that wait for their payloads to be processed return
+	close(h.stop)
+
+	h.server = nil
+	h.listener = nil
+
+	h.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return server.Shutdown(ctx)
+}
+
+func (h *HTTPReader) Read() (kodex.Payload, error) {
+	return h.ReadContext(context.Background())
+}
+
+func (h *HTTPReader) ReadContext(ctx context.Context) (kodex.Payload, error) {
+
+	h.mutex.Lock()
+	payloads, stop := h.payloads, h.stop
+	h.mutex.Unlock()
+
+	if payloads == nil {
+		return nil, fmt.Errorf("reader is not set up")
+	}
+
+	select {
+	case payload := <-payloads:
+		return payload, nil
+	case <-stop:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (h *HTTPReader) authorized(r *http.Request) bool {
+
+	if len(h.Tokens) == 0 {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if !ok {
+		return false
+	}
+
+	authorized := false
+
+	// we compare all tokens to not leak which one matched
+	for _, validToken := range h.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(validToken)) == 1 {
+			authorized = true
+		}
+	}
+
+	return authorized
+}
+
+// Returns the format matching the content type of the request. Requests
+// without a content type use the first format.
+func (h *HTTPReader) format(contentType string) *httpFormat {
+
+	if contentType == "" {
+		return h.formats[0]
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return nil
+	}
+
+	for _, format := range h.formats {
+		if format.definition.MIMEType == mediaType {
+			return format
+		}
+	}
+
+	return nil
+}
+
+func respond(w http.ResponseWriter, status int, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	respond(w, status, map[string]interface{}{"message": message})
+}
+
+func (h *HTTPReader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		respondWithError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	format := h.format(r.Header.Get("Content-Type"))
+
+	if format == nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, "unsupported content type")
+		return
+	}
+
+	h.mutex.Lock()
+	payloads, stop := h.payloads, h.stop
+	h.mutex.Unlock()
+
+	body := http.MaxBytesReader(w, r.Body, h.MaxBodySize)
+
+	items, err := decodeAll(format.format, body)
+
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		} else {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("invalid data: %v", err))
+		}
+		return
+	}
+
+	if len(items) == 0 {
+		respond(w, http.StatusOK, map[string]interface{}{"items": 0})
+		return
+	}
+
+	payload := &HTTPPayload{
+		items:   items,
+		headers: h.Headers,
+		result:  make(chan error, 1),
+	}
+
+	timer := time.NewTimer(h.Timeout)
+	defer timer.Stop()
+
+	select {
+	case payloads <- payload:
+	case <-stop:
+		respondWithError(w, http.StatusServiceUnavailable, "shutting down")
+		return
+	case <-timer.C:
+		respondWithError(w, http.StatusServiceUnavailable, "timeout while waiting for a reader")
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	select {
+	case err := <-payload.result:
+		if err != nil {
+			respondWithError(w, http.StatusServiceUnavailable, err.Error())
+		} else {
+			respond(w, http.StatusOK, map[string]interface{}{"items": len(items)})
+		}
+	case <-stop:
+		respondWithError(w, http.StatusServiceUnavailable, "shutting down")
+	case <-timer.C:
+		// the payload might still be processed
+		respondWithError(w, http.StatusGatewayTimeout, "timeout while waiting for processing")
+	}
+}
+
+func decodeAll(format kodex.Format, reader io.Reader) ([]*kodex.Item, error) {
+
+	decoder, err := format.Decoder(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*kodex.Item, 0)
+
+	for {
+		item, err := decoder.Decode()
+		if err == io.EOF {
+			return items, nil
+		} else if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func MakeHTTPReader(config map[string]interface{}) (kodex.Reader, error) {
+	if params, err := HTTPReaderForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		reader := &HTTPReader{
+			Address:       params["address"].(string),
+			Path:          params["path"].(string),
+			Formats:       params["formats"].([]string),
+			FormatConfigs: params["format-configs"].(map[string]interface{}),
+			Tokens:        params["tokens"].([]string),
+			MaxBodySize:   params["max-body-size"].(int64),
+			Timeout:       time.Duration(params["timeout"].(float64) * float64(time.Second)),
+			Headers:       params["headers"].(map[string]interface{}),
+		}
+		if len(reader.Formats) == 0 {
+			return nil, fmt.Errorf("at least one format is required")
+		}
+		if tlsConfig, ok := params["tls"].(map[string]interface{}); ok {
+			reader.TLS = tlsConfig
+		}
+		return reader, nil
+	}
+}
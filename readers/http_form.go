@@ -0,0 +1,98 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+var HTTPReaderForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the HTTP reader form",
+	Fields: []forms.Field{
+		{
+			// the address to listen on, e.g. 127.0.0.1:8080
+			Name: "address",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "path",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "/"},
+				forms.IsString{},
+			},
+		},
+		{
+			// the accepted formats, we pick one based on the content type of
+			// the request. The first format is the default.
+			Name: "formats",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{"ndjson", "json-array", "csv"}},
+				forms.IsStringList{},
+			},
+		},
+		{
+			// the configs of the formats, by format name
+			Name: "format-configs",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+		{
+			// if given, requests need to provide one of these bearer tokens
+			Name: "tokens",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{}},
+				forms.IsStringList{},
+			},
+		},
+		{
+			Name: "tls",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &TLSForm,
+				},
+			},
+		},
+		{
+			Name: "max-body-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 10 * 1024 * 1024},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			// how long we wait for a payload to be acknowledged (in seconds)
+			Name: "timeout",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 30.0},
+				forms.IsFloat{HasMin: true, Min: 0.01},
+			},
+		},
+		{
+			Name: "headers",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+	},
+}
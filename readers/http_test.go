@@ -0,0 +1,295 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func makeTestHTTPReader(t *testing.T, config map[string]interface{}) *HTTPReader {
+
+	config["address"] = "127.0.0.1:0"
+
+	reader, err := MakeHTTPReader(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { reader.Teardown() })
+
+	httpReader := reader.(*HTTPReader)
+
+	// we acknowledge all payloads unless they contain a "reject" field
+	go func() {
+		for {
+			payload, err := httpReader.Read()
+			if err != nil {
+				return
+			}
+			if payload == nil {
+				if httpReader.Addr() == nil {
+					return
+				}
+				continue
+			}
+			if _, ok := payload.Items()[0].Get("reject"); ok {
+				payload.Reject()
+			} else {
+				payload.Acknowledge()
+			}
+		}
+	}()
+
+	return httpReader
+}
+
+func post(t *testing.T, client *http.Client, url, contentType, token, body string) int {
+
+	req, err := http.NewRequest("POST", url, bytes.NewBufferString(body))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestHTTPReader(t *testing.T) {
+
+	reader := makeTestHTTPReader(t, map[string]interface{}{
+		"path":          "/items",
+		"tokens":        []interface{}{"secret"},
+		"max-body-size": 1000,
+	})
+
+	url := fmt.Sprintf("http://%s/items", reader.Addr())
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	for _, test := range []struct {
+		contentType string
+		token       string
+		body        string
+		status      int
+	}{
+		{"", "secret", "{\"a\": 1}\n{\"a\": 2}\n", 200},
+		{"application/x-ndjson", "secret", "{\"a\": 1}\n", 200},
+		{"application/json; charset=utf-8", "secret", "[{\"a\": 1}, {\"a\": 2}]", 200},
+		{"text/csv", "secret", "a,b\n1,2\n", 200},
+		{"text/csv", "", "a,b\n1,2\n", 401},
+		{"text/csv", "wrong", "a,b\n1,2\n", 401},
+		{"application/yaml", "secret", "a: 1\n", 415},
+		{"application/x-ndjson", "secret", "{\"a\": ", 400},
+		{"application/x-ndjson", "secret", "{\"reject\": true}\n", 503},
+		{"application/x-ndjson", "secret", string(bytes.Repeat([]byte("{\"a\": 1}\n"), 200)), 413},
+	} {
+		if status := post(t, client, url, test.contentType, test.token, test.body); status != test.status {
+			t.Errorf("Expected status %d for %q, got %d", test.status, test.body, status)
+		}
+	}
+}
+
+func TestHTTPReaderTimeout(t *testing.T) {
+
+	reader, err := MakeHTTPReader(map[string]interface{}{
+		"address": "127.0.0.1:0",
+		"timeout": 0.1,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer reader.Teardown()
+
+	// nobody reads the payload
+	url := fmt.Sprintf("http://%s/", reader.(*HTTPReader).Addr())
+
+	if status := post(t, http.DefaultClient, url, "", "", "{\"a\": 1}\n"); status != 503 {
+		t.Errorf("Expected status 503, got %d", status)
+	}
+}
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	der         []byte
+}
+
+func makeTestCertificate(t *testing.T, name string, parent *testCertificate, isCA bool) *testCertificate {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+
+	signer, signerKey := template, key
+
+	if parent != nil {
+		signer, signerKey = parent.certificate, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCertificate{certificate: certificate, key: key, der: der}
+}
+
+func (c *testCertificate) write(t *testing.T, dir, name string) (string, string) {
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestHTTPReaderMutualTLS(t *testing.T) {
+
+	dir := t.TempDir()
+
+	ca := makeTestCertificate(t, "ca", nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := makeTestCertificate(t, "server", ca, false).write(t, dir, "server")
+	clientCertFile, clientKeyFile := makeTestCertificate(t, "client", ca, false).write(t, dir, "client")
+
+	reader := makeTestHTTPReader(t, map[string]interface{}{
+		"tls": map[string]interface{}{
+			"cert-file":      certFile,
+			"key-file":       keyFile,
+			"client-ca-file": caFile,
+		},
+	})
+
+	url := fmt.Sprintf("https://%s/", reader.Addr())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.certificate)
+
+	clientCertificate, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:      pool,
+				Certificates: []tls.Certificate{clientCertificate},
+			},
+		},
+	}
+
+	if status := post(t, client, url, "", "", "{\"a\": 1}\n"); status != 200 {
+		t.Errorf("Expected status 200, got %d", status)
+	}
+
+	// clients without a certificate are rejected
+	client = &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: pool,
+			},
+		},
+	}
+
+	req, _ := http.NewRequest("POST", url, bytes.NewBufferString("{\"a\": 1}\n"))
+
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+		t.Errorf("Expected an error without a client certificate")
+	}
+}
@@ -26,6 +26,11 @@ var Readers = kodex.ReaderDefinitions{
 		Form:     FileReaderForm,
 		Internal: true,
 	},
+	"files": kodex.ReaderDefinition{
+		Maker:    MakeFilesReader,
+		Form:     FilesReaderForm,
+		Internal: true,
+	},
 	"stdin": kodex.ReaderDefinition{
 		Maker:    MakeStdinReader,
 		Form:     StdinReaderForm,
@@ -36,6 +41,21 @@ var Readers = kodex.ReaderDefinitions{
 		Form:     GenerateForm,
 		Internal: true,
 	},
+	"http": kodex.ReaderDefinition{
+		Maker:    MakeHTTPReader,
+		Form:     HTTPReaderForm,
+		Internal: true,
+	},
+	"syslog": kodex.ReaderDefinition{
+		Maker:    MakeSyslogReader,
+		Form:     SyslogReaderForm,
+		Internal: true,
+	},
+	"sql": kodex.ReaderDefinition{
+		Maker:    MakeSQLReader,
+		Form:     SQLReaderForm,
+		Internal: true,
+	},
 	"bytes": kodex.ReaderDefinition{
 		Maker:    MakeBytesReader,
 		Form:     BytesReaderForm,
@@ -0,0 +1,466 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/sqldialect"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reads the rows of a query via database/sql. The driver needs to be
+// registered, e.g. by importing it in the main package or a plugin.
+//
+// With a cursor column we read the rows in chunks ordered by that column
+// and store the last value of every chunk in the state file once the chunk
+// has been acknowledged, so that the next run only reads new rows. The column
+// needs to be unique and strictly increasing, otherwise rows can be skipped.
+type SQLReader struct {
+	Driver       string
+	DSN          string
+	Dialect      string
+	Query        string
+	CursorColumn string
+	StateFile    string
+	Follow       bool
+	PollInterval time.Duration
+	ChunkSize    int
+	Headers      map[string]interface{}
+	dialect      *sqldialect.Dialect
+	db           *sql.DB
+	rows         *sql.Rows
+	state        *SQLState
+	endOfStream  bool
+	checkpoints  []*sqlCheckpoint
+	mutex        sync.Mutex
+}
+
+// The cursor after a given chunk, which we save once the chunk and all
+// chunks before it have been acknowledged.
+type sqlCheckpoint struct {
+	state        SQLState
+	acknowledged bool
+}
+
+// A chunk of rows read with a cursor
+type SQLPayload struct {
+	*kodex.BasicPayload
+	reader     *SQLReader
+	checkpoint *sqlCheckpoint
+}
+
+func (p *SQLPayload) Acknowledge() error {
+	return p.reader.commit(p.checkpoint)
+}
+
+// The cursor of the SQL reader. We store the type of the value so that we
+// can restore it with the right type.
+type SQLState struct {
+	Cursor     interface{} `json:"cursor"`
+	CursorType string      `json:"cursor-type,omitempty"`
+}
+
+func (s *SQLReader) Purge() error {
+	return nil
+}
+
+func (s *SQLReader) Setup(stream kodex.Stream) error {
+
+	var err error
+
+	if s.dialect, err = sqldialect.Get(s.Dialect, s.Driver); err != nil {
+		return err
+	}
+
+	if s.db, err = sql.Open(s.Driver, s.DSN); err != nil {
+		return err
+	}
+
+	if err := s.db.Ping(); err != nil {
+		s.db.Close()
+		s.db = nil
+		return err
+	}
+
+	s.endOfStream = false
+
+	s.mutex.Lock()
+	s.checkpoints = nil
+	s.mutex.Unlock()
+
+	return s.loadState()
+}
+
+func (s *SQLReader) Teardown() error {
+
+	if s.rows != nil {
+		s.rows.Close()
+		s.rows = nil
+	}
+
+	// the state file gets updated when payloads are acknowledged
+	if s.db != nil {
+		if err := s.db.Close(); err != nil {
+			return err
+		}
+		s.db = nil
+	}
+
+	return nil
+}
+
+func (s *SQLReader) Read() (kodex.Payload, error) {
+	return s.ReadContext(context.Background())
+}
+
+func (s *SQLReader) ReadContext(ctx context.Context) (kodex.Payload, error) {
+
+	if s.db == nil {
+		return nil, fmt.Errorf("reader is not set up")
+	}
+
+	if s.endOfStream {
+		return nil, nil
+	}
+
+	var items []*kodex.Item
+	var done bool
+	var err error
+
+	if s.CursorColumn == "" {
+		items, done, err = s.readAll(ctx)
+	} else {
+		items, done, err = s.readIncremental(ctx)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	kodex.Log.Debugf("Read %d rows...", len(items))
+
+	if done && !s.Follow {
+		s.endOfStream = true
+		return s.payload(items, true), nil
+	}
+
+	if len(items) == 0 {
+		select {
+		case <-time.After(s.PollInterval):
+		case <-ctx.Done():
+		}
+		return nil, nil
+	}
+
+	return s.payload(items, false), nil
+}
+
+// Returns a payload for the given items. With a cursor, the payload saves
+// the cursor after the items when it gets acknowledged.
+func (s *SQLReader) payload(items []*kodex.Item, endOfStream bool) kodex.Payload {
+
+	payload := kodex.MakeBasicPayload(items, s.Headers, endOfStream)
+
+	if s.CursorColumn == "" || len(items) == 0 {
+		return payload
+	}
+
+	checkpoint := &sqlCheckpoint{state: *s.state}
+
+	s.mutex.Lock()
+	s.checkpoints = append(s.checkpoints, checkpoint)
+	s.mutex.Unlock()
+
+	return &SQLPayload{
+		BasicPayload: payload,
+		reader:       s,
+		checkpoint:   checkpoint,
+	}
+}
+
+// Marks the given checkpoint as acknowledged and saves the latest cursor
+// for which all chunks up to it have been acknowledged.
+func (s *SQLReader) commit(checkpoint *sqlCheckpoint) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	checkpoint.acknowledged = true
+
+	var last *sqlCheckpoint
+
+	for len(s.checkpoints) > 0 && s.checkpoints[0].acknowledged {
+		last = s.checkpoints[0]
+		s.checkpoints = s.checkpoints[1:]
+	}
+
+	if last == nil {
+		return nil
+	}
+
+	return s.saveState(&last.state)
+}
+
+// Reads the next chunk of rows of the query
+func (s *SQLReader) readAll(ctx context.Context) ([]*kodex.Item, bool, error) {
+
+	// the rows stay bound to the context of the read that ran the query
+	if s.rows == nil {
+		var err error
+		if s.rows, err = s.db.QueryContext(ctx, s.Query); err != nil {
+			return nil, false, err
+		}
+	}
+
+	items, done, err := scanRows(s.rows, s.ChunkSize)
+
+	if done || err != nil {
+		s.rows.Close()
+		s.rows = nil
+	}
+
+	return items, done, err
+}
+
+// Reads the next chunk of rows after the cursor
+func (s *SQLReader) readIncremental(ctx context.Context) ([]*kodex.Item, bool, error) {
+
+	var args []interface{}
+
+	if s.state.Cursor != nil {
+		args = append(args, s.state.Cursor)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.Incremental(s.Query, s.CursorColumn, s.state.Cursor != nil, s.ChunkSize), args...)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer rows.Close()
+
+	items, _, err := scanRows(rows, 0)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(items) > 0 {
+
+		cursor, ok := items[len(items)-1].Get(s.CursorColumn)
+
+		if !ok || cursor == nil {
+			return nil, false, fmt.Errorf("cursor column '%s' is missing or NULL", s.CursorColumn)
+		}
+
+		// the next read continues after the cursor, we save it once the
+		// items have been acknowledged
+		if err := s.state.setCursor(cursor); err != nil {
+			return nil, false, err
+		}
+	}
+
+	// if we got less rows than requested there are no more rows for now
+	return items, len(items) < s.ChunkSize, nil
+}
+
+// Turns up to limit rows (or all rows if limit is 0) into items. Returns
+// true if there are no more rows.
+func scanRows(rows *sql.Rows, limit int) ([]*kodex.Item, bool, error) {
+
+	columns, err := rows.ColumnTypes()
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	items := make([]*kodex.Item, 0, limit)
+
+	for limit == 0 || len(items) < limit {
+
+		if !rows.Next() {
+			return items, true, rows.Err()
+		}
+
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, false, err
+		}
+
+		data := make(map[string]interface{}, len(columns))
+
+		for i, column := range columns {
+			data[column.Name()] = sqlValue(values[i], column)
+		}
+
+		items = append(items, kodex.MakeItem(data))
+	}
+
+	return items, false, nil
+}
+
+// Many drivers return text as bytes, we convert it to strings unless the
+// column contains binary data.
+func sqlValue(value interface{}, column *sql.ColumnType) interface{} {
+
+	data, ok := value.([]byte)
+
+	if !ok {
+		return value
+	}
+
+	typeName := strings.ToUpper(column.DatabaseTypeName())
+
+	if strings.Contains(typeName, "BLOB") || strings.Contains(typeName, "BINARY") || typeName == "BYTEA" || typeName == "IMAGE" {
+		return append([]byte{}, data...)
+	}
+
+	return string(data)
+}
+
+func (s *SQLState) setCursor(value interface{}) error {
+	switch v := value.(type) {
+	case int64:
+		s.Cursor, s.CursorType = v, "int"
+	case float64:
+		s.Cursor, s.CursorType = v, "float"
+	case string:
+		s.Cursor, s.CursorType = v, "string"
+	case time.Time:
+		s.Cursor, s.CursorType = v, "time"
+	default:
+		return fmt.Errorf("unsupported cursor type %T", value)
+	}
+	return nil
+}
+
+// Restores the type of the cursor after decoding it from JSON
+func (s *SQLState) restoreCursor() error {
+
+	if s.Cursor == nil {
+		return nil
+	}
+
+	var err error
+
+	switch s.CursorType {
+	case "int":
+		if number, ok := s.Cursor.(json.Number); ok {
+			s.Cursor, err = number.Int64()
+		}
+	case "float":
+		if number, ok := s.Cursor.(json.Number); ok {
+			s.Cursor, err = number.Float64()
+		}
+	case "time":
+		if str, ok := s.Cursor.(string); ok {
+			s.Cursor, err = time.Parse(time.RFC3339Nano, str)
+		}
+	}
+
+	return err
+}
+
+func (s *SQLReader) loadState() error {
+
+	s.state = &SQLState{}
+
+	if s.StateFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.StateFile)
+
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(s.state); err != nil {
+		return fmt.Errorf("invalid state file: %w", err)
+	}
+
+	if err := s.state.restoreCursor(); err != nil {
+		return fmt.Errorf("invalid state file: %w", err)
+	}
+
+	return nil
+}
+
+// Writes the state to a temporary file that we then move into place, so that
+// the state file is always complete.
+func (s *SQLReader) saveState(state *SQLState) error {
+
+	if s.StateFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.StateFile + ".tmp"
+
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, s.StateFile)
+}
+
+func MakeSQLReader(config map[string]interface{}) (kodex.Reader, error) {
+	if params, err := SQLReaderForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		reader := &SQLReader{
+			Driver:       params["driver"].(string),
+			DSN:          params["dsn"].(string),
+			Dialect:      params["dialect"].(string),
+			Query:        params["query"].(string),
+			CursorColumn: params["cursor-column"].(string),
+			StateFile:    params["state-file"].(string),
+			Follow:       params["follow"].(bool),
+			PollInterval: time.Duration(params["poll-interval"].(float64) * float64(time.Second)),
+			ChunkSize:    int(params["chunk-size"].(int64)),
+			Headers:      params["headers"].(map[string]interface{}),
+		}
+		if reader.Follow && reader.CursorColumn == "" {
+			return nil, fmt.Errorf("follow mode requires a cursor column")
+		}
+		return reader, nil
+	}
+}
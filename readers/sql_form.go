@@ -0,0 +1,107 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/sqldialect"
+)
+
+var SQLReaderForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the SQL reader form",
+	Fields: []forms.Field{
+		{
+			// the name of a registered database/sql driver, e.g. sqlite
+			Name: "driver",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "dsn",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			// if not given we guess the dialect from the driver name
+			Name: "dialect",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsIn{Choices: append([]interface{}{""}, sqldialect.Names...)},
+			},
+		},
+		{
+			// a SELECT query, e.g. SELECT id, email FROM users
+			Name: "query",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			// a unique and strictly increasing column of the query (e.g. an
+			// auto-incrementing ID). If given, we read rows in the order of
+			// this column and remember the last value between runs.
+			Name: "cursor-column",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// the file in which we store the cursor
+			Name: "state-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// if true we keep polling for new rows (requires a cursor column)
+			Name: "follow",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: false},
+				forms.IsBoolean{},
+			},
+		},
+		{
+			// how long we wait before polling for new rows (in seconds)
+			Name: "poll-interval",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 5.0},
+				forms.IsFloat{HasMin: true, Min: 0.01},
+			},
+		},
+		{
+			Name: "chunk-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 100},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			Name: "headers",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+	},
+}
@@ -0,0 +1,257 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"database/sql"
+	"github.com/kiprotect/kodex"
+	_ "modernc.org/sqlite"
+	"path/filepath"
+	"testing"
+)
+
+func makeTestDB(t *testing.T) (*sql.DB, string) {
+
+	dsn := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := sql.Open("sqlite", dsn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, avatar BLOB)"); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, dsn
+}
+
+func insertTestUsers(t *testing.T, db *sql.DB, from, to int) {
+	for i := from; i <= to; i++ {
+		if _, err := db.Exec("INSERT INTO users (id, email, avatar) VALUES (?, ?, ?)", i, "user@example.com", []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Reads all payloads until the end of the stream and returns the IDs.
+func readTestUsers(t *testing.T, config map[string]interface{}) []int64 {
+
+	reader, err := MakeSQLReader(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer reader.Teardown()
+
+	ids := make([]int64, 0)
+
+	for {
+		payload, err := reader.Read()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if payload == nil {
+			t.Fatal("expected a payload")
+		}
+
+		if len(payload.Items()) > config["chunk-size"].(int) {
+			t.Fatalf("Expected at most %d items per payload", config["chunk-size"])
+		}
+
+		for _, item := range payload.Items() {
+			id, _ := item.Get("id")
+			ids = append(ids, id.(int64))
+			if email, _ := item.Get("email"); email != "user@example.com" {
+				t.Errorf("Unexpected email: %v", email)
+			}
+			if avatar, _ := item.Get("avatar"); avatar.([]byte)[0] != byte(id.(int64)) {
+				t.Errorf("Unexpected avatar: %v", avatar)
+			}
+		}
+
+		if err := payload.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+
+		if payload.EndOfStream() {
+			return ids
+		}
+	}
+}
+
+func TestSQLReader(t *testing.T) {
+
+	db, dsn := makeTestDB(t)
+
+	insertTestUsers(t, db, 1, 5)
+
+	config := map[string]interface{}{
+		"driver":     "sqlite",
+		"dsn":        dsn,
+		"query":      "SELECT * FROM users",
+		"chunk-size": 2,
+	}
+
+	if ids := readTestUsers(t, config); len(ids) != 5 {
+		t.Fatalf("Expected five rows, got %v", ids)
+	}
+
+	config["cursor-column"] = "id"
+	config["state-file"] = filepath.Join(t.TempDir(), "state.json")
+
+	// the rows are read in the order of the cursor
+	if ids := readTestUsers(t, config); len(ids) != 5 || ids[0] != 1 || ids[4] != 5 {
+		t.Fatalf("Unexpected rows: %v", ids)
+	}
+
+	insertTestUsers(t, db, 6, 8)
+
+	// we only read the new rows
+	if ids := readTestUsers(t, config); len(ids) != 3 || ids[0] != 6 || ids[2] != 8 {
+		t.Fatalf("Unexpected rows: %v", ids)
+	}
+
+	if ids := readTestUsers(t, config); len(ids) != 0 {
+		t.Fatalf("Expected no rows, got %v", ids)
+	}
+}
+
+func TestSQLReaderAcknowledge(t *testing.T) {
+
+	db, dsn := makeTestDB(t)
+
+	insertTestUsers(t, db, 1, 4)
+
+	config := map[string]interface{}{
+		"driver":        "sqlite",
+		"dsn":           dsn,
+		"query":         "SELECT * FROM users",
+		"chunk-size":    2,
+		"cursor-column": "id",
+		"state-file":    filepath.Join(t.TempDir(), "state.json"),
+	}
+
+	reader, err := MakeSQLReader(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := reader.Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := reader.Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first.Items()) != 2 || len(second.Items()) != 2 {
+		t.Fatalf("Expected two chunks of two rows")
+	}
+
+	// the second chunk alone does not move the cursor, as the first one
+	// has not been acknowledged yet
+	if err := second.Acknowledge(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the unacknowledged rows get delivered again
+	if ids := readTestUsers(t, config); len(ids) != 4 || ids[0] != 1 {
+		t.Fatalf("Unexpected rows: %v", ids)
+	}
+
+	if ids := readTestUsers(t, config); len(ids) != 0 {
+		t.Fatalf("Expected no rows, got %v", ids)
+	}
+}
+
+func TestSQLReaderFollow(t *testing.T) {
+
+	db, dsn := makeTestDB(t)
+
+	insertTestUsers(t, db, 1, 2)
+
+	reader, err := MakeSQLReader(map[string]interface{}{
+		"driver":        "sqlite",
+		"dsn":           dsn,
+		"query":         "SELECT id FROM users",
+		"cursor-column": "id",
+		"follow":        true,
+		"poll-interval": 0.01,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer reader.Teardown()
+
+	read := func() []*kodex.Item {
+		payload, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload == nil {
+			return nil
+		}
+		if payload.EndOfStream() {
+			t.Fatal("Unexpected end of stream")
+		}
+		return payload.Items()
+	}
+
+	if items := read(); len(items) != 2 {
+		t.Fatalf("Expected two items, got %d", len(items))
+	}
+
+	if items := read(); items != nil {
+		t.Fatalf("Expected no items, got %d", len(items))
+	}
+
+	insertTestUsers(t, db, 3, 3)
+
+	if items := read(); len(items) != 1 {
+		t.Fatalf("Expected one item, got %d", len(items))
+	}
+}
@@ -18,20 +18,29 @@ package readers
 
 import (
 	"bufio"
-	"compress/gzip"
-	"encoding/json"
+	"context"
 	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/compression"
 	"io"
 	"os"
 )
 
 type StdinReader struct {
-	Reader     *bufio.Reader
-	GzReader   *gzip.Reader
-	Format     string
-	Compressed bool
-	Headers    map[string]interface{}
-	ChunkSize  int
+	Reader       *bufio.Reader
+	Decompressor io.ReadCloser
+	Format       string
+	FormatConfig map[string]interface{}
+	Compression  string
+	Headers      map[string]interface{}
+	ChunkSize    int
+	decoder      kodex.ItemDecoder
+	pending      chan stdinResult
+}
+
+type stdinResult struct {
+	items       []*kodex.Item
+	endOfStream bool
+	err         error
 }
 
 type StdinPayload struct {
@@ -62,30 +71,26 @@ func (f *StdinReader) Purge() error {
 
 func (s *StdinReader) Setup(stream kodex.Stream) error {
 
-	var reader io.Reader
 	var err error
 
-	if s.Compressed {
-		s.GzReader, err = gzip.NewReader(os.Stdin)
-		if err != nil {
-			return err
-		}
-		reader = s.GzReader
-	} else {
-		reader = os.Stdin
+	// with the "auto" codec we detect the compression from the data
+	if s.Decompressor, err = compression.NewReader(os.Stdin, s.Compression, ""); err != nil {
+		return err
 	}
 
-	s.Reader = bufio.NewReader(reader)
+	s.Reader = bufio.NewReader(s.Decompressor)
+
+	if s.decoder, err = makeItemDecoder(stream, s.Reader, s.Format, s.FormatConfig); err != nil {
+		return err
+	}
 
 	return nil
 
 }
 
 func (s *StdinReader) Teardown() error {
-	if s.GzReader != nil {
-		if err := s.GzReader.Close(); err != nil {
-			return err
-		}
+	if s.Decompressor != nil {
+		return s.Decompressor.Close()
 	}
 	return nil
 }
@@ -104,6 +109,10 @@ func (f *StdinPayload) Headers() map[string]interface{} {
 }
 
 func (s *StdinReader) Read() (kodex.Payload, error) {
+	return s.ReadContext(context.Background())
+}
+
+func (s *StdinReader) ReadContext(ctx context.Context) (kodex.Payload, error) {
 
 	payload, err := s.MakeStdinPayload()
 
@@ -111,33 +120,29 @@ func (s *StdinReader) Read() (kodex.Payload, error) {
 		return nil, err
 	}
 
-	items := make([]*kodex.Item, 0)
-
-	endOfStdin := false
-
-	for i := 0; i < s.ChunkSize; i++ {
-		item := make(map[string]interface{})
-		line, err := s.Reader.ReadBytes('\n')
-		if err == io.EOF {
-			endOfStdin = true
-			if len(line) == 0 {
-				break
-			}
-		} else if err != nil {
-			return nil, err
-		}
-		if len(line) <= 1 {
-			continue
-		}
-		switch s.Format {
-		case "json":
-			err := json.Unmarshal(line, &item)
-			if err != nil {
-				return nil, err
-			}
-			item := kodex.MakeItem(item)
-			items = append(items, item)
-		}
+	// reading from stdin blocks, so we read in the background and keep the
+	// result for the next call if the context gets cancelled before
+	if s.pending == nil {
+		s.pending = make(chan stdinResult, 1)
+		go func(pending chan stdinResult) {
+			items, endOfStdin, err := readItems(s.decoder, s.ChunkSize)
+			pending <- stdinResult{items: items, endOfStream: endOfStdin, err: err}
+		}(s.pending)
+	}
+
+	var result stdinResult
+
+	select {
+	case result = <-s.pending:
+		s.pending = nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	items, endOfStdin := result.items, result.endOfStream
+
+	if result.err != nil {
+		return nil, result.err
 	}
 
 	kodex.Log.Debugf("Read %d items... (%v)", len(items), endOfStdin)
@@ -154,10 +159,11 @@ func MakeStdinReader(config map[string]interface{}) (kodex.Reader, error) {
 		return nil, err
 	} else {
 		return &StdinReader{
-			ChunkSize:  int(params["chunk-size"].(int64)),
-			Headers:    params["headers"].(map[string]interface{}),
-			Format:     params["format"].(string),
-			Compressed: params["compressed"].(bool),
+			ChunkSize:    int(params["chunk-size"].(int64)),
+			Headers:      params["headers"].(map[string]interface{}),
+			Format:       params["format"].(string),
+			FormatConfig: params["format-config"].(map[string]interface{}),
+			Compression:  params["compressed"].(string),
 		}, nil
 	}
 }
@@ -18,6 +18,7 @@ package readers
 
 import (
 	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/compression"
 )
 
 var StdinReaderForm = forms.Form{
@@ -27,14 +28,22 @@ var StdinReaderForm = forms.Form{
 			Name: "format",
 			Validators: []forms.Validator{
 				forms.IsRequired{},
-				forms.IsIn{Choices: []interface{}{"json"}},
+				forms.IsString{},
 			},
 		},
 		{
+			Name: "format-config",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+		{
+			// a compression codec, "auto" to detect it or "none"
 			Name: "compressed",
 			Validators: []forms.Validator{
 				forms.IsOptional{Default: false},
-				forms.IsBoolean{},
+				compression.IsCodec{},
 			},
 		},
 		{
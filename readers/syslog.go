@@ -0,0 +1,374 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/syslog"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Receives syslog messages via UDP, TCP or TLS and turns them into items.
+// Over TCP and TLS we support octet counting and newline-delimited framing
+// (RFC 6587).
+type SyslogReader struct {
+	Address        string
+	Protocol       string
+	TLS            map[string]interface{}
+	MaxMessageSize int
+	ChunkSize      int
+	Headers        map[string]interface{}
+	listener       net.Listener
+	packetConn     net.PacketConn
+	connections    map[net.Conn]bool
+	items          chan *kodex.Item
+	stop           chan bool
+	wg             sync.WaitGroup
+	mutex          sync.Mutex
+}
+
+func (s *SyslogReader) Purge() error {
+	return nil
+}
+
+func (s *SyslogReader) Setup(stream kodex.Stream) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.listener != nil || s.packetConn != nil {
+		return nil
+	}
+
+	s.items = make(chan *kodex.Item, s.ChunkSize*10)
+	s.stop = make(chan bool)
+	s.connections = make(map[net.Conn]bool)
+
+	switch s.Protocol {
+	case "udp":
+		conn, err := net.ListenPacket("udp", s.Address)
+		if err != nil {
+			return err
+		}
+		s.packetConn = conn
+		s.wg.Add(1)
+		go s.receivePackets(conn)
+	case "tcp", "tls":
+		listener, err := net.Listen("tcp", s.Address)
+		if err != nil {
+			return err
+		}
+		if s.Protocol == "tls" {
+			if s.TLS == nil {
+				listener.Close()
+				return fmt.Errorf("a TLS config is required")
+			}
+			tlsConfig, err := makeTLSConfig(s.TLS)
+			if err != nil {
+				listener.Close()
+				return err
+			}
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		s.listener = listener
+		s.wg.Add(1)
+		go s.accept(listener)
+	default:
+		return fmt.Errorf("unknown protocol: %s", s.Protocol)
+	}
+
+	return nil
+}
+
+// Returns the address we listen on, which is useful if the port was chosen
+// automatically.
+func (s *SyslogReader) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener != nil {
+		return s.listener.Addr()
+	} else if s.packetConn != nil {
+		return s.packetConn.LocalAddr()
+	}
+	return nil
+}
+
+func (s *SyslogReader) Teardown() error {
+
+	s.mutex.Lock()
+
+	if s.listener == nil && s.packetConn == nil {
+		s.mutex.Unlock()
+		return nil
+	}
+
+	close(s.stop)
+
+	var err error
+
+	if s.listener != nil {
+		err = s.listener.Close()
+		s.listener = nil
+	}
+
+	if s.packetConn != nil {
+		err = s.packetConn.Close()
+		s.packetConn = nil
+	}
+
+	for conn := range s.connections {
+		conn.Close()
+	}
+
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *SyslogReader) Read() (kodex.Payload, error) {
+	return s.ReadContext(context.Background())
+}
+
+func (s *SyslogReader) ReadContext(ctx context.Context) (kodex.Payload, error) {
+
+	s.mutex.Lock()
+	items, stop := s.items, s.stop
+	s.mutex.Unlock()
+
+	if items == nil {
+		return nil, fmt.Errorf("reader is not set up")
+	}
+
+	payloadItems := make([]*kodex.Item, 0, s.ChunkSize)
+
+	// we wait for the first item...
+	select {
+	case item := <-items:
+		payloadItems = append(payloadItems, item)
+	case <-stop:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// ...and add all other items that are available
+	for len(payloadItems) < s.ChunkSize {
+		select {
+		case item := <-items:
+			payloadItems = append(payloadItems, item)
+		default:
+			return kodex.MakeBasicPayload(payloadItems, s.Headers, false), nil
+		}
+	}
+
+	return kodex.MakeBasicPayload(payloadItems, s.Headers, false), nil
+}
+
+func (s *SyslogReader) submit(data []byte) bool {
+
+	values, err := syslog.Parse(data)
+
+	if err != nil {
+		// we keep messages that we can't parse
+		kodex.Log.Warningf("Cannot parse syslog message: %v", err)
+		values = map[string]interface{}{syslog.Msg: string(data)}
+	}
+
+	select {
+	case s.items <- kodex.MakeItem(values):
+		return true
+	case <-s.stop:
+		return false
+	}
+}
+
+func (s *SyslogReader) receivePackets(conn net.PacketConn) {
+
+	defer s.wg.Done()
+
+	buf := make([]byte, s.MaxMessageSize)
+
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.stop:
+			default:
+				kodex.Log.Error(err)
+			}
+			return
+		}
+		if n > 0 && !s.submit(buf[:n]) {
+			return
+		}
+	}
+}
+
+func (s *SyslogReader) accept(listener net.Listener) {
+
+	defer s.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			select {
+			case <-s.stop:
+			default:
+				kodex.Log.Error(err)
+			}
+			return
+		}
+
+		s.mutex.Lock()
+		select {
+		case <-s.stop:
+			conn.Close()
+			s.mutex.Unlock()
+			return
+		default:
+		}
+		s.connections[conn] = true
+		s.wg.Add(1)
+		s.mutex.Unlock()
+
+		go s.receive(conn)
+	}
+}
+
+func (s *SyslogReader) receive(conn net.Conn) {
+
+	defer func() {
+		conn.Close()
+		s.mutex.Lock()
+		delete(s.connections, conn)
+		s.mutex.Unlock()
+		s.wg.Done()
+	}()
+
+	reader := bufio.NewReaderSize(conn, s.MaxMessageSize+16)
+
+	for {
+		message, err := s.readFrame(reader)
+
+		if err != nil {
+			if err != io.EOF {
+				select {
+				case <-s.stop:
+				default:
+					kodex.Log.Warningf("Closing syslog connection from %s: %v", conn.RemoteAddr(), err)
+				}
+			}
+			return
+		}
+
+		if len(message) > 0 && !s.submit(message) {
+			return
+		}
+	}
+}
+
+// Reads a message using octet counting ("12 <13>1 - ...") or a newline as
+// delimiter.
+func (s *SyslogReader) readFrame(reader *bufio.Reader) ([]byte, error) {
+
+	first, err := reader.Peek(1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+
+		var length []byte
+
+		// the length has at most 10 digits
+		for i := 2; ; i++ {
+			if length, err = reader.Peek(i); err != nil {
+				return nil, err
+			}
+			if length[i-1] == ' ' {
+				break
+			} else if i > 10 || length[i-1] < '0' || length[i-1] > '9' {
+				return nil, fmt.Errorf("invalid message length")
+			}
+		}
+
+		n, err := strconv.Atoi(string(length[:len(length)-1]))
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid message length")
+		}
+
+		reader.Discard(len(length))
+
+		if n > s.MaxMessageSize {
+			return nil, fmt.Errorf("message too long")
+		}
+
+		message := make([]byte, n)
+
+		if _, err := io.ReadFull(reader, message); err != nil {
+			return nil, err
+		}
+
+		return message, nil
+	}
+
+	line, err := reader.ReadSlice('\n')
+
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("message too long")
+	} else if err == io.EOF && len(line) > 0 {
+		// the last message might not be terminated
+		return append([]byte{}, line...), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{}, line...), nil
+}
+
+func MakeSyslogReader(config map[string]interface{}) (kodex.Reader, error) {
+	if params, err := SyslogReaderForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		reader := &SyslogReader{
+			Address:        params["address"].(string),
+			Protocol:       params["protocol"].(string),
+			MaxMessageSize: int(params["max-message-size"].(int64)),
+			ChunkSize:      int(params["chunk-size"].(int64)),
+			Headers:        params["headers"].(map[string]interface{}),
+		}
+		if tlsConfig, ok := params["tls"].(map[string]interface{}); ok {
+			reader.TLS = tlsConfig
+		}
+		if reader.Protocol == "tls" && reader.TLS == nil {
+			return nil, fmt.Errorf("a TLS config is required")
+		}
+		return reader, nil
+	}
+}
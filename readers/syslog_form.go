@@ -0,0 +1,74 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+var SyslogReaderForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the syslog reader form",
+	Fields: []forms.Field{
+		{
+			// the address to listen on, e.g. 0.0.0.0:514
+			Name: "address",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "protocol",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "udp"},
+				forms.IsIn{Choices: []interface{}{"udp", "tcp", "tls"}},
+			},
+		},
+		{
+			// required for the TLS protocol
+			Name: "tls",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &TLSForm,
+				},
+			},
+		},
+		{
+			// longer messages are truncated (UDP) or rejected (TCP)
+			Name: "max-message-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 64 * 1024},
+				forms.IsInteger{HasMin: true, Min: 480, HasMax: true, Max: 16 * 1024 * 1024},
+			},
+		},
+		{
+			Name: "chunk-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 100},
+				forms.IsInteger{HasMin: true, Min: 1, HasMax: true, Max: 10000},
+			},
+		},
+		{
+			Name: "headers",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+	},
+}
@@ -0,0 +1,220 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package readers
+
+import (
+	"context"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/syslog"
+	"github.com/kiprotect/kodex/writers"
+	"net"
+	"testing"
+	"time"
+)
+
+func makeTestSyslogReader(t *testing.T, config map[string]interface{}) *SyslogReader {
+
+	config["address"] = "127.0.0.1:0"
+
+	reader, err := MakeSyslogReader(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { reader.Teardown() })
+
+	return reader.(*SyslogReader)
+}
+
+// Reads payloads until we have n items
+func readSyslogItems(t *testing.T, reader *SyslogReader, n int) []*kodex.Item {
+	items := make([]*kodex.Item, 0, n)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for len(items) < n {
+		payload, err := reader.ReadContext(ctx)
+		if err == context.DeadlineExceeded {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if payload != nil {
+			items = append(items, payload.Items()...)
+		}
+	}
+	if len(items) != n {
+		t.Fatalf("Expected %d items, got %d", n, len(items))
+	}
+	return items
+}
+
+func TestSyslogReaderUDP(t *testing.T) {
+
+	reader := makeTestSyslogReader(t, map[string]interface{}{
+		"protocol": "udp",
+	})
+
+	conn, err := net.Dial("udp", reader.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	for _, message := range []string{
+		"<34>1 2003-10-11T22:14:15.003Z host su - ID47 - 'su root' failed for lonvick",
+		"<13>Feb  5 17:32:18 host sshd[42]: Accepted publickey for alice from 10.0.0.1",
+		"not a syslog message",
+	} {
+		if _, err := conn.Write([]byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items := readSyslogItems(t, reader, 3)
+
+	if app, _ := items[0].Get(syslog.App); app != "su" {
+		t.Errorf("Expected app su, got %v", app)
+	}
+
+	if procID, _ := items[1].Get(syslog.ProcID); procID != "42" {
+		t.Errorf("Expected process ID 42, got %v", procID)
+	}
+
+	// we keep messages that we cannot parse
+	if msg, _ := items[2].Get(syslog.Msg); msg != "not a syslog message" {
+		t.Errorf("Unexpected message: %v", msg)
+	}
+}
+
+func TestSyslogReaderTCP(t *testing.T) {
+
+	reader := makeTestSyslogReader(t, map[string]interface{}{
+		"protocol":         "tcp",
+		"max-message-size": 1000,
+	})
+
+	conn, err := net.Dial("tcp", reader.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	// octet counting and newline-delimited framing can be mixed
+	if _, err := conn.Write([]byte("24 <13>1 - host app - - - a" + "<13>1 - host app - - - b\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	items := readSyslogItems(t, reader, 2)
+
+	for i, expected := range []string{"a", "b"} {
+		if msg, _ := items[i].Get(syslog.Msg); msg != expected {
+			t.Errorf("Expected message %q, got %q", expected, msg)
+		}
+	}
+
+	// we close connections that send messages that are too long
+	conn.Write([]byte("5000 <13>"))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+}
+
+func TestSyslogWriterTLS(t *testing.T) {
+
+	dir := t.TempDir()
+
+	ca := makeTestCertificate(t, "ca", nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := makeTestCertificate(t, "server", ca, false).write(t, dir, "server")
+	clientCertFile, clientKeyFile := makeTestCertificate(t, "client", ca, false).write(t, dir, "client")
+
+	reader := makeTestSyslogReader(t, map[string]interface{}{
+		"protocol": "tls",
+		"tls": map[string]interface{}{
+			"cert-file":      certFile,
+			"key-file":       keyFile,
+			"client-ca-file": caFile,
+		},
+	})
+
+	for _, format := range []string{"rfc5424", "rfc3164"} {
+
+		writer, err := writers.MakeSyslogWriter(map[string]interface{}{
+			"address":  reader.Addr().String(),
+			"protocol": "tls",
+			"format":   format,
+			"tls": map[string]interface{}{
+				"ca-file":   caFile,
+				"cert-file": clientCertFile,
+				"key-file":  clientKeyFile,
+			},
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := writer.Setup(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		items := []*kodex.Item{
+			kodex.MakeItem(map[string]interface{}{
+				syslog.Facility: 4.0,
+				syslog.Severity: 2.0,
+				syslog.Hostname: "host",
+				syslog.App:      "su",
+				syslog.Msg:      "login failed for 3f9a",
+			}),
+			kodex.MakeItem(map[string]interface{}{
+				syslog.Msg: "second message",
+			}),
+		}
+
+		if err := writer.Write(kodex.MakeBasicPayload(items, nil, false)); err != nil {
+			t.Fatal(err)
+		}
+
+		writer.Teardown()
+
+		received := readSyslogItems(t, reader, 2)
+
+		if msg, _ := received[0].Get(syslog.Msg); msg != "login failed for 3f9a" {
+			t.Errorf("%s: unexpected message %v", format, msg)
+		}
+
+		if facility, _ := received[0].Get(syslog.Facility); facility != int64(4) {
+			t.Errorf("%s: unexpected facility %v", format, facility)
+		}
+
+		if protocol, _ := received[1].Get(syslog.Protocol); protocol != format {
+			t.Errorf("Expected protocol %s, got %v", format, protocol)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package sqldialect contains the differences between SQL databases that
+// matter to the SQL reader and writer, like placeholders, identifier quoting
+// and upserts.
+package sqldialect
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	Postgres = "postgres"
+	MySQL    = "mysql"
+	SQLite   = "sqlite"
+	Generic  = "generic"
+)
+
+var Names = []interface{}{Postgres, MySQL, SQLite, Generic}
+
+type Dialect struct {
+	Name string
+}
+
+// Returns the dialect with the given name. If the name is empty we guess the
+// dialect from the name of the database/sql driver.
+func Get(name, driver string) (*Dialect, error) {
+
+	if name == "" {
+		switch driver {
+		case "postgres", "pgx", "pq":
+			name = Postgres
+		case "mysql":
+			name = MySQL
+		case "sqlite", "sqlite3":
+			name = SQLite
+		default:
+			name = Generic
+		}
+	}
+
+	for _, n := range Names {
+		if n == name {
+			return &Dialect{Name: name}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown SQL dialect: %s", name)
+}
+
+// Returns the placeholder for the i-th argument (starting at 1).
+func (d *Dialect) Placeholder(i int) string {
+	if d.Name == Postgres {
+		return fmt.Sprintf("$%d", i)
+	}
+	return "?"
+}
+
+// Quotes an identifier like a table or column name. Names containing dots
+// (e.g. schema.table) are quoted part by part.
+func (d *Dialect) Quote(identifier string) string {
+
+	quote := `"`
+
+	if d.Name == MySQL {
+		quote = "`"
+	}
+
+	parts := strings.Split(identifier, ".")
+
+	for i, part := range parts {
+		parts[i] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// Returns an INSERT statement for the given columns. If keys are given we
+// update existing rows with the same keys instead (an upsert).
+func (d *Dialect) Insert(table string, columns []string, keys []string) string {
+
+	quoted := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+
+	for i, column := range columns {
+		quoted[i] = d.Quote(column)
+		placeholders[i] = d.Placeholder(i + 1)
+	}
+
+	statement := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", d.Quote(table), strings.Join(quoted, ", "), strings.Join(placeholders, ", "))
+
+	if len(keys) == 0 {
+		return statement
+	}
+
+	isKey := make(map[string]bool, len(keys))
+	quotedKeys := make([]string, len(keys))
+
+	for i, key := range keys {
+		isKey[key] = true
+		quotedKeys[i] = d.Quote(key)
+	}
+
+	updates := make([]string, 0, len(columns))
+
+	for i, column := range columns {
+		if isKey[column] {
+			continue
+		}
+		if d.Name == MySQL {
+			updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", quoted[i], quoted[i]))
+		} else {
+			updates = append(updates, fmt.Sprintf("%s = excluded.%s", quoted[i], quoted[i]))
+		}
+	}
+
+	if d.Name == MySQL {
+		if len(updates) == 0 {
+			// we still need an update clause, so we set a key to itself
+			updates = append(updates, fmt.Sprintf("%s = %s", quotedKeys[0], quotedKeys[0]))
+		}
+		return statement + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	}
+
+	if len(updates) == 0 {
+		return statement + fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(quotedKeys, ", "))
+	}
+
+	return statement + fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(quotedKeys, ", "), strings.Join(updates, ", "))
+}
+
+// Wraps the query so that it returns at most limit rows with a cursor value
+// greater than the given placeholder (if after is true), ordered by the
+// cursor.
+func (d *Dialect) Incremental(query, cursor string, after bool, limit int) string {
+
+	statement := fmt.Sprintf("SELECT * FROM (%s) kodex_query", query)
+
+	if after {
+		statement += fmt.Sprintf(" WHERE %s > %s", d.Quote(cursor), d.Placeholder(1))
+	}
+
+	return statement + fmt.Sprintf(" ORDER BY %s LIMIT %d", d.Quote(cursor), limit)
+}
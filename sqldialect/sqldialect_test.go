@@ -0,0 +1,58 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sqldialect
+
+import (
+	"testing"
+)
+
+func TestInsert(t *testing.T) {
+
+	for _, test := range []struct {
+		dialect   string
+		driver    string
+		keys      []string
+		statement string
+	}{
+		{"", "sqlite", nil, `INSERT INTO "public"."users" ("id", "email") VALUES (?, ?)`},
+		{"", "pgx", []string{"id"}, `INSERT INTO "public"."users" ("id", "email") VALUES ($1, $2) ON CONFLICT ("id") DO UPDATE SET "email" = excluded."email"`},
+		{"mysql", "", []string{"id"}, "INSERT INTO `public`.`users` (`id`, `email`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `email` = VALUES(`email`)"},
+		{"generic", "", []string{"id", "email"}, `INSERT INTO "public"."users" ("id", "email") VALUES (?, ?) ON CONFLICT ("id", "email") DO NOTHING`},
+	} {
+
+		dialect, err := Get(test.dialect, test.driver)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if statement := dialect.Insert("public.users", []string{"id", "email"}, test.keys); statement != test.statement {
+			t.Errorf("Unexpected statement for %s: %s", dialect.Name, statement)
+		}
+	}
+
+	if _, err := Get("oracle", ""); err == nil {
+		t.Errorf("Expected an error for an unknown dialect")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	dialect := &Dialect{Name: Postgres}
+	if quoted := dialect.Quote(`a"b`); quoted != `"a""b"` {
+		t.Errorf("Unexpected quoted identifier: %s", quoted)
+	}
+}
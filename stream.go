@@ -39,6 +39,7 @@ type StreamStats struct {
 type Stream interface {
 	Processable // Processable includes Model
 	PriorityModel
+	ParallelModel
 	Configs() ([]Config, error)
 	Config(id []byte) (Config, error)
 	MakeConfig(id []byte) Config
@@ -143,13 +144,15 @@ func (b *BaseStream) MarshalJSON() ([]byte, error) {
 	}
 
 	data := map[string]interface{}{
-		"name":        b.Self.Name(),
-		"status":      b.Self.Status(),
-		"description": b.Self.Description(),
-		"projectID":   hex.EncodeToString(b.Self.Project().ID()),
-		"data":        b.Self.Data(),
-		"configs":     configs,
-		"sources":     sourcesList,
+		"name":          b.Self.Name(),
+		"status":        b.Self.Status(),
+		"description":   b.Self.Description(),
+		"projectID":     hex.EncodeToString(b.Self.Project().ID()),
+		"data":          b.Self.Data(),
+		"workers":       b.Self.Workers(),
+		"partition-key": b.Self.PartitionKey(),
+		"configs":       configs,
+		"sources":       sourcesList,
 	}
 
 	for k, v := range JSONData(b.Self) {
@@ -172,6 +175,10 @@ func (b *BaseStream) update(params map[string]interface{}) error {
 			err = b.Self.SetDescription(value.(string))
 		case "data":
 			err = b.Self.SetData(value)
+		case "workers":
+			err = b.Self.SetWorkers(int(value.(int64)))
+		case "partition-key":
+			err = b.Self.SetPartitionKey(value.(string))
 		}
 		if err != nil {
 			return err
@@ -190,6 +197,16 @@ var DescriptionValidators = []forms.Validator{
 	forms.IsString{MaxLength: 10000},
 }
 
+var WorkersValidators = []forms.Validator{
+	forms.IsOptional{Default: 0},
+	forms.IsInteger{HasMin: true, Min: 0, HasMax: true, Max: 1024},
+}
+
+var PartitionKeyValidators = []forms.Validator{
+	forms.IsOptional{Default: ""},
+	forms.IsString{MaxLength: 100},
+}
+
 var IsValidStreamStatus = forms.IsIn{
 	Choices: []interface{}{
 		string(ActiveStream),
@@ -221,6 +238,14 @@ var StreamForm = forms.Form{
 			Name:       "data",
 			Validators: []forms.Validator{forms.IsOptional{}, forms.IsStringMap{}},
 		},
+		{
+			Name:       "workers",
+			Validators: WorkersValidators,
+		},
+		{
+			Name:       "partition-key",
+			Validators: PartitionKeyValidators,
+		},
 	},
 }
 
@@ -0,0 +1,457 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package syslog parses and serializes syslog messages in the RFC 5424 and
+// RFC 3164 (BSD) formats.
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RFC5424 = "rfc5424"
+	RFC3164 = "rfc3164"
+)
+
+// the fields of parsed messages
+const (
+	Facility       = "facility"
+	Severity       = "severity"
+	Version        = "version"
+	Timestamp      = "timestamp"
+	Hostname       = "hostname"
+	App            = "app"
+	ProcID         = "procid"
+	MsgID          = "msgid"
+	StructuredData = "structured-data"
+	Msg            = "msg"
+	Protocol       = "protocol"
+)
+
+const nilValue = "-"
+
+const rfc3164Timestamp = "Jan _2 15:04:05"
+
+// Parses an RFC 5424 or RFC 3164 message. Fields that are missing in the
+// message are missing in the result. Timestamps are returned in RFC 3339
+// format, RFC 3164 timestamps are assumed to be in the current year and the
+// local time zone.
+func Parse(data []byte) (map[string]interface{}, error) {
+
+	message := string(bytes.TrimRight(data, "\r\n\x00"))
+
+	if !strings.HasPrefix(message, "<") {
+		return nil, fmt.Errorf("missing priority")
+	}
+
+	end := strings.IndexByte(message, '>')
+
+	if end < 2 || end > 4 {
+		return nil, fmt.Errorf("invalid priority")
+	}
+
+	priority, err := strconv.Atoi(message[1:end])
+
+	if err != nil || priority > 191 {
+		return nil, fmt.Errorf("invalid priority")
+	}
+
+	values := map[string]interface{}{
+		Facility: int64(priority / 8),
+		Severity: int64(priority % 8),
+	}
+
+	rest := message[end+1:]
+
+	// RFC 5424 messages start with the version
+	if len(rest) > 1 && rest[0] >= '1' && rest[0] <= '9' && rest[1] == ' ' {
+		return values, parseRFC5424(rest, values)
+	}
+
+	return values, parseRFC3164(rest, values)
+}
+
+// Splits off the next space-separated field.
+func nextField(s string) (string, string) {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+func parseRFC5424(s string, values map[string]interface{}) error {
+
+	values[Protocol] = RFC5424
+
+	var field string
+
+	field, s = nextField(s)
+	version, _ := strconv.Atoi(field)
+	values[Version] = int64(version)
+
+	field, s = nextField(s)
+
+	if field != nilValue {
+		ts, err := time.Parse(time.RFC3339Nano, field)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp: %w", err)
+		}
+		values[Timestamp] = ts.Format(time.RFC3339Nano)
+	}
+
+	for _, key := range []string{Hostname, App, ProcID, MsgID} {
+		if field, s = nextField(s); field != nilValue && field != "" {
+			values[key] = field
+		}
+	}
+
+	if strings.HasPrefix(s, nilValue) {
+		s = s[len(nilValue):]
+	} else if strings.HasPrefix(s, "[") {
+		structuredData, rest, err := parseStructuredData(s)
+		if err != nil {
+			return err
+		}
+		values[StructuredData] = structuredData
+		s = rest
+	} else {
+		return fmt.Errorf("invalid structured data")
+	}
+
+	if strings.HasPrefix(s, " ") {
+		// the message may start with a byte order mark
+		if msg := strings.TrimPrefix(s[1:], "\ufeff"); msg != "" {
+			values[Msg] = msg
+		}
+	}
+
+	return nil
+}
+
+// Parses structured data elements like [id@1 key="value"][id2 ...].
+func parseStructuredData(s string) (map[string]interface{}, string, error) {
+
+	elements := make(map[string]interface{})
+
+	for strings.HasPrefix(s, "[") {
+
+		s = s[1:]
+
+		i := strings.IndexAny(s, " ]")
+
+		if i < 1 {
+			return nil, "", fmt.Errorf("invalid structured data ID")
+		}
+
+		params := make(map[string]interface{})
+		elements[s[:i]] = params
+		s = s[i:]
+
+		for {
+			s = strings.TrimLeft(s, " ")
+
+			if strings.HasPrefix(s, "]") {
+				s = s[1:]
+				break
+			}
+
+			j := strings.Index(s, "=\"")
+
+			if j < 1 {
+				return nil, "", fmt.Errorf("invalid structured data parameter")
+			}
+
+			name := s[:j]
+			s = s[j+2:]
+
+			var value strings.Builder
+			closed := false
+
+			for k := 0; k < len(s); k++ {
+				if s[k] == '\\' && k+1 < len(s) && strings.IndexByte("\"\\]", s[k+1]) >= 0 {
+					value.WriteByte(s[k+1])
+					k++
+				} else if s[k] == '"' {
+					s = s[k+1:]
+					closed = true
+					break
+				} else {
+					value.WriteByte(s[k])
+				}
+			}
+
+			if !closed {
+				return nil, "", fmt.Errorf("unterminated structured data parameter")
+			}
+
+			params[name] = value.String()
+		}
+	}
+
+	return elements, s, nil
+}
+
+func parseRFC3164(s string, values map[string]interface{}) error {
+
+	values[Protocol] = RFC3164
+
+	if len(s) >= len(rfc3164Timestamp) {
+		if ts, err := time.ParseInLocation(rfc3164Timestamp, s[:len(rfc3164Timestamp)], time.Local); err == nil {
+			now := time.Now()
+			ts = ts.AddDate(now.Year(), 0, 0)
+			// messages from the end of last year
+			if ts.After(now.AddDate(0, 1, 0)) {
+				ts = ts.AddDate(-1, 0, 0)
+			}
+			values[Timestamp] = ts.Format(time.RFC3339Nano)
+			s = strings.TrimPrefix(s[len(rfc3164Timestamp):], " ")
+
+			// the hostname is optional, we assume that the first field is the
+			// tag if it ends with a colon or contains a process ID
+			if field, rest := nextField(s); field != "" && rest != "" && !strings.ContainsAny(field, ":[") {
+				values[Hostname] = field
+				s = rest
+			}
+		}
+	}
+
+	// the tag is followed by the process ID or a colon, without these we
+	// assume that there is no tag
+	if i := strings.IndexAny(s, ":[ "); i > 0 && s[i] != ' ' {
+
+		values[App] = s[:i]
+		s = s[i:]
+
+		if strings.HasPrefix(s, "[") {
+			if j := strings.IndexByte(s, ']'); j > 0 {
+				values[ProcID] = s[1:j]
+				s = s[j+1:]
+			}
+		}
+
+		s = strings.TrimPrefix(s, ":")
+		s = strings.TrimPrefix(s, " ")
+	}
+
+	if s != "" {
+		values[Msg] = s
+	}
+
+	return nil
+}
+
+func intValue(value interface{}, defaultValue int) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case string:
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+// Returns a printable value without spaces, as required for header fields
+func headerValue(value interface{}, maxLength int) string {
+
+	if value == nil {
+		return nilValue
+	}
+
+	s := fmt.Sprint(value)
+
+	if s == "" {
+		return nilValue
+	}
+
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+
+	if len(s) > maxLength {
+		s = s[:maxLength]
+	}
+
+	return s
+}
+
+func timestamp(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		if ts, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Serializes a message in the given format. Missing fields are left empty
+// where possible, the timestamp defaults to the current time for RFC 3164.
+func Format(values map[string]interface{}, protocol string) ([]byte, error) {
+
+	facility := intValue(values[Facility], 1)
+	severity := intValue(values[Severity], 6)
+
+	if facility < 0 || facility > 23 || severity < 0 || severity > 7 {
+		return nil, fmt.Errorf("invalid facility or severity")
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "<%d>", facility*8+severity)
+
+	msg, _ := values[Msg].(string)
+
+	if msg == "" && values[Msg] != nil {
+		msg = fmt.Sprint(values[Msg])
+	}
+
+	switch protocol {
+	case RFC5424:
+
+		buf.WriteString("1 ")
+
+		if ts, ok := timestamp(values[Timestamp]); ok {
+			buf.WriteString(ts.Format(time.RFC3339Nano))
+		} else {
+			buf.WriteString(nilValue)
+		}
+
+		for _, field := range []struct {
+			key       string
+			maxLength int
+		}{{Hostname, 255}, {App, 48}, {ProcID, 128}, {MsgID, 32}} {
+			buf.WriteByte(' ')
+			buf.WriteString(headerValue(values[field.key], field.maxLength))
+		}
+
+		buf.WriteByte(' ')
+
+		if err := formatStructuredData(&buf, values[StructuredData]); err != nil {
+			return nil, err
+		}
+
+		if msg != "" {
+			buf.WriteByte(' ')
+			buf.WriteString(msg)
+		}
+
+	case RFC3164:
+
+		ts, ok := timestamp(values[Timestamp])
+
+		if !ok {
+			ts = time.Now()
+		}
+
+		buf.WriteString(ts.Local().Format(rfc3164Timestamp))
+
+		if hostname, ok := values[Hostname]; ok {
+			buf.WriteByte(' ')
+			buf.WriteString(headerValue(hostname, 255))
+		}
+
+		if app, ok := values[App]; ok {
+			buf.WriteByte(' ')
+			buf.WriteString(headerValue(app, 32))
+			if procID, ok := values[ProcID]; ok {
+				buf.WriteString("[" + headerValue(procID, 128) + "]")
+			}
+			buf.WriteByte(':')
+		}
+
+		buf.WriteByte(' ')
+		buf.WriteString(msg)
+
+	default:
+		return nil, fmt.Errorf("unknown syslog protocol: %s", protocol)
+	}
+
+	return buf.Bytes(), nil
+}
+
+var sdEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "]", "\\]")
+
+// Returns a valid structured data ID or parameter name
+func sdName(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, headerValue(value, 32))
+}
+
+func formatStructuredData(buf *bytes.Buffer, value interface{}) error {
+
+	elements, ok := value.(map[string]interface{})
+
+	if !ok || len(elements) == 0 {
+		buf.WriteString(nilValue)
+		return nil
+	}
+
+	ids := make([]string, 0, len(elements))
+
+	for id := range elements {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for _, id := range ids {
+
+		params, ok := elements[id].(map[string]interface{})
+
+		if !ok {
+			return fmt.Errorf("invalid structured data element %s", id)
+		}
+
+		buf.WriteString("[" + sdName(id))
+
+		names := make([]string, 0, len(params))
+
+		for name := range params {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Fprintf(buf, " %s=\"%s\"", sdName(name), sdEscaper.Replace(fmt.Sprint(params[name])))
+		}
+
+		buf.WriteByte(']')
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package syslog_test
+
+import (
+	"fmt"
+	"github.com/kiprotect/kodex/syslog"
+	"testing"
+	"time"
+)
+
+func TestParseRFC5424(t *testing.T) {
+
+	values, err := syslog.Parse([]byte(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication\]"][meta x="1"] An application event log entry...` + "\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		syslog.Facility:  int64(20),
+		syslog.Severity:  int64(5),
+		syslog.Version:   int64(1),
+		syslog.Timestamp: "2003-10-11T22:14:15.003Z",
+		syslog.Hostname:  "mymachine.example.com",
+		syslog.App:       "evntslog",
+		syslog.MsgID:     "ID47",
+		syslog.Msg:       "An application event log entry...",
+		syslog.Protocol:  syslog.RFC5424,
+	}
+
+	for key, value := range expected {
+		if values[key] != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, values[key])
+		}
+	}
+
+	if _, ok := values[syslog.ProcID]; ok {
+		t.Errorf("Expected the process ID to be missing")
+	}
+
+	sd := values[syslog.StructuredData].(map[string]interface{})
+
+	if source := sd["exampleSDID@32473"].(map[string]interface{})["eventSource"]; source != `App"lication]` {
+		t.Errorf("Unexpected event source: %v", source)
+	}
+
+	if x := sd["meta"].(map[string]interface{})["x"]; x != "1" {
+		t.Errorf("Unexpected value: %v", x)
+	}
+
+	// a minimal message
+	if values, err := syslog.Parse([]byte("<13>1 - - - - - -")); err != nil {
+		t.Fatal(err)
+	} else if len(values) != 4 {
+		t.Errorf("Expected only the priority, version and protocol, got %v", values)
+	}
+}
+
+func TestParseRFC3164(t *testing.T) {
+
+	for _, test := range []struct {
+		message  string
+		expected map[string]interface{}
+	}{
+		{
+			"<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8",
+			map[string]interface{}{
+				syslog.Facility: int64(4),
+				syslog.Severity: int64(2),
+				syslog.Hostname: "mymachine",
+				syslog.App:      "su",
+				syslog.ProcID:   "123",
+				syslog.Msg:      "'su root' failed for lonvick on /dev/pts/8",
+			},
+		},
+		{
+			"<13>Feb  5 17:32:18 sshd: Accepted publickey for alice from 10.0.0.1",
+			map[string]interface{}{
+				syslog.App: "sshd",
+				syslog.Msg: "Accepted publickey for alice from 10.0.0.1",
+			},
+		},
+		{
+			"<13>just a message",
+			map[string]interface{}{
+				syslog.Msg: "just a message",
+			},
+		},
+	} {
+		values, err := syslog.Parse([]byte(test.message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range test.expected {
+			if values[key] != value {
+				t.Errorf("%s: expected %s to be %v, got %v", test.message, key, value, values[key])
+			}
+		}
+	}
+
+	values, _ := syslog.Parse([]byte("<13>Feb  5 17:32:18 host app: message"))
+
+	if ts, err := time.Parse(time.RFC3339, values[syslog.Timestamp].(string)); err != nil {
+		t.Fatal(err)
+	} else if ts.Month() != time.February || ts.Day() != 5 || ts.Hour() != 17 {
+		t.Errorf("Unexpected timestamp: %v", ts)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, message := range []string{"", "no priority", "<1000>1 - - - - - -", "<13>1 invalid - - - - -", "<13>1 - - - - - [id x=\"1]"} {
+		if _, err := syslog.Parse([]byte(message)); err == nil {
+			t.Errorf("Expected an error for %q", message)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+
+	values := map[string]interface{}{
+		syslog.Facility:  20.0,
+		syslog.Severity:  int64(5),
+		syslog.Timestamp: "2003-10-11T22:14:15.003Z",
+		syslog.Hostname:  "my machine",
+		syslog.App:       "app",
+		syslog.ProcID:    "12",
+		syslog.StructuredData: map[string]interface{}{
+			"b": map[string]interface{}{"y": "a \"quoted\" ]value\\"},
+			"a": map[string]interface{}{"x": 1},
+		},
+		syslog.Msg: "user 7f3a logged in",
+	}
+
+	data, err := syslog.Format(values, syslog.RFC5424)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<165>1 2003-10-11T22:14:15.003Z my_machine app 12 - [a x="1"][b y="a \"quoted\" \]value\\"] user 7f3a logged in`
+
+	if string(data) != expected {
+		t.Fatalf("Unexpected message: %s", data)
+	}
+
+	// we can parse what we format
+	for _, protocol := range []string{syslog.RFC5424, syslog.RFC3164} {
+
+		data, err := syslog.Format(values, protocol)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := syslog.Parse(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range []string{syslog.Facility, syslog.Severity, syslog.App, syslog.ProcID, syslog.Msg} {
+			if fmt.Sprint(parsed[key]) != fmt.Sprint(values[key]) {
+				t.Errorf("%s: expected %s to be %v, got %v", protocol, key, values[key], parsed[key])
+			}
+		}
+	}
+
+	if _, err := syslog.Format(map[string]interface{}{syslog.Facility: 24}, syslog.RFC5424); err == nil {
+		t.Errorf("Expected an error for an invalid facility")
+	}
+}
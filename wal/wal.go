@@ -0,0 +1,699 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package wal implements a write-ahead log that stores records in a series of
+// segment files. Consumers acknowledge records by their offset, the log
+// persists the offset up to which all records were acknowledged and deletes
+// segments that only contain acknowledged records. Records that were not
+// acknowledged before the log was closed are delivered again when it is
+// opened the next time.
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// when we sync written records to disk
+const (
+	SyncAlways   = "always"
+	SyncInterval = "interval"
+	SyncNever    = "never"
+)
+
+var SyncPolicies = []interface{}{SyncAlways, SyncInterval, SyncNever}
+
+var ErrFull = fmt.Errorf("the log is full")
+
+const headerSize = 8
+const offsetFile = "offset"
+
+var segmentName = regexp.MustCompile(`^(\d{20})\.log$`)
+
+type Options struct {
+	// we start a new segment once the current one reaches this size (in bytes)
+	SegmentSize int64
+	// the maximum size of all segments (in bytes), 0 means no limit. As we
+	// only delete whole segments it should be a multiple of the segment size.
+	MaxSize      int64
+	Sync         string
+	SyncInterval time.Duration
+}
+
+type Record struct {
+	Offset uint64
+	Data   []byte
+}
+
+type segment struct {
+	base uint64
+	size int64
+	path string
+	file *os.File
+}
+
+// a record that was read but not acknowledged yet
+type inflight struct {
+	segment      *segment
+	pos          int64
+	acknowledged bool
+}
+
+type Log struct {
+	path     string
+	options  Options
+	segments []*segment
+	// the offset of the next record that we append
+	next uint64
+	// all records before this offset were acknowledged
+	committed uint64
+	// the offset and position of the next record that we read
+	readOffset  uint64
+	readSegment *segment
+	readPos     int64
+	pending     map[uint64]*inflight
+	retry       []uint64
+	size        int64
+	closed      bool
+	notify      chan struct{}
+	stop        chan bool
+	wg          sync.WaitGroup
+	mutex       sync.Mutex
+}
+
+// Opens the log in the given directory, creating it if necessary. Incomplete
+// records at the end of the log (e.g. after a crash) are removed.
+func Open(path string, options Options) (*Log, error) {
+
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		path:    path,
+		options: options,
+		pending: make(map[uint64]*inflight),
+		notify:  make(chan struct{}, 1),
+	}
+
+	for _, entry := range entries {
+		match := segmentName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		base, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if err := l.openSegment(base); err != nil {
+			l.closeFiles()
+			return nil, err
+		}
+	}
+
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i].base < l.segments[j].base })
+
+	if err := l.recover(); err != nil {
+		l.closeFiles()
+		return nil, err
+	}
+
+	if options.Sync == SyncInterval && options.SyncInterval > 0 {
+		l.stop = make(chan bool)
+		l.wg.Add(1)
+		go l.syncPeriodically()
+	}
+
+	return l, nil
+}
+
+func (l *Log) openSegment(base uint64) error {
+
+	path := filepath.Join(l.path, fmt.Sprintf("%020d.log", base))
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.segments = append(l.segments, &segment{
+		base: base,
+		size: info.Size(),
+		path: path,
+		file: file,
+	})
+
+	l.size += info.Size()
+
+	return nil
+}
+
+// Determines the next offset from the last segment and restores the
+// committed offset and the read position.
+func (l *Log) recover() error {
+
+	committed, hasOffset, err := l.loadOffset()
+
+	if err != nil {
+		return err
+	}
+
+	if len(l.segments) == 0 {
+		if err := l.openSegment(committed); err != nil {
+			return err
+		}
+	}
+
+	last := l.segments[len(l.segments)-1]
+
+	count, pos := scan(last, -1)
+
+	if pos < last.size {
+		// the last record is incomplete, we remove it
+		if err := last.file.Truncate(pos); err != nil {
+			return err
+		}
+		l.size -= last.size - pos
+		last.size = pos
+	}
+
+	l.next = last.base + count
+
+	if first := l.segments[0].base; !hasOffset || committed < first {
+		committed = first
+	} else if committed > l.next {
+		committed = l.next
+	}
+
+	l.committed = committed
+	l.readOffset = committed
+
+	// we find the position of the first unacknowledged record
+	for i := len(l.segments) - 1; i >= 0; i-- {
+		if l.segments[i].base <= committed {
+			l.readSegment = l.segments[i]
+			_, l.readPos = scan(l.segments[i], int64(committed-l.segments[i].base))
+			break
+		}
+	}
+
+	return nil
+}
+
+// Scans the records of a segment and returns the number of valid records and
+// the position after the last one. If limit is not negative we stop after the
+// given number of records.
+func scan(s *segment, limit int64) (uint64, int64) {
+
+	var count uint64
+	var pos int64
+
+	for limit < 0 || int64(count) < limit {
+		data, err := readRecord(s, pos)
+		if err != nil {
+			break
+		}
+		pos += int64(headerSize + len(data))
+		count++
+	}
+
+	return count, pos
+}
+
+func readRecord(s *segment, pos int64) ([]byte, error) {
+
+	header := make([]byte, headerSize)
+
+	if n, err := s.file.ReadAt(header, pos); n < headerSize {
+		if err == nil || err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+
+	if int64(length) > s.size-pos-headerSize {
+		return nil, io.EOF
+	}
+
+	data := make([]byte, length)
+
+	if _, err := s.file.ReadAt(data, pos+headerSize); err != nil {
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(data) != checksum {
+		return nil, fmt.Errorf("invalid checksum in segment %s at position %d", s.path, pos)
+	}
+
+	return data, nil
+}
+
+func (l *Log) loadOffset() (uint64, bool, error) {
+
+	data, err := os.ReadFile(filepath.Join(l.path, offsetFile))
+
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	offset, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid offset file: %w", err)
+	}
+
+	return offset, true, nil
+}
+
+func (l *Log) saveOffset() error {
+
+	path := filepath.Join(l.path, offsetFile)
+	tmpPath := path + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.WriteString(strconv.FormatUint(l.committed, 10)); err != nil {
+		file.Close()
+		return err
+	}
+
+	if l.options.Sync == SyncAlways {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (l *Log) syncPeriodically() {
+
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.options.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.mutex.Lock()
+			if !l.closed {
+				if err := l.segments[len(l.segments)-1].file.Sync(); err != nil {
+					// we will try again with the next tick
+					l.mutex.Unlock()
+					continue
+				}
+			}
+			l.mutex.Unlock()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Appends a record to the log and returns its offset.
+func (l *Log) Append(data []byte) (uint64, error) {
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return 0, fmt.Errorf("the log is closed")
+	}
+
+	recordSize := int64(headerSize + len(data))
+
+	if l.options.MaxSize > 0 && l.size+recordSize > l.options.MaxSize {
+		return 0, ErrFull
+	}
+
+	active := l.segments[len(l.segments)-1]
+
+	if l.options.SegmentSize > 0 && active.size > 0 && active.size+recordSize > l.options.SegmentSize {
+		if err := l.openSegment(l.next); err != nil {
+			return 0, err
+		}
+		// the previous segment won't change anymore
+		if l.options.Sync != SyncNever {
+			if err := active.file.Sync(); err != nil {
+				return 0, err
+			}
+		}
+		active = l.segments[len(l.segments)-1]
+	}
+
+	record := make([]byte, recordSize)
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[headerSize:], data)
+
+	if _, err := active.file.Write(record); err != nil {
+		// we try to remove the partially written record
+		active.file.Truncate(active.size)
+		return 0, err
+	}
+
+	if l.options.Sync == SyncAlways {
+		if err := active.file.Sync(); err != nil {
+			return 0, err
+		}
+	}
+
+	active.size += recordSize
+	l.size += recordSize
+
+	offset := l.next
+	l.next++
+
+	l.signal()
+
+	return offset, nil
+}
+
+// Returns a channel that receives a value whenever a record can be read. It
+// buffers a single notification, so only a single reader should wait on it.
+func (l *Log) Notify() <-chan struct{} {
+	return l.notify
+}
+
+func (l *Log) signal() {
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Returns the next record that wasn't read yet, or a rejected record that
+// should be delivered again. Returns nil if there are no records.
+func (l *Log) Read() (*Record, error) {
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return nil, fmt.Errorf("the log is closed")
+	}
+
+	for len(l.retry) > 0 {
+
+		offset := l.retry[0]
+		l.retry = l.retry[1:]
+
+		record, ok := l.pending[offset]
+
+		if !ok || record.acknowledged {
+			continue
+		}
+
+		data, err := readRecord(record.segment, record.pos)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &Record{Offset: offset, Data: data}, nil
+	}
+
+	for {
+
+		data, err := readRecord(l.readSegment, l.readPos)
+
+		if err == io.EOF {
+			// we continue with the next segment (if there is one)
+			if l.readSegment == l.segments[len(l.segments)-1] {
+				return nil, nil
+			}
+			found := false
+			for _, s := range l.segments {
+				if s.base == l.readOffset && s != l.readSegment {
+					l.readSegment = s
+					l.readPos = 0
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("missing segment for offset %d", l.readOffset)
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		record := &Record{Offset: l.readOffset, Data: data}
+
+		l.pending[l.readOffset] = &inflight{
+			segment: l.readSegment,
+			pos:     l.readPos,
+		}
+
+		l.readOffset++
+		l.readPos += int64(headerSize + len(data))
+
+		return record, nil
+	}
+}
+
+// Marks a record as processed. Once all records before it were acknowledged
+// as well we won't deliver it again.
+func (l *Log) Acknowledge(offset uint64) error {
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	record, ok := l.pending[offset]
+
+	if !ok {
+		return fmt.Errorf("unknown offset: %d", offset)
+	}
+
+	record.acknowledged = true
+
+	committed := l.committed
+
+	for {
+		if record, ok := l.pending[l.committed]; !ok || !record.acknowledged {
+			break
+		}
+		delete(l.pending, l.committed)
+		l.committed++
+	}
+
+	if committed == l.committed {
+		return nil
+	}
+
+	if err := l.saveOffset(); err != nil {
+		return err
+	}
+
+	return l.compact()
+}
+
+// Delivers the record again with the next read.
+func (l *Log) Reject(offset uint64) error {
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if record, ok := l.pending[offset]; !ok || record.acknowledged {
+		return fmt.Errorf("unknown offset: %d", offset)
+	}
+
+	l.retry = append(l.retry, offset)
+
+	l.signal()
+
+	return nil
+}
+
+// Delivers all records that were read but not acknowledged yet again with
+// the next reads, in the order of their offsets.
+func (l *Log) RejectPending() error {
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return fmt.Errorf("the log is closed")
+	}
+
+	retry := make([]uint64, 0, len(l.pending))
+
+	for offset, record := range l.pending {
+		if !record.acknowledged {
+			retry = append(retry, offset)
+		}
+	}
+
+	sort.Slice(retry, func(i, j int) bool { return retry[i] < retry[j] })
+
+	l.retry = retry
+
+	if len(retry) > 0 {
+		l.signal()
+	}
+
+	return nil
+}
+
+// Deletes segments that only contain acknowledged records.
+func (l *Log) compact() error {
+
+	for len(l.segments) > 1 && l.segments[1].base <= l.committed {
+
+		s := l.segments[0]
+
+		if err := s.file.Close(); err != nil {
+			return err
+		}
+
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+
+		l.size -= s.size
+		l.segments = l.segments[1:]
+
+		// the reader is at the end of the segment
+		if l.readSegment == s {
+			l.readSegment = l.segments[0]
+			l.readPos = 0
+		}
+	}
+
+	return nil
+}
+
+// Removes all records from the log.
+func (l *Log) Purge() error {
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return fmt.Errorf("the log is closed")
+	}
+
+	l.committed = l.next
+	l.readOffset = l.next
+	l.pending = make(map[uint64]*inflight)
+	l.retry = nil
+
+	if active := l.segments[len(l.segments)-1]; active.size > 0 {
+		if err := l.openSegment(l.next); err != nil {
+			return err
+		}
+	}
+
+	l.readSegment = l.segments[len(l.segments)-1]
+	l.readPos = 0
+
+	if err := l.saveOffset(); err != nil {
+		return err
+	}
+
+	return l.compact()
+}
+
+// Returns the size of all segments in bytes.
+func (l *Log) Size() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.size
+}
+
+// Returns the number of records that were not acknowledged yet.
+func (l *Log) Pending() uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.next - l.committed
+}
+
+func (l *Log) closeFiles() error {
+	var err error
+	for _, s := range l.segments {
+		if closeErr := s.file.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (l *Log) Close() error {
+
+	l.mutex.Lock()
+
+	if l.closed {
+		l.mutex.Unlock()
+		return nil
+	}
+
+	l.closed = true
+	l.mutex.Unlock()
+
+	if l.stop != nil {
+		close(l.stop)
+		l.wg.Wait()
+	}
+
+	if l.options.Sync != SyncNever {
+		if err := l.segments[len(l.segments)-1].file.Sync(); err != nil {
+			l.closeFiles()
+			return err
+		}
+	}
+
+	return l.closeFiles()
+}
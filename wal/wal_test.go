@@ -0,0 +1,221 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestLog(t *testing.T, path string, options Options) *Log {
+	t.Helper()
+	log, err := Open(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { log.Close() })
+	return log
+}
+
+func appendTestRecords(t *testing.T, log *Log, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if _, err := log.Append([]byte(fmt.Sprintf("record-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readTestRecord(t *testing.T, log *Log, expected string) *Record {
+	t.Helper()
+	record, err := log.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record == nil {
+		t.Fatalf("Expected record %s", expected)
+	}
+	if string(record.Data) != expected {
+		t.Fatalf("Expected record %s, got %s", expected, record.Data)
+	}
+	return record
+}
+
+func segments(t *testing.T, path string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(path, "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestLog(t *testing.T) {
+
+	path := t.TempDir()
+	options := Options{Sync: SyncAlways, SegmentSize: 32}
+
+	log := openTestLog(t, path, options)
+
+	appendTestRecords(t, log, 0, 10)
+
+	// every segment holds two records
+	if n := len(segments(t, path)); n != 5 {
+		t.Fatalf("Expected 5 segments, got %d", n)
+	}
+
+	records := make([]*Record, 0)
+
+	for i := 0; i < 5; i++ {
+		records = append(records, readTestRecord(t, log, fmt.Sprintf("record-%d", i)))
+	}
+
+	// we acknowledge records out of order
+	for _, i := range []int{1, 0, 3, 4} {
+		if err := log.Acknowledge(records[i].Offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first segment was fully acknowledged
+	if n := len(segments(t, path)); n != 4 {
+		t.Fatalf("Expected 4 segments, got %d", n)
+	}
+
+	// rejected records are delivered again
+	if err := log.Reject(records[2].Offset); err != nil {
+		t.Fatal(err)
+	}
+
+	readTestRecord(t, log, "record-2")
+	readTestRecord(t, log, "record-5")
+
+	// all records that are being processed are delivered again
+	if err := log.RejectPending(); err != nil {
+		t.Fatal(err)
+	}
+
+	readTestRecord(t, log, "record-2")
+	readTestRecord(t, log, "record-5")
+	readTestRecord(t, log, "record-6")
+
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// records that were not acknowledged are delivered again
+	log = openTestLog(t, path, options)
+
+	if pending := log.Pending(); pending != 8 {
+		t.Fatalf("Expected 8 pending records, got %d", pending)
+	}
+
+	for i := 2; i < 10; i++ {
+		record := readTestRecord(t, log, fmt.Sprintf("record-%d", i))
+		if err := log.Acknowledge(record.Offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if record, err := log.Read(); err != nil || record != nil {
+		t.Fatalf("Expected no record, got %v (%v)", record, err)
+	}
+
+	if n := len(segments(t, path)); n != 1 {
+		t.Fatalf("Expected one segment, got %d", n)
+	}
+
+	// offsets continue after the last record
+	if offset, err := log.Append([]byte("record-10")); err != nil || offset != 10 {
+		t.Fatalf("Expected offset 10, got %d (%v)", offset, err)
+	}
+}
+
+func TestLogRecovery(t *testing.T) {
+
+	path := t.TempDir()
+
+	log := openTestLog(t, path, Options{Sync: SyncNever})
+
+	appendTestRecords(t, log, 0, 3)
+
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// we simulate a crash while writing the last record
+	files := segments(t, path)
+
+	info, err := os.Stat(files[0])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Truncate(files[0], info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	log = openTestLog(t, path, Options{Sync: SyncNever})
+
+	readTestRecord(t, log, "record-0")
+	readTestRecord(t, log, "record-1")
+
+	if record, err := log.Read(); err != nil || record != nil {
+		t.Fatalf("Expected no record, got %v (%v)", record, err)
+	}
+
+	appendTestRecords(t, log, 3, 4)
+	readTestRecord(t, log, "record-3")
+}
+
+func TestLogLimits(t *testing.T) {
+
+	path := t.TempDir()
+
+	log := openTestLog(t, path, Options{Sync: SyncInterval, SyncInterval: 1e6, SegmentSize: 32, MaxSize: 64})
+
+	appendTestRecords(t, log, 0, 4)
+
+	if _, err := log.Append([]byte("record-4")); err != ErrFull {
+		t.Fatalf("Expected ErrFull, got %v", err)
+	}
+
+	// acknowledging records frees up space
+	for i := 0; i < 2; i++ {
+		record := readTestRecord(t, log, fmt.Sprintf("record-%d", i))
+		if err := log.Acknowledge(record.Offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	appendTestRecords(t, log, 4, 5)
+
+	if err := log.Purge(); err != nil {
+		t.Fatal(err)
+	}
+
+	if record, err := log.Read(); err != nil || record != nil {
+		t.Fatalf("Expected no record, got %v (%v)", record, err)
+	}
+
+	if size := log.Size(); size != 0 {
+		t.Fatalf("Expected an empty log, got %d bytes", size)
+	}
+}
@@ -0,0 +1,326 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/wal"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var WALChannelForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the WAL channel form",
+	Fields: []forms.Field{
+		{
+			// the directory in which we store the logs, each model gets its
+			// own subdirectory
+			Name: "path",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{MinLength: 1},
+			},
+		},
+		{
+			Name: "segment-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 64 * 1024 * 1024},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			// the maximum size of the log of a single model, writes fail if
+			// it is exceeded (0 means no limit)
+			Name: "max-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+		{
+			Name: "sync",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: wal.SyncAlways},
+				forms.IsIn{Choices: wal.SyncPolicies},
+			},
+		},
+		{
+			// how often we sync the logs to disk with the "interval" policy
+			// (in seconds)
+			Name: "sync-interval",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 1.0},
+				forms.IsFloat{HasMin: true, Min: 0.001},
+			},
+		},
+	},
+}
+
+// Keeps the logs of the WAL channels, which are shared between the internal
+// readers and writers of a model.
+type WALStore struct {
+	mutex sync.Mutex
+	logs  map[string]*wal.Log
+}
+
+func MakeWALStore() *WALStore {
+	return &WALStore{
+		logs: make(map[string]*wal.Log),
+	}
+}
+
+// Returns the log in the given directory, opening it if necessary.
+func (w *WALStore) Log(path string, options wal.Options) (*wal.Log, error) {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if log, ok := w.logs[path]; ok {
+		return log, nil
+	}
+
+	log, err := wal.Open(path, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	w.logs[path] = log
+
+	return log, nil
+}
+
+// Closes all logs.
+func (w *WALStore) Close() error {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var err error
+
+	for path, log := range w.logs {
+		if closeErr := log.Close(); closeErr != nil {
+			Log.Error(closeErr)
+			err = closeErr
+		}
+		delete(w.logs, path)
+	}
+
+	return err
+}
+
+func getWALStore(controller Controller) (*WALStore, error) {
+	walStoreObj, ok := controller.GetVar("wal-store")
+	if !ok {
+		walStoreObj = MakeWALStore()
+		controller.SetVar("wal-store", walStoreObj)
+	}
+	walStore, ok := walStoreObj.(*WALStore)
+	if !ok {
+		return nil, fmt.Errorf("not a valid WAL store")
+	}
+	return walStore, nil
+}
+
+type walChannel struct {
+	Store   *WALStore
+	Path    string
+	Options wal.Options
+	Model   Model
+	log     *wal.Log
+}
+
+func makeWALChannel(controller Controller, config map[string]interface{}) (*walChannel, error) {
+
+	params, err := WALChannelForm.Validate(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := getWALStore(controller)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &walChannel{
+		Store: store,
+		Path:  params["path"].(string),
+		Options: wal.Options{
+			SegmentSize:  params["segment-size"].(int64),
+			MaxSize:      params["max-size"].(int64),
+			Sync:         params["sync"].(string),
+			SyncInterval: time.Duration(params["sync-interval"].(float64) * float64(time.Second)),
+		},
+	}, nil
+}
+
+func (w *walChannel) SetupWithModel(model Model) error {
+	var err error
+	w.Model = model
+	w.log, err = w.Store.Log(filepath.Join(w.Path, model.Type(), hex.EncodeToString(model.ID())), w.Options)
+	return err
+}
+
+func (w *walChannel) Teardown() error {
+	// the log is shared, so we keep it open
+	return nil
+}
+
+type walRecord struct {
+	Items       []*Item                `json:"items"`
+	Headers     map[string]interface{} `json:"headers"`
+	EndOfStream bool                   `json:"end-of-stream"`
+	// the key order of every item, if any of them has one
+	KeyOrders [][]string `json:"key-orders,omitempty"`
+}
+
+// Returns the key orders of the given items, or nil if none of them has one
+func keyOrders(items []*Item) [][]string {
+	var orders [][]string
+	for i, item := range items {
+		if item.KeyOrder() == nil {
+			continue
+		}
+		if orders == nil {
+			orders = make([][]string, len(items))
+		}
+		orders[i] = item.KeyOrder()
+	}
+	return orders
+}
+
+// An internal reader that reads payloads from a write-ahead log on disk.
+// Payloads that were not acknowledged are delivered again after a restart.
+type WALInternalReader struct {
+	*walChannel
+}
+
+type WALPayload struct {
+	*BasicPayload
+	log    *wal.Log
+	offset uint64
+	once   sync.Once
+}
+
+func (p *WALPayload) Acknowledge() error {
+	var err error
+	p.once.Do(func() { err = p.log.Acknowledge(p.offset) })
+	return err
+}
+
+func (p *WALPayload) Reject() error {
+	var err error
+	p.once.Do(func() { err = p.log.Reject(p.offset) })
+	return err
+}
+
+func (w *WALInternalReader) Read() (Payload, error) {
+
+	record, err := w.log.Read()
+
+	if err != nil || record == nil {
+		return nil, err
+	}
+
+	var data struct {
+		Items       []map[string]interface{} `json:"items"`
+		Headers     map[string]interface{}   `json:"headers"`
+		EndOfStream bool                     `json:"end-of-stream"`
+		KeyOrders   [][]string               `json:"key-orders"`
+	}
+
+	if err := json.Unmarshal(record.Data, &data); err != nil {
+		// we can't process this record, so we skip it
+		Log.Errorf("Skipping invalid record at offset %d: %v", record.Offset, err)
+		return nil, w.log.Acknowledge(record.Offset)
+	}
+
+	items := make([]*Item, len(data.Items))
+
+	for i, item := range data.Items {
+		items[i] = MakeItem(item)
+		if i < len(data.KeyOrders) {
+			items[i].SetKeyOrder(data.KeyOrders[i])
+		}
+	}
+
+	return &WALPayload{
+		BasicPayload: MakeBasicPayload(items, data.Headers, data.EndOfStream),
+		log:          w.log,
+		offset:       record.Offset,
+	}, nil
+}
+
+func (w *WALInternalReader) Setup(Stream) error {
+	return fmt.Errorf("setup with stream not supported")
+}
+
+// The records in the log were already acknowledged to their sources, so we
+// keep them and only deliver the ones that are being processed again.
+func (w *WALInternalReader) Purge() error {
+	return w.log.RejectPending()
+}
+
+func (w *WALInternalReader) Notify() <-chan struct{} {
+	return w.log.Notify()
+}
+
+// Returns the number of records that were not acknowledged yet, which
+// includes the payloads that are being processed.
+func (w *WALInternalReader) Queued() int {
+	return int(w.log.Pending())
+}
+
+// An internal writer that appends payloads to a write-ahead log on disk. As
+// soon as a payload is written we acknowledge it.
+type WALInternalWriter struct {
+	*walChannel
+}
+
+func (w *WALInternalWriter) Setup(Config) error {
+	return fmt.Errorf("setup with config not supported")
+}
+
+func (w *WALInternalWriter) Close() error {
+	return nil
+}
+
+func (w *WALInternalWriter) Write(payload Payload) error {
+
+	data, err := json.Marshal(&walRecord{
+		Items:       payload.Items(),
+		Headers:     payload.Headers(),
+		EndOfStream: payload.EndOfStream(),
+		KeyOrders:   keyOrders(payload.Items()),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.log.Append(data); err != nil {
+		return err
+	}
+
+	return payload.Acknowledge()
+}
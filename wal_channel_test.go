@@ -0,0 +1,190 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kodex_test
+
+import (
+	"github.com/kiprotect/kodex"
+	pt "github.com/kiprotect/kodex/helpers/testing"
+	pf "github.com/kiprotect/kodex/helpers/testing/fixtures"
+	"testing"
+)
+
+func TestWALChannel(t *testing.T) {
+
+	var fixtureConfig = []pt.FC{
+		pt.FC{&pf.Settings{}, "settings"},
+		pt.FC{&pf.Controller{}, "controller"},
+		pt.FC{&pf.Project{Name: "test"}, "project"},
+		pt.FC{&pf.Stream{Name: "test", Project: "project"}, "stream"},
+	}
+
+	fixtures, err := pt.SetupFixtures(fixtureConfig)
+	defer pt.TeardownFixtures(fixtureConfig, fixtures)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := fixtures["controller"].(kodex.Controller)
+	stream := fixtures["stream"].(kodex.Stream)
+
+	controller.Settings().Set("internal-channel", map[string]interface{}{
+		"type": "wal",
+		"config": map[string]interface{}{
+			"path": t.TempDir(),
+		},
+	})
+
+	makeChannel := func() *kodex.InternalChannel {
+		channel := kodex.MakeInternalChannel()
+		if err := channel.Setup(controller, stream); err != nil {
+			t.Fatal(err)
+		}
+		return channel
+	}
+
+	// closes all logs, as if we restarted
+	restart := func() {
+		walStore, _ := controller.GetVar("wal-store")
+		if err := walStore.(*kodex.WALStore).Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer restart()
+
+	channel := makeChannel()
+
+	item := kodex.MakeItem(map[string]interface{}{"foo": "bar", "baz": "bam"})
+	item.SetKeyOrder([]string{"foo", "baz"})
+
+	delivery := kodex.MakeDelivery(kodex.MakeBasicPayload(nil, nil, false))
+	payload := delivery.Derive([]*kodex.Item{item}, map[string]interface{}{"source": "test"}, false)
+	delivery.Acknowledge()
+
+	if err := channel.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	// the payload was acknowledged once it was written to disk
+	if !delivery.Done() {
+		t.Fatal("Expected the payload to be acknowledged")
+	}
+
+	if queued, ok := channel.Queued(); !ok || queued != 1 {
+		t.Fatalf("Expected one queued payload, got %d", queued)
+	}
+
+	select {
+	case <-channel.Notify():
+	default:
+		t.Fatal("Expected a notification")
+	}
+
+	read := func(channel *kodex.InternalChannel) kodex.Payload {
+		payload, err := channel.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return payload
+	}
+
+	for i := 0; i < 2; i++ {
+
+		payload := read(channel)
+
+		if payload == nil {
+			t.Fatal("Expected a payload")
+		}
+
+		if value, _ := payload.Items()[0].Get("foo"); value != "bar" {
+			t.Errorf("Unexpected value: %v", value)
+		}
+
+		// the key order (e.g. of CSV columns) should be preserved
+		if order := payload.Items()[0].KeyOrder(); len(order) != 2 || order[0] != "foo" || order[1] != "baz" {
+			t.Errorf("Unexpected key order: %v", order)
+		}
+
+		if payload.Headers()["source"] != "test" {
+			t.Errorf("Unexpected headers: %v", payload.Headers())
+		}
+
+		if i == 1 {
+			if err := payload.Acknowledge(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the payload wasn't acknowledged, so we receive it again
+		restart()
+		channel = makeChannel()
+	}
+
+	if payload := read(channel); payload != nil {
+		t.Fatalf("Expected no payload")
+	}
+
+	if queued, _ := channel.Queued(); queued != 0 {
+		t.Fatalf("Expected no queued payloads, got %d", queued)
+	}
+	for _, value := range []string{"one", "two"} {
+		item := kodex.MakeItem(map[string]interface{}{"foo": value})
+		if err := channel.Write(kodex.MakeBasicPayload([]*kodex.Item{item}, nil, false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	readValue := func(channel *kodex.InternalChannel) string {
+		payload := read(channel)
+		if payload == nil {
+			t.Fatal("Expected a payload")
+		}
+		value, _ := payload.Items()[0].Get("foo")
+		return value.(string)
+	}
+
+	if value := readValue(channel); value != "one" {
+		t.Fatalf("Unexpected value: %s", value)
+	}
+
+	// purging the channel (e.g. on a hard stop) must not drop records, as
+	// they were already acknowledged to their sources
+	if err := channel.Purge(); err != nil {
+		t.Fatal(err)
+	}
+
+	if value := readValue(channel); value != "one" {
+		t.Fatalf("Unexpected value: %s", value)
+	}
+
+	restart()
+	channel = makeChannel()
+
+	for _, expected := range []string{"one", "two"} {
+		payload := read(channel)
+		if payload == nil {
+			t.Fatal("Expected a payload")
+		}
+		if value, _ := payload.Items()[0].Get("foo"); value != expected {
+			t.Fatalf("Expected %s, got %v", expected, value)
+		}
+		if err := payload.Acknowledge(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
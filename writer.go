@@ -17,6 +17,7 @@
 package kodex
 
 import (
+	"context"
 	"github.com/kiprotect/go-helpers/forms"
 )
 
@@ -41,6 +42,21 @@ type ClosableWriter interface {
 	Close() error
 }
 
+// A writer that can be interrupted while it writes a payload, e.g. while it
+// waits for a remote system or retries a request.
+type ContextWriter interface {
+	Writer
+	WriteContext(context.Context, Payload) error
+}
+
+// Writes a payload, using the context if the writer supports it.
+func WriteContext(ctx context.Context, writer Writer, payload Payload) error {
+	if contextWriter, ok := writer.(ContextWriter); ok {
+		return contextWriter.WriteContext(ctx, payload)
+	}
+	return writer.Write(payload)
+}
+
 // A writer that is able to write objects for a specific model such as a stream
 // or an destination. Used for internal data routing.
 type ModelWriter interface {
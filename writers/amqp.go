@@ -17,14 +17,13 @@
 package writers
 
 import (
-	"bufio"
 	"bytes"
-	"compress/gzip"
 	"encoding/hex"
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/compression"
+	"github.com/kiprotect/kodex/formats"
 	"github.com/streadway/amqp"
-	"io"
 	"time"
 )
 
@@ -35,7 +34,8 @@ type AMQPBase struct {
 	QueueExpiresAfterMs int64
 	URL                 string
 	Format              string
-	Compress            bool
+	FormatConfig        map[string]interface{}
+	Compress            string
 	QueueName           string
 	RoutingKey          string
 	BaseRoutingKey      string
@@ -43,6 +43,7 @@ type AMQPBase struct {
 	Exchange            string
 	ExchangeType        string
 	Model               kodex.Model
+	format              *writerFormat
 }
 
 type AMQPWriter struct {
@@ -52,15 +53,19 @@ type AMQPWriter struct {
 }
 
 func MakeAMQPBase(params map[string]interface{}) (AMQPBase, error) {
+	format := params["format"].(string)
+	formatConfig := params["format-config"].(map[string]interface{})
 	return AMQPBase{
 		URL:                 params["url"].(string),
-		Compress:            params["compress"].(bool),
+		Compress:            params["compress"].(string),
 		BaseRoutingKey:      params["routing_key"].(string),
 		BaseQueueName:       params["queue"].(string),
 		QueueExpiresAfterMs: params["queue_expires_after_ms"].(int64),
 		Exchange:            params["exchange"].(string),
 		ExchangeType:        params["exchange_type"].(string),
-		Format:              params["format"].(string),
+		Format:              format,
+		FormatConfig:        formatConfig,
+		format:              makeWriterFormat(format, formatConfig),
 	}, nil
 }
 
@@ -80,43 +85,22 @@ func MakeAMQPWriter(config map[string]interface{}) (kodex.Writer, error) {
 }
 
 func (a *AMQPWriter) Write(payload kodex.Payload) error {
-	var buf *bytes.Buffer
+	var buf bytes.Buffer
 
-	var writer io.Writer
-	var gzWriter *gzip.Writer
-	var bufioWriter *bufio.Writer
+	writer, err := compression.NewWriter(&buf, a.Compress)
 
-	b := make([]byte, 0)
-	buf = bytes.NewBuffer(b)
-
-	if a.Compress {
-		gzWriter = gzip.NewWriter(buf)
-		writer = gzWriter
-	} else {
-		bufioWriter = bufio.NewWriter(buf)
-		writer = bufioWriter
+	if err != nil {
+		return err
 	}
 
-	for _, item := range payload.Items() {
-		serializedItem, err := item.Serialize(a.Format)
-		if err != nil {
-			return err
-		}
-		_, err = writer.Write(serializedItem)
-		if err != nil {
-			return err
-		}
-		_, err = writer.Write([]byte("\n"))
-		if err != nil {
-			return err
-		}
+	if encoder, err := a.format.encoder(writer, nil); err != nil {
+		return err
+	} else if err := kodex.EncodeItems(encoder, payload.Items()); err != nil {
+		return err
 	}
 
-	if a.Compress {
-		gzWriter.Flush()
-		gzWriter.Close()
-	} else {
-		bufioWriter.Flush()
+	if err := writer.Close(); err != nil {
+		return err
 	}
 
 	bs := buf.Bytes()
@@ -159,6 +143,9 @@ func (a *AMQPWriter) Write(payload kodex.Payload) error {
 }
 
 func (a *AMQPWriter) Setup(config kodex.Config) error {
+	if err := a.SetupFormat(formats.ConfigDefinitions(config)); err != nil {
+		return err
+	}
 	return a.setup(nil)
 }
 
@@ -183,6 +170,17 @@ func (a *AMQPWriter) setup(model kodex.Model) error {
 	return nil
 }
 
+// Resolves the format using the given definitions, so that formats provided
+// by plugins can be used.
+func (a *AMQPBase) SetupFormat(definitions *kodex.Definitions) error {
+	return a.format.setup(definitions)
+}
+
+// Returns the format of the items
+func (a *AMQPBase) ItemFormat() (kodex.Format, error) {
+	return a.format.get()
+}
+
 func (a *AMQPBase) SetupWithModel(model kodex.Model) error {
 	return a.setup(model)
 }
@@ -196,6 +194,12 @@ func (a *AMQPBase) setup(model kodex.Model) error {
 
 	a.Model = model
 
+	if stream, ok := model.(kodex.Stream); ok {
+		if err := a.SetupFormat(formats.StreamDefinitions(stream)); err != nil {
+			return err
+		}
+	}
+
 	if a.Model != nil {
 		strId := hex.EncodeToString(model.ID())
 		typeName := model.Type()
@@ -19,6 +19,7 @@ package writers
 import (
 	"fmt"
 	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/compression"
 )
 
 type TopicExchangeChosen struct{}
@@ -38,14 +39,22 @@ var AMQPBaseForm = forms.Form{
 			Name: "format",
 			Validators: []forms.Validator{
 				forms.IsOptional{Default: "json"},
-				forms.IsIn{Choices: []interface{}{"json"}},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "format-config",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
 			},
 		},
 		{
+			// a compression codec or "none"
 			Name: "compress",
 			Validators: []forms.Validator{
 				forms.IsOptional{Default: false},
-				forms.IsBoolean{},
+				compression.IsCodec{Writing: true},
 			},
 		},
 		{
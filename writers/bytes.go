@@ -17,19 +17,22 @@
 package writers
 
 import (
-	"bufio"
 	"bytes"
-	"compress/gzip"
+	"fmt"
 	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/compression"
+	"github.com/kiprotect/kodex/formats"
 	"io"
 	"sync"
 )
 
 type BytesWriter struct {
-	Output   []byte
-	Format   string
-	Compress bool
-	mutex    *sync.Mutex
+	Output       []byte
+	Format       string
+	FormatConfig map[string]interface{}
+	Compress     string
+	format       *writerFormat
+	mutex        *sync.Mutex
 }
 
 func (s *BytesWriter) Teardown() error {
@@ -37,51 +40,43 @@ func (s *BytesWriter) Teardown() error {
 }
 
 func (s *BytesWriter) Setup(config kodex.Config) error {
-	return nil
+	return s.format.setup(formats.ConfigDefinitions(config))
 }
 
 func (s *BytesWriter) Write(payload kodex.Payload) error {
 
-	var buf *bytes.Buffer
-
-	var writer io.Writer
-	var gzWriter *gzip.Writer
-	var bufioWriter *bufio.Writer
-
-	b := make([]byte, 0)
-	buf = bytes.NewBuffer(b)
+	var buf bytes.Buffer
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if s.Compress {
-		gzWriter = gzip.NewWriter(buf)
-		writer = gzWriter
-	} else {
-		bufioWriter = bufio.NewWriter(buf)
-		writer = bufioWriter
+	// formats like Parquet produce self-contained files
+	if format, err := s.format.get(); err != nil {
+		return err
+	} else if _, ok := format.(kodex.FileFormat); ok && len(s.Output) > 0 {
+		return fmt.Errorf("cannot append to existing %s data", s.Format)
 	}
 
-	for _, item := range payload.Items() {
-		serializedItem, err := item.Serialize(s.Format)
-		if err != nil {
-			return err
-		}
-		_, err = writer.Write(serializedItem)
-		if err != nil {
-			return err
-		}
-		_, err = writer.Write([]byte("\n"))
-		if err != nil {
-			return err
-		}
+	writer, err := compression.NewWriter(&buf, s.Compress)
+
+	if err != nil {
+		return err
 	}
 
-	if s.Compress {
-		gzWriter.Flush()
-		gzWriter.Close()
-	} else {
-		bufioWriter.Flush()
+	var existing func() (io.ReadCloser, error)
+
+	if len(s.Output) > 0 {
+		existing = existingBytes(s.Output, s.Compress)
+	}
+
+	if encoder, err := s.format.encoder(writer, existing); err != nil {
+		return err
+	} else if err := kodex.EncodeItems(encoder, payload.Items()); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
 	}
 
 	s.Output = append(s.Output, buf.Bytes()...)
@@ -93,11 +88,15 @@ func MakeBytesWriter(config map[string]interface{}) (kodex.Writer, error) {
 	if params, err := BytesWriterForm.Validate(config); err != nil {
 		return nil, err
 	} else {
+		format := params["format"].(string)
+		formatConfig := params["format-config"].(map[string]interface{})
 		return &BytesWriter{
-			Format:   params["format"].(string),
-			Compress: params["compress"].(bool),
-			Output:   make([]byte, 0),
-			mutex:    &sync.Mutex{},
+			Format:       format,
+			FormatConfig: formatConfig,
+			Compress:     params["compress"].(string),
+			Output:       make([]byte, 0),
+			format:       makeWriterFormat(format, formatConfig),
+			mutex:        &sync.Mutex{},
 		}, nil
 	}
 }
@@ -18,6 +18,7 @@ package writers
 
 import (
 	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/compression"
 )
 
 var BytesWriterForm = forms.Form{
@@ -26,14 +27,22 @@ var BytesWriterForm = forms.Form{
 		{
 			Name: "format",
 			Validators: []forms.Validator{
-				forms.IsIn{Choices: []interface{}{"json"}},
+				forms.IsString{},
 			},
 		},
 		{
+			Name: "format-config",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+		{
+			// a compression codec or "none"
 			Name: "compress",
 			Validators: []forms.Validator{
 				forms.IsOptional{Default: false},
-				forms.IsBoolean{},
+				compression.IsCodec{Writing: true},
 			},
 		},
 	},
@@ -0,0 +1,124 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"bytes"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/compression"
+	"github.com/kiprotect/kodex/formats"
+	"io"
+	"os"
+)
+
+// The format of a writer. We resolve it when setting up the writer, so that
+// formats provided by plugins can be used.
+type writerFormat struct {
+	name       string
+	config     map[string]interface{}
+	definition kodex.FormatDefinition
+	format     kodex.Format
+}
+
+func makeWriterFormat(name string, config map[string]interface{}) *writerFormat {
+	return &writerFormat{
+		name:   name,
+		config: config,
+	}
+}
+
+func (w *writerFormat) setup(definitions *kodex.Definitions) error {
+	var err error
+	w.definition, w.format, err = formats.Make(definitions, w.name, w.config)
+	return err
+}
+
+// Returns the format, falling back to the built-in formats if the writer
+// was not set up.
+func (w *writerFormat) get() (kodex.Format, error) {
+	if w.format == nil {
+		if err := w.setup(nil); err != nil {
+			return nil, err
+		}
+	}
+	return w.format, nil
+}
+
+// Returns an encoder that writes to the writer. If there is existing data
+// and the format supports it, the encoder appends to that data instead.
+func (w *writerFormat) encoder(writer io.Writer, existing func() (io.ReadCloser, error)) (kodex.ItemEncoder, error) {
+
+	format, err := w.get()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if appendingFormat, ok := format.(kodex.AppendingFormat); ok && existing != nil {
+		existingReader, err := existing()
+		if err != nil {
+			return nil, err
+		}
+		defer existingReader.Close()
+		return appendingFormat.AppendEncoder(writer, existingReader)
+	}
+
+	return format.Encoder(writer)
+}
+
+// Opens existing, possibly compressed data for reading.
+func openExisting(reader io.ReadCloser, codec string) (io.ReadCloser, error) {
+
+	decompressor, err := compression.NewReader(reader, codec, "")
+
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	return &decompressingReadCloser{ReadCloser: decompressor, closer: reader}, nil
+}
+
+func existingFile(path string, codec string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return openExisting(f, codec)
+	}
+}
+
+func existingBytes(data []byte, codec string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return openExisting(io.NopCloser(bytes.NewReader(data)), codec)
+	}
+}
+
+// Closes the decompressor and the underlying reader
+type decompressingReadCloser struct {
+	io.ReadCloser
+	closer io.Closer
+}
+
+func (d *decompressingReadCloser) Close() error {
+	if err := d.ReadCloser.Close(); err != nil {
+		d.closer.Close()
+		return err
+	}
+	return d.closer.Close()
+}
@@ -17,9 +17,10 @@
 package writers
 
 import (
-	"compress/gzip"
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/compression"
+	"github.com/kiprotect/kodex/formats"
 	"io"
 	"os"
 	"path/filepath"
@@ -27,20 +28,88 @@ import (
 	"time"
 )
 
+// Writes items to files. Without any rotation settings or partitions we
+// write one file per run (or per minute with add-time). Otherwise we rotate
+// files by size, item count and age and record closed files in a manifest,
+// see file_rotation.go.
 type FileWriter struct {
-	BasePath string
-	Name     string
-	Format   string
-	Compress bool
-	AddTime  bool
-	mutex    *sync.Mutex
+	BasePath       string
+	Name           string
+	Format         string
+	FormatConfig   map[string]interface{}
+	Compress       string
+	AddTime        bool
+	MaxSize        int64
+	MaxItems       int
+	RotateInterval time.Duration
+	Partitions     []FilePartition
+	MaxOpenFiles   int
+	Manifest       string
+	format         *writerFormat
+	openFile       *openFile
+	partFiles      map[string]*partFile
+	sequences      map[string]int
+	stop           chan bool
+	wg             sync.WaitGroup
+	mutex          *sync.Mutex
+}
+
+// A file that stays open across writes, which we use for file formats like
+// Parquet that cannot be appended to.
+type openFile struct {
+	// the path of the file as determined by the writer
+	path       string
+	file       *os.File
+	compressor io.WriteCloser
+	encoder    kodex.ItemEncoder
+}
+
+func (o *openFile) close() error {
+	err := o.encoder.Close()
+	if compressErr := o.compressor.Close(); err == nil {
+		err = compressErr
+	}
+	if syncErr := o.file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 func (s *FileWriter) Teardown() error {
+
+	if s.stop != nil {
+		close(s.stop)
+		s.wg.Wait()
+		s.stop = nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.partFiles) > 0 {
+		files := make([]*partFile, 0, len(s.partFiles))
+		for _, f := range s.partFiles {
+			files = append(files, f)
+		}
+		return s.rotate(files...)
+	}
+
+	if s.openFile != nil {
+		err := s.openFile.close()
+		s.openFile = nil
+		return err
+	}
 	return nil
 }
 
 func (s *FileWriter) Setup(config kodex.Config) error {
+	if err := s.format.setup(formats.ConfigDefinitions(config)); err != nil {
+		return err
+	}
+	s.startRotation()
 	if s.BasePath == "" {
 		return nil
 	}
@@ -52,60 +121,165 @@ func (s *FileWriter) Setup(config kodex.Config) error {
 		return fmt.Errorf("path must be a directory")
 	}
 
+	if s.rotating() {
+		return s.recoverFiles()
+	}
+
 	return nil
 }
 
-func (s *FileWriter) Write(payload kodex.Payload) error {
+func (s *FileWriter) startRotation() {
+	if s.RotateInterval > 0 && s.stop == nil {
+		s.stop = make(chan bool)
+		s.wg.Add(1)
+		go s.rotateExpired(s.stop)
+	}
+}
 
-	var fileName, extension string
+// Returns the path of the file to write to. If n is greater than zero we
+// add it as a suffix to the name.
+func (s *FileWriter) path(ts int64, n int) string {
+
+	extension := s.Format + compression.Extension(s.Compress)
+
+	name := s.Name
 
-	if s.Compress {
-		extension = fmt.Sprintf("%s.gz", s.Format)
-	} else {
-		extension = s.Format
-	}
 	if s.AddTime {
-		tn := time.Now().UTC().Unix()
-		//we rotate the files every 60 seconds
-		ts := tn - (tn % 60)
-		fileName = fmt.Sprintf("%s-%d.%s", s.Name, ts, extension)
-	} else {
-		fileName = fmt.Sprintf("%s.%s", s.Name, extension)
+		name = fmt.Sprintf("%s-%d", name, ts)
+	}
+
+	if n > 0 {
+		name = fmt.Sprintf("%s-%d", name, n)
+	}
+
+	return filepath.Join(s.BasePath, fmt.Sprintf("%s.%s", name, extension))
+}
+
+func (s *FileWriter) Write(payload kodex.Payload) error {
+
+	if s.rotating() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.writeRotating(payload.Items(), time.Now())
 	}
 
-	fullPath := filepath.Join(s.BasePath, fileName)
+	tn := time.Now().UTC().Unix()
+	//we rotate the files every 60 seconds
+	ts := tn - (tn % 60)
+
+	fullPath := s.path(ts, 0)
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	format, err := s.format.get()
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := format.(kodex.FileFormat); ok {
+		return s.writeToOpenFile(format, fullPath, ts, payload.Items())
+	}
+
 	f, err := os.OpenFile(fullPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
 		return err
 	}
 
-	defer f.Close()
-	defer f.Sync()
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
 
-	var writer io.Writer = f
+	writer, err := compression.NewWriter(f, s.Compress)
 
-	if s.Compress {
-		gzWriter := gzip.NewWriter(writer)
-		writer = gzWriter
-		defer gzWriter.Close()
-		defer gzWriter.Flush()
+	if err != nil {
+		f.Close()
+		return err
 	}
 
-	for _, item := range payload.Items() {
-		serializedItem, err := item.Serialize(s.Format)
+	var existing func() (io.ReadCloser, error)
+
+	// when appending to an existing file some formats (e.g. CSV) need to
+	// read the existing data
+	if info.Size() > 0 {
+		existing = existingFile(fullPath, s.Compress)
+	}
+
+	encoder, err := s.format.encoder(writer, existing)
+
+	if err != nil {
+		writer.Close()
+		f.Close()
+		return err
+	}
+
+	err = kodex.EncodeItems(encoder, payload.Items())
+
+	// closing the compressor writes its trailer (e.g. for zstd or xz), so we
+	// need to check the error before we report the items as written
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (s *FileWriter) writeToOpenFile(format kodex.Format, fullPath string, ts int64, items []*kodex.Item) error {
+
+	// we close the file when rotating
+	if s.openFile != nil && s.openFile.path != fullPath {
+		err := s.openFile.close()
+		s.openFile = nil
 		if err != nil {
 			return err
 		}
-		_, err = writer.Write(serializedItem)
+	}
+
+	if s.openFile == nil {
+
+		var f *os.File
+		var err error
+
+		// we never overwrite existing files but add a suffix instead
+		for n := 0; ; n++ {
+			if f, err = os.OpenFile(s.path(ts, n), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666); err == nil {
+				break
+			} else if !os.IsExist(err) {
+				return err
+			}
+		}
+
+		compressor, err := compression.NewWriter(f, s.Compress)
+
 		if err != nil {
+			f.Close()
 			return err
 		}
-		_, err = writer.Write([]byte("\n"))
-		if err != nil {
+
+		s.openFile = &openFile{
+			path:       fullPath,
+			file:       f,
+			compressor: compressor,
+		}
+
+		if s.openFile.encoder, err = format.Encoder(compressor); err != nil {
+			f.Close()
+			s.openFile = nil
+			return err
+		}
+	}
+
+	for _, item := range items {
+		if err := s.openFile.encoder.Encode(item); err != nil {
 			return err
 		}
 	}
@@ -118,13 +292,23 @@ func MakeFileWriter(config map[string]interface{}) (kodex.Writer, error) {
 	if params, err := FileWriterForm.Validate(config); err != nil {
 		return nil, err
 	} else {
+		format := params["format"].(string)
+		formatConfig := params["format-config"].(map[string]interface{})
 		return &FileWriter{
-			BasePath: params["path"].(string),
-			Name:     params["base-name"].(string),
-			AddTime:  params["add-time"].(bool),
-			Compress: params["compress"].(bool),
-			Format:   params["format"].(string),
-			mutex:    &sync.Mutex{},
+			BasePath:       params["path"].(string),
+			Name:           params["base-name"].(string),
+			AddTime:        params["add-time"].(bool),
+			Compress:       params["compress"].(string),
+			Format:         format,
+			FormatConfig:   formatConfig,
+			MaxSize:        params["max-size"].(int64),
+			MaxItems:       int(params["max-items"].(int64)),
+			RotateInterval: time.Duration(params["rotate-interval"].(float64) * float64(time.Second)),
+			Partitions:     parseFilePartitions(params["partitions"].([]interface{})),
+			MaxOpenFiles:   int(params["max-open-files"].(int64)),
+			Manifest:       params["manifest"].(string),
+			format:         makeWriterFormat(format, formatConfig),
+			mutex:          &sync.Mutex{},
 		}, nil
 	}
 }
@@ -18,6 +18,8 @@ package writers
 
 import (
 	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/compression"
+	"regexp"
 )
 
 var FileWriterForm = forms.Form{
@@ -41,14 +43,22 @@ var FileWriterForm = forms.Form{
 			Name: "format",
 			Validators: []forms.Validator{
 				forms.IsOptional{Default: "json"},
-				forms.IsIn{Choices: []interface{}{"json", "csv"}},
+				forms.IsString{},
 			},
 		},
 		{
+			Name: "format-config",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
+			},
+		},
+		{
+			// a compression codec or "none"
 			Name: "compress",
 			Validators: []forms.Validator{
 				forms.IsOptional{Default: false},
-				forms.IsBoolean{},
+				compression.IsCodec{Writing: true},
 			},
 		},
 		{
@@ -58,5 +68,97 @@ var FileWriterForm = forms.Form{
 				forms.IsBoolean{},
 			},
 		},
+		{
+			// we rotate files once they (approximately) reach this size in
+			// bytes, 0 means no limit
+			Name: "max-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+		{
+			// we rotate files once they contain this many items, 0 means no
+			// limit
+			Name: "max-items",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+		{
+			// we rotate files once they have been open for this long (in
+			// seconds), 0 means no limit
+			Name: "rotate-interval",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0.0},
+				forms.IsFloat{HasMin: true, Min: 0},
+			},
+		},
+		{
+			// Hive-style partitions (e.g. dt=2024-01-01/region=eu) based on
+			// item fields or the event time
+			Name: "partitions",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{}},
+				forms.IsList{
+					Validators: []forms.Validator{
+						forms.IsStringMap{
+							Form: &FilePartitionForm,
+						},
+					},
+				},
+			},
+		},
+		{
+			// if we exceed this number of open (partition) files we rotate
+			// the file that was written to least recently
+			Name: "max-open-files",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 100},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+		{
+			// the file (relative to the path) to which we append the list of
+			// files that were closed on every rotation
+			Name: "manifest",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "_manifest.jsonl"},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+var FilePartitionForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the file partition form",
+	Fields: []forms.Field{
+		{
+			// the name of the partition in the path
+			Name: "name",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.MatchesRegex{Regexp: regexp.MustCompile(`^[A-Za-z0-9_\-\.]+$`)},
+			},
+		},
+		{
+			// the item field to use, defaults to the name of the partition
+			Name: "field",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// if given, we interpret the field as a timestamp (RFC 3339 or
+			// Unix seconds) and format it using this Go time layout, e.g.
+			// 2006-01-02. Without a field we use the current time.
+			Name: "time-format",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
 	},
 }
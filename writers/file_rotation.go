@@ -0,0 +1,599 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/compression"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the partition value that Hive uses for missing values
+const defaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// A Hive-style partition of the output, e.g. region=eu or dt=2024-01-01.
+type FilePartition struct {
+	Name       string
+	Field      string
+	TimeFormat string
+}
+
+// A file that we write to until we rotate it. We write to a hidden
+// temporary file that we rename once the file is complete, so that
+// downstream loaders only ever see complete files.
+type partFile struct {
+	*openFile
+	key       string
+	tmpPath   string
+	partition map[string]string
+	counter   *countingWriter
+	items     int
+	opened    time.Time
+	lastWrite time.Time
+	// whether we can flush the written items to disk without closing the file
+	flushable bool
+}
+
+type flusher interface {
+	Flush() error
+}
+
+// Flushes the compressor and syncs the file, so that the items written so
+// far survive a crash.
+func (f *partFile) flush() error {
+	if compressor, ok := f.compressor.(flusher); ok {
+		if err := compressor.Flush(); err != nil {
+			return err
+		}
+	}
+	return f.file.Sync()
+}
+
+// Counts the bytes written to a file, which we use to check the file size
+// without calling stat after every write.
+type countingWriter struct {
+	writer io.Writer
+	n      int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// A line of the manifest, which lists the files that were closed in a
+// rotation.
+type manifestEntry struct {
+	RotatedAt time.Time      `json:"rotated-at"`
+	Files     []manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Path      string            `json:"path"`
+	Partition map[string]string `json:"partition,omitempty"`
+	Items     int               `json:"items"`
+	Size      int64             `json:"size"`
+	OpenedAt  time.Time         `json:"opened-at"`
+	// whether the file was recovered after a crash, in which case we do
+	// not know the number of items
+	Recovered bool `json:"recovered,omitempty"`
+}
+
+func parseFilePartitions(params []interface{}) []FilePartition {
+	partitions := make([]FilePartition, len(params))
+	for i, param := range params {
+		partitionParams := param.(map[string]interface{})
+		partitions[i] = FilePartition{
+			Name:       partitionParams["name"].(string),
+			Field:      partitionParams["field"].(string),
+			TimeFormat: partitionParams["time-format"].(string),
+		}
+	}
+	return partitions
+}
+
+// Returns the value of the partition for the given item.
+func (p *FilePartition) value(item *kodex.Item, now time.Time) string {
+
+	field := p.Field
+
+	if field == "" && p.TimeFormat == "" {
+		field = p.Name
+	}
+
+	if p.TimeFormat != "" {
+
+		t := now
+
+		if field != "" {
+			value, _ := item.Get(field)
+			var ok bool
+			if t, ok = partitionTime(value); !ok {
+				return defaultPartition
+			}
+		}
+
+		return t.UTC().Format(p.TimeFormat)
+	}
+
+	value, ok := item.Get(field)
+
+	if !ok || value == nil {
+		return defaultPartition
+	}
+
+	if s := fmt.Sprint(value); s != "" {
+		return s
+	}
+
+	return defaultPartition
+}
+
+func partitionTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, true
+		}
+	case int64:
+		return time.Unix(v, 0), true
+	case int:
+		return time.Unix(int64(v), 0), true
+	case float64:
+		return time.Unix(0, int64(v*1e9)), true
+	}
+	return time.Time{}, false
+}
+
+// Escapes characters that are not allowed in partition values, like Hive
+// does.
+func escapePartitionValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Returns the partition path (e.g. dt=2024-01-01/region=eu) and values for
+// the given item.
+func (s *FileWriter) partition(item *kodex.Item, now time.Time) (string, map[string]string) {
+
+	if len(s.Partitions) == 0 {
+		return "", nil
+	}
+
+	values := make(map[string]string, len(s.Partitions))
+	dirs := make([]string, len(s.Partitions))
+
+	for i, partition := range s.Partitions {
+		value := partition.value(item, now)
+		values[partition.Name] = value
+		dirs[i] = partition.Name + "=" + escapePartitionValue(value)
+	}
+
+	return strings.Join(dirs, "/"), values
+}
+
+// Returns true if the writer rotates files.
+func (s *FileWriter) rotating() bool {
+	return s.MaxSize > 0 || s.MaxItems > 0 || s.RotateInterval > 0 || len(s.Partitions) > 0
+}
+
+func (s *FileWriter) full(f *partFile) bool {
+	return (s.MaxItems > 0 && f.items >= s.MaxItems) || (s.MaxSize > 0 && f.counter.n >= s.MaxSize)
+}
+
+func (s *FileWriter) expired(f *partFile, now time.Time) bool {
+	return s.RotateInterval > 0 && now.Sub(f.opened) >= s.RotateInterval
+}
+
+func (s *FileWriter) writeRotating(items []*kodex.Item, now time.Time) error {
+
+	if s.partFiles == nil {
+		s.partFiles = make(map[string]*partFile)
+		s.sequences = make(map[string]int)
+	}
+
+	// we group the items by partition but keep their order
+	keys := make([]string, 0)
+	groups := make(map[string][]*kodex.Item)
+	values := make(map[string]map[string]string)
+
+	for _, item := range items {
+		key, partitionValues := s.partition(item, now)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+			values[key] = partitionValues
+		}
+		groups[key] = append(groups[key], item)
+	}
+
+	written := make(map[*partFile]bool)
+
+	for _, key := range keys {
+
+		items := groups[key]
+
+		for len(items) > 0 {
+
+			f, err := s.partFile(key, values[key], now)
+
+			if err != nil {
+				return err
+			}
+
+			n := len(items)
+
+			if s.MaxItems > 0 && n > s.MaxItems-f.items {
+				n = s.MaxItems - f.items
+			}
+
+			for _, item := range items[:n] {
+				if err := f.encoder.Encode(item); err != nil {
+					return err
+				}
+			}
+
+			f.items += n
+			f.lastWrite = now
+			items = items[n:]
+			written[f] = true
+
+			if s.full(f) {
+				delete(written, f)
+				if err := s.rotate(f); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// the items get acknowledged once we return, so we make sure that they
+	// are on disk. If the file cannot be flushed (e.g. with xz compression
+	// or Parquet) we need to rotate it instead.
+	for f := range written {
+		if f.flushable {
+			if err := f.flush(); err != nil {
+				return err
+			}
+		} else if err := s.rotate(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Moves temporary files that were left behind by a crash to their final
+// location, as they contain items that were already acknowledged. We assume
+// that no other writer uses the same base path and name. The last items in
+// a recovered file might be incomplete, these were not acknowledged and will
+// be written again.
+func (s *FileWriter) recoverFiles() error {
+
+	extension := s.Format + compression.Extension(s.Compress)
+	prefix := "." + s.Name + "-"
+	suffix := "." + extension + ".tmp"
+
+	entry := &manifestEntry{
+		RotatedAt: time.Now().UTC(),
+		Files:     make([]manifestFile, 0),
+	}
+
+	err := filepath.Walk(s.BasePath, func(tmpPath string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		name := info.Name()
+
+		if info.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			return nil
+		}
+
+		path := filepath.Join(filepath.Dir(tmpPath), strings.TrimSuffix(name[1:], ".tmp"))
+
+		if _, err := os.Stat(path); err == nil {
+			kodex.Log.Warningf("Cannot recover %s, %s exists already", tmpPath, path)
+			return nil
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := os.Rename(tmpPath, path); err != nil {
+			return err
+		}
+
+		kodex.Log.Warningf("Recovered incomplete file %s", path)
+
+		relPath, err := filepath.Rel(filepath.Join(s.BasePath, "."), path)
+
+		if err != nil {
+			relPath = path
+		}
+
+		entry.Files = append(entry.Files, manifestFile{
+			Path:      filepath.ToSlash(relPath),
+			Size:      info.Size(),
+			OpenedAt:  info.ModTime().UTC(),
+			Recovered: true,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if len(entry.Files) > 0 && s.Manifest != "" {
+		return s.writeManifest(entry)
+	}
+
+	return nil
+}
+
+// Returns the open file for the given partition, opening a new one if
+// necessary.
+func (s *FileWriter) partFile(key string, values map[string]string, now time.Time) (*partFile, error) {
+
+	if f, ok := s.partFiles[key]; ok {
+		if !s.expired(f, now) {
+			return f, nil
+		}
+		if err := s.rotate(f); err != nil {
+			return nil, err
+		}
+	}
+
+	// we close the file that was written to least recently
+	if len(s.partFiles) >= s.MaxOpenFiles {
+		var oldest *partFile
+		for _, f := range s.partFiles {
+			if oldest == nil || f.lastWrite.Before(oldest.lastWrite) {
+				oldest = f
+			}
+		}
+		if err := s.rotate(oldest); err != nil {
+			return nil, err
+		}
+	}
+
+	dir := filepath.Join(s.BasePath, filepath.FromSlash(key))
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	name := s.Name
+
+	if s.AddTime {
+		name = fmt.Sprintf("%s-%d", name, now.Unix())
+	}
+
+	extension := s.Format + compression.Extension(s.Compress)
+
+	var file *os.File
+	var path, tmpPath string
+
+	// we never overwrite existing files (e.g. from an earlier run)
+	for {
+		s.sequences[key]++
+		fileName := fmt.Sprintf("%s-%04d.%s", name, s.sequences[key], extension)
+		path = filepath.Join(dir, fileName)
+		tmpPath = filepath.Join(dir, "."+fileName+".tmp")
+
+		if _, err := os.Stat(path); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		var err error
+
+		if file, err = os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666); err == nil {
+			break
+		} else if !os.IsExist(err) {
+			return nil, err
+		}
+	}
+
+	counter := &countingWriter{writer: file}
+
+	compressor, err := compression.NewWriter(counter, s.Compress)
+
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	format, err := s.format.get()
+
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	encoder, err := s.format.encoder(compressor, nil)
+
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	_, fileFormat := format.(kodex.FileFormat)
+	_, flushable := compressor.(flusher)
+
+	f := &partFile{
+		openFile: &openFile{
+			path:       path,
+			file:       file,
+			compressor: compressor,
+			encoder:    encoder,
+		},
+		key:       key,
+		tmpPath:   tmpPath,
+		partition: values,
+		counter:   counter,
+		opened:    now,
+		lastWrite: now,
+		// Parquet (and other file formats) buffer the items until the file
+		// gets closed
+		flushable: !fileFormat && (flushable || s.Compress == compression.None),
+	}
+
+	s.partFiles[key] = f
+
+	return f, nil
+}
+
+// Closes the given files, moves them to their final location and adds them
+// to the manifest.
+func (s *FileWriter) rotate(files ...*partFile) error {
+
+	var firstErr error
+
+	entry := &manifestEntry{
+		RotatedAt: time.Now().UTC(),
+		Files:     make([]manifestFile, 0, len(files)),
+	}
+
+	for _, f := range files {
+
+		delete(s.partFiles, f.key)
+
+		err := f.close()
+
+		if err == nil {
+			err = os.Rename(f.tmpPath, f.path)
+		}
+
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		path := f.path
+
+		if relPath, err := filepath.Rel(filepath.Join(s.BasePath, "."), f.path); err == nil {
+			path = relPath
+		}
+
+		entry.Files = append(entry.Files, manifestFile{
+			Path:      filepath.ToSlash(path),
+			Partition: f.partition,
+			Items:     f.items,
+			Size:      f.counter.n,
+			OpenedAt:  f.opened.UTC(),
+		})
+	}
+
+	if len(entry.Files) > 0 && s.Manifest != "" {
+		if err := s.writeManifest(entry); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (s *FileWriter) writeManifest(entry *manifestEntry) error {
+
+	data, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.BasePath, s.Manifest), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0666)
+
+	if err != nil {
+		return err
+	}
+
+	// we write every entry with a single call so that readers never see
+	// partial lines
+	_, err = f.Write(append(data, '\n'))
+
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Rotates files that have been open for longer than the rotation interval,
+// even if no new items arrive.
+func (s *FileWriter) rotateExpired(stop chan bool) {
+
+	defer s.wg.Done()
+
+	interval := s.RotateInterval
+
+	if interval > time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			expired := make([]*partFile, 0)
+			for _, f := range s.partFiles {
+				if s.expired(f, now) {
+					expired = append(expired, f)
+				}
+			}
+			if len(expired) > 0 {
+				if err := s.rotate(expired...); err != nil {
+					kodex.Log.Error(err)
+				}
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,587 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/compression"
+	"github.com/kiprotect/kodex/readers"
+	"github.com/kiprotect/kodex/writers"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVFileWriter(t *testing.T) {
+
+	path := t.TempDir()
+
+	writer, err := writers.MakeFileWriter(map[string]interface{}{
+		"path":      path,
+		"base-name": "items",
+		"format":    "csv",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := [][]*kodex.Item{
+		{kodex.MakeItem(map[string]interface{}{"name": "a, b", "count": int64(1)})},
+		{kodex.MakeItem(map[string]interface{}{"name": "c", "count": int64(2)})},
+	}
+
+	for _, items := range payloads {
+		if err := writer.Write(kodex.MakeBasicPayload(items, nil, false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filename := filepath.Join(path, "items.csv")
+
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the header should only be written once
+	if string(data) != "count,name\r\n1,\"a, b\"\r\n2,c\r\n" {
+		t.Fatalf("Unexpected file content: %q", string(data))
+	}
+
+	reader, err := readers.MakeFileReader(map[string]interface{}{
+		"path":   filename,
+		"format": "csv",
+		"format-config": map[string]interface{}{
+			"columns": []interface{}{
+				map[string]interface{}{"name": "count", "type": "int"},
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer reader.Teardown()
+
+	payload, err := reader.Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := payload.Items()
+
+	if len(items) != 2 {
+		t.Fatalf("Expected two items, got %d", len(items))
+	}
+
+	if count, _ := items[1].Get("count"); count != int64(2) {
+		t.Errorf("Expected a count of 2, got %v", count)
+	}
+}
+
+func TestParquetFileWriter(t *testing.T) {
+
+	path := t.TempDir()
+
+	config := map[string]interface{}{
+		"path":      path,
+		"base-name": "items",
+		"format":    "parquet",
+		"format-config": map[string]interface{}{
+			"row-group-size": 2,
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+
+		writer, err := writers.MakeFileWriter(config)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := writer.Setup(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		// all payloads end up in the same file
+		for j := 0; j < 3; j++ {
+			items := []*kodex.Item{kodex.MakeItem(map[string]interface{}{"id": int64(j), "name": "test"})}
+			if err := writer.Write(kodex.MakeBasicPayload(items, nil, false)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := writer.Teardown(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// existing files are never overwritten
+	for _, name := range []string{"items.parquet", "items-1.parquet"} {
+
+		reader, err := readers.MakeFileReader(map[string]interface{}{
+			"path":   filepath.Join(path, name),
+			"format": "parquet",
+			"format-config": map[string]interface{}{
+				"columns": []interface{}{"id"},
+			},
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := reader.Setup(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		payload, err := reader.Read()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader.Teardown()
+
+		items := payload.Items()
+
+		if len(items) != 3 {
+			t.Fatalf("Expected three items, got %d", len(items))
+		}
+
+		if id, _ := items[2].Get("id"); id != int64(2) {
+			t.Errorf("Expected an ID of 2, got %v", id)
+		}
+
+		if _, ok := items[2].Get("name"); ok {
+			t.Errorf("Expected the name column to be skipped")
+		}
+	}
+}
+
+func TestCompressedFileWriter(t *testing.T) {
+
+	for _, codec := range []string{"gzip", "zstd", "xz"} {
+
+		t.Run(codec, func(t *testing.T) {
+
+			path := t.TempDir()
+
+			writer, err := writers.MakeFileWriter(map[string]interface{}{
+				"path":      path,
+				"base-name": "items",
+				"format":    "csv",
+				"compress":  codec,
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := writer.Setup(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			// we append to the compressed file
+			for i := 0; i < 2; i++ {
+				items := []*kodex.Item{kodex.MakeItem(map[string]interface{}{"id": int64(i)})}
+				if err := writer.Write(kodex.MakeBasicPayload(items, nil, false)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			matches, _ := filepath.Glob(filepath.Join(path, "items.csv.*"))
+
+			if len(matches) != 1 {
+				t.Fatalf("Expected one file, got %v", matches)
+			}
+
+			// the codec is detected from the extension
+			reader, err := readers.MakeFileReader(map[string]interface{}{
+				"path":       matches[0],
+				"format":     "csv",
+				"compressed": "auto",
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := reader.Setup(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			defer reader.Teardown()
+
+			payload, err := reader.Read()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			items := payload.Items()
+
+			if len(items) != 2 {
+				t.Fatalf("Expected two items, got %d", len(items))
+			}
+
+			if id, _ := items[1].Get("id"); id != "1" {
+				t.Errorf("Expected an ID of 1, got %v", id)
+			}
+		})
+	}
+}
+
+// Reads the manifest and returns the paths of the files for every rotation.
+func readManifest(t *testing.T, path string) [][]string {
+
+	data, err := os.ReadFile(filepath.Join(path, "_manifest.jsonl"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotations := make([][]string, 0)
+
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+
+		var entry struct {
+			Files []struct {
+				Path  string `json:"path"`
+				Items int    `json:"items"`
+			} `json:"files"`
+		}
+
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+
+		paths := make([]string, 0)
+
+		for _, file := range entry.Files {
+			paths = append(paths, fmt.Sprintf("%s:%d", file.Path, file.Items))
+		}
+
+		sort.Strings(paths)
+		rotations = append(rotations, paths)
+	}
+
+	return rotations
+}
+
+// A compressor that fails to write its trailer when it gets closed
+type failingCompressor struct {
+	io.Writer
+}
+
+func (f failingCompressor) Close() error {
+	return fmt.Errorf("cannot write trailer")
+}
+
+func TestFileWriterCompressorError(t *testing.T) {
+
+	compression.Codecs["failing"] = &compression.Codec{
+		Name:      "failing",
+		Extension: ".fail",
+		Writer: func(writer io.Writer) (io.WriteCloser, error) {
+			return failingCompressor{writer}, nil
+		},
+	}
+
+	defer delete(compression.Codecs, "failing")
+
+	writer, err := writers.MakeFileWriter(map[string]interface{}{
+		"path":      t.TempDir(),
+		"base-name": "items",
+		"format":    "csv",
+		"compress":  "failing",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	items := []*kodex.Item{kodex.MakeItem(map[string]interface{}{"id": int64(1)})}
+
+	// the items were not written completely, so we must not report success
+	if err := writer.Write(kodex.MakeBasicPayload(items, nil, false)); err == nil {
+		t.Fatalf("Expected an error when closing the compressor fails")
+	}
+}
+
+func TestPartitionedFileWriter(t *testing.T) {
+
+	path := t.TempDir()
+
+	config := map[string]interface{}{
+		"path":      path,
+		"base-name": "part",
+		"format":    "ndjson",
+		"compress":  "gzip",
+		"max-items": 2,
+		"partitions": []interface{}{
+			map[string]interface{}{"name": "dt", "field": "ts", "time-format": "2006-01-02"},
+			map[string]interface{}{"name": "region"},
+		},
+	}
+
+	writer, err := writers.MakeFileWriter(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	items := []*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"ts": "2024-01-01T10:00:00Z", "region": "eu"}),
+		kodex.MakeItem(map[string]interface{}{"ts": "2024-01-01T11:00:00Z", "region": "us"}),
+		kodex.MakeItem(map[string]interface{}{"ts": "2024-01-01T12:00:00Z", "region": "eu"}),
+		kodex.MakeItem(map[string]interface{}{"ts": "2024-01-01T13:00:00Z", "region": "eu"}),
+		kodex.MakeItem(map[string]interface{}{"ts": "2024-01-02T10:00:00Z", "region": "a/b"}),
+		kodex.MakeItem(map[string]interface{}{"ts": "invalid"}),
+	}
+
+	if err := writer.Write(kodex.MakeBasicPayload(items, nil, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first file is complete, the others are still being written to
+	if rotations := readManifest(t, path); len(rotations) != 1 || rotations[0][0] != "dt=2024-01-01/region=eu/part-0001.ndjson.gz:2" {
+		t.Fatalf("Unexpected manifest: %v", rotations)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(path, "*", "*", "*.gz")); len(matches) != 1 {
+		t.Fatalf("Expected one complete file, got %v", matches)
+	}
+
+	if err := writer.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotations := readManifest(t, path)
+
+	expected := []string{
+		"dt=2024-01-01/region=eu/part-0002.ndjson.gz:1",
+		"dt=2024-01-01/region=us/part-0001.ndjson.gz:1",
+		"dt=2024-01-02/region=a%2Fb/part-0001.ndjson.gz:1",
+		"dt=__HIVE_DEFAULT_PARTITION__/region=__HIVE_DEFAULT_PARTITION__/part-0001.ndjson.gz:1",
+	}
+
+	if len(rotations) != 2 || strings.Join(rotations[1], " ") != strings.Join(expected, " ") {
+		t.Fatalf("Unexpected manifest: %v", rotations)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(path, "*", "*", ".*.tmp")); len(matches) != 0 {
+		t.Fatalf("Expected no temporary files, got %v", matches)
+	}
+
+	reader, err := readers.MakeFileReader(map[string]interface{}{
+		"path":       filepath.Join(path, "dt=2024-01-01", "region=eu", "part-0001.ndjson.gz"),
+		"format":     "ndjson",
+		"compressed": "auto",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reader.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer reader.Teardown()
+
+	payload, err := reader.Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(payload.Items()) != 2 {
+		t.Fatalf("Expected two items, got %d", len(payload.Items()))
+	}
+
+	// existing files are never overwritten
+	writer, err = writers.MakeFileWriter(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Write(kodex.MakeBasicPayload(items[:1], nil, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+
+	if rotations := readManifest(t, path); len(rotations) != 3 || rotations[2][0] != "dt=2024-01-01/region=eu/part-0003.ndjson.gz:1" {
+		t.Fatalf("Unexpected manifest: %v", rotations)
+	}
+}
+
+func TestRotatingFileWriter(t *testing.T) {
+
+	for _, test := range []struct {
+		config    map[string]interface{}
+		wait      time.Duration
+		rotations int
+	}{
+		// every write exceeds the size
+		{map[string]interface{}{"max-size": 1}, 0, 3},
+		// we rotate in the background
+		{map[string]interface{}{"rotate-interval": 0.05}, 200 * time.Millisecond, 1},
+	} {
+
+		path := t.TempDir()
+
+		test.config["path"] = path
+		test.config["base-name"] = "items"
+
+		writer, err := writers.MakeFileWriter(test.config)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := writer.Setup(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			items := []*kodex.Item{kodex.MakeItem(map[string]interface{}{"id": int64(i)})}
+			if err := writer.Write(kodex.MakeBasicPayload(items, nil, false)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		time.Sleep(test.wait)
+
+		if rotations := readManifest(t, path); len(rotations) != test.rotations {
+			t.Errorf("Expected %d rotations for %v, got %v", test.rotations, test.config, rotations)
+		}
+
+		if err := writer.Teardown(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRotatingFileWriterRecovery(t *testing.T) {
+
+	path := t.TempDir()
+
+	config := map[string]interface{}{
+		"path":      path,
+		"base-name": "items",
+		"format":    "ndjson",
+		"compress":  "gzip",
+		"max-items": 10,
+	}
+
+	writer, err := writers.MakeFileWriter(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	items := []*kodex.Item{
+		kodex.MakeItem(map[string]interface{}{"id": int64(1)}),
+		kodex.MakeItem(map[string]interface{}{"id": int64(2)}),
+	}
+
+	if err := writer.Write(kodex.MakeBasicPayload(items, nil, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the items are acknowledged once we return, so they need to be on disk
+	data, err := os.ReadFile(filepath.Join(path, ".items-0001.ndjson.gz.tmp"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the gzip trailer is only written when the file gets closed
+	if data, err := io.ReadAll(gzipReader); err != io.ErrUnexpectedEOF || strings.Count(string(data), "\n") != 2 {
+		t.Fatalf("Expected two flushed items, got %q (%v)", data, err)
+	}
+
+	// we simulate a crash by not tearing down the writer, the next writer
+	// should recover the file
+	writer, err = writers.MakeFileWriter(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer writer.Teardown()
+
+	if rotations := readManifest(t, path); len(rotations) != 1 || rotations[0][0] != "items-0001.ndjson.gz:0" {
+		t.Fatalf("Unexpected manifest: %v", rotations)
+	}
+
+	if _, err := os.Stat(filepath.Join(path, "items-0001.ndjson.gz")); err != nil {
+		t.Fatalf("Expected the file to be recovered: %v", err)
+	}
+}
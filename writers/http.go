@@ -17,55 +17,243 @@
 package writers
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/formats"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Sends items to an HTTP endpoint via POST requests. Requests that fail
+// with a network error, a 408, 429 or 5xx status are retried with
+// exponential backoff, other non-2xx responses fail immediately.
+//
+// If a batch size is given, items from concurrent writes are combined into
+// batches. A write only returns once the batch containing its items has been
+// sent, so payloads are never acknowledged before the endpoint accepted them.
 type HTTPWriter struct {
-	Format  string
-	URL     string
-	Config  kodex.Config
-	Headers map[string]interface{}
+	Format        string
+	URL           string
+	Config        kodex.Config
+	Headers       map[string]interface{}
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	MaxRetries    int
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	TLS           map[string]interface{}
+	Signing       map[string]interface{}
+	format        *writerFormat
+	client        *http.Client
+	batch         *httpBatch
+	mutex         sync.Mutex
+}
+
+type httpBatch struct {
+	items []*kodex.Item
+	timer *time.Timer
+	done  chan bool
+	err   error
+}
+
+// an error for which we retry the request
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
 }
 
 func (s *HTTPWriter) Teardown() error {
+
+	s.mutex.Lock()
+	batch := s.batch
+	s.mutex.Unlock()
+
+	// we send the items that are still waiting
+	if batch != nil {
+		s.flush(context.Background(), batch)
+		<-batch.done
+	}
+
+	if s.client != nil {
+		s.client.CloseIdleConnections()
+	}
+
 	return nil
 }
 
 func (s *HTTPWriter) Setup(config kodex.Config) error {
+
 	s.Config = config
+
+	if err := s.format.setup(formats.ConfigDefinitions(config)); err != nil {
+		return err
+	}
+
+	tlsConfig, err := makeTLSConfig(s.TLS)
+
+	if err != nil {
+		return err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	s.client = &http.Client{
+		Timeout:   s.Timeout,
+		Transport: transport,
+	}
+
 	return nil
 }
 
 func (s *HTTPWriter) Write(payload kodex.Payload) error {
+	return s.WriteContext(context.Background(), payload)
+}
 
-	b := make([]byte, 0)
-	buf := bytes.NewBuffer(b)
-	writer := bufio.NewWriter(buf)
+// Writes the payload, giving up on the request (or the wait for the batch)
+// once the context is cancelled. A batch that contains the items might still
+// be sent in that case.
+func (s *HTTPWriter) WriteContext(ctx context.Context, payload kodex.Payload) error {
 
-	for _, item := range payload.Items() {
-		serializedItem, err := item.Serialize(s.Format)
-		if err != nil {
-			return err
+	if s.client == nil {
+		return fmt.Errorf("writer is not set up")
+	}
+
+	items := payload.Items()
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	if s.BatchSize == 0 {
+		return s.send(ctx, items)
+	}
+
+	s.mutex.Lock()
+
+	batch := s.batch
+
+	if batch == nil {
+		batch = &httpBatch{done: make(chan bool)}
+		batch.timer = time.AfterFunc(s.FlushInterval, func() { s.flush(context.Background(), batch) })
+		s.batch = batch
+	}
+
+	batch.items = append(batch.items, items...)
+	full := len(batch.items) >= s.BatchSize
+
+	s.mutex.Unlock()
+
+	if full {
+		s.flush(ctx, batch)
+	}
+
+	select {
+	case <-batch.done:
+		return batch.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sends the batch unless another goroutine already took care of it.
+func (s *HTTPWriter) flush(ctx context.Context, batch *httpBatch) {
+
+	s.mutex.Lock()
+
+	if s.batch != batch {
+		s.mutex.Unlock()
+		return
+	}
+
+	s.batch = nil
+	s.mutex.Unlock()
+
+	batch.timer.Stop()
+
+	for items := batch.items; len(items) > 0 && batch.err == nil; {
+		n := len(items)
+		if n > s.BatchSize {
+			n = s.BatchSize
 		}
-		_, err = writer.Write(serializedItem)
-		if err != nil {
-			return err
+		batch.err = s.send(ctx, items[:n])
+		items = items[n:]
+	}
+
+	close(batch.done)
+}
+
+func (s *HTTPWriter) send(ctx context.Context, items []*kodex.Item) error {
+
+	var buf bytes.Buffer
+
+	if encoder, err := s.format.encoder(&buf, nil); err != nil {
+		return err
+	} else if err := kodex.EncodeItems(encoder, items); err != nil {
+		return err
+	}
+
+	body := buf.Bytes()
+
+	for attempt := 0; ; attempt++ {
+
+		err := s.post(ctx, body)
+
+		if err == nil {
+			return nil
 		}
-		_, err = writer.Write([]byte("\n"))
-		if err != nil {
+
+		retryable, ok := err.(*retryableError)
+
+		if !ok || attempt >= s.MaxRetries {
 			return err
 		}
+
+		delay := s.Backoff << attempt
+
+		// we also end up here if the shift overflows
+		if delay > s.MaxBackoff || delay < s.Backoff {
+			delay = s.MaxBackoff
+		}
+
+		if retryable.retryAfter > 0 {
+			delay = retryable.retryAfter
+			if delay > s.MaxBackoff {
+				delay = s.MaxBackoff
+			}
+		}
+
+		kodex.Log.Warningf("HTTP request failed, retrying in %v: %v", delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+}
+
+func (s *HTTPWriter) post(ctx context.Context, body []byte) error {
 
-	writer.Flush()
+	req, err := http.NewRequestWithContext(ctx, "POST", s.URL, bytes.NewReader(body))
 
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", s.URL, buf)
+	if err != nil {
+		return err
+	}
 
 	for k, v := range s.Headers {
 		req.Header.Add(k, v.(string))
@@ -77,21 +265,98 @@ func (s *HTTPWriter) Write(payload kodex.Payload) error {
 		req.Header.Add("X-KIP-Config", hex.EncodeToString(s.Config.ID()))
 	}
 
-	req.Header.Add("Content-Type", fmt.Sprintf("application/%s", s.Format))
+	req.Header.Set("Content-Type", s.format.definition.MIMEType)
 
-	_, err = client.Do(req)
+	if s.Signing != nil {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(s.Signing["timestamp-header"].(string), timestamp)
+		req.Header.Set(s.Signing["header"].(string), "sha256="+HTTPSignature([]byte(s.Signing["secret"].(string)), timestamp, body))
+	}
+
+	resp, err := s.client.Do(req)
+
+	if err != nil {
+		return &retryableError{err: err}
+	}
+
+	defer resp.Body.Close()
+
+	// we read (part of) the body so that we can reuse the connection and
+	// include the response in the error message
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("HTTP request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(response)))
+
+	if resp.StatusCode == 408 || resp.StatusCode == 429 || resp.StatusCode >= 500 {
+		return &retryableError{err: err, retryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+	}
 
 	return err
 }
 
+// Returns the signature of a request body, which is the hex-encoded
+// HMAC-SHA256 of the timestamp and the body, separated by a dot. Including
+// the timestamp allows receivers to reject replayed requests.
+func HTTPSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Parses a Retry-After header, which contains either a number of seconds
+// or an HTTP date.
+func retryAfter(value string) time.Duration {
+
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
 func MakeHTTPWriter(config map[string]interface{}) (kodex.Writer, error) {
 	if params, err := HTTPWriterForm.Validate(config); err != nil {
 		return nil, err
 	} else {
-		return &HTTPWriter{
-			Format:  params["format"].(string),
-			URL:     params["url"].(string),
-			Headers: params["headers"].(map[string]interface{}),
-		}, nil
+		writer := &HTTPWriter{
+			Format:        params["format"].(string),
+			format:        makeWriterFormat(params["format"].(string), params["format-config"].(map[string]interface{})),
+			URL:           params["url"].(string),
+			Headers:       params["headers"].(map[string]interface{}),
+			BatchSize:     int(params["batch-size"].(int64)),
+			FlushInterval: seconds(params["flush-interval"].(float64)),
+			Timeout:       seconds(params["timeout"].(float64)),
+			MaxRetries:    int(params["max-retries"].(int64)),
+			Backoff:       seconds(params["backoff"].(float64)),
+			MaxBackoff:    seconds(params["max-backoff"].(float64)),
+			TLS:           params["tls"].(map[string]interface{}),
+		}
+		if signing, ok := params["signing"].(map[string]interface{}); ok {
+			writer.Signing = signing
+		}
+		return writer, nil
 	}
 }
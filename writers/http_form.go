@@ -26,20 +26,28 @@ var HTTPWriterForm = forms.Form{
 		{
 			Name: "format",
 			Validators: []forms.Validator{
-				forms.IsIn{Choices: []interface{}{"json"}},
+				forms.IsOptional{Default: "json"},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "format-config",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{},
 			},
 		},
 		{
 			Name: "url",
 			Validators: []forms.Validator{
-				forms.IsOptional{Default: false},
+				forms.IsRequired{},
 				forms.IsString{},
 			},
 		},
 		{
 			Name: "headers",
 			Validators: []forms.Validator{
-				forms.IsOptional{Default: false},
+				forms.IsOptional{Default: map[string]interface{}{}},
 				forms.IsStringMap{
 					Form: &forms.Form{
 						Fields: []forms.Field{
@@ -54,5 +62,104 @@ var HTTPWriterForm = forms.Form{
 				},
 			},
 		},
+		{
+			// the maximum number of items per request, 0 means that we send
+			// every payload in a single request
+			Name: "batch-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+		{
+			// how long we wait for more items before sending a batch that is
+			// not full (in seconds)
+			Name: "flush-interval",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 1.0},
+				forms.IsFloat{HasMin: true, Min: 0},
+			},
+		},
+		{
+			// the timeout for a single request (in seconds)
+			Name: "timeout",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 30.0},
+				forms.IsFloat{HasMin: true, Min: 0.01},
+			},
+		},
+		{
+			// how often we retry failed requests
+			Name: "max-retries",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 5},
+				forms.IsInteger{HasMin: true, Min: 0},
+			},
+		},
+		{
+			// the delay before the first retry, which doubles with every
+			// further retry (in seconds)
+			Name: "backoff",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 0.5},
+				forms.IsFloat{HasMin: true, Min: 0},
+			},
+		},
+		{
+			// the maximum delay between retries, which also limits delays
+			// requested by the server via Retry-After (in seconds)
+			Name: "max-backoff",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 30.0},
+				forms.IsFloat{HasMin: true, Min: 0},
+			},
+		},
+		{
+			Name: "tls",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{
+					Form: &TLSForm,
+				},
+			},
+		},
+		{
+			Name: "signing",
+			Validators: []forms.Validator{
+				forms.IsOptional{},
+				forms.IsStringMap{
+					Form: &HTTPSigningForm,
+				},
+			},
+		},
+	},
+}
+
+// If configured we sign requests with HMAC-SHA256, so that receivers can
+// verify that the data comes from us and was not modified.
+var HTTPSigningForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the HTTP signing form",
+	Fields: []forms.Field{
+		{
+			Name: "secret",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{MinLength: 16},
+			},
+		},
+		{
+			Name: "header",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "X-Kodex-Signature"},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "timestamp-header",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "X-Kodex-Timestamp"},
+				forms.IsString{},
+			},
+		},
 	},
 }
@@ -0,0 +1,290 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/writers"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the requests a test server received.
+type testRequests struct {
+	bodies [][]byte
+	mutex  sync.Mutex
+}
+
+func (r *testRequests) add(t *testing.T, req *http.Request) []byte {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.bodies = append(r.bodies, body)
+	return body
+}
+
+func (r *testRequests) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.bodies)
+}
+
+func makeTestHTTPWriter(t *testing.T, config map[string]interface{}) kodex.Writer {
+
+	writer, err := writers.MakeHTTPWriter(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { writer.Teardown() })
+
+	return writer
+}
+
+func testPayload(n int) kodex.Payload {
+	items := make([]*kodex.Item, n)
+	for i := range items {
+		items[i] = kodex.MakeItem(map[string]interface{}{"i": int64(i)})
+	}
+	return kodex.MakeBasicPayload(items, nil, false)
+}
+
+func TestHTTPWriterBatches(t *testing.T) {
+
+	requests := &testRequests{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("Unexpected content type: %s", r.Header.Get("Content-Type"))
+		}
+		requests.add(t, r)
+	}))
+
+	defer server.Close()
+
+	writer := makeTestHTTPWriter(t, map[string]interface{}{
+		"url":            server.URL,
+		"format":         "ndjson",
+		"batch-size":     2,
+		"flush-interval": 0.2,
+	})
+
+	// large payloads are split
+	if err := writer.Write(testPayload(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent writes are combined
+	var wg sync.WaitGroup
+
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := writer.Write(testPayload(1)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// batches that are not full are sent after the flush interval
+	if err := writer.Write(testPayload(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []int
+
+	for _, body := range requests.bodies {
+		lines = append(lines, bytes.Count(body, []byte("\n")))
+	}
+
+	if len(lines) != 4 || lines[0] != 2 || lines[1] != 1 || lines[2] != 2 || lines[3] != 1 {
+		t.Fatalf("Unexpected batches: %v", lines)
+	}
+}
+
+func TestHTTPWriterRetries(t *testing.T) {
+
+	var status []int
+	var mutex sync.Mutex
+
+	requests := &testRequests{}
+
+	// responds with the given status codes, and 200 afterwards
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.add(t, r)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if len(status) > 0 {
+			if status[0] == 429 {
+				w.Header().Set("Retry-After", "3600")
+			}
+			w.WriteHeader(status[0])
+			status = status[1:]
+		}
+	}))
+
+	defer server.Close()
+
+	writer := makeTestHTTPWriter(t, map[string]interface{}{
+		"url":         server.URL,
+		"max-retries": 3,
+		"backoff":     0.01,
+		"max-backoff": 0.05,
+	})
+
+	for _, test := range []struct {
+		status   []int
+		attempts int
+		fails    bool
+	}{
+		{nil, 1, false},
+		// the Retry-After delay is limited by the maximum backoff
+		{[]int{500, 429, 503}, 4, false},
+		{[]int{500, 500, 500, 500}, 4, true},
+		{[]int{401}, 1, true},
+		{[]int{404, 500}, 1, true},
+	} {
+
+		mutex.Lock()
+		status = test.status
+		mutex.Unlock()
+
+		before := requests.count()
+
+		err := writer.Write(testPayload(1))
+
+		if (err != nil) != test.fails {
+			t.Errorf("Unexpected result for %v: %v", test.status, err)
+		}
+
+		if attempts := requests.count() - before; attempts != test.attempts {
+			t.Errorf("Expected %d attempts for %v, got %d", test.attempts, test.status, attempts)
+		}
+	}
+}
+
+func TestHTTPWriterTimeout(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+
+	defer server.Close()
+
+	writer := makeTestHTTPWriter(t, map[string]interface{}{
+		"url":         server.URL,
+		"timeout":     0.05,
+		"max-retries": 0,
+	})
+
+	if err := writer.Write(testPayload(1)); err == nil {
+		t.Fatal("Expected a timeout error")
+	}
+}
+
+func TestHTTPWriterTLSAndSigning(t *testing.T) {
+
+	secret := "a-secret-that-is-long-enough"
+	requests := &testRequests{}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		body := requests.add(t, r)
+
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(401)
+			return
+		}
+
+		timestamp := r.Header.Get("X-Kodex-Timestamp")
+
+		if r.Header.Get("X-Kodex-Signature") != "sha256="+writers.HTTPSignature([]byte(secret), timestamp, body) {
+			w.WriteHeader(403)
+		}
+	}))
+
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	// we use the certificate of the server as CA and client certificate
+	dir := t.TempDir()
+	certificate := server.TLS.Certificates[0]
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	key, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := func(tlsConfig map[string]interface{}, signing string) map[string]interface{} {
+		return map[string]interface{}{
+			"url":         server.URL,
+			"max-retries": 0,
+			"tls":         tlsConfig,
+			"signing":     map[string]interface{}{"secret": signing},
+		}
+	}
+
+	for _, test := range []struct {
+		tls   map[string]interface{}
+		key   string
+		fails bool
+	}{
+		{map[string]interface{}{"ca-file": certFile, "cert-file": certFile, "key-file": keyFile}, secret, false},
+		{map[string]interface{}{"ca-file": certFile, "cert-file": certFile, "key-file": keyFile}, "a-different-secret", true},
+		// we don't send a client certificate
+		{map[string]interface{}{"ca-file": certFile}, secret, true},
+		// we don't trust the server
+		{map[string]interface{}{}, secret, true},
+	} {
+		writer := makeTestHTTPWriter(t, config(test.tls, test.key))
+		if err := writer.Write(testPayload(2)); (err != nil) != test.fails {
+			t.Errorf("Unexpected result for %v: %v", test.tls, err)
+		}
+	}
+}
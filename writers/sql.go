@@ -0,0 +1,208 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/sqldialect"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Inserts or upserts items into a table via database/sql. The driver needs
+// to be registered, e.g. by importing it in the main package or a plugin.
+// Every batch of items is written in a single transaction.
+type SQLWriter struct {
+	Driver     string
+	DSN        string
+	Dialect    string
+	Table      string
+	Columns    map[string]interface{}
+	KeyColumns []string
+	BatchSize  int
+	dialect    *sqldialect.Dialect
+	db         *sql.DB
+}
+
+func (s *SQLWriter) Setup(config kodex.Config) error {
+
+	var err error
+
+	if s.dialect, err = sqldialect.Get(s.Dialect, s.Driver); err != nil {
+		return err
+	}
+
+	if s.db, err = sql.Open(s.Driver, s.DSN); err != nil {
+		return err
+	}
+
+	if err := s.db.Ping(); err != nil {
+		s.db.Close()
+		s.db = nil
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLWriter) Teardown() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
+func (s *SQLWriter) Write(payload kodex.Payload) error {
+	return s.WriteContext(context.Background(), payload)
+}
+
+func (s *SQLWriter) WriteContext(ctx context.Context, payload kodex.Payload) error {
+
+	items := payload.Items()
+
+	for len(items) > 0 {
+
+		n := len(items)
+
+		if n > s.BatchSize {
+			n = s.BatchSize
+		}
+
+		if err := s.writeBatch(ctx, items[:n]); err != nil {
+			return err
+		}
+
+		items = items[n:]
+	}
+
+	return nil
+}
+
+func (s *SQLWriter) writeBatch(ctx context.Context, items []*kodex.Item) error {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	// we prepare one statement for every set of columns
+	statements := make(map[string]*sql.Stmt)
+
+	for _, item := range items {
+
+		columns, values := s.row(item)
+		key := strings.Join(columns, "\x00")
+
+		statement, ok := statements[key]
+
+		if !ok {
+			if statement, err = tx.PrepareContext(ctx, s.dialect.Insert(s.Table, columns, s.KeyColumns)); err != nil {
+				tx.Rollback()
+				return err
+			}
+			statements[key] = statement
+		}
+
+		if _, err := statement.ExecContext(ctx, values...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Returns the columns (in a stable order) and values for the item.
+func (s *SQLWriter) row(item *kodex.Item) ([]string, []interface{}) {
+
+	var columns []string
+
+	if len(s.Columns) > 0 {
+		columns = make([]string, 0, len(s.Columns))
+		for column := range s.Columns {
+			columns = append(columns, column)
+		}
+	} else {
+		columns = item.Keys()
+	}
+
+	sort.Strings(columns)
+
+	values := make([]interface{}, len(columns))
+
+	for i, column := range columns {
+
+		field := column
+
+		if len(s.Columns) > 0 {
+			field = s.Columns[column].(string)
+		}
+
+		// missing fields become NULL
+		value, _ := item.Get(field)
+		values[i] = sqlArg(value)
+	}
+
+	return columns, values
+}
+
+// Drivers can't store maps and lists, so we store them as JSON.
+func sqlArg(value interface{}) interface{} {
+
+	if value == nil {
+		return nil
+	}
+
+	switch value.(type) {
+	case []byte, time.Time, driver.Valuer:
+		return value
+	}
+
+	switch reflect.TypeOf(value).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		if data, err := json.Marshal(value); err == nil {
+			return string(data)
+		}
+	}
+
+	return value
+}
+
+func MakeSQLWriter(config map[string]interface{}) (kodex.Writer, error) {
+	if params, err := SQLWriterForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		return &SQLWriter{
+			Driver:     params["driver"].(string),
+			DSN:        params["dsn"].(string),
+			Dialect:    params["dialect"].(string),
+			Table:      params["table"].(string),
+			Columns:    params["columns"].(map[string]interface{}),
+			KeyColumns: params["key-columns"].([]string),
+			BatchSize:  int(params["batch-size"].(int64)),
+		}, nil
+	}
+}
@@ -0,0 +1,95 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+	"github.com/kiprotect/kodex/sqldialect"
+)
+
+var SQLWriterForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the SQL writer form",
+	Fields: []forms.Field{
+		{
+			// the name of a registered database/sql driver, e.g. sqlite
+			Name: "driver",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "dsn",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			// if not given we guess the dialect from the driver name
+			Name: "dialect",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsIn{Choices: append([]interface{}{""}, sqldialect.Names...)},
+			},
+		},
+		{
+			Name: "table",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			// maps columns to item fields. If not given we use the fields of
+			// every item as columns.
+			Name: "columns",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{
+					Form: &forms.Form{
+						Fields: []forms.Field{
+							{
+								Name: "*",
+								Validators: []forms.Validator{
+									forms.IsString{},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			// if given we update rows with the same values in these columns
+			// instead of inserting new ones (requires a unique index)
+			Name: "key-columns",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: []interface{}{}},
+				forms.IsStringList{},
+			},
+		},
+		{
+			// the maximum number of items per transaction
+			Name: "batch-size",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 1000},
+				forms.IsInteger{HasMin: true, Min: 1},
+			},
+		},
+	},
+}
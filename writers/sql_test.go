@@ -0,0 +1,138 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers_test
+
+import (
+	"database/sql"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/writers"
+	_ "modernc.org/sqlite"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLWriter(t *testing.T) {
+
+	dsn := filepath.Join(t.TempDir(), "test.db")
+
+	db, err := sql.Open("sqlite", dsn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL, tags TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	writer, err := writers.MakeSQLWriter(map[string]interface{}{
+		"driver": "sqlite",
+		"dsn":    dsn,
+		"table":  "users",
+		"columns": map[string]interface{}{
+			"id":    "user_id",
+			"email": "email",
+			"tags":  "tags",
+		},
+		"key-columns": []interface{}{"id"},
+		"batch-size":  2,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Setup(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	defer writer.Teardown()
+
+	write := func(items ...map[string]interface{}) error {
+		payloadItems := make([]*kodex.Item, len(items))
+		for i, item := range items {
+			payloadItems[i] = kodex.MakeItem(item)
+		}
+		return writer.Write(kodex.MakeBasicPayload(payloadItems, nil, false))
+	}
+
+	if err := write(
+		map[string]interface{}{"user_id": int64(1), "email": "a@example.com", "tags": []interface{}{"a", "b"}},
+		map[string]interface{}{"user_id": int64(2), "email": "b@example.com"},
+		map[string]interface{}{"user_id": int64(3), "email": "c@example.com", "other": "ignored"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// existing rows are updated
+	if err := write(map[string]interface{}{"user_id": int64(2), "email": "new@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second batch fails, so the fifth item is not written
+	if err := write(
+		map[string]interface{}{"user_id": int64(4), "email": "d@example.com"},
+		map[string]interface{}{"user_id": int64(5), "email": "e@example.com"},
+		map[string]interface{}{"user_id": int64(6), "email": "f@example.com"},
+		map[string]interface{}{"user_id": int64(7)},
+	); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	rows, err := db.Query("SELECT id, email, tags FROM users ORDER BY id")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rows.Close()
+
+	expected := []struct {
+		id    int64
+		email string
+		tags  sql.NullString
+	}{
+		{1, "a@example.com", sql.NullString{String: `["a","b"]`, Valid: true}},
+		{2, "new@example.com", sql.NullString{}},
+		{3, "c@example.com", sql.NullString{}},
+		{4, "d@example.com", sql.NullString{}},
+		{5, "e@example.com", sql.NullString{}},
+	}
+
+	i := 0
+
+	for ; rows.Next(); i++ {
+
+		var id int64
+		var email string
+		var tags sql.NullString
+
+		if err := rows.Scan(&id, &email, &tags); err != nil {
+			t.Fatal(err)
+		}
+
+		if i >= len(expected) || id != expected[i].id || email != expected[i].email || tags != expected[i].tags {
+			t.Errorf("Unexpected row %d: %d, %s, %v", i, id, email, tags)
+		}
+	}
+
+	if i != len(expected) {
+		t.Errorf("Expected %d rows, got %d", len(expected), i)
+	}
+}
@@ -0,0 +1,163 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"github.com/kiprotect/kodex"
+	"github.com/kiprotect/kodex/syslog"
+	"net"
+	"sync"
+	"time"
+)
+
+// Sends items as syslog messages via UDP, TCP or TLS. Items use the fields
+// produced by the syslog reader (facility, severity, hostname, app, msg, ...).
+type SyslogWriter struct {
+	Address   string
+	Protocol  string
+	Format    string
+	Framing   string
+	TLS       map[string]interface{}
+	Timeout   time.Duration
+	tlsConfig *tls.Config
+	conn      net.Conn
+	mutex     sync.Mutex
+}
+
+func (s *SyslogWriter) Setup(config kodex.Config) error {
+	if s.Protocol == "tls" {
+		var err error
+		if s.tlsConfig, err = makeTLSConfig(s.TLS); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SyslogWriter) Teardown() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.close()
+}
+
+func (s *SyslogWriter) close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *SyslogWriter) connect() error {
+
+	if s.conn != nil {
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: s.Timeout}
+
+	var err error
+
+	switch s.Protocol {
+	case "tls":
+		s.conn, err = tls.DialWithDialer(dialer, "tcp", s.Address, s.tlsConfig)
+	default:
+		s.conn, err = dialer.Dial(s.Protocol, s.Address)
+	}
+
+	return err
+}
+
+func (s *SyslogWriter) frame(message []byte) []byte {
+
+	// every UDP datagram contains a single message
+	if s.Protocol == "udp" {
+		return message
+	}
+
+	if s.Framing == "newline" {
+		return append(message, '\n')
+	}
+
+	return append([]byte(fmt.Sprintf("%d ", len(message))), message...)
+}
+
+func (s *SyslogWriter) Write(payload kodex.Payload) error {
+
+	messages := make([][]byte, 0, len(payload.Items()))
+
+	for _, item := range payload.Items() {
+		message, err := syslog.Format(item.All(), s.Format)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, s.frame(message))
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var err error
+
+	// if the connection was closed we reconnect once
+	for i := 0; i < 2; i++ {
+
+		if err = s.connect(); err != nil {
+			continue
+		}
+
+		s.conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+
+		if s.Protocol == "udp" {
+			for len(messages) > 0 {
+				if _, err = s.conn.Write(messages[0]); err != nil {
+					break
+				}
+				messages = messages[1:]
+			}
+		} else {
+			_, err = s.conn.Write(bytes.Join(messages, nil))
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		s.close()
+	}
+
+	return err
+}
+
+func MakeSyslogWriter(config map[string]interface{}) (kodex.Writer, error) {
+	if params, err := SyslogWriterForm.Validate(config); err != nil {
+		return nil, err
+	} else {
+		return &SyslogWriter{
+			Address:  params["address"].(string),
+			Protocol: params["protocol"].(string),
+			Format:   params["format"].(string),
+			Framing:  params["framing"].(string),
+			TLS:      params["tls"].(map[string]interface{}),
+			Timeout:  time.Duration(params["timeout"].(float64) * float64(time.Second)),
+		}, nil
+	}
+}
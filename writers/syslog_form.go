@@ -0,0 +1,75 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"github.com/kiprotect/go-helpers/forms"
+)
+
+var SyslogWriterForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the syslog writer form",
+	Fields: []forms.Field{
+		{
+			// the address of the syslog server, e.g. siem.example.com:6514
+			Name: "address",
+			Validators: []forms.Validator{
+				forms.IsRequired{},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "protocol",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "udp"},
+				forms.IsIn{Choices: []interface{}{"udp", "tcp", "tls"}},
+			},
+		},
+		{
+			// the message format
+			Name: "format",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "rfc5424"},
+				forms.IsIn{Choices: []interface{}{"rfc5424", "rfc3164"}},
+			},
+		},
+		{
+			// how messages are delimited over TCP and TLS (RFC 6587)
+			Name: "framing",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: "octet-counting"},
+				forms.IsIn{Choices: []interface{}{"octet-counting", "newline"}},
+			},
+		},
+		{
+			Name: "tls",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: map[string]interface{}{}},
+				forms.IsStringMap{
+					Form: &TLSForm,
+				},
+			},
+		},
+		{
+			// the timeout for connecting and writing (in seconds)
+			Name: "timeout",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: 10.0},
+				forms.IsFloat{HasMin: true, Min: 0.01},
+			},
+		},
+	},
+}
@@ -0,0 +1,102 @@
+// Kodex (Community Edition - CE) - Privacy & Security Engineering Platform
+// Copyright (C) 2019-2024  KIProtect GmbH (HRB 208395B) - Germany
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package writers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/kiprotect/go-helpers/forms"
+	"os"
+)
+
+var TLSForm = forms.Form{
+	ErrorMsg: "invalid data encountered in the TLS form",
+	Fields: []forms.Field{
+		{
+			// if given, we only accept server certificates signed by these
+			// CAs instead of the system CAs
+			Name: "ca-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// a client certificate, if the server requires one
+			Name: "cert-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			Name: "key-file",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+		{
+			// the expected name of the server, if it differs from the address
+			Name: "server-name",
+			Validators: []forms.Validator{
+				forms.IsOptional{Default: ""},
+				forms.IsString{},
+			},
+		},
+	},
+}
+
+// Returns the TLS config for clients.
+func makeTLSConfig(params map[string]interface{}) (*tls.Config, error) {
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: params["server-name"].(string),
+	}
+
+	if caFile := params["ca-file"].(string); caFile != "" {
+
+		data, err := os.ReadFile(caFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+
+		config.RootCAs = pool
+	}
+
+	if certFile := params["cert-file"].(string); certFile != "" {
+
+		certificate, err := tls.LoadX509KeyPair(certFile, params["key-file"].(string))
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
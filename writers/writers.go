@@ -30,6 +30,16 @@ var Writers = kodex.WriterDefinitions{
 		Maker: MakeHTTPWriter,
 		Form:  HTTPWriterForm,
 	},
+	"syslog": kodex.WriterDefinition{
+		Maker:    MakeSyslogWriter,
+		Form:     SyslogWriterForm,
+		Internal: true,
+	},
+	"sql": kodex.WriterDefinition{
+		Maker:    MakeSQLWriter,
+		Form:     SQLWriterForm,
+		Internal: true,
+	},
 	"bytes": kodex.WriterDefinition{
 		Maker:    MakeBytesWriter,
 		Form:     BytesWriterForm,